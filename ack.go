@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// Acknowledgments: an operator marks an ongoing problem as known, which
+// stops renotifications until the resource is back UP.
+///////////////////////////////////////////////////////////////////////////////
+
+type AckRequest struct {
+	Name string
+	User string
+}
+
+func (s *StatusChecker) Ack(name, user string) error {
+	conf, _ := s.Lookup(name)
+	if conf == nil {
+		return fmt.Errorf("no resource named %q", name)
+	}
+	if len(user) == 0 {
+		return fmt.Errorf("no user acknowledging %s", name)
+	}
+	s.statusMutex.Lock()
+	defer s.statusMutex.Unlock()
+	a, ok := s.alerts[conf.Address]
+	if !ok {
+		return fmt.Errorf("%s has no ongoing problem", name)
+	}
+	a.AckedBy = user
+	a.AckedAt = time.Now()
+	slog.Info("Ack", "resource", conf.Name, "address", conf.Address, "user", user)
+	return nil
+}
+
+func RegisterAckHandler(sc *StatusChecker) {
+	describeAPI(&apiOperation{
+		Method: "POST", Path: "/api/ack", Summary: "Acknowledge an ongoing problem of a resource",
+		Form:      []string{"name", "user"},
+		Responses: map[int]interface{}{http.StatusNoContent: nil, http.StatusBadRequest: nil},
+	})
+	http.HandleFunc("/api/ack", func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method != "POST" {
+			http.Error(rw, "POST required", http.StatusMethodNotAllowed)
+			return
+		}
+		args := AckRequest{req.FormValue("name"), req.FormValue("user")}
+		if err := sc.Ack(args.Name, args.User); err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		audit(callerOf(req), req.RemoteAddr, AuditAck, args.Name, args)
+		rw.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,476 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// Automatic certificates: an ACME (RFC 8555) client obtaining certificates
+// of -acme-domains, e.g. from Let's Encrypt, with the tls-alpn-01 challenge
+// (RFC 8737) answered by the server itself, so it must be reachable at port
+// 443 of the domains. Certificates are renewed 30 days before they expire.
+///////////////////////////////////////////////////////////////////////////////
+
+var (
+	acmeDomains   = flag.String("acme-domains", "", "Comma separated domains of an automatic certificate, instead of -tls-cert.")
+	acmeEmail     = flag.String("acme-email", "", "A contact of an ACME account, e.g. of expiry warnings.")
+	acmeDirectory = flag.String("acme-directory", "https://acme-v02.api.letsencrypt.org/directory", "An ACME directory URL.")
+	acmeCache     = flag.String("acme-cache", "acme", "A directory of an ACME account key and certificates.")
+)
+
+const (
+	acmeALPN    = "acme-tls/1"
+	acmeRenewal = 30 * 24 * time.Hour
+	acmeTimeout = 5 * time.Minute
+)
+
+// acmePollWait is a wait between polls of pending authorizations and orders.
+var acmePollWait = 2 * time.Second
+
+var oidACMEIdentifier = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 31}
+
+type acmeDir struct {
+	NewNonce   string `json:"newNonce"`
+	NewAccount string `json:"newAccount"`
+	NewOrder   string `json:"newOrder"`
+}
+
+type acmeOrder struct {
+	Status         string   `json:"status"`
+	Authorizations []string `json:"authorizations"`
+	Finalize       string   `json:"finalize"`
+	Certificate    string   `json:"certificate"`
+}
+
+type acmeAuthz struct {
+	Status     string `json:"status"`
+	Identifier struct {
+		Value string `json:"value"`
+	} `json:"identifier"`
+	Challenges []struct {
+		Type  string `json:"type"`
+		URL   string `json:"url"`
+		Token string `json:"token"`
+	} `json:"challenges"`
+}
+
+type acmeProblem struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail"`
+}
+
+type acmeManager struct {
+	domains   []string
+	email     string
+	directory string
+	cache     string
+	client    *http.Client
+
+	m          sync.Mutex
+	cert       *tls.Certificate
+	renewing   bool
+	challenges map[string]*tls.Certificate // by domain
+
+	// Of an account, set by register.
+	key   *ecdsa.PrivateKey
+	kid   string
+	dir   *acmeDir
+	nonce string
+}
+
+func newACMEManager(domains []string) *acmeManager {
+	return &acmeManager{
+		domains:    domains,
+		email:      *acmeEmail,
+		directory:  *acmeDirectory,
+		cache:      *acmeCache,
+		client:     &http.Client{Timeout: 30 * time.Second},
+		challenges: make(map[string]*tls.Certificate),
+	}
+}
+
+func (a *acmeManager) certPath() string {
+	return filepath.Join(a.cache, strings.Join(a.domains, ",")+".pem")
+}
+
+// GetCertificate is a tls.Config.GetCertificate answering challenges and
+// serving an obtained certificate, obtained on a first use if needed.
+func (a *acmeManager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if len(hello.SupportedProtos) == 1 && hello.SupportedProtos[0] == acmeALPN {
+		a.m.Lock()
+		defer a.m.Unlock()
+		if c, ok := a.challenges[strings.ToLower(hello.ServerName)]; ok {
+			return c, nil
+		}
+		return nil, fmt.Errorf("no challenge of %q", hello.ServerName)
+	}
+	a.m.Lock()
+	c := a.cert
+	if c == nil {
+		if b, err := ioutil.ReadFile(a.certPath()); err == nil {
+			if cert, err := tls.X509KeyPair(b, b); err == nil {
+				a.cert, c = &cert, &cert
+			}
+		}
+	}
+	renew := c == nil || time.Until(c.Leaf.NotAfter) < acmeRenewal
+	if renew && c != nil && !a.renewing {
+		a.renewing = true
+		go a.renew()
+	}
+	a.m.Unlock()
+	if c != nil {
+		return c, nil
+	}
+	if err := a.obtain(); err != nil {
+		return nil, err
+	}
+	a.m.Lock()
+	defer a.m.Unlock()
+	return a.cert, nil
+}
+
+func (a *acmeManager) renew() {
+	if err := a.obtain(); err != nil {
+		slog.Error("ACME renewal", "error", err)
+	}
+	a.m.Lock()
+	a.renewing = false
+	a.m.Unlock()
+}
+
+// obtainMutex serializes ACME orders.
+var obtainMutex sync.Mutex
+
+// obtain orders a certificate of the domains and caches it.
+func (a *acmeManager) obtain() error {
+	obtainMutex.Lock()
+	defer obtainMutex.Unlock()
+	a.m.Lock()
+	fresh := a.cert != nil && time.Until(a.cert.Leaf.NotAfter) > acmeRenewal
+	a.m.Unlock()
+	if fresh {
+		return nil
+	}
+	slog.Info("ACME ordering a certificate", "domains", a.domains)
+	if err := a.register(); err != nil {
+		return fmt.Errorf("account: %s", err)
+	}
+	var ids []map[string]string
+	for _, d := range a.domains {
+		ids = append(ids, map[string]string{"type": "dns", "value": d})
+	}
+	order := &acmeOrder{}
+	resp, err := a.post(a.dir.NewOrder, map[string]interface{}{"identifiers": ids}, order)
+	if err != nil {
+		return fmt.Errorf("order: %s", err)
+	}
+	orderURL := resp.Header.Get("Location")
+	for _, u := range order.Authorizations {
+		if err := a.authorize(u); err != nil {
+			return err
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: a.domains[0]}, DNSNames: a.domains,
+	}, key)
+	if err != nil {
+		return err
+	}
+	if _, err := a.post(order.Finalize, map[string]string{"csr": b64(csr)}, order); err != nil {
+		return fmt.Errorf("finalize: %s", err)
+	}
+	for deadline := time.Now().Add(acmeTimeout); order.Status != "valid"; {
+		if order.Status == "invalid" || time.Now().After(deadline) {
+			return fmt.Errorf("order %s", order.Status)
+		}
+		time.Sleep(acmePollWait)
+		if _, err := a.post(orderURL, nil, order); err != nil {
+			return err
+		}
+	}
+	resp, err = a.post(order.Certificate, nil, nil)
+	if err != nil {
+		return fmt.Errorf("certificate: %s", err)
+	}
+	chain, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	b := append(chain, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})...)
+	cert, err := tls.X509KeyPair(b, b)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(a.certPath(), b, 0600); err != nil {
+		slog.Warn("ACME caching a certificate", "error", err)
+	}
+	a.m.Lock()
+	a.cert = &cert
+	a.m.Unlock()
+	slog.Info("ACME got a certificate", "domains", a.domains, "not_after", cert.Leaf.NotAfter)
+	return nil
+}
+
+// authorize answers a tls-alpn-01 challenge of an authorization.
+func (a *acmeManager) authorize(u string) error {
+	authz := &acmeAuthz{}
+	if _, err := a.post(u, nil, authz); err != nil {
+		return fmt.Errorf("authorization: %s", err)
+	}
+	if authz.Status == "valid" {
+		return nil
+	}
+	domain := authz.Identifier.Value
+	for _, ch := range authz.Challenges {
+		if ch.Type != "tls-alpn-01" {
+			continue
+		}
+		cert, err := a.challengeCert(domain, ch.Token)
+		if err != nil {
+			return err
+		}
+		a.m.Lock()
+		a.challenges[domain] = cert
+		a.m.Unlock()
+		defer func() {
+			a.m.Lock()
+			delete(a.challenges, domain)
+			a.m.Unlock()
+		}()
+		if _, err := a.post(ch.URL, struct{}{}, nil); err != nil {
+			return fmt.Errorf("challenge of %s: %s", domain, err)
+		}
+		for deadline := time.Now().Add(acmeTimeout); authz.Status != "valid"; {
+			if authz.Status == "invalid" || time.Now().After(deadline) {
+				return fmt.Errorf("authorization of %s %s", domain, authz.Status)
+			}
+			time.Sleep(acmePollWait)
+			if _, err := a.post(u, nil, authz); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("no tls-alpn-01 challenge of %s", domain)
+}
+
+// challengeCert returns a self-signed certificate of RFC 8737.
+func (a *acmeManager) challengeCert(domain, token string) (*tls.Certificate, error) {
+	thumb, err := jwkThumbprint(&a.key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256([]byte(token + "." + thumb))
+	ext, err := asn1.Marshal(sum[:])
+	if err != nil {
+		return nil, err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:    big.NewInt(1),
+		Subject:         pkix.Name{CommonName: domain},
+		DNSNames:        []string{domain},
+		NotBefore:       time.Now().Add(-time.Hour),
+		NotAfter:        time.Now().Add(24 * time.Hour),
+		ExtraExtensions: []pkix.Extension{{Id: oidACMEIdentifier, Critical: true, Value: ext}},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+// register loads or creates an account key and registers it.
+func (a *acmeManager) register() error {
+	if a.kid != "" {
+		return nil
+	}
+	if err := os.MkdirAll(a.cache, 0700); err != nil {
+		return err
+	}
+	keyPath := filepath.Join(a.cache, "account.key")
+	if b, err := ioutil.ReadFile(keyPath); err == nil {
+		block, _ := pem.Decode(b)
+		if block == nil {
+			return fmt.Errorf("%s: no PEM", keyPath)
+		}
+		if a.key, err = x509.ParseECPrivateKey(block.Bytes); err != nil {
+			return err
+		}
+	} else {
+		if a.key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+			return err
+		}
+		der, err := x509.MarshalECPrivateKey(a.key)
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600); err != nil {
+			return err
+		}
+	}
+	resp, err := a.client.Get(a.directory)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	a.dir = &acmeDir{}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(a.dir); err != nil {
+		return err
+	}
+	account := map[string]interface{}{"termsOfServiceAgreed": true}
+	if len(a.email) > 0 {
+		account["contact"] = []string{"mailto:" + a.email}
+	}
+	resp, err = a.post(a.dir.NewAccount, account, nil)
+	if err != nil {
+		return err
+	}
+	a.kid = resp.Header.Get("Location")
+	return nil
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func jwk(pub *ecdsa.PublicKey) map[string]string {
+	return map[string]string{
+		"crv": "P-256",
+		"kty": "EC",
+		"x":   b64(pub.X.FillBytes(make([]byte, 32))),
+		"y":   b64(pub.Y.FillBytes(make([]byte, 32))),
+	}
+}
+
+// jwkThumbprint of RFC 7638, encoding/json sorts keys as required.
+func jwkThumbprint(pub *ecdsa.PublicKey) (string, error) {
+	b, err := json.Marshal(jwk(pub))
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return b64(sum[:]), nil
+}
+
+func (a *acmeManager) newNonce() (string, error) {
+	if n := a.nonce; n != "" {
+		a.nonce = ""
+		return n, nil
+	}
+	resp, err := a.client.Head(a.dir.NewNonce)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	if n := resp.Header.Get("Replay-Nonce"); n != "" {
+		return n, nil
+	}
+	return "", errors.New("no nonce")
+}
+
+// post sends a JWS signed request, a nil payload is a POST-as-GET. A JSON
+// response is decoded into v if it's set, a body is left to read otherwise.
+func (a *acmeManager) post(url string, payload, v interface{}) (*http.Response, error) {
+	for retry := 0; ; retry++ {
+		resp, err := a.postOnce(url, payload)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode/100 == 2 {
+			if v != nil {
+				defer resp.Body.Close()
+				return resp, json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+			}
+			return resp, nil
+		}
+		p := &acmeProblem{}
+		json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(p)
+		resp.Body.Close()
+		if p.Type == "urn:ietf:params:acme:error:badNonce" && retry < 3 {
+			continue
+		}
+		return nil, fmt.Errorf("%s: %s %s", resp.Status, p.Type, p.Detail)
+	}
+}
+
+func (a *acmeManager) postOnce(url string, payload interface{}) (*http.Response, error) {
+	nonce, err := a.newNonce()
+	if err != nil {
+		return nil, err
+	}
+	protected := map[string]interface{}{"alg": "ES256", "nonce": nonce, "url": url}
+	if a.kid != "" {
+		protected["kid"] = a.kid
+	} else {
+		protected["jwk"] = jwk(&a.key.PublicKey)
+	}
+	ph, err := json.Marshal(protected)
+	if err != nil {
+		return nil, err
+	}
+	pl := ""
+	if payload != nil {
+		b, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		pl = b64(b)
+	}
+	signed := b64(ph) + "." + pl
+	sum := sha256.Sum256([]byte(signed))
+	r, s, err := ecdsa.Sign(rand.Reader, a.key, sum[:])
+	if err != nil {
+		return nil, err
+	}
+	sig := append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	body, err := json.Marshal(map[string]string{"protected": b64(ph), "payload": pl, "signature": b64(sig)})
+	if err != nil {
+		return nil, err
+	}
+	resp, err := a.client.Post(url, "application/jose+json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	a.nonce = resp.Header.Get("Replay-Nonce")
+	return resp, nil
+}
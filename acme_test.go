@@ -0,0 +1,448 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeACME is an ACME directory verifying requests like a real one, with
+// knobs for failures.
+type fakeACME struct {
+	srv *httptest.Server
+	ca  *x509.Certificate
+	key *ecdsa.PrivateKey
+
+	// Knobs.
+	validity    time.Duration // of certificates, 90 days if 0
+	badNonces   int           // requests answered with badNonce
+	orderError  bool          // new orders are rejected
+	noALPN      bool          // authorizations have no tls-alpn-01 challenge
+	failAuthz   bool          // challenges are invalid
+	failOrder   bool          // orders are invalid after finalize
+	finalizeErr bool          // finalize is rejected
+	badProof    bool          // challenge certificates are checked with another token
+
+	m        sync.Mutex
+	nonces   map[string]bool
+	nonceSeq int
+	accounts map[string]*ecdsa.PublicKey // by kid
+	token    string
+	answered bool // the challenge is answered
+	csr      *x509.CertificateRequest
+	orders   int
+	requests int
+	manager  *acmeManager // asked for a challenge certificate
+}
+
+func newFakeACME(t *testing.T) *fakeACME {
+	f := &fakeACME{nonces: make(map[string]bool), accounts: make(map[string]*ecdsa.PublicKey), token: "tok-1"}
+	var err error
+	if f.key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "fake ACME CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &f.key.PublicKey, f.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.ca, err = x509.ParseCertificate(der); err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/dir", func(rw http.ResponseWriter, req *http.Request) {
+		json.NewEncoder(rw).Encode(map[string]string{
+			"newNonce": f.srv.URL + "/nonce", "newAccount": f.srv.URL + "/account", "newOrder": f.srv.URL + "/order",
+		})
+	})
+	mux.HandleFunc("/nonce", func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Replay-Nonce", f.nonce())
+	})
+	mux.HandleFunc("/", f.handle)
+	f.srv = httptest.NewServer(mux)
+	t.Cleanup(f.srv.Close)
+	return f
+}
+
+func (f *fakeACME) nonce() string {
+	f.m.Lock()
+	defer f.m.Unlock()
+	f.nonceSeq++
+	n := fmt.Sprintf("nonce-%d", f.nonceSeq)
+	f.nonces[n] = true
+	return n
+}
+
+func (f *fakeACME) problem(rw http.ResponseWriter, code int, typ, detail string) {
+	rw.Header().Set("Content-Type", "application/problem+json")
+	rw.WriteHeader(code)
+	json.NewEncoder(rw).Encode(map[string]string{"type": "urn:ietf:params:acme:error:" + typ, "detail": detail})
+}
+
+// verify checks a JWS of a request and returns its payload and a kid.
+func (f *fakeACME) verify(req *http.Request) ([]byte, string, error) {
+	var jws struct{ Protected, Payload, Signature string }
+	if err := json.NewDecoder(req.Body).Decode(&jws); err != nil {
+		return nil, "", err
+	}
+	dec := base64.RawURLEncoding
+	ph, err := dec.DecodeString(jws.Protected)
+	if err != nil {
+		return nil, "", err
+	}
+	var protected struct {
+		Alg, Nonce, URL, Kid string
+		JWK                  map[string]string
+	}
+	if err := json.Unmarshal(ph, &protected); err != nil {
+		return nil, "", err
+	}
+	if protected.Alg != "ES256" || protected.URL != f.srv.URL+req.URL.Path {
+		return nil, "", fmt.Errorf("protected header %s", ph)
+	}
+	f.m.Lock()
+	defer f.m.Unlock()
+	if !f.nonces[protected.Nonce] {
+		return nil, "", fmt.Errorf("nonce %q", protected.Nonce)
+	}
+	delete(f.nonces, protected.Nonce)
+	var pub *ecdsa.PublicKey
+	switch {
+	case len(protected.Kid) > 0:
+		pub = f.accounts[protected.Kid]
+	case protected.JWK != nil && req.URL.Path == "/account":
+		x, _ := dec.DecodeString(protected.JWK["x"])
+		y, _ := dec.DecodeString(protected.JWK["y"])
+		pub = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+	}
+	if pub == nil {
+		return nil, "", fmt.Errorf("no account key")
+	}
+	sig, err := dec.DecodeString(jws.Signature)
+	if err != nil || len(sig) != 64 {
+		return nil, "", fmt.Errorf("signature %q", jws.Signature)
+	}
+	sum := sha256.Sum256([]byte(jws.Protected + "." + jws.Payload))
+	if !ecdsa.Verify(pub, sum[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+		return nil, "", fmt.Errorf("bad signature")
+	}
+	payload, err := dec.DecodeString(jws.Payload)
+	if err != nil {
+		return nil, "", err
+	}
+	kid := protected.Kid
+	if len(kid) == 0 {
+		kid = fmt.Sprintf("%s/acct/%d", f.srv.URL, len(f.accounts)+1)
+		f.accounts[kid] = pub
+	}
+	return payload, kid, nil
+}
+
+func (f *fakeACME) handle(rw http.ResponseWriter, req *http.Request) {
+	rw.Header().Set("Replay-Nonce", f.nonce())
+	f.m.Lock()
+	f.requests++
+	bad := f.badNonces > 0
+	if bad {
+		f.badNonces--
+	}
+	f.m.Unlock()
+	if bad {
+		f.problem(rw, http.StatusBadRequest, "badNonce", "try again")
+		return
+	}
+	payload, kid, err := f.verify(req)
+	if err != nil {
+		f.problem(rw, http.StatusUnauthorized, "malformed", err.Error())
+		return
+	}
+	f.m.Lock()
+	defer f.m.Unlock()
+	order := func(status string) map[string]interface{} {
+		o := map[string]interface{}{"status": status, "authorizations": []string{f.srv.URL + "/authz/1"},
+			"finalize": f.srv.URL + "/finalize/1"}
+		if status == "valid" {
+			o["certificate"] = f.srv.URL + "/cert/1"
+		}
+		return o
+	}
+	switch req.URL.Path {
+	case "/account":
+		rw.Header().Set("Location", kid)
+		rw.WriteHeader(http.StatusCreated)
+		json.NewEncoder(rw).Encode(map[string]string{"status": "valid"})
+	case "/order":
+		if f.orderError {
+			f.problem(rw, http.StatusForbidden, "rejectedIdentifier", "not today")
+			return
+		}
+		var o struct {
+			Identifiers []struct{ Type, Value string }
+		}
+		json.Unmarshal(payload, &o)
+		if len(o.Identifiers) != 1 || o.Identifiers[0].Type != "dns" || o.Identifiers[0].Value != "a.test" {
+			f.problem(rw, http.StatusBadRequest, "malformed", string(payload))
+			return
+		}
+		f.orders++
+		f.answered, f.csr = false, nil
+		rw.Header().Set("Location", f.srv.URL+"/orders/1")
+		rw.WriteHeader(http.StatusCreated)
+		json.NewEncoder(rw).Encode(order("pending"))
+	case "/authz/1":
+		status := "pending"
+		switch {
+		case f.answered && f.failAuthz:
+			status = "invalid"
+		case f.answered:
+			status = "valid"
+		}
+		challenges := []map[string]string{{"type": "http-01", "url": f.srv.URL + "/chall/0", "token": "x"}}
+		if !f.noALPN {
+			challenges = append(challenges, map[string]string{"type": "tls-alpn-01", "url": f.srv.URL + "/chall/1",
+				"token": f.token})
+		}
+		json.NewEncoder(rw).Encode(map[string]interface{}{"status": status,
+			"identifier": map[string]string{"type": "dns", "value": "a.test"}, "challenges": challenges})
+	case "/chall/1":
+		if string(payload) != "{}" {
+			f.problem(rw, http.StatusBadRequest, "malformed", "want {}")
+			return
+		}
+		if err := f.validate(); err != nil {
+			f.problem(rw, http.StatusForbidden, "unauthorized", err.Error())
+			return
+		}
+		f.answered = true
+		json.NewEncoder(rw).Encode(map[string]string{"status": "processing"})
+	case "/finalize/1":
+		if f.finalizeErr {
+			f.problem(rw, http.StatusForbidden, "badCSR", "no")
+			return
+		}
+		var p struct{ CSR string }
+		json.Unmarshal(payload, &p)
+		der, _ := base64.RawURLEncoding.DecodeString(p.CSR)
+		csr, err := x509.ParseCertificateRequest(der)
+		if err != nil || csr.CheckSignature() != nil || len(csr.DNSNames) != 1 || csr.DNSNames[0] != "a.test" {
+			f.problem(rw, http.StatusBadRequest, "badCSR", fmt.Sprint(err))
+			return
+		}
+		f.csr = csr
+		json.NewEncoder(rw).Encode(order("processing"))
+	case "/orders/1":
+		status := "valid"
+		if f.failOrder {
+			status = "invalid"
+		}
+		json.NewEncoder(rw).Encode(order(status))
+	case "/cert/1":
+		validity := f.validity
+		if validity == 0 {
+			validity = 90 * 24 * time.Hour
+		}
+		tmpl := &x509.Certificate{
+			SerialNumber: big.NewInt(int64(f.orders + 1)),
+			Subject:      pkix.Name{CommonName: "a.test"},
+			DNSNames:     f.csr.DNSNames,
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(validity),
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, f.ca, f.csr.PublicKey, f.key)
+		if err != nil {
+			f.problem(rw, http.StatusInternalServerError, "serverInternal", err.Error())
+			return
+		}
+		rw.Header().Set("Content-Type", "application/pem-certificate-chain")
+		pem.Encode(rw, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+		pem.Encode(rw, &pem.Block{Type: "CERTIFICATE", Bytes: f.ca.Raw})
+	default:
+		f.problem(rw, http.StatusNotFound, "malformed", req.URL.Path)
+	}
+}
+
+// validate checks a tls-alpn-01 certificate like a server of RFC 8737 would.
+func (f *fakeACME) validate() error {
+	cert, err := f.manager.GetCertificate(&tls.ClientHelloInfo{ServerName: "a.test", SupportedProtos: []string{acmeALPN}})
+	if err != nil {
+		return err
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return err
+	}
+	var pub *ecdsa.PublicKey
+	for _, p := range f.accounts {
+		pub = p
+	}
+	thumb, err := jwkThumbprint(pub)
+	if err != nil {
+		return err
+	}
+	token := f.token
+	if f.badProof {
+		token += "x"
+	}
+	want := sha256.Sum256([]byte(token + "." + thumb))
+	for _, ext := range leaf.Extensions {
+		if ext.Id.Equal(oidACMEIdentifier) {
+			var got []byte
+			if _, err := asn1.Unmarshal(ext.Value, &got); err != nil || !ext.Critical || string(got) != string(want[:]) {
+				return fmt.Errorf("a wrong acmeIdentifier")
+			}
+			if len(leaf.DNSNames) != 1 || leaf.DNSNames[0] != "a.test" {
+				return fmt.Errorf("names %v", leaf.DNSNames)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("no acmeIdentifier")
+}
+
+func (f *fakeACME) newManager(cache string) *acmeManager {
+	a := newACMEManager([]string{"a.test"})
+	a.directory = f.srv.URL + "/dir"
+	a.cache = cache
+	a.email = "ops@a.test"
+	f.manager = a
+	return a
+}
+
+func TestACMEObtain(t *testing.T) {
+	defer func(d time.Duration) { acmePollWait = d }(acmePollWait)
+	acmePollWait = time.Millisecond
+	f := newFakeACME(t)
+	f.badNonces = 1 // retried
+	cache := t.TempDir()
+	a := f.newManager(cache)
+	cert, err := a.GetCertificate(&tls.ClientHelloInfo{ServerName: "a.test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.Leaf == nil || cert.Leaf.Subject.CommonName != "a.test" || len(cert.Certificate) != 2 {
+		t.Fatalf("certificate %v", cert.Leaf)
+	}
+	if err := cert.Leaf.CheckSignatureFrom(f.ca); err != nil {
+		t.Error(err)
+	}
+	if len(a.challenges) != 0 {
+		t.Errorf("challenges left: %v", a.challenges)
+	}
+	if _, err := a.GetCertificate(&tls.ClientHelloInfo{ServerName: "a.test", SupportedProtos: []string{acmeALPN}}); err == nil {
+		t.Error("a challenge answered after an authorization")
+	}
+
+	// Another manager reads a cached certificate without ordering.
+	requests := f.requests
+	b := f.newManager(cache)
+	cached, err := b.GetCertificate(&tls.ClientHelloInfo{ServerName: "a.test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cached.Leaf.Equal(cert.Leaf) || f.requests != requests || f.orders != 1 {
+		t.Errorf("cached certificate not used, %d requests", f.requests-requests)
+	}
+}
+
+func TestACMERenewal(t *testing.T) {
+	defer func(d time.Duration) { acmePollWait = d }(acmePollWait)
+	acmePollWait = time.Millisecond
+	f := newFakeACME(t)
+	f.validity = 10 * 24 * time.Hour
+	a := f.newManager(t.TempDir())
+	first, err := a.GetCertificate(&tls.ClientHelloInfo{ServerName: "a.test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.m.Lock()
+	f.validity = 0
+	f.m.Unlock()
+	// The expiring certificate is served while a new one is ordered.
+	if c, err := a.GetCertificate(&tls.ClientHelloInfo{ServerName: "a.test"}); err != nil || c != first {
+		t.Fatalf("got %v, %v", c, err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		a.m.Lock()
+		renewed := a.cert != first && !a.renewing
+		a.m.Unlock()
+		if renewed {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("not renewed")
+		}
+	}
+	c, err := a.GetCertificate(&tls.ClientHelloInfo{ServerName: "a.test"})
+	f.m.Lock()
+	defer f.m.Unlock()
+	if err != nil || time.Until(c.Leaf.NotAfter) < acmeRenewal || f.orders != 2 {
+		t.Errorf("got %v, %v after %d orders", c.Leaf.NotAfter, err, f.orders)
+	}
+}
+
+func TestACMEErrors(t *testing.T) {
+	defer func(d time.Duration) { acmePollWait = d }(acmePollWait)
+	acmePollWait = time.Millisecond
+	tests := []struct {
+		name string
+		knob func(f *fakeACME)
+		err  string
+	}{
+		{"bad nonces", func(f *fakeACME) { f.badNonces = 10 },
+			"account: 400 Bad Request: urn:ietf:params:acme:error:badNonce try again"},
+		{"order rejected", func(f *fakeACME) { f.orderError = true },
+			"order: 403 Forbidden: urn:ietf:params:acme:error:rejectedIdentifier not today"},
+		{"no tls-alpn-01", func(f *fakeACME) { f.noALPN = true }, "no tls-alpn-01 challenge of a.test"},
+		{"challenge invalid", func(f *fakeACME) { f.failAuthz = true }, "authorization of a.test invalid"},
+		{"challenge not answered", func(f *fakeACME) { f.badProof = true },
+			"challenge of a.test: 403 Forbidden: urn:ietf:params:acme:error:unauthorized a wrong acmeIdentifier"},
+		{"finalize rejected", func(f *fakeACME) { f.finalizeErr = true },
+			"finalize: 403 Forbidden: urn:ietf:params:acme:error:badCSR no"},
+		{"order invalid", func(f *fakeACME) { f.failOrder = true }, "order invalid"},
+	}
+	for _, tt := range tests {
+		f := newFakeACME(t)
+		tt.knob(f)
+		a := f.newManager(t.TempDir())
+		_, err := a.GetCertificate(&tls.ClientHelloInfo{ServerName: "a.test"})
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("%s: got error %v, want %s", tt.name, err, tt.err)
+		}
+		if len(a.challenges) != 0 {
+			t.Errorf("%s: challenges left: %v", tt.name, a.challenges)
+		}
+	}
+
+	// An unreachable directory.
+	f := newFakeACME(t)
+	a := f.newManager(t.TempDir())
+	f.srv.Close()
+	if _, err := a.GetCertificate(&tls.ClientHelloInfo{ServerName: "a.test"}); err == nil ||
+		!strings.HasPrefix(err.Error(), "account: ") {
+		t.Errorf("unreachable: got error %v", err)
+	}
+}
@@ -0,0 +1,467 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// AdminService of admin.proto: the gRPC admin interface, served at the
+// command address with reflection. Methods call AdminServer ones, so they
+// check roles and audit changes alike; -rpc also serves net/rpc.
+///////////////////////////////////////////////////////////////////////////////
+
+const adminServiceName = "statusmonitor.admin.v1.AdminService"
+
+// pbResource is a resource with its last status, nil if never checked.
+// Credentials of Conf are redacted unless a caller is an admin.
+type pbResource struct {
+	Conf     *ResConf
+	Status   *Status
+	Paused   bool
+	AckedBy  string
+	Uptime   float64 // of the last 24h
+	Coverage float64
+}
+
+type pbAddRequest struct {
+	Conf *ResConf
+}
+
+// pbRemoveRequest has a Name or an Address.
+type pbRemoveRequest struct {
+	Name    *string
+	Address *string
+}
+
+type pbRemoveResponse struct {
+	Removed bool
+}
+
+// pbUpdateRequest changes fields of Conf in UpdateMask, ones which aren't
+// zero if it's empty. Tags, Headers and Export are merged.
+type pbUpdateRequest struct {
+	Name       string
+	Conf       *ResConf
+	UpdateMask *pbFieldMask
+}
+
+type pbListResponse struct {
+	Resources []*pbResource
+}
+
+type GetRequest struct {
+	Name string
+}
+
+type pbAckResponse struct{}
+
+type pbExportResponse struct {
+	Confs []*ResConf
+}
+
+type pbOnCallResponse struct {
+	OnCall []*OnCall
+}
+
+type pbDiagnosticsResponse struct {
+	Archive []byte // a zip archive
+}
+
+var resConfMessage = &pbMessage{Name: "ResConf", Type: reflect.TypeOf(ResConf{}), Fields: []pbField{
+	{1, "name", "Name"}, {2, "address", "Address"}, {3, "type", "Type"}, {4, "tags", "Tags"},
+	{5, "max_ttfb", "MaxTTFB"}, {6, "max_total", "MaxTotal"}, {7, "group", "Group"}, {8, "notify", "Notify"},
+	{9, "renotify", "Renotify"}, {10, "notify_policy", "NotifyPolicy"}, {11, "private", "Private"},
+	{12, "expires", "Expires"}, {13, "interval", "Interval"}, {14, "method", "Method"}, {15, "protocol", "Protocol"},
+	{16, "proxy", "Proxy"}, {17, "resolver", "Resolver"}, {18, "family", "Family"}, {19, "token", "Token"},
+	{20, "grace", "Grace"}, {21, "service", "Service"}, {22, "message", "Message"}, {23, "ping", "Ping"},
+	{24, "start_tls", "StartTLS"}, {25, "topic", "Topic"}, {26, "min_isr", "MinISR"},
+	{27, "max_offset", "MaxOffset"}, {28, "bind_dn", "BindDN"}, {29, "password", "Password"},
+	{30, "expect", "Expect"}, {31, "headers", "Headers"}, {32, "export", "Export"}, {33, "priority", "Priority"},
+	{34, "sample", "Sample"}, {35, "check_ocsp", "CheckOCSP"}, {36, "weight", "Weight"}, {37, "record", "Record"},
+	{38, "resolvers", "Resolvers"}, {39, "paused", "Paused"},
+}}
+
+var adminProto = &pbFile{Name: "admin.proto", Package: "statusmonitor.admin.v1",
+	Deps: []string{"google/protobuf/timestamp.proto", "google/protobuf/empty.proto", "google/protobuf/field_mask.proto"},
+	Enums: []*pbEnum{{Name: "State", Type: reflect.TypeOf(StateNeverChecked), Values: []string{
+		"STATE_NEVER_CHECKED", "STATE_UP", "STATE_DEGRADED", "STATE_DOWN", "STATE_INVALID",
+	}}},
+	Messages: []*pbMessage{
+		resConfMessage,
+		{Name: "Status", Type: reflect.TypeOf(Status{}), Fields: []pbField{
+			{1, "when", "When"}, {2, "status_code", "StatusCode"}, {3, "ttfb_ns", "TTFB"},
+			{4, "latency_ns", "Latency"}, {5, "error", "Error"}, {6, "state", "State"}, {7, "slow", "Slow"},
+			{8, "degraded", "Degraded"}, {9, "expected", "Expected"}, {10, "details", "Details"},
+			{11, "sample", "Sample"}, {12, "proto", "Proto"}, {13, "tls_version", "TLSVersion"},
+			{14, "cert_issuer", "CertIssuer"}, {15, "remote_ip", "RemoteIP"}, {16, "cert", "Cert"},
+		}},
+		{Name: "Sample", Type: reflect.TypeOf(Sample{}), Fields: []pbField{
+			{1, "period_ns", "Period"}, {2, "count", "Count"}, {3, "errors", "Errors"},
+			{4, "min_latency_ns", "MinLatency"}, {5, "max_latency_ns", "MaxLatency"},
+		}},
+		{Name: "CertReport", Type: reflect.TypeOf(CertReport{}), Fields: []pbField{
+			{1, "subject", "Subject"}, {2, "not_after", "NotAfter"}, {3, "ocsp", "OCSP"},
+			{4, "stapled", "Stapled"}, {5, "warnings", "Warnings"},
+		}},
+		{Name: "Resource", Type: reflect.TypeOf(pbResource{}), Fields: []pbField{
+			{1, "conf", "Conf"}, {2, "status", "Status"}, {3, "paused", "Paused"}, {4, "acked_by", "AckedBy"},
+			{5, "uptime", "Uptime"}, {6, "coverage", "Coverage"},
+		}},
+		{Name: "AddRequest", Type: reflect.TypeOf(pbAddRequest{}), Fields: []pbField{{1, "conf", "Conf"}}},
+		{Name: "RemoveRequest", Type: reflect.TypeOf(pbRemoveRequest{}), Fields: []pbField{
+			{1, "name", "Name"}, {2, "address", "Address"},
+		}, Oneofs: map[string][]int{"key": {1, 2}}},
+		{Name: "RemoveResponse", Type: reflect.TypeOf(pbRemoveResponse{}), Fields: []pbField{{1, "removed", "Removed"}}},
+		{Name: "UpdateRequest", Type: reflect.TypeOf(pbUpdateRequest{}), Fields: []pbField{
+			{1, "name", "Name"}, {2, "conf", "Conf"}, {3, "update_mask", "UpdateMask"},
+		}},
+		{Name: "ListRequest", Type: reflect.TypeOf(ListRequest{}), Fields: []pbField{{1, "tag", "Tag"}}},
+		{Name: "ListResponse", Type: reflect.TypeOf(pbListResponse{}), Fields: []pbField{{1, "resources", "Resources"}}},
+		{Name: "GetRequest", Type: reflect.TypeOf(GetRequest{}), Fields: []pbField{{1, "name", "Name"}}},
+		{Name: "PauseRequest", Type: reflect.TypeOf(PauseRequest{}), Fields: []pbField{
+			{1, "name", "Name"}, {2, "resume", "Resume"},
+		}},
+		{Name: "AckRequest", Type: reflect.TypeOf(AckRequest{}), Fields: []pbField{{1, "name", "Name"}, {2, "user", "User"}}},
+		{Name: "AckResponse", Type: reflect.TypeOf(pbAckResponse{})},
+		{Name: "WatchRequest", Type: reflect.TypeOf(WatchRequest{}), Fields: []pbField{
+			{1, "name", "Name"}, {2, "every_ns", "Every"}, {3, "for_ns", "For"},
+		}},
+		{Name: "PreviewRequest", Type: reflect.TypeOf(PreviewRequest{}), Fields: []pbField{
+			{1, "name", "Name"}, {2, "from", "From"}, {3, "to", "To"}, {4, "slow", "Slow"},
+		}},
+		{Name: "Delivery", Type: reflect.TypeOf(Delivery{}), Fields: []pbField{
+			{1, "notifier", "Notifier"}, {2, "recipient", "Recipient"}, {3, "message", "Message"},
+		}},
+		{Name: "PreviewResponse", Type: reflect.TypeOf(PreviewResult{}), Fields: []pbField{
+			{1, "transition", "Transition"}, {2, "deliveries", "Deliveries"},
+		}},
+		{Name: "ExportResponse", Type: reflect.TypeOf(pbExportResponse{}), Fields: []pbField{{1, "confs", "Confs"}}},
+		{Name: "ImportRequest", Type: reflect.TypeOf(ImportRequest{}), Fields: []pbField{{1, "confs", "Configs"}}},
+		{Name: "ImportResponse", Type: reflect.TypeOf(ReloadResult{}), Fields: []pbField{
+			{1, "added", "Added"}, {2, "removed", "Removed"}, {3, "updated", "Updated"},
+		}},
+		{Name: "Contact", Type: reflect.TypeOf(Contact{}), Fields: []pbField{
+			{1, "name", "Name"}, {2, "phone", "Phone"}, {3, "pushover_user", "PushoverUser"},
+		}},
+		{Name: "OnCall", Type: reflect.TypeOf(OnCall{}), Fields: []pbField{
+			{1, "rotation", "Rotation"}, {2, "current", "Current"}, {3, "next", "Next"}, {4, "handoff", "Handoff"},
+		}},
+		{Name: "OnCallResponse", Type: reflect.TypeOf(pbOnCallResponse{}), Fields: []pbField{{1, "on_call", "OnCall"}}},
+		{Name: "DiagnosticsRequest", Type: reflect.TypeOf(DiagnosticsRequest{}), Fields: []pbField{
+			{1, "name", "Name"}, {2, "since_ns", "Since"},
+		}},
+		{Name: "DiagnosticsResponse", Type: reflect.TypeOf(pbDiagnosticsResponse{}), Fields: []pbField{
+			{1, "archive", "Archive"},
+		}},
+		{Name: "AgentHello", Type: reflect.TypeOf(AgentHello{}), Fields: []pbField{{1, "region", "Region"}}},
+		{Name: "AgentConfig", Type: reflect.TypeOf(AgentConfig{}), Fields: []pbField{
+			{1, "confs", "Configs"}, {2, "interval_ns", "Interval"},
+		}},
+		{Name: "AgentResult", Type: reflect.TypeOf(AgentResult{}), Fields: []pbField{
+			{1, "name", "Name"}, {2, "status", "Status"},
+		}},
+		{Name: "AgentReport", Type: reflect.TypeOf(AgentReport{}), Fields: []pbField{
+			{1, "region", "Region"}, {2, "results", "Results"}, {3, "seq", "Seq"},
+		}},
+		{Name: "AgentReportAck", Type: reflect.TypeOf(AgentReportAck{}), Fields: []pbField{
+			{1, "seq", "Seq"}, {2, "results", "Results"},
+		}},
+	},
+	Services: []*grpcService{adminService, agentService},
+}
+
+func unary(name string, in, out interface{}, f func(a *AdminServer, in interface{}) (interface{}, error)) *grpcMethod {
+	return &grpcMethod{Name: name, In: reflect.TypeOf(in), Out: reflect.TypeOf(out), Unary: f}
+}
+
+var adminService = &grpcService{Name: adminServiceName, Methods: []*grpcMethod{
+	unary("Add", pbAddRequest{}, pbResource{}, func(a *AdminServer, in interface{}) (interface{}, error) {
+		conf := in.(*pbAddRequest).Conf
+		if conf == nil {
+			return nil, &grpcError{grpcInvalidArgument, "no conf"}
+		}
+		var status int
+		if err := a.Add(conf, &status); err != nil {
+			return nil, err
+		}
+		return a.get(conf.Name)
+	}),
+	unary("Remove", pbRemoveRequest{}, pbRemoveResponse{}, func(a *AdminServer, in interface{}) (interface{}, error) {
+		r := in.(*pbRemoveRequest)
+		var args RemoveRequest
+		switch {
+		case r.Name != nil:
+			args = RemoveRequest{*r.Name, NameKeyType}
+		case r.Address != nil:
+			args = RemoveRequest{*r.Address, AddressKeyType}
+		default:
+			return nil, &grpcError{grpcInvalidArgument, "no name or address"}
+		}
+		var status int
+		err := a.Remove(args, &status)
+		return &pbRemoveResponse{status == 0}, err
+	}),
+	unary("Update", pbUpdateRequest{}, pbResource{}, func(a *AdminServer, in interface{}) (interface{}, error) {
+		r := in.(*pbUpdateRequest)
+		fields, err := updateFields(r.Conf, r.UpdateMask)
+		if err != nil {
+			return nil, &grpcError{grpcInvalidArgument, err.Error()}
+		}
+		var cfg ResConf
+		if err := a.Update(UpdateRequest{r.Name, fields}, &cfg); err != nil {
+			return nil, err
+		}
+		return a.get(cfg.Name)
+	}),
+	unary("List", ListRequest{}, pbListResponse{}, func(a *AdminServer, in interface{}) (interface{}, error) {
+		if err := a.allow(RoleViewer); err != nil {
+			return nil, err
+		}
+		var tags []string
+		if t := in.(*ListRequest).Tag; len(t) > 0 {
+			tags = append(tags, t)
+		}
+		ret := &pbListResponse{}
+		for _, el := range tagged(a.sc.Snapshot(), tags) {
+			ret.Resources = append(ret.Resources, a.resource(el))
+		}
+		return ret, nil
+	}),
+	unary("Get", GetRequest{}, pbResource{}, func(a *AdminServer, in interface{}) (interface{}, error) {
+		if err := a.allow(RoleViewer); err != nil {
+			return nil, err
+		}
+		return a.get(in.(*GetRequest).Name)
+	}),
+	unary("Pause", PauseRequest{}, pbResource{}, func(a *AdminServer, in interface{}) (interface{}, error) {
+		var cfg ResConf
+		if err := a.Pause(*in.(*PauseRequest), &cfg); err != nil {
+			return nil, err
+		}
+		return a.get(cfg.Name)
+	}),
+	unary("Ack", AckRequest{}, pbAckResponse{}, func(a *AdminServer, in interface{}) (interface{}, error) {
+		var status int
+		return &pbAckResponse{}, a.Ack(*in.(*AckRequest), &status)
+	}),
+	unary("Watch", WatchRequest{}, pbEmpty{}, func(a *AdminServer, in interface{}) (interface{}, error) {
+		var status int
+		return &pbEmpty{}, a.Watch(*in.(*WatchRequest), &status)
+	}),
+	unary("Preview", PreviewRequest{}, PreviewResult{}, func(a *AdminServer, in interface{}) (interface{}, error) {
+		var ret PreviewResult
+		return &ret, a.Preview(*in.(*PreviewRequest), &ret)
+	}),
+	unary("Export", pbEmpty{}, pbExportResponse{}, func(a *AdminServer, in interface{}) (interface{}, error) {
+		ret := &pbExportResponse{}
+		return ret, a.Export(0, &ret.Confs)
+	}),
+	unary("Import", ImportRequest{}, ReloadResult{}, func(a *AdminServer, in interface{}) (interface{}, error) {
+		var ret ReloadResult
+		return &ret, a.Import(*in.(*ImportRequest), &ret)
+	}),
+	unary("OnCall", pbEmpty{}, pbOnCallResponse{}, func(a *AdminServer, in interface{}) (interface{}, error) {
+		ret := &pbOnCallResponse{}
+		return ret, a.OnCall(0, &ret.OnCall)
+	}),
+	unary("Diagnostics", DiagnosticsRequest{}, pbDiagnosticsResponse{}, func(a *AdminServer, in interface{}) (interface{}, error) {
+		ret := &pbDiagnosticsResponse{}
+		return ret, a.Diagnostics(*in.(*DiagnosticsRequest), &ret.Archive)
+	}),
+}}
+
+func init() {
+	registerProto(adminProto, true)
+}
+
+// resource returns a resource as seen by a caller, redacted (see
+// redactConf) unless it's an admin.
+func (a *AdminServer) resource(h tmplHelper) *pbResource {
+	r := &pbResource{Conf: h.Conf, Paused: h.Conf.Paused, AckedBy: h.AckedBy}
+	r.Uptime, r.Coverage = a.sc.Uptime(h.Address, 24*time.Hour)
+	if !a.allows(RoleAdmin) {
+		r.Conf = redactConf(h.Conf)
+	}
+	if h.Status.Checked() {
+		r.Status = h.Status
+	}
+	return r
+}
+
+func (a *AdminServer) get(name string) (*pbResource, error) {
+	for _, el := range a.sc.Snapshot() {
+		if el.Name == name {
+			return a.resource(el), nil
+		}
+	}
+	return nil, &grpcError{grpcNotFound, fmt.Sprintf("no resource named %q", name)}
+}
+
+// updateFields returns a JSON object of fields of conf in a mask, as
+// patchResource takes, ones which aren't zero if a mask is empty.
+func updateFields(conf *ResConf, mask *pbFieldMask) ([]byte, error) {
+	if conf == nil {
+		return nil, fmt.Errorf("no conf")
+	}
+	v := reflect.ValueOf(conf).Elem()
+	fields := make(map[string]interface{})
+	if mask == nil || len(mask.Paths) == 0 {
+		for _, f := range resConfMessage.Fields {
+			if fv := v.FieldByName(f.Go); !fv.IsZero() {
+				fields[f.Go] = fv.Interface()
+			}
+		}
+	}
+	if mask != nil {
+		for _, path := range mask.Paths {
+			f := resConfField(path)
+			if f == nil {
+				return nil, fmt.Errorf("unknown field %q of ResConf", path)
+			}
+			fields[f.Go] = v.FieldByName(f.Go).Interface()
+		}
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("no fields to change")
+	}
+	return json.Marshal(fields)
+}
+
+// resConfField returns a field of ResConf by a proto name or by a Go one in
+// any case, as encoding/json matches it.
+func resConfField(name string) *pbField {
+	for i, f := range resConfMessage.Fields {
+		if f.Name == name || strings.EqualFold(f.Go, name) {
+			return &resConfMessage.Fields[i]
+		}
+	}
+	return nil
+}
+
+///////////////////////////////////////////////////////////////////////////////
+// Commands over gRPC: grpcAdmin takes AdminServer calls of net/rpc, so
+// commands work with both, see dialAdmin.
+///////////////////////////////////////////////////////////////////////////////
+
+// adminClient is an *rpc.Client or a grpcAdmin.
+type adminClient interface {
+	Call(serviceMethod string, args interface{}, reply interface{}) error
+	Close() error
+}
+
+type grpcAdmin struct {
+	*grpcClient
+}
+
+func (c grpcAdmin) invoke(method string, in, out interface{}) error {
+	return c.Invoke("/"+adminServiceName+"/"+method, in, out)
+}
+
+// Call calls a gRPC method of an AdminServer one, with its args and reply.
+func (c grpcAdmin) Call(serviceMethod string, args interface{}, reply interface{}) error {
+	var r pbResource
+	switch serviceMethod {
+	case "AdminServer.Add":
+		return c.invoke("Add", &pbAddRequest{args.(*ResConf)}, &r)
+	case "AdminServer.Remove":
+		rr := args.(RemoveRequest)
+		in := &pbRemoveRequest{Address: &rr.Key}
+		if rr.Type == NameKeyType {
+			in = &pbRemoveRequest{Name: &rr.Key}
+		}
+		var resp pbRemoveResponse
+		err := c.invoke("Remove", in, &resp)
+		if !resp.Removed {
+			*reply.(*int) = 1
+		}
+		return err
+	case "AdminServer.Update":
+		ur := args.(UpdateRequest)
+		in := &pbUpdateRequest{Name: ur.Name, Conf: &ResConf{}, UpdateMask: &pbFieldMask{}}
+		if err := json.Unmarshal(ur.Fields, in.Conf); err != nil {
+			return err
+		}
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(ur.Fields, &fields); err != nil {
+			return err
+		}
+		for name := range fields {
+			f := resConfField(name)
+			if f == nil {
+				return fmt.Errorf("unknown field %q of ResConf", name)
+			}
+			in.UpdateMask.Paths = append(in.UpdateMask.Paths, f.Name)
+		}
+		if err := c.invoke("Update", in, &r); err != nil {
+			return err
+		}
+		*reply.(*ResConf) = *r.Conf
+		return nil
+	case "AdminServer.Pause":
+		if err := c.invoke("Pause", ptr(args), &r); err != nil {
+			return err
+		}
+		*reply.(*ResConf) = *r.Conf
+		return nil
+	case "AdminServer.List":
+		var resp pbListResponse
+		if err := c.invoke("List", ptr(args), &resp); err != nil {
+			return err
+		}
+		ret := []*apiStatus{}
+		for _, r := range resp.Resources {
+			ret = append(ret, r.apiStatus())
+		}
+		*reply.(*[]*apiStatus) = ret
+		return nil
+	case "AdminServer.Ack":
+		return c.invoke("Ack", ptr(args), &pbAckResponse{})
+	case "AdminServer.Watch":
+		return c.invoke("Watch", ptr(args), &pbEmpty{})
+	case "AdminServer.Preview":
+		return c.invoke("Preview", ptr(args), reply)
+	case "AdminServer.Export":
+		var resp pbExportResponse
+		err := c.invoke("Export", &pbEmpty{}, &resp)
+		*reply.(*[]*ResConf) = resp.Confs
+		return err
+	case "AdminServer.Import":
+		return c.invoke("Import", ptr(args), reply)
+	case "AdminServer.OnCall":
+		var resp pbOnCallResponse
+		err := c.invoke("OnCall", &pbEmpty{}, &resp)
+		*reply.(*[]*OnCall) = resp.OnCall
+		return err
+	case "AdminServer.Diagnostics":
+		var resp pbDiagnosticsResponse
+		err := c.invoke("Diagnostics", ptr(args), &resp)
+		*reply.(*[]byte) = resp.Archive
+		return err
+	}
+	return fmt.Errorf("gRPC: no method of %s", serviceMethod)
+}
+
+// ptr returns a pointer to a copy of a struct passed by value.
+func ptr(v interface{}) interface{} {
+	p := reflect.New(reflect.TypeOf(v))
+	p.Elem().Set(reflect.ValueOf(v))
+	return p.Interface()
+}
+
+// apiStatus returns a resource as in -mode list.
+func (r *pbResource) apiStatus() *apiStatus {
+	c := r.Conf
+	if c == nil {
+		c = &ResConf{}
+	}
+	a := &apiStatus{Name: c.Name, Address: c.Address, Type: c.Type, Group: c.Group, Tags: c.Tags,
+		State: r.Status.State(), AckedBy: r.AckedBy, Uptime: r.Uptime, Coverage: r.Coverage}
+	if st := r.Status; st != nil {
+		a.When, a.StatusCode, a.TTFB, a.Latency, a.Error = st.When, st.StatusCode, st.TTFB, st.Latency, st.Error
+	}
+	return a
+}
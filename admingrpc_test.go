@@ -0,0 +1,414 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// protoDecls returns declarations of a .proto file by a message, an enum or
+// a service name, e.g. "repeated string notify = 8" of ResConf.
+func protoDecls(t *testing.T, path string) map[string][]string {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	decls := make(map[string][]string)
+	block := regexp.MustCompile(`^(message|enum|service) (\w+) \{(\})?$`)
+	var name string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		switch m := block.FindStringSubmatch(line); {
+		case m != nil:
+			name = m[2]
+			decls[name] = []string{}
+			if len(m[3]) > 0 {
+				name = ""
+			}
+		case line == "}" && !strings.HasPrefix(s.Text(), " "):
+			name = ""
+		case len(name) > 0 && len(line) > 0 && !strings.HasPrefix(line, "//") && !strings.HasSuffix(line, "{") && line != "}":
+			decls[name] = append(decls[name], strings.TrimSuffix(line, ";"))
+		}
+	}
+	if err := s.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return decls
+}
+
+// schemaDecls returns declarations of a file of the schema as protoDecls
+// does.
+func schemaDecls(f *pbFile) map[string][]string {
+	d := f.descriptor()
+	typeName := func(fd *pbFieldDescriptorProto) string {
+		if len(fd.TypeName) > 0 {
+			return strings.TrimPrefix(strings.TrimPrefix(fd.TypeName, "."+f.Package+"."), ".")
+		}
+		return map[int32]string{pbTypeDouble: "double", pbTypeInt64: "int64", pbTypeInt32: "int32",
+			pbTypeBool: "bool", pbTypeString: "string", pbTypeBytes: "bytes"}[fd.Type]
+	}
+	decls := make(map[string][]string)
+	for _, m := range d.MessageType {
+		decls[m.Name] = []string{}
+		for _, fd := range m.Field {
+			decl := typeName(fd)
+			for _, entry := range m.NestedType {
+				if decl == m.Name+"."+entry.Name {
+					decl = fmt.Sprintf("map<%s, %s>", typeName(entry.Field[0]), typeName(entry.Field[1]))
+				}
+			}
+			if fd.Label == 3 && !strings.HasPrefix(decl, "map<") {
+				decl = "repeated " + decl
+			}
+			decls[m.Name] = append(decls[m.Name], fmt.Sprintf("%s %s = %d", decl, fd.Name, fd.Number))
+		}
+	}
+	for _, e := range d.EnumType {
+		for _, v := range e.Value {
+			decls[e.Name] = append(decls[e.Name], fmt.Sprintf("%s = %d", v.Name, v.Number))
+		}
+	}
+	for _, s := range d.Service {
+		for _, m := range s.Method {
+			in, out := strings.TrimPrefix(m.InputType, "."), strings.TrimPrefix(m.OutputType, ".")
+			in, out = strings.TrimPrefix(in, f.Package+"."), strings.TrimPrefix(out, f.Package+".")
+			if m.ClientStreaming {
+				in = "stream " + in
+			}
+			if m.ServerStreaming {
+				out = "stream " + out
+			}
+			decls[s.Name] = append(decls[s.Name], fmt.Sprintf("rpc %s(%s) returns (%s)", m.Name, in, out))
+		}
+	}
+	return decls
+}
+
+func TestAdminProto(t *testing.T) {
+	proto := protoDecls(t, "admin.proto")
+	for name, want := range schemaDecls(adminProto) {
+		got, ok := proto[name]
+		if !ok {
+			t.Errorf("admin.proto: no %s", name)
+			continue
+		}
+		// Oneofs are flattened.
+		var flat []string
+		for _, decl := range got {
+			if !strings.HasPrefix(decl, "oneof ") {
+				flat = append(flat, decl)
+			}
+		}
+		if strings.Join(flat, "; ") != strings.Join(want, "; ") {
+			t.Errorf("admin.proto: %s\n%s\nwant\n%s", name, strings.Join(flat, "\n"), strings.Join(want, "\n"))
+		}
+	}
+}
+
+// jsonString marshals v for comparisons, unexported fields and time zones
+// aside.
+func jsonString(t *testing.T, v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestProtobufRoundTrip(t *testing.T) {
+	var resources []*pbResource
+	for i := range yamlStrings {
+		c := &ResConf{}
+		fillResConf(t, c, i)
+		resources = append(resources, &pbResource{Conf: c, Paused: true, AckedBy: yamlStrings[i], Uptime: 99.5})
+	}
+	resources = append(resources, &pbResource{}, &pbResource{Conf: &ResConf{Name: "minimal", MinISR: -1}, Status: &Status{
+		When: time.Date(1960, 1, 2, 3, 4, 5, 6, time.UTC), StatusCode: 503, TTFB: time.Millisecond, Latency: -1,
+		Slow: SlowTotal, Expected: 204, Details: []string{"a", "", "c"},
+		Sample: &Sample{Period: time.Minute, Count: 10, Errors: 1, MinLatency: 1, MaxLatency: 1 << 40},
+		Cert:   &CertReport{Subject: "CN=x", NotAfter: time.Unix(1<<33, 999999999).UTC(), Stapled: true},
+	}})
+	for _, r := range resources {
+		b, err := pbMarshal(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got pbResource
+		if err := pbUnmarshal(b, &got); err != nil {
+			t.Fatalf("%+v: %s", r.Conf, err)
+		}
+		if got.Status != nil {
+			got.Status.When = got.Status.When.UTC()
+			if got.Status.Cert != nil {
+				got.Status.Cert.NotAfter = got.Status.Cert.NotAfter.UTC()
+			}
+		}
+		if got.Conf != nil {
+			got.Conf.Expires = got.Conf.Expires.UTC()
+		}
+		if g, w := jsonString(t, &got), jsonString(t, r); g != w {
+			t.Errorf("round trip\n%s\nwant\n%s", g, w)
+		}
+	}
+}
+
+func TestProtobufDecode(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		b    []byte
+		want string
+		err  string
+	}{
+		{"unknown fields skipped", []byte{0x50, 0x01, 0x5a, 0x01, 'x', 0x61, 1, 2, 3, 4, 5, 6, 7, 8, 0x6d, 1, 2, 3, 4, 0x12, 0x01, 'e'},
+			`{"Added":null,"Removed":["e"],"Updated":null}`, ""},
+		{"truncated length", []byte{0x0a, 0x05, 'a'}, "", "malformed protobuf message"},
+		{"bad varint", []byte{0x50, 0xff}, "", "malformed protobuf message"},
+		{"group", []byte{0x0b}, "", "protobuf: wire type 3 isn't supported"},
+		{"wrong wire type", []byte{0x08, 0x01}, "", "added: wire type 0 of string"},
+	} {
+		var r ReloadResult
+		err := pbUnmarshal(tc.b, &r)
+		switch {
+		case len(tc.err) > 0 && (err == nil || err.Error() != tc.err):
+			t.Errorf("%s: error %v, want %s", tc.name, err, tc.err)
+		case len(tc.err) == 0 && err != nil:
+			t.Errorf("%s: %s", tc.name, err)
+		case len(tc.err) == 0 && jsonString(t, &r) != tc.want:
+			t.Errorf("%s: %s, want %s", tc.name, jsonString(t, &r), tc.want)
+		}
+	}
+	// Packed repeated fields, protoc packs scalars.
+	var ext pbExtensionNumberResponse
+	if err := pbUnmarshal([]byte{0x12, 0x03, 1, 0x96, 0x01, 0x10, 0x05}, &ext); err != nil || fmt.Sprint(ext.ExtensionNumber) != "[1 150 5]" {
+		t.Errorf("packed %v, %v", ext.ExtensionNumber, err)
+	}
+}
+
+// newGRPCTestServer serves gRPC services over h2c with keys and makes
+// commands send to it.
+func newGRPCTestServer(t *testing.T, sc *StatusChecker, keys []*ApiKey) {
+	mux := http.NewServeMux()
+	for _, s := range []*grpcService{adminService, agentService, reflectionService} {
+		for _, m := range s.Methods {
+			mux.HandleFunc("/"+s.Name+"/"+m.Name, grpcHandler(sc, m))
+		}
+	}
+	srv := httptest.NewUnstartedServer(RequireKey(keys, mux))
+	srv.Config.Protocols = new(http.Protocols)
+	srv.Config.Protocols.SetUnencryptedHTTP2(true)
+	srv.Start()
+	oldAddr, oldAdmin, oldKey := *addr, *adminAddr, *apiKey
+	*addr, *adminAddr = srv.Listener.Addr().String(), ""
+	t.Cleanup(func() {
+		srv.Close()
+		*addr, *adminAddr, *apiKey = oldAddr, oldAdmin, oldKey
+		openAccess = false
+	})
+}
+
+func TestGRPCAdmin(t *testing.T) {
+	sc := NewStatusChecker(&Config{Configs: []*ResConf{
+		{Name: "web", Address: "http://user:pw@example.com/", Headers: map[string]string{"Authorization": "secret"}},
+	}})
+	newGRPCTestServer(t, sc, []*ApiKey{{Name: "adm", Key: "adminkey", Role: RoleAdmin}, {Name: "view", Key: "viewkey", Role: RoleViewer},
+		{Name: "op", Key: "opkey", Role: RoleOperator}})
+	c, err := dialGRPC()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	admin := grpcAdmin{c}
+
+	var r pbResource
+	var ge *grpcError
+	*apiKey = ""
+	if err := admin.invoke("Get", &GetRequest{"web"}, &r); err == nil || err.Error() != "API key required, use -key or STATUSMONITOR_KEY" {
+		t.Errorf("anonymous: %v", err)
+	}
+	*apiKey = "viewkey"
+	if err := admin.invoke("Get", &GetRequest{"web"}, &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Conf.Address != "http://REDACTED@example.com/" || r.Conf.Headers["Authorization"] != "REDACTED" || r.Status != nil {
+		t.Errorf("viewer got %+v", r.Conf)
+	}
+	if err := admin.invoke("Get", &GetRequest{"nope"}, &r); !errors.As(err, &ge) || ge.code != grpcNotFound {
+		t.Errorf("not found: %v", err)
+	}
+	if err := admin.Call("AdminServer.Add", &ResConf{Name: "api", Address: "http://example.com/api"}, new(int)); !errors.As(err, &ge) ||
+		ge.code != grpcPermissionDenied || ge.msg != "admin role required" {
+		t.Errorf("viewer added: %v", err)
+	}
+	if err := admin.Call("AdminServer.Preview", PreviewRequest{Name: "web", To: "DOWN"}, &PreviewResult{}); !errors.As(err, &ge) || ge.code != grpcPermissionDenied {
+		t.Errorf("viewer previewed: %v", err)
+	}
+	if err := admin.Call("AdminServer.OnCall", 0, new([]*OnCall)); !errors.As(err, &ge) || ge.code != grpcPermissionDenied {
+		t.Errorf("viewer got on-call shifts: %v", err)
+	}
+
+	*apiKey = "opkey"
+	var paused ResConf
+	if err := admin.Call("AdminServer.Pause", PauseRequest{Name: "web"}, &paused); err != nil || !paused.Paused ||
+		paused.Headers["Authorization"] != "REDACTED" || paused.Address != "http://REDACTED@example.com/" {
+		t.Errorf("operator paused %+v, %v", paused, err)
+	}
+	if err := admin.Call("AdminServer.OnCall", 0, new([]*OnCall)); err != nil {
+		t.Errorf("operator on-call shifts: %v", err)
+	}
+	// Over net/rpc too.
+	op := &AdminServer{sc: sc, caller: &Caller{Name: "op", Role: RoleOperator}}
+	if err := op.Pause(PauseRequest{Name: "web", Resume: true}, &paused); err != nil || paused.Headers["Authorization"] != "REDACTED" {
+		t.Errorf("operator resumed %+v, %v", paused, err)
+	}
+
+	*apiKey = "adminkey"
+	if err := admin.invoke("Get", &GetRequest{"web"}, &r); err != nil || r.Conf.Headers["Authorization"] != "secret" {
+		t.Errorf("admin got %+v, %v", r.Conf, err)
+	}
+	if err := admin.Call("AdminServer.Add", &ResConf{Name: "api", Address: "http://example.com/api"}, new(int)); err != nil {
+		t.Fatal(err)
+	}
+	var cfg ResConf
+	if err := admin.Call("AdminServer.Update", UpdateRequest{"api", []byte(`{"interval": "30s", "Tags": {"a": "b"}}`)}, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Interval != "30s" || cfg.Tags["a"] != "b" || cfg.Address != "http://example.com/api" {
+		t.Errorf("updated %+v", cfg)
+	}
+	if err := admin.Call("AdminServer.Update", UpdateRequest{"api", []byte(`{"Nope": 1}`)}, &cfg); err == nil || err.Error() != `unknown field "Nope" of ResConf` {
+		t.Errorf("unknown field: %v", err)
+	}
+	// Fields set are changed without a mask.
+	in := &pbUpdateRequest{Name: "api", Conf: &ResConf{Group: "g"}}
+	if err := admin.invoke("Update", in, &r); err != nil || r.Conf.Group != "g" || r.Conf.Interval != "30s" {
+		t.Errorf("updated %+v, %v", r.Conf, err)
+	}
+	if err := admin.Call("AdminServer.Pause", PauseRequest{Name: "api"}, &cfg); err != nil || !cfg.Paused {
+		t.Errorf("paused %+v, %v", cfg, err)
+	}
+	var list []*apiStatus
+	if err := admin.Call("AdminServer.List", ListRequest{"a=b"}, &list); err != nil || len(list) != 1 || list[0].Name != "api" {
+		t.Errorf("list %+v, %v", list, err)
+	}
+	var removed int
+	if err := admin.Call("AdminServer.Remove", RemoveRequest{"http://example.com/api", AddressKeyType}, &removed); err != nil || removed != 0 {
+		t.Errorf("removed %d, %v", removed, err)
+	}
+	if err := admin.Call("AdminServer.Remove", RemoveRequest{"api", NameKeyType}, &removed); err != nil || removed != 1 {
+		t.Errorf("removed twice %d, %v", removed, err)
+	}
+}
+
+func TestGRPCReflection(t *testing.T) {
+	newGRPCTestServer(t, NewStatusChecker(nil), nil)
+	c, err := dialGRPC()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	const method = "/grpc.reflection.v1.ServerReflection/ServerReflectionInfo"
+
+	var resp pbReflectionResponse
+	empty := ""
+	if err := c.Invoke(method, &pbReflectionRequest{ListServices: &empty}, &resp); err != nil {
+		t.Fatal(err)
+	}
+	var services []string
+	for _, s := range resp.ListServicesResponse.Service {
+		services = append(services, s.Name)
+	}
+	sort.Strings(services)
+	if got := strings.Join(services, " "); got != "grpc.reflection.v1.ServerReflection statusmonitor.admin.v1.AdminService statusmonitor.admin.v1.AgentService" {
+		t.Errorf("services %s", got)
+	}
+
+	symbol := "statusmonitor.admin.v1.AdminService.Pause"
+	resp = pbReflectionResponse{}
+	if err := c.Invoke(method, &pbReflectionRequest{FileContainingSymbol: &symbol}, &resp); err != nil {
+		t.Fatal(err)
+	}
+	var files []string
+	for _, b := range resp.FileDescriptorResponse.FileDescriptorProto {
+		var fd pbFileDescriptorProto
+		if err := pbUnmarshal(b, &fd); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, fd.Name)
+	}
+	if got := strings.Join(files, " "); got != "admin.proto google/protobuf/timestamp.proto google/protobuf/empty.proto google/protobuf/field_mask.proto" {
+		t.Errorf("files %s", got)
+	}
+
+	symbol = "statusmonitor.admin.v1.Nope"
+	resp = pbReflectionResponse{}
+	if err := c.Invoke(method, &pbReflectionRequest{FileContainingSymbol: &symbol}, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if e := resp.ErrorResponse; e == nil || e.ErrorCode != grpcNotFound || e.ErrorMessage != `symbol "statusmonitor.admin.v1.Nope" not found` {
+		t.Errorf("error %+v", e)
+	}
+}
+
+func TestAgentReports(t *testing.T) {
+	sc := NewStatusChecker(&Config{Configs: []*ResConf{{Name: "web", Address: "http://example.com/"}}})
+	newGRPCTestServer(t, sc, []*ApiKey{{Name: "probe", Key: "agentkey", Role: RoleAgent}, {Name: "view", Key: "viewkey", Role: RoleViewer}})
+	c, err := dialGRPC()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	method := "/" + agentService.Name + "/Report"
+
+	*apiKey = "viewkey"
+	s, err := c.Stream(method)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ge *grpcError
+	if err := s.Recv(&AgentReportAck{}); !errors.As(err, &ge) || ge.code != grpcPermissionDenied {
+		t.Errorf("viewer reported: %v", err)
+	}
+	s.Close()
+
+	*apiKey = "agentkey"
+	var conf AgentConfig
+	if err := c.Invoke("/"+agentService.Name+"/Config", &AgentHello{"eu"}, &conf); err != nil || len(conf.Configs) != 1 {
+		t.Fatalf("config %+v, %v", conf, err)
+	}
+	if s, err = c.Stream(method); err != nil {
+		t.Fatal(err)
+	}
+	when := time.Now().Add(-time.Minute).Truncate(time.Second)
+	for seq := int64(1); seq <= 2; seq++ {
+		st := &Status{When: when.Add(time.Duration(seq) * time.Second), StatusCode: 200}
+		if err := s.Send(&AgentReport{"eu", []*AgentResult{{"web", st}}, seq}); err != nil {
+			t.Fatal(err)
+		}
+		var ack AgentReportAck
+		if err := s.Recv(&ack); err != nil || ack.Seq != seq || ack.Results != 1 {
+			t.Errorf("ack %+v, %v", ack, err)
+		}
+	}
+	s.w.Close()
+	if err := s.Recv(&AgentReportAck{}); err != io.EOF {
+		t.Errorf("end of a stream: %v", err)
+	}
+	s.Close()
+	r := sc.Regions()
+	if len(r.Agents) != 1 || r.Agents[0].Region != "eu" || r.Resources[0].States["eu"] != StateUp {
+		t.Errorf("regions %+v %+v", r.Agents, r.Resources[0])
+	}
+}
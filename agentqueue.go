@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// A queue of agent reports: an agent checks on while a server is
+// unreachable and replays queued reports, with results of their original
+// times, once it's back. Reports are files in -agent-queue, one per
+// report, so they outlive an outage and a restart of an agent, unless
+// -agent-queue-memory keeps them in memory only.
+///////////////////////////////////////////////////////////////////////////////
+
+var (
+	agentQueueDir    = flag.String("agent-queue", "agent-queue", "A directory of agent reports queued while a server is unreachable.")
+	agentQueueMemory = flag.Bool("agent-queue-memory", false, "Queue agent reports in memory only, they're lost when an agent stops.")
+	agentQueueMax    = flag.Int("agent-queue-max", 10000, "How many agent reports are queued at most, the oldest are dropped.")
+)
+
+type agentQueue struct {
+	dir  string
+	max  int
+	seqs []int64 // ascending
+	next int64
+	mem  map[int64]*AgentReport // reports not in files
+}
+
+// openAgentQueue opens a queue of reports in dir, in memory if it's empty.
+func openAgentQueue(dir string, max int) (*agentQueue, error) {
+	q := &agentQueue{dir: dir, max: max, next: 1, mem: make(map[int64]*AgentReport)}
+	if len(dir) == 0 {
+		return q, nil
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		seq, err := strconv.ParseInt(strings.TrimSuffix(f.Name(), ".json"), 10, 64)
+		if err != nil || !strings.HasSuffix(f.Name(), ".json") {
+			continue
+		}
+		q.seqs = append(q.seqs, seq)
+	}
+	sort.Slice(q.seqs, func(i, j int) bool { return q.seqs[i] < q.seqs[j] })
+	if len(q.seqs) > 0 {
+		q.next = q.seqs[len(q.seqs)-1] + 1
+		log.Printf("Agent queue %s: %d reports", dir, len(q.seqs))
+	}
+	return q, nil
+}
+
+func (q *agentQueue) path(seq int64) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%020d.json", seq))
+}
+
+func (q *agentQueue) len() int {
+	return len(q.seqs)
+}
+
+// push queues a report with a next Seq, dropping the oldest ones over max.
+// A report which can't be written is kept in memory.
+func (q *agentQueue) push(r *AgentReport) error {
+	r.Seq = q.next
+	q.next++
+	q.seqs = append(q.seqs, r.Seq)
+	for len(q.seqs) > max(q.max, 1) {
+		log.Printf("Agent queue full, report %d dropped", q.seqs[0])
+		q.remove(q.seqs[0])
+	}
+	if len(q.dir) == 0 {
+		q.mem[r.Seq] = r
+		return nil
+	}
+	b, err := json.Marshal(r)
+	if err == nil {
+		tmp := q.path(r.Seq) + ".tmp"
+		if err = ioutil.WriteFile(tmp, b, 0600); err == nil {
+			err = os.Rename(tmp, q.path(r.Seq))
+		}
+	}
+	if err != nil {
+		q.mem[r.Seq] = r
+	}
+	return err
+}
+
+// load returns a queued report.
+func (q *agentQueue) load(seq int64) (*AgentReport, error) {
+	if r, ok := q.mem[seq]; ok {
+		return r, nil
+	}
+	b, err := ioutil.ReadFile(q.path(seq))
+	if err != nil {
+		return nil, err
+	}
+	r := &AgentReport{}
+	if err := json.Unmarshal(b, r); err != nil {
+		return nil, fmt.Errorf("%s: %s", q.path(seq), err)
+	}
+	r.Seq = seq
+	return r, nil
+}
+
+// remove removes a report, e.g. acknowledged by a server.
+func (q *agentQueue) remove(seq int64) {
+	for i, s := range q.seqs {
+		if s == seq {
+			q.seqs = append(q.seqs[:i], q.seqs[i+1:]...)
+			break
+		}
+	}
+	if _, ok := q.mem[seq]; ok {
+		delete(q.mem, seq)
+		return
+	}
+	if len(q.dir) > 0 {
+		if err := os.Remove(q.path(seq)); err != nil && !os.IsNotExist(err) {
+			log.Printf("Agent queue: %s", err)
+		}
+	}
+}
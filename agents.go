@@ -0,0 +1,368 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// Probe agents: -mode agent checks resources of a server from another
+// network location (-region) and streams results back over AgentService of
+// gRPC, every -interval of the server. Reports are queued (agentqueue.go)
+// while the server is unreachable and replayed later, so uptime of each
+// region in /api/regions, next to its states, has no gaps.
+///////////////////////////////////////////////////////////////////////////////
+
+var region = flag.String("region", hostname(), "A location an agent checks from, a host name by default.")
+
+// agentRetry is how long an agent waits before reconnecting.
+const agentRetry = 10 * time.Second
+
+// RegionLocal is a region of checks of a server itself.
+const RegionLocal = "local"
+
+type AgentHello struct {
+	Region string
+}
+
+type AgentConfig struct {
+	Configs  []*ResConf
+	Interval time.Duration
+}
+
+type AgentReport struct {
+	Region  string
+	Results []*AgentResult
+	Seq     int64 // of a report of an agent, acknowledged by AgentReportAck
+}
+
+type AgentReportAck struct {
+	Seq     int64
+	Results int // recorded
+}
+
+type AgentResult struct {
+	Name   string
+	Status *Status
+}
+
+type agentRegion struct {
+	lastSeen time.Time
+	statuses map[string]*Status   // the newest ones, by resource name
+	history  map[string][]*Status // by resource name, ordered by When
+}
+
+var agentService = &grpcService{Name: "statusmonitor.admin.v1.AgentService", Methods: []*grpcMethod{
+	unary("Config", AgentHello{}, AgentConfig{}, func(a *AdminServer, in interface{}) (interface{}, error) {
+		var ret AgentConfig
+		return &ret, a.AgentConfig(*in.(*AgentHello), &ret)
+	}),
+	{Name: "Report", In: reflect.TypeOf(AgentReport{}), Out: reflect.TypeOf(AgentReportAck{}), Stream: agentReports},
+}}
+
+// agentReports records reports of a stream, acknowledging each.
+func agentReports(a *AdminServer, s *grpcStream) error {
+	if err := a.allowAgent(); err != nil {
+		return err
+	}
+	for {
+		var r AgentReport
+		if err := s.Recv(&r); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		a.sc.AgentReport(&r)
+		if err := s.Send(&AgentReportAck{r.Seq, len(r.Results)}); err != nil {
+			return err
+		}
+	}
+}
+
+func hostname() string {
+	name, _ := os.Hostname()
+	return name
+}
+
+// AgentConfigs returns resources agents check.
+func (s *StatusChecker) AgentConfigs() *AgentConfig {
+	s.m.Lock()
+	defer s.m.Unlock()
+	ret := &AgentConfig{Interval: *interval}
+	for _, c := range s.config.Configs {
+		if !c.chained() && !c.Paused && c.validate() == nil {
+			ret.Configs = append(ret.Configs, c)
+		}
+	}
+	return ret
+}
+
+// AgentReport records results of an agent. Reports queued by an agent
+// while a server was unreachable come late, so results are put in a
+// history by When and a result sent twice is recorded once.
+func (s *StatusChecker) AgentReport(r *AgentReport) {
+	s.statusMutex.Lock()
+	defer s.statusMutex.Unlock()
+	ar, ok := s.regions[r.Region]
+	if !ok {
+		slog.Info("Agent reported", "region", r.Region, "resources", len(r.Results))
+		ar = &agentRegion{statuses: make(map[string]*Status), history: make(map[string][]*Status)}
+		s.regions[r.Region] = ar
+	}
+	ar.lastSeen = time.Now()
+	for _, el := range r.Results {
+		if el.Status == nil {
+			continue
+		}
+		if last, ok := ar.statuses[el.Name]; !ok || !el.Status.When.Before(last.When) {
+			ar.statuses[el.Name] = el.Status
+		}
+		h := ar.history[el.Name]
+		i := sort.Search(len(h), func(i int) bool { return !h[i].When.Before(el.Status.When) })
+		if i < len(h) && h[i].When.Equal(el.Status.When) {
+			continue
+		}
+		h = append(h, nil)
+		copy(h[i+1:], h[i:])
+		h[i] = el.Status
+		if len(h) > historySize {
+			h = h[len(h)-historySize:]
+		}
+		ar.history[el.Name] = h
+	}
+}
+
+// agentStale is true if an agent didn't report for 3 intervals.
+func agentStale(lastSeen time.Time) bool {
+	return time.Since(lastSeen) > 3**interval
+}
+
+type apiAgent struct {
+	Region   string
+	LastSeen time.Time
+	Stale    bool
+}
+
+type apiRegionStates struct {
+	Name    string
+	Address string
+	States  map[string]State // by region, RegionLocal for this server
+	// Uptime and Coverage of the last 24h by region, with reports an agent
+	// queued while this server was unreachable.
+	Uptime   map[string]float64
+	Coverage map[string]float64
+	// Disagree is true if checked resources have different states in some
+	// regions.
+	Disagree bool
+}
+
+type apiRegions struct {
+	Agents    []*apiAgent
+	Resources []*apiRegionStates
+}
+
+// Regions returns agents and states of resources by region, states of
+// stale agents are left out.
+func (s *StatusChecker) Regions() *apiRegions {
+	ret := &apiRegions{Agents: []*apiAgent{}, Resources: []*apiRegionStates{}}
+	snapshot := s.Snapshot()
+	to := time.Now()
+	from := to.Add(-24 * time.Hour)
+	localFrom := from
+	if localFrom.Before(startTime) {
+		localFrom = startTime
+	}
+	s.statusMutex.Lock()
+	defer s.statusMutex.Unlock()
+	for name, r := range s.regions {
+		ret.Agents = append(ret.Agents, &apiAgent{name, r.lastSeen, agentStale(r.lastSeen)})
+	}
+	sort.Slice(ret.Agents, func(i, j int) bool { return ret.Agents[i].Region < ret.Agents[j].Region })
+	for _, el := range snapshot {
+		rs := &apiRegionStates{Name: el.Name, Address: redactAddress(el.Address), States: map[string]State{RegionLocal: el.Status.State()},
+			Uptime: make(map[string]float64), Coverage: make(map[string]float64)}
+		rs.Uptime[RegionLocal], rs.Coverage[RegionLocal] = historyUptime(s.history[el.Address], localFrom, to)
+		for _, a := range ret.Agents {
+			r := s.regions[a.Region]
+			if st, ok := r.statuses[el.Name]; ok && !a.Stale {
+				rs.States[a.Region] = st.State()
+			}
+			if h := r.history[el.Name]; len(h) > 0 {
+				rs.Uptime[a.Region], rs.Coverage[a.Region] = historyUptime(h, from, to)
+			}
+		}
+		seen := make(map[State]bool)
+		for _, state := range rs.States {
+			if state != StateNeverChecked {
+				seen[state] = true
+			}
+		}
+		rs.Disagree = len(seen) > 1
+		ret.Resources = append(ret.Resources, rs)
+	}
+	return ret
+}
+
+func RegisterRegionsHandler(sc *StatusChecker) {
+	describeAPI(&apiOperation{
+		Method: "GET", Path: "/api/regions", Summary: "Compare states of resources seen by agents in regions",
+		Responses: map[int]interface{}{http.StatusOK: &apiRegions{}},
+	})
+	http.HandleFunc("/api/regions", func(rw http.ResponseWriter, req *http.Request) {
+		writeJSON(rw, http.StatusOK, sc.Regions())
+	})
+}
+
+// runAgent checks resources of a server and reports results until it's
+// killed. Reports are queued while the server is unreachable and replayed
+// in order once it's back.
+func runAgent() {
+	if len(*region) == 0 {
+		log.Fatalf("For -mode agent one must specify -region")
+	}
+	dir := *agentQueueDir
+	if *agentQueueMemory {
+		dir = ""
+	}
+	q, err := openAgentQueue(dir, *agentQueueMax)
+	if err != nil {
+		log.Fatalf("Agent queue: %s", err)
+	}
+	a := &agent{q: q}
+	for {
+		time.Sleep(a.round())
+	}
+}
+
+type agent struct {
+	q      *agentQueue
+	client *grpcClient
+	stream *grpcClientStream
+	conf   *AgentConfig // the last one, checked while a server is unreachable
+}
+
+func (a *agent) connect() error {
+	if a.stream != nil {
+		return nil
+	}
+	client, err := dialGRPC()
+	if err != nil {
+		return err
+	}
+	stream, err := client.Stream("/" + agentService.Name + "/Report")
+	if err != nil {
+		client.Close()
+		return err
+	}
+	a.client, a.stream = client, stream
+	log.Printf("Agent %s connected to: %s", *region, commandAddr())
+	return nil
+}
+
+func (a *agent) disconnect(err error) {
+	log.Printf("Agent error: %s, %d reports queued", err, a.q.len())
+	if a.stream != nil {
+		a.stream.Close()
+		a.client.Close()
+		a.client, a.stream = nil, nil
+	}
+}
+
+// round checks resources once, queues a report, replays queued reports if
+// a server is reachable and returns how long to wait for the next round.
+func (a *agent) round() time.Duration {
+	start := time.Now()
+	err := a.connect()
+	if err == nil {
+		var conf AgentConfig
+		if err = a.client.Invoke("/"+agentService.Name+"/Config", &AgentHello{*region}, &conf); err == nil {
+			a.conf = &conf
+		}
+	}
+	if err != nil {
+		a.disconnect(err)
+	}
+	if a.conf == nil {
+		return agentRetry
+	}
+	report := &AgentReport{Region: *region, Results: agentCheck(a.conf.Configs, *workers)}
+	if err := a.q.push(report); err != nil {
+		log.Printf("Agent queue: %s", err)
+	}
+	if a.stream != nil {
+		if err := a.flush(); err != nil {
+			a.disconnect(err)
+		}
+	}
+	return a.wait(time.Since(start))
+}
+
+// wait returns how long to wait for a next round after one took elapsed.
+// Rounds longer than an interval skip missed ones rather than run back to
+// back.
+func (a *agent) wait(elapsed time.Duration) time.Duration {
+	interval := a.conf.Interval
+	if interval <= 0 {
+		return agentRetry
+	}
+	if elapsed >= interval {
+		log.Printf("Agent round took %s, %d intervals of %s skipped", elapsed.Round(time.Millisecond), int64(elapsed/interval), interval)
+	}
+	return interval - elapsed%interval
+}
+
+// flush sends queued reports in order, each one removed once acknowledged.
+func (a *agent) flush() error {
+	n := a.q.len()
+	for a.q.len() > 0 {
+		seq := a.q.seqs[0]
+		report, err := a.q.load(seq)
+		if err != nil {
+			log.Printf("Agent queue: %s, report %d dropped", err, seq)
+			a.q.remove(seq)
+			continue
+		}
+		if err := a.stream.Send(report); err != nil {
+			return err
+		}
+		var ack AgentReportAck
+		if err := a.stream.Recv(&ack); err != nil {
+			return err
+		}
+		if ack.Seq != seq {
+			return fmt.Errorf("report %d acknowledged as %d", seq, ack.Seq)
+		}
+		a.q.remove(seq)
+	}
+	if n > 1 {
+		log.Printf("Agent %s replayed %d queued reports", *region, n)
+	}
+	return nil
+}
+
+// agentCheck checks resources with n workers.
+func agentCheck(confs []*ResConf, n int) []*AgentResult {
+	ret := make([]*AgentResult, len(confs))
+	sem := make(chan bool, max(n, 1))
+	var wg sync.WaitGroup
+	for i, c := range confs {
+		wg.Add(1)
+		sem <- true
+		go func(i int, c *ResConf) {
+			defer wg.Done()
+			ret[i] = &AgentResult{c.Name, CheckStatus(c)}
+			<-sem
+		}(i, c)
+	}
+	wg.Wait()
+	return ret
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestAgentQueue(t *testing.T) {
+	dir := t.TempDir()
+	q, err := openAgentQueue(dir, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	when := time.Now().Add(-time.Hour).Truncate(time.Second)
+	for i := 0; i < 4; i++ {
+		st := &Status{When: when.Add(time.Duration(i) * time.Minute), StatusCode: 200}
+		if err := q.push(&AgentReport{Region: "eu", Results: []*AgentResult{{"web", st}}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if q.len() != 3 || q.seqs[0] != 2 {
+		t.Fatalf("queued %v", q.seqs)
+	}
+	q.remove(2)
+
+	q, err = openAgentQueue(dir, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.len() != 2 || q.seqs[0] != 3 || q.next != 5 {
+		t.Fatalf("reopened %v, next %d", q.seqs, q.next)
+	}
+	r, err := q.load(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Seq != 3 || r.Region != "eu" || !r.Results[0].Status.When.Equal(when.Add(2*time.Minute)) {
+		t.Errorf("loaded %+v %+v", r, r.Results[0].Status)
+	}
+
+	q, _ = openAgentQueue("", 1)
+	q.push(&AgentReport{Region: "eu"})
+	q.push(&AgentReport{Region: "eu"})
+	if r, err := q.load(2); q.len() != 1 || err != nil || r.Seq != 2 {
+		t.Errorf("in memory %v, %v", q.seqs, err)
+	}
+}
+
+func TestAgentReportHistory(t *testing.T) {
+	sc := NewStatusChecker(&Config{Configs: []*ResConf{{Name: "web", Address: "http://example.com/"}}})
+	now := time.Now()
+	report := func(ago time.Duration, code int) {
+		sc.AgentReport(&AgentReport{Region: "eu", Results: []*AgentResult{{"web", &Status{When: now.Add(-ago), StatusCode: code}}}})
+	}
+	report(time.Minute, 200)
+	report(3*time.Minute, 500) // replayed late
+	report(2*time.Minute, 200)
+	report(3*time.Minute, 500) // sent twice
+	h := sc.regions["eu"].history["web"]
+	if len(h) != 3 || !h[0].When.Equal(now.Add(-3*time.Minute)) || !h[2].When.Equal(now.Add(-time.Minute)) {
+		t.Fatalf("history %+v", h)
+	}
+	if sc.regions["eu"].statuses["web"].StatusCode != 200 {
+		t.Errorf("a late result replaced a newer one")
+	}
+	r := sc.Regions()
+	if up := r.Resources[0].Uptime["eu"]; up <= 0 || up >= 100 {
+		t.Errorf("uptime %v", up)
+	}
+}
+
+func TestAgentReplay(t *testing.T) {
+	sc := NewStatusChecker(&Config{Configs: []*ResConf{{Name: "web", Address: "http://127.0.0.1:1/"}}})
+	newGRPCTestServer(t, sc, []*ApiKey{{Name: "probe", Key: "agentkey", Role: RoleAgent}})
+	oldRegion := *region
+	*region, *apiKey = "eu", "agentkey"
+	defer func() { *region = oldRegion }()
+	q, _ := openAgentQueue(t.TempDir(), 10)
+	a := &agent{q: q}
+	a.round()
+	if a.conf == nil || a.stream == nil || q.len() != 0 {
+		t.Fatalf("connected %v, queued %d", a.stream != nil, q.len())
+	}
+
+	// The server is unreachable.
+	serverAddr := *addr
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	*addr = l.Addr().String()
+	l.Close()
+	a.disconnect(net.ErrClosed)
+	a.round()
+	a.round()
+	if a.stream != nil || q.len() != 2 {
+		t.Fatalf("connected %v, queued %d", a.stream != nil, q.len())
+	}
+
+	*addr = serverAddr
+	a.round()
+	if a.stream == nil || q.len() != 0 {
+		t.Fatalf("connected %v, queued %d", a.stream != nil, q.len())
+	}
+	defer a.client.Close()
+	defer a.stream.Close()
+	h := sc.regions["eu"].history["web"]
+	if len(h) != 4 {
+		t.Fatalf("history %d", len(h))
+	}
+	for i := 1; i < len(h); i++ {
+		if !h[i-1].When.Before(h[i].When) {
+			t.Errorf("history %v before %v", h[i-1].When, h[i].When)
+		}
+	}
+}
+
+func TestAgentWait(t *testing.T) {
+	a := &agent{conf: &AgentConfig{Interval: 10 * time.Second}}
+	for elapsed, want := range map[time.Duration]time.Duration{
+		time.Second:      9 * time.Second,
+		10 * time.Second: 10 * time.Second,
+		25 * time.Second: 5 * time.Second,
+	} {
+		if got := a.wait(elapsed); got != want {
+			t.Errorf("after %s waits %s, want %s", elapsed, got, want)
+		}
+	}
+	a.conf.Interval = 0
+	if got := a.wait(time.Second); got != agentRetry {
+		t.Errorf("without an interval waits %s", got)
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// An OpenAPI 3 document of the JSON API at /api/openapi.json. Handlers
+// describe their operations with describeAPI, schemas are generated from
+// Go types of requests and responses.
+///////////////////////////////////////////////////////////////////////////////
+
+type apiParam struct {
+	Name     string
+	In       string // path or query
+	Required bool
+	Doc      string
+}
+
+type apiOperation struct {
+	Method  string
+	Path    string // e.g. /api/resources/{name}
+	Summary string
+	Params  []apiParam
+	// Body is a value of a JSON request body type, if any, Form lists
+	// fields of a form encoded one.
+	Body interface{}
+	Form []string
+	// Responses are values of response body types by a status code, nil if
+	// there's no body.
+	Responses map[int]interface{}
+}
+
+var apiOperations []*apiOperation
+
+func describeAPI(ops ...*apiOperation) {
+	apiOperations = append(apiOperations, ops...)
+}
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(time.Duration(0))
+	stateType    = reflect.TypeOf(State(0))
+)
+
+// schemaGen generates JSON schemas of Go types as encoding/json marshals
+// them, named structs become components.
+type schemaGen struct {
+	components map[string]interface{}
+}
+
+func (g *schemaGen) schema(t reflect.Type) map[string]interface{} {
+	switch t {
+	case timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case durationType:
+		return map[string]interface{}{"type": "integer", "format": "int64", "description": "nanoseconds"}
+	case stateType:
+		var states []string
+		for s := StateNeverChecked; s <= StateInvalid; s++ {
+			states = append(states, s.String())
+		}
+		return map[string]interface{}{"type": "string", "enum": states}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return g.schema(t.Elem())
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		if len(t.Name()) == 0 {
+			return g.object(t)
+		}
+		name := strings.ToUpper(t.Name()[:1]) + t.Name()[1:]
+		if _, ok := g.components[name]; !ok {
+			g.components[name] = nil // breaks recursion
+			g.components[name] = g.object(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	}
+	return map[string]interface{}{}
+}
+
+func (g *schemaGen) object(t reflect.Type) map[string]interface{} {
+	props := make(map[string]interface{})
+	g.fields(t, props)
+	return map[string]interface{}{"type": "object", "properties": props}
+}
+
+func (g *schemaGen) fields(t reflect.Type, props map[string]interface{}) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		if f.Anonymous && len(tag) == 0 {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			g.fields(ft, props)
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		name := f.Name
+		if n := strings.Split(tag, ",")[0]; len(n) > 0 {
+			name = n
+		}
+		props[name] = g.schema(f.Type)
+	}
+}
+
+func openAPIDocument() map[string]interface{} {
+	g := &schemaGen{components: make(map[string]interface{})}
+	paths := make(map[string]interface{})
+	for _, op := range apiOperations {
+		o := map[string]interface{}{"summary": op.Summary}
+		var params []interface{}
+		for _, p := range op.Params {
+			params = append(params, map[string]interface{}{
+				"name": p.Name, "in": p.In, "required": p.Required || p.In == "path",
+				"description": p.Doc, "schema": map[string]interface{}{"type": "string"},
+			})
+		}
+		if len(params) > 0 {
+			o["parameters"] = params
+		}
+		if op.Body != nil {
+			o["requestBody"] = map[string]interface{}{"required": true, "content": map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": g.schema(reflect.TypeOf(op.Body))},
+			}}
+		} else if len(op.Form) > 0 {
+			props := make(map[string]interface{})
+			for _, f := range op.Form {
+				props[f] = map[string]interface{}{"type": "string"}
+			}
+			o["requestBody"] = map[string]interface{}{"required": true, "content": map[string]interface{}{
+				"application/x-www-form-urlencoded": map[string]interface{}{"schema": map[string]interface{}{"type": "object", "properties": props}},
+			}}
+		}
+		responses := make(map[string]interface{})
+		for code, v := range op.Responses {
+			r := map[string]interface{}{"description": http.StatusText(code)}
+			if v != nil {
+				r["content"] = map[string]interface{}{
+					"application/json": map[string]interface{}{"schema": g.schema(reflect.TypeOf(v))},
+				}
+			}
+			responses[strconv.Itoa(code)] = r
+		}
+		o["responses"] = responses
+		item, ok := paths[op.Path].(map[string]interface{})
+		if !ok {
+			item = make(map[string]interface{})
+			paths[op.Path] = item
+		}
+		item[strings.ToLower(op.Method)] = o
+	}
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info":    map[string]interface{}{"title": "statusmonitor", "version": "1"},
+		"paths":   paths,
+		"components": map[string]interface{}{
+			"schemas": g.components,
+		},
+	}
+}
+
+func RegisterOpenAPIHandler(sc *StatusChecker) {
+	http.HandleFunc("/api/openapi.json", func(rw http.ResponseWriter, req *http.Request) {
+		writeJSON(rw, http.StatusOK, openAPIDocument())
+	})
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// An audit log of administrative actions: who added, changed, removed,
+// watched or acknowledged what and when. Entries are appended to an -audit
+// file (JSON lines) and recent ones are served at /api/audit to admins.
+///////////////////////////////////////////////////////////////////////////////
+
+var auditPath = flag.String("audit", "", "An append-only audit log file (JSON lines) of administrative actions.")
+
+// maxAuditEntries is how many recent entries are kept in memory.
+const maxAuditEntries = 1000
+
+const (
+	AuditAdd    = "add"
+	AuditUpdate = "update"
+	AuditRemove = "remove"
+	AuditWatch  = "watch"
+	AuditAck    = "ack"
+)
+
+type AuditEntry struct {
+	When   time.Time
+	Actor  string      // a key or a user, anonymous if there are none
+	Role   Role        `json:",omitempty"`
+	From   string      `json:",omitempty"` // a remote address
+	Action string      // e.g. AuditAdd
+	Name   string      // of a resource
+	Params interface{} `json:",omitempty"`
+}
+
+type auditLog struct {
+	m       sync.Mutex
+	f       *os.File
+	entries []*AuditEntry
+}
+
+var audits = &auditLog{}
+
+// OpenAudit reads recent entries of an audit log at path and appends new
+// ones to it.
+func OpenAudit(path string) error {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		e := &AuditEntry{}
+		if err := json.Unmarshal(sc.Bytes(), e); err != nil {
+			slog.Warn("Audit log", "path", path, "error", err)
+			continue
+		}
+		audits.add(e)
+	}
+	if err := sc.Err(); err != nil {
+		f.Close()
+		return err
+	}
+	audits.m.Lock()
+	audits.f = f
+	audits.m.Unlock()
+	return nil
+}
+
+func (a *auditLog) add(e *AuditEntry) {
+	a.entries = append(a.entries, e)
+	if len(a.entries) > maxAuditEntries {
+		a.entries = a.entries[len(a.entries)-maxAuditEntries:]
+	}
+}
+
+// audit records an action of a caller (nil if anonymous) from an address.
+func audit(c *Caller, from, action, name string, params interface{}) {
+	e := &AuditEntry{When: time.Now(), Actor: "anonymous", From: from, Action: action, Name: name, Params: params}
+	if c != nil {
+		e.Actor, e.Role = c.Name, c.Role
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		slog.Error("Audit", "error", err)
+		return
+	}
+	audits.m.Lock()
+	defer audits.m.Unlock()
+	audits.add(e)
+	if audits.f != nil {
+		if _, err := audits.f.Write(append(b, '\n')); err != nil {
+			slog.Error("Audit", "error", err)
+		}
+	}
+}
+
+// Audit returns recent entries, the most recent first, of a resource with
+// a given name or all if it's empty.
+func Audit(name string, limit int) []*AuditEntry {
+	audits.m.Lock()
+	defer audits.m.Unlock()
+	ret := []*AuditEntry{}
+	for i := len(audits.entries) - 1; i >= 0 && len(ret) < limit; i-- {
+		if e := audits.entries[i]; len(name) == 0 || e.Name == name {
+			ret = append(ret, e)
+		}
+	}
+	return ret
+}
+
+func RegisterAuditHandler(sc *StatusChecker) {
+	describeAPI(&apiOperation{
+		Method: "GET", Path: "/api/audit", Summary: "List administrative actions, the most recent first",
+		Params: []apiParam{
+			{Name: "name", In: "query", Doc: "of a single resource"},
+			{Name: "limit", In: "query", Doc: "100 if empty"},
+		},
+		Responses: map[int]interface{}{http.StatusOK: []AuditEntry{}},
+	})
+	http.HandleFunc("/api/audit", func(rw http.ResponseWriter, req *http.Request) {
+		limit, err := strconv.Atoi(req.FormValue("limit"))
+		if err != nil || limit <= 0 {
+			limit = 100
+		}
+		writeJSON(rw, http.StatusOK, Audit(req.FormValue("name"), limit))
+	})
+}
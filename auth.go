@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/rpc"
+	"os"
+	"strings"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// API keys: with keys defined in the config or a -keys file, mutating HTTP
+// requests and the RPC admin interface need a key, sent as
+// "Authorization: Bearer <key>" or "X-Api-Key: <key>".
+///////////////////////////////////////////////////////////////////////////////
+
+var (
+	keysFilePath = flag.String("keys", "", "A file with API keys, a \"name key\" or a key per line.")
+	apiKey       = flag.String("key", os.Getenv("STATUSMONITOR_KEY"), "An API key of commands sent to server.")
+)
+
+type ApiKey struct {
+	Name string
+	Key  string
+	Role Role `json:",omitempty"` // admin if empty
+}
+
+func (k *ApiKey) caller() *Caller {
+	if len(k.Role) == 0 {
+		return &Caller{k.Name, RoleAdmin}
+	}
+	return &Caller{k.Name, k.Role}
+}
+
+// LoadKeys reads API keys from a file, a key per line optionally preceded by
+// its name and followed by its role, # starts a comment.
+func LoadKeys(path string) ([]*ApiKey, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var ret []*ApiKey
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		switch len(fields) {
+		case 1:
+			ret = append(ret, &ApiKey{Name: fmt.Sprintf("%s:%d", path, n), Key: fields[0]})
+		case 2:
+			ret = append(ret, &ApiKey{Name: fields[0], Key: fields[1]})
+		case 3:
+			ret = append(ret, &ApiKey{Name: fields[0], Key: fields[1], Role: Role(fields[2])})
+		default:
+			return nil, fmt.Errorf("%s:%d: want a name, a key and a role", path, n)
+		}
+	}
+	return ret, sc.Err()
+}
+
+// mutating methods need a key, CONNECT is an RPC connection.
+func mutating(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS":
+		return false
+	}
+	return true
+}
+
+func requestKey(req *http.Request) string {
+	if k := req.Header.Get("X-Api-Key"); len(k) > 0 {
+		return k
+	}
+	if h := req.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		return strings.TrimPrefix(h, "Bearer ")
+	}
+	return ""
+}
+
+// findKey returns a matching key or nil, comparisons take constant time.
+func findKey(keys []*ApiKey, key string) *ApiKey {
+	var ret *ApiKey
+	for _, k := range keys {
+		if len(k.Key) > 0 && subtle.ConstantTimeCompare([]byte(k.Key), []byte(key)) == 1 {
+			ret = k
+		}
+	}
+	return ret
+}
+
+// openAccess is whether anonymous callers have all roles, as there are no
+// keys, see RequireKey.
+var openAccess bool
+
+// RequireKey wraps h so mutating requests need one of keys or a logged in
+// user (see RequireLogin), with a role allowing a request. Anonymous
+// requests pass if there are no keys, else only ones a viewer may make.
+func RequireKey(keys []*ApiKey, h http.Handler) http.Handler {
+	for _, k := range keys {
+		if err := checkRole(k.Role); err != nil {
+			log.Fatalf("API key %s: %s", k.Name, err)
+		}
+	}
+	openAccess = len(keys) == 0
+	if openAccess {
+		slog.Warn("No API keys, anyone can change resources", "address", commandAddr())
+	}
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		c := callerOf(req)
+		if k := findKey(keys, requestKey(req)); k != nil {
+			c = k.caller()
+			req = withCaller(req, c)
+		}
+		if c == nil {
+			needsKey := mutating(req.Method) || !RoleViewer.allows(requiredRole(req))
+			if needsKey && !openAccess && !isHeartbeat(req) {
+				slog.Warn("Unauthorized", "method", req.Method, "path", req.URL.Path, "from", req.RemoteAddr)
+				rw.Header().Set("WWW-Authenticate", `Bearer realm="statusmonitor"`)
+				http.Error(rw, "API key required", http.StatusUnauthorized)
+				return
+			}
+			h.ServeHTTP(rw, req)
+			return
+		}
+		if need := requiredRole(req); !c.Role.allows(need) {
+			slog.Warn("Denied", "method", req.Method, "path", req.URL.Path, "caller", c.Name, "role", c.Role, "needs", need)
+			http.Error(rw, fmt.Sprintf("%s role required", need), http.StatusForbidden)
+			return
+		}
+		if mutating(req.Method) {
+			slog.Info("Request", "method", req.Method, "path", req.URL.Path, "caller", c.Name)
+		}
+		h.ServeHTTP(rw, req)
+	})
+}
+
+// dialAdmin connects to the admin interface of a server, gRPC or net/rpc
+// with -rpc.
+func dialAdmin() (adminClient, error) {
+	if *rpcCompat {
+		c, err := dialRPC()
+		if err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+	c, err := dialGRPC()
+	if err != nil {
+		return nil, err
+	}
+	return grpcAdmin{c}, nil
+}
+
+// dialRPC connects to the net/rpc admin interface of a server like
+// rpc.DialHTTP, with -key and a client certificate if set.
+func dialRPC() (*rpc.Client, error) {
+	conn, err := dialServer()
+	if err != nil {
+		return nil, err
+	}
+	req := "CONNECT " + rpc.DefaultRPCPath + " HTTP/1.0\r\n"
+	if len(*apiKey) > 0 {
+		req += "Authorization: Bearer " + *apiKey + "\r\n"
+	}
+	io.WriteString(conn, req+"\r\n")
+	resp, err := http.ReadResponse(bufio.NewReader(conn), &http.Request{Method: "CONNECT"})
+	if err == nil && resp.Status != "200 Connected to Go RPC" {
+		err = errors.New(resp.Status)
+		if resp.StatusCode == http.StatusUnauthorized {
+			err = errors.New("API key required, use -key or STATUSMONITOR_KEY")
+		}
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return rpc.NewClient(conn), nil
+}
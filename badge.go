@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// Status badges: shields.io-style SVGs, e.g. /badge/api.svg showing
+// "up 99.95%", to embed in READMEs and dashboards.
+///////////////////////////////////////////////////////////////////////////////
+
+const badgeTmplStr = `<svg xmlns="http://www.w3.org/2000/svg" width="{{.Width}}" height="20" role="img" aria-label="{{xml .Label}}: {{xml .Value}}">
+<title>{{xml .Label}}: {{xml .Value}}</title>
+<linearGradient id="s" x2="0" y2="100%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient>
+<clipPath id="r"><rect width="{{.Width}}" height="20" rx="3" fill="#fff"/></clipPath>
+<g clip-path="url(#r)">
+<rect width="{{.LabelWidth}}" height="20" fill="#555"/>
+<rect x="{{.LabelWidth}}" width="{{.ValueWidth}}" height="20" fill="{{.Color}}"/>
+<rect width="{{.Width}}" height="20" fill="url(#s)"/>
+</g>
+<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">
+<text x="{{.LabelX}}" y="14">{{xml .Label}}</text>
+<text x="{{.ValueX}}" y="14">{{xml .Value}}</text>
+</g>
+</svg>
+`
+
+// An SVG is XML, text/template with explicit escaping avoids html/template
+// treating it as HTML.
+// An SVG is XML, not HTML, so text/template with explicit escaping.
+var badgeTmpl = template.Must(template.New("badge").Funcs(template.FuncMap{
+	"xml": template.HTMLEscapeString,
+}).Parse(badgeTmplStr))
+
+type badge struct {
+	Label, Value, Color    string
+	LabelWidth, ValueWidth int
+}
+
+func (b *badge) Width() int  { return b.LabelWidth + b.ValueWidth }
+func (b *badge) LabelX() int { return b.LabelWidth / 2 }
+func (b *badge) ValueX() int { return b.LabelWidth + b.ValueWidth/2 }
+
+// textWidth approximates a width of a text in 11px Verdana.
+func textWidth(s string) int {
+	return 7*len([]rune(s)) + 10
+}
+
+func newBadge(label string, st *Status, uptime, coverage float64) *badge {
+	b := &badge{Label: label, Value: strings.ToLower(st.State().String()), Color: "#9f9f9f"}
+	if !st.Checked() {
+		b.Value = "pending"
+	}
+	switch st.State() {
+	case StateUp:
+		b.Color = uptimeColor(uptime)
+	case StateDegraded:
+		b.Color = "#f9a825"
+	case StateDown:
+		b.Color = "#c62828"
+	}
+	if coverage > 0 {
+		b.Value += fmt.Sprintf(" %.2f%%", uptime)
+	}
+	b.LabelWidth, b.ValueWidth = textWidth(b.Label), textWidth(b.Value)
+	return b
+}
+
+func RegisterBadgeHandler(sc *StatusChecker) {
+	// /badge/<name>.svg, uptime is of the last ?period (24h by default).
+	http.HandleFunc("/badge/", func(rw http.ResponseWriter, req *http.Request) {
+		name := strings.TrimPrefix(req.URL.Path, "/badge/")
+		if !strings.HasSuffix(name, ".svg") {
+			http.NotFound(rw, req)
+			return
+		}
+		name = strings.TrimSuffix(name, ".svg")
+		conf, st := sc.Lookup(name)
+		if conf == nil {
+			http.NotFound(rw, req)
+			return
+		}
+		period := 24 * time.Hour
+		if p := req.FormValue("period"); len(p) > 0 {
+			d, err := time.ParseDuration(p)
+			if err != nil || d <= 0 {
+				http.Error(rw, fmt.Sprintf("bad period %q", p), http.StatusBadRequest)
+				return
+			}
+			period = d
+		}
+		uptime, coverage := sc.Uptime(conf.Address, period)
+		label := req.FormValue("label")
+		if len(label) == 0 {
+			label = conf.Name
+		}
+		rw.Header().Set("Content-Type", "image/svg+xml")
+		rw.Header().Set("Cache-Control", "no-cache, max-age=0")
+		if err := badgeTmpl.Execute(rw, newBadge(label, st, uptime, coverage)); err != nil {
+			slog.Error("Badge render", "error", err)
+		}
+	})
+}
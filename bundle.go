@@ -0,0 +1,167 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// Signed config bundles: a zip with a manifest, a config and templates,
+// signed with ed25519. A bundle is verified before anything in it is used,
+// so config provenance can be proven, e.g. in air-gapped deployments.
+///////////////////////////////////////////////////////////////////////////////
+
+var (
+	bundlePath  = flag.String("bundle", "", "A signed config bundle (zip) loaded instead of -config, its signature is in <bundle>.sig.")
+	bundleKey   = flag.String("bundle-key", "", "A public key verifying -bundle, a private one for -mode sign.")
+	bundleForce = flag.Bool("bundle-force", false, "Load a -bundle older than one loaded before, e.g. to roll back.")
+)
+
+// A bundle contains:
+//
+//	manifest.json - a BundleManifest
+//	config.json   - a Config
+//	templates/    - optional, as -templates
+type BundleManifest struct {
+	Version int
+	Created time.Time
+	Comment string `json:",omitempty"`
+}
+
+type Bundle struct {
+	Manifest  *BundleManifest
+	Config    *Config
+	Templates fs.FS // rooted at templates/
+}
+
+func readKey(path string, size int) ([]byte, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	if len(key) != size {
+		return nil, fmt.Errorf("%s: not an ed25519 key of %d bytes", path, size)
+	}
+	return key, nil
+}
+
+// LoadBundle verifies a bundle at path with a public key at keyPath and
+// returns its contents.
+func LoadBundle(path, keyPath string) (*Bundle, error) {
+	if len(keyPath) == 0 {
+		return nil, errors.New("a bundle needs -bundle-key")
+	}
+	pub, err := readKey(keyPath, ed25519.PublicKeySize)
+	if err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := readKey(path+".sig", ed25519.SignatureSize)
+	if err != nil {
+		return nil, err
+	}
+	if !ed25519.Verify(pub, b, sig) {
+		return nil, fmt.Errorf("%s: bad signature", path)
+	}
+
+	z, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		return nil, err
+	}
+	ret := &Bundle{Manifest: &BundleManifest{}, Config: NewConfig()}
+	for name, v := range map[string]interface{}{"manifest.json": ret.Manifest, "config.json": ret.Config} {
+		data, err := fs.ReadFile(z, name)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+		if err := json.Unmarshal(data, v); err != nil {
+			return nil, fmt.Errorf("%s: %s: %s", path, name, err)
+		}
+	}
+	// An old bundle is still signed, so only its version tells a rollback
+	// apart from a new one.
+	loaded, err := loadedBundleVersion(path)
+	if err != nil {
+		return nil, err
+	}
+	if ret.Manifest.Version < loaded && !*bundleForce {
+		return nil, fmt.Errorf("%s: version %d is older than %d loaded before, see -bundle-force", path,
+			ret.Manifest.Version, loaded)
+	}
+	// Secrets stay out of a bundle.
+	if err := ret.Config.expandEnv(); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	if ret.Templates, err = fs.Sub(z, "templates"); err != nil {
+		return nil, err
+	}
+	if ret.Manifest.Version != loaded {
+		err := ioutil.WriteFile(path+".version", []byte(strconv.Itoa(ret.Manifest.Version)+"\n"), 0644)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+// loadedBundleVersion returns a version of a bundle at path loaded before,
+// kept in <path>.version, 0 if there was none.
+func loadedBundleVersion(path string) (int, error) {
+	b, err := ioutil.ReadFile(path + ".version")
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	v, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return 0, fmt.Errorf("%s.version: %s", path, err)
+	}
+	return v, nil
+}
+
+// SignBundle writes a signature of a bundle at path with a private key at
+// keyPath.
+func SignBundle(path, keyPath string) error {
+	priv, err := readKey(keyPath, ed25519.PrivateKeySize)
+	if err != nil {
+		return err
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	sig := ed25519.Sign(ed25519.PrivateKey(priv), b)
+	return ioutil.WriteFile(path+".sig", []byte(base64.StdEncoding.EncodeToString(sig)+"\n"), 0644)
+}
+
+// GenerateBundleKey writes a new key pair to prefix.pub and prefix.key.
+func GenerateBundleKey(prefix string) error {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(prefix+".key", []byte(base64.StdEncoding.EncodeToString(priv)+"\n"), 0600); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(prefix+".pub", []byte(base64.StdEncoding.EncodeToString(pub)+"\n"), 0644)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// Consul catalog discovery: every instance of a service with a tag is
+// checked. An instance is checked with HTTP if its service meta has
+// statusmonitor_path (and optionally statusmonitor_scheme), otherwise its
+// port is checked with TCP.
+///////////////////////////////////////////////////////////////////////////////
+
+var (
+	consulCatalog = flag.String("consul-catalog", "", "A Consul agent, e.g. http://127.0.0.1:8500, whose services are checked. -kv-token is sent to it.")
+	consulTag     = flag.String("consul-tag", "statusmonitor", "A tag of services checked with -consul-catalog.")
+	consulSync    = flag.Duration("consul-sync", 30*time.Second, "How often services of -consul-catalog are synced.")
+)
+
+type consulService struct {
+	Node           string
+	Address        string
+	ServiceID      string
+	ServiceName    string
+	ServiceAddress string
+	ServicePort    int
+	ServiceMeta    map[string]string
+}
+
+// resource returns a check of a service instance.
+func (s *consulService) resource() *ResConf {
+	host := s.ServiceAddress
+	if len(host) == 0 {
+		host = s.Address
+	}
+	hostPort := net.JoinHostPort(host, strconv.Itoa(s.ServicePort))
+	r := &ResConf{
+		Name:    s.ServiceName + "@" + hostPort,
+		Address: hostPort,
+		Type:    "tcp",
+		Group:   s.ServiceName,
+	}
+	if path, ok := s.ServiceMeta["statusmonitor_path"]; ok {
+		scheme := s.ServiceMeta["statusmonitor_scheme"]
+		if len(scheme) == 0 {
+			scheme = "http"
+		}
+		r.Type = ""
+		r.Address = scheme + "://" + hostPort + "/" + strings.TrimPrefix(path, "/")
+	}
+	return r
+}
+
+func consulGet(path string, v interface{}) error {
+	req, err := http.NewRequest("GET", strings.TrimSuffix(*consulCatalog, "/")+path, nil)
+	if err != nil {
+		return err
+	}
+	if len(*kvToken) > 0 {
+		req.Header.Set("X-Consul-Token", *kvToken)
+	}
+	resp, err := notifyClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(b)))
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// consulResources returns checks of instances of tagged services.
+func consulResources() ([]*ResConf, error) {
+	var services map[string][]string
+	if err := consulGet("/v1/catalog/services", &services); err != nil {
+		return nil, err
+	}
+	var names []string
+	for name, tags := range services {
+		for _, t := range tags {
+			if t == *consulTag {
+				names = append(names, name)
+				break
+			}
+		}
+	}
+	sort.Strings(names)
+	var ret []*ResConf
+	for _, name := range names {
+		var instances []*consulService
+		path := fmt.Sprintf("/v1/catalog/service/%s?tag=%s", url.PathEscape(name), url.QueryEscape(*consulTag))
+		if err := consulGet(path, &instances); err != nil {
+			return nil, err
+		}
+		for _, s := range instances {
+			ret = append(ret, s.resource())
+		}
+	}
+	return ret, nil
+}
+
+// discoverConsul syncs services every -consul-sync, a failed sync keeps
+// resources as they are.
+func (s *StatusChecker) discoverConsul() {
+	for {
+		confs, err := consulResources()
+		if err != nil {
+			slog.Error("Discovery", "source", "consul", "error", err)
+		} else {
+			s.syncDiscovered("consul", confs)
+		}
+		time.Sleep(*consulSync)
+	}
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	_ "crypto/sha1"
+	_ "crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"time"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// A certificate report part: details of served certificates and optional
+// OCSP validation, revoked or unstapled certificates are reported as
+// warnings.
+///////////////////////////////////////////////////////////////////////////////
+
+const (
+	OCSPGood    = "good"
+	OCSPRevoked = "revoked"
+	OCSPUnknown = "unknown"
+)
+
+type CertReport struct {
+	Subject  string
+	NotAfter time.Time
+	OCSP     string   `json:",omitempty"` // OCSPGood, OCSPRevoked or OCSPUnknown
+	Stapled  bool     `json:",omitempty"`
+	Warnings []string `json:",omitempty"`
+}
+
+func certReport(c *ResConf, cs *tls.ConnectionState) *CertReport {
+	if len(cs.PeerCertificates) == 0 {
+		return nil
+	}
+	leaf := cs.PeerCertificates[0]
+	r := &CertReport{Subject: leaf.Subject.String(), NotAfter: leaf.NotAfter}
+	if !c.CheckOCSP {
+		return r
+	}
+	if len(cs.VerifiedChains) == 0 || len(cs.VerifiedChains[0]) < 2 {
+		r.Warnings = append(r.Warnings, "no issuer certificate, OCSP not checked")
+		return r
+	}
+	issuer := cs.VerifiedChains[0][1]
+
+	raw := cs.OCSPResponse
+	r.Stapled = len(raw) > 0
+	if !r.Stapled {
+		r.Warnings = append(r.Warnings, "OCSP response not stapled")
+		var err error
+		if raw, err = queryOCSP(leaf, issuer); err != nil {
+			r.Warnings = append(r.Warnings, fmt.Sprintf("OCSP query: %s", err))
+			return r
+		}
+	}
+	resp, err := parseOCSP(raw, leaf, issuer)
+	if err != nil {
+		r.Warnings = append(r.Warnings, fmt.Sprintf("OCSP response: %s", err))
+		return r
+	}
+	switch {
+	case bool(resp.Good):
+		r.OCSP = OCSPGood
+	case !resp.Revoked.RevocationTime.IsZero():
+		r.OCSP = OCSPRevoked
+		r.Warnings = append(r.Warnings, fmt.Sprintf("certificate revoked at %s", resp.Revoked.RevocationTime.Format(time.RFC3339)))
+	default:
+		r.OCSP = OCSPUnknown
+		r.Warnings = append(r.Warnings, "OCSP responder doesn't know the certificate")
+	}
+	return r
+}
+
+// ASN.1 structures from RFC 6960.
+
+type ocspResponse struct {
+	Status   asn1.Enumerated
+	Response ocspResponseBytes `asn1:"explicit,tag:0,optional"`
+}
+
+type ocspResponseBytes struct {
+	Type     asn1.ObjectIdentifier
+	Response []byte
+}
+
+type ocspBasicResponse struct {
+	TBSResponseData    asn1.RawValue
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          asn1.BitString
+	Certificates       []asn1.RawValue `asn1:"explicit,tag:0,optional"`
+}
+
+type ocspResponseData struct {
+	Version     int `asn1:"optional,default:0,explicit,tag:0"`
+	ResponderID asn1.RawValue
+	ProducedAt  time.Time `asn1:"generalized"`
+	Responses   []ocspSingleResponse
+}
+
+type ocspSingleResponse struct {
+	CertID     ocspCertID
+	Good       asn1.Flag        `asn1:"tag:0,optional"`
+	Revoked    ocspRevokedInfo  `asn1:"tag:1,optional"`
+	Unknown    asn1.Flag        `asn1:"tag:2,optional"`
+	ThisUpdate time.Time        `asn1:"generalized"`
+	NextUpdate time.Time        `asn1:"generalized,explicit,tag:0,optional"`
+	Extensions []pkix.Extension `asn1:"explicit,tag:1,optional"`
+}
+
+type ocspRevokedInfo struct {
+	RevocationTime time.Time       `asn1:"generalized"`
+	Reason         asn1.Enumerated `asn1:"explicit,tag:0,optional"`
+}
+
+type ocspCertID struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	NameHash      []byte
+	IssuerKeyHash []byte
+	SerialNumber  *big.Int
+}
+
+type ocspRequest struct {
+	TBSRequest ocspTBSRequest
+}
+
+type ocspTBSRequest struct {
+	Version     int `asn1:"explicit,tag:0,default:0,optional"`
+	RequestList []ocspRequestEntry
+}
+
+type ocspRequestEntry struct {
+	Cert ocspCertID
+}
+
+var (
+	oidOCSPBasic = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 1}
+	oidSHA1      = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+
+	// ocspHashes are hash algorithms of a CertID.
+	ocspHashes = map[string]crypto.Hash{
+		"1.3.14.3.2.26":          crypto.SHA1,
+		"2.16.840.1.101.3.4.2.1": crypto.SHA256,
+	}
+
+	ocspSignatureAlgorithms = map[string]x509.SignatureAlgorithm{
+		"1.2.840.113549.1.1.5":  x509.SHA1WithRSA,
+		"1.2.840.113549.1.1.11": x509.SHA256WithRSA,
+		"1.2.840.113549.1.1.12": x509.SHA384WithRSA,
+		"1.2.840.113549.1.1.13": x509.SHA512WithRSA,
+		"1.2.840.10045.4.1":     x509.ECDSAWithSHA1,
+		"1.2.840.10045.4.3.2":   x509.ECDSAWithSHA256,
+		"1.2.840.10045.4.3.3":   x509.ECDSAWithSHA384,
+		"1.2.840.10045.4.3.4":   x509.ECDSAWithSHA512,
+		"1.3.101.112":           x509.PureEd25519,
+	}
+)
+
+// ocspSkew is how far ahead of this clock a responder's one may be.
+const ocspSkew = 5 * time.Minute
+
+// ocspIssuerHashes returns hashes of a name and a public key of issuer, as
+// in a CertID.
+func ocspIssuerHashes(issuer *x509.Certificate, h crypto.Hash) ([]byte, []byte, error) {
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(issuer.RawSubjectPublicKeyInfo, &spki); err != nil {
+		return nil, nil, err
+	}
+	name, key := h.New(), h.New()
+	name.Write(issuer.RawSubject)
+	key.Write(spki.PublicKey.RightAlign())
+	return name.Sum(nil), key.Sum(nil), nil
+}
+
+// matches reports whether id is of cert issued by issuer.
+func (id *ocspCertID) matches(cert, issuer *x509.Certificate) bool {
+	h, ok := ocspHashes[id.HashAlgorithm.Algorithm.String()]
+	if !ok || id.SerialNumber == nil || id.SerialNumber.Cmp(cert.SerialNumber) != 0 {
+		return false
+	}
+	name, key, err := ocspIssuerHashes(issuer, h)
+	return err == nil && bytes.Equal(id.NameHash, name) && bytes.Equal(id.IssuerKeyHash, key)
+}
+
+// parseOCSP parses a DER OCSP response, verifies it's signed by issuer (or
+// a responder delegated by issuer for OCSP signing, RFC 6960 4.2.2.2) and
+// returns a current status of cert.
+func parseOCSP(der []byte, cert, issuer *x509.Certificate) (*ocspSingleResponse, error) {
+	var resp ocspResponse
+	if _, err := asn1.Unmarshal(der, &resp); err != nil {
+		return nil, err
+	}
+	if resp.Status != 0 {
+		return nil, fmt.Errorf("responder status %d", resp.Status)
+	}
+	if !resp.Response.Type.Equal(oidOCSPBasic) {
+		return nil, fmt.Errorf("unsupported response type %s", resp.Response.Type)
+	}
+	var basic ocspBasicResponse
+	if _, err := asn1.Unmarshal(resp.Response.Response, &basic); err != nil {
+		return nil, err
+	}
+	var data ocspResponseData
+	if _, err := asn1.Unmarshal(basic.TBSResponseData.FullBytes, &data); err != nil {
+		return nil, err
+	}
+
+	signer := issuer
+	if len(basic.Certificates) > 0 {
+		delegated, err := x509.ParseCertificate(basic.Certificates[0].FullBytes)
+		if err != nil {
+			return nil, err
+		}
+		if err := delegated.CheckSignatureFrom(issuer); err != nil {
+			return nil, fmt.Errorf("responder certificate: %s", err)
+		}
+		ocspSigning := false
+		for _, u := range delegated.ExtKeyUsage {
+			ocspSigning = ocspSigning || u == x509.ExtKeyUsageOCSPSigning
+		}
+		if !ocspSigning {
+			return nil, errors.New("responder certificate not for OCSP signing")
+		}
+		signer = delegated
+	}
+	algo, ok := ocspSignatureAlgorithms[basic.SignatureAlgorithm.Algorithm.String()]
+	if !ok {
+		return nil, fmt.Errorf("unsupported signature algorithm %s", basic.SignatureAlgorithm.Algorithm)
+	}
+	if err := signer.CheckSignature(algo, basic.TBSResponseData.FullBytes, basic.Signature.RightAlign()); err != nil {
+		return nil, fmt.Errorf("bad signature: %s", err)
+	}
+
+	now := time.Now()
+	for i := range data.Responses {
+		r := &data.Responses[i]
+		if !r.CertID.matches(cert, issuer) {
+			continue
+		}
+		if r.ThisUpdate.After(now.Add(ocspSkew)) {
+			return nil, fmt.Errorf("thisUpdate %s in the future", r.ThisUpdate.Format(time.RFC3339))
+		}
+		if !r.NextUpdate.IsZero() && now.After(r.NextUpdate) {
+			return nil, fmt.Errorf("expired at %s", r.NextUpdate.Format(time.RFC3339))
+		}
+		return r, nil
+	}
+	return nil, errors.New("no status of the certificate")
+}
+
+// queryOCSP asks an OCSP responder of cert for its status.
+func queryOCSP(cert, issuer *x509.Certificate) ([]byte, error) {
+	if len(cert.OCSPServer) == 0 {
+		return nil, errors.New("no OCSP responder")
+	}
+	nameHash, keyHash, err := ocspIssuerHashes(issuer, crypto.SHA1)
+	if err != nil {
+		return nil, err
+	}
+	req, err := asn1.Marshal(ocspRequest{ocspTBSRequest{
+		RequestList: []ocspRequestEntry{{ocspCertID{
+			HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA1, Parameters: asn1.NullRawValue},
+			NameHash:      nameHash,
+			IssuerKeyHash: keyHash,
+			SerialNumber:  cert.SerialNumber,
+		}}},
+	}})
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	hreq, err := http.NewRequestWithContext(ctx, "POST", cert.OCSPServer[0], bytes.NewReader(req))
+	if err != nil {
+		return nil, err
+	}
+	hreq.Header.Set("Content-Type", "application/ocsp-request")
+	resp, err := checkClient.Do(hreq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", cert.OCSPServer[0], resp.Status)
+	}
+	return ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+const certsTmplStr = `
+<html><head><title>Certyfikaty</title></head>
+<style type="text/css">
+table, th, td {
+	border: 1px solid black;
+}
+</style>
+<body>
+<p><a href="/status">Status</a></p>
+<table>
+<tr>
+<td>Nazwa</td>
+<td>Certyfikat</td>
+<td>Wystawca</td>
+<td>Ważny do</td>
+<td>OCSP</td>
+<td>Ostrzeżenia</td>
+</tr>
+{{range .}}
+<tr>
+<td><a href="/check?name={{.Name}}">{{.Name}}</a></td>
+<td>{{.Status.Cert.Subject}}</td>
+<td>{{.Status.CertIssuer}}</td>
+<td>{{formatTime .Status.Cert.NotAfter}}</td>
+<td>{{if .Status.Cert.OCSP}}{{.Status.Cert.OCSP}}{{if .Status.Cert.Stapled}} (stapled){{end}}{{else}}-{{end}}</td>
+<td>{{range .Status.Cert.Warnings}}{{.}}<br>{{end}}</td>
+</tr>
+{{end}}
+</table>
+</body>
+</html>
+`
+
+var certsTmpl = page("certs", certsTmplStr)
+
+func RegisterCertsHandler(sc *StatusChecker) {
+	http.HandleFunc("/certs", func(rw http.ResponseWriter, req *http.Request) {
+		var arr []tmplHelper
+		for _, el := range sc.Snapshot() {
+			if el.Status != nil && el.Status.Cert != nil {
+				arr = append(arr, el)
+			}
+		}
+		if err := certsTmpl.Execute(rw, arr); err != nil {
+			slog.Error("Template render", "error", err)
+		}
+	})
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// newTestCert issues a certificate by parent, self-signed if it's nil.
+func newTestCert(t *testing.T, tmpl *x509.Certificate, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl.NotBefore, tmpl.NotAfter = time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	signer, signerKey := tmpl, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert, key}
+}
+
+// ocspTestResponse returns a response of a good cert of issuer, signed by
+// signer, with a responder certificate unless signer is a CA.
+func ocspTestResponse(t *testing.T, cert *x509.Certificate, issuer, signer *testCert, thisUpdate, nextUpdate time.Time) []byte {
+	name, key, err := ocspIssuerHashes(issuer.cert, crypto.SHA1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyID, _ := asn1.Marshal(key)
+	tbs, err := asn1.Marshal(ocspResponseData{
+		ResponderID: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 2, IsCompound: true, Bytes: keyID},
+		ProducedAt:  time.Now().UTC().Truncate(time.Second),
+		Responses: []ocspSingleResponse{{
+			CertID: ocspCertID{
+				HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA1, Parameters: asn1.NullRawValue},
+				NameHash:      name, IssuerKeyHash: key, SerialNumber: cert.SerialNumber,
+			},
+			Good:       true,
+			ThisUpdate: thisUpdate.UTC().Truncate(time.Second),
+			NextUpdate: nextUpdate.UTC().Truncate(time.Second),
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256(tbs)
+	sig, err := signer.key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	basic := ocspBasicResponse{
+		TBSResponseData:    asn1.RawValue{FullBytes: tbs},
+		SignatureAlgorithm: pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}},
+		Signature:          asn1.BitString{Bytes: sig, BitLength: 8 * len(sig)},
+	}
+	if !signer.cert.IsCA {
+		basic.Certificates = []asn1.RawValue{{FullBytes: signer.cert.Raw}}
+	}
+	b, err := asn1.Marshal(basic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := asn1.Marshal(ocspResponse{Response: ocspResponseBytes{oidOCSPBasic, b}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+func TestParseOCSP(t *testing.T) {
+	ca := newTestCert(t, &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "CA"},
+		IsCA: true, BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign}, nil)
+	other := newTestCert(t, &x509.Certificate{SerialNumber: big.NewInt(2), Subject: pkix.Name{CommonName: "Other CA"},
+		IsCA: true, BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign}, nil)
+	leaf := newTestCert(t, &x509.Certificate{SerialNumber: big.NewInt(42), Subject: pkix.Name{CommonName: "leaf"}}, ca)
+	responder := newTestCert(t, &x509.Certificate{SerialNumber: big.NewInt(3), Subject: pkix.Name{CommonName: "OCSP"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageOCSPSigning}}, ca)
+	noEKU := newTestCert(t, &x509.Certificate{SerialNumber: big.NewInt(4), Subject: pkix.Name{CommonName: "server"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}}, ca)
+
+	now := time.Now()
+	tests := []struct {
+		name    string
+		der     []byte
+		wantErr string
+	}{
+		{"by issuer", ocspTestResponse(t, leaf.cert, ca, ca, now.Add(-time.Hour), now.Add(time.Hour)), ""},
+		{"by responder", ocspTestResponse(t, leaf.cert, ca, responder, now.Add(-time.Hour), time.Time{}), ""},
+		{"responder without EKU", ocspTestResponse(t, leaf.cert, ca, noEKU, now.Add(-time.Hour), now.Add(time.Hour)), "not for OCSP signing"},
+		{"another issuer", ocspTestResponse(t, leaf.cert, other, ca, now.Add(-time.Hour), now.Add(time.Hour)), "no status"},
+		{"future", ocspTestResponse(t, leaf.cert, ca, ca, now.Add(time.Hour), now.Add(2*time.Hour)), "in the future"},
+		{"expired", ocspTestResponse(t, leaf.cert, ca, ca, now.Add(-2*time.Hour), now.Add(-time.Hour)), "expired"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := parseOCSP(tt.der, leaf.cert, ca.cert)
+			if len(tt.wantErr) == 0 {
+				if err != nil || !bool(r.Good) {
+					t.Errorf("got %+v, %v", r, err)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
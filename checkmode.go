@@ -0,0 +1,214 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/http/httptrace"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// A one-shot check: -mode check runs a single check locally, printing each
+// step of a request (DNS, connect, TLS, first byte) and how a result meets
+// expectations, and exits with 0 if it's UP, 1 if DEGRADED and 2 otherwise.
+// It's meant for debugging check definitions before they're added.
+///////////////////////////////////////////////////////////////////////////////
+
+// checkResource returns a resource of -mode check: one named -sname in
+// -config or -saddr with -set fields.
+func checkResource() (*ResConf, error) {
+	if len(*configFilePath) > 0 && len(*sName) > 0 {
+		config, err := LoadConfig(*configFilePath)
+		if err != nil {
+			return nil, err
+		}
+		if c := config.Find(func(el *ResConf) bool { return el.Name == *sName }); c != nil {
+			return c, nil
+		}
+		return nil, fmt.Errorf("%s: no resource named %q", *configFilePath, *sName)
+	}
+	if len(*sAddr) == 0 {
+		return nil, fmt.Errorf("for -mode check one must specify -saddr, or -sname and -config")
+	}
+	c := &ResConf{Name: *sName, Address: *sAddr}
+	if len(c.Name) == 0 {
+		c.Name = c.Address
+	}
+	if len(*setFields) == 0 {
+		return c, c.validate()
+	}
+	return patchResource(c, []byte(*setFields))
+}
+
+// checkReport is a -json result of -mode check.
+type checkReport struct {
+	Name    string
+	Address string
+	Type    string `json:",omitempty"`
+	State   State
+	Steps   []*checkStep `json:",omitempty"`
+	*Status
+	Exports map[string]string `json:",omitempty"`
+}
+
+type checkStep struct {
+	Elapsed time.Duration // since a request started
+	Step    string
+}
+
+// verboseTransport records steps of requests, printing them unless quiet.
+type verboseTransport struct {
+	base  http.RoundTripper
+	quiet bool
+	steps []*checkStep
+}
+
+func (t *verboseTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	step := func(format string, args ...interface{}) {
+		s := &checkStep{time.Since(start), fmt.Sprintf(format, args...)}
+		t.steps = append(t.steps, s)
+		if !t.quiet {
+			fmt.Printf("  %8s  %s\n", humanizeLatency(s.Elapsed), s.Step)
+		}
+	}
+	trace := &httptrace.ClientTrace{
+		DNSDone: func(info httptrace.DNSDoneInfo) {
+			if info.Err != nil {
+				step("DNS lookup failed: %s", info.Err)
+				return
+			}
+			var addrs []string
+			for _, a := range info.Addrs {
+				addrs = append(addrs, a.String())
+			}
+			step("DNS lookup: %s", strings.Join(addrs, ", "))
+		},
+		ConnectDone: func(network, addr string, err error) {
+			if err != nil {
+				step("Connect to %s failed: %s", addr, err)
+				return
+			}
+			step("Connected to %s", addr)
+		},
+		TLSHandshakeDone: func(cs tls.ConnectionState, err error) {
+			if err != nil {
+				step("TLS handshake failed: %s", err)
+				return
+			}
+			step("TLS handshake: %s, %s", tls.VersionName(cs.Version), tls.CipherSuiteName(cs.CipherSuite))
+		},
+		WroteRequest: func(info httptrace.WroteRequestInfo) {
+			step("%s %s sent", req.Method, req.URL)
+		},
+		GotFirstResponseByte: func() { step("First response byte") },
+	}
+	if !t.quiet {
+		fmt.Printf("%s %s\n", req.Method, req.URL)
+	}
+	return t.base.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+}
+
+// RunCheck checks c once printing details, JSON if asJSON, it returns an
+// exit code.
+func RunCheck(c *ResConf, asJSON bool) int {
+	client := clientFor(c)
+	tr := &verboseTransport{base: client.Transport, quiet: asJSON}
+	client.Transport = tr
+	st := CheckStatus(c)
+	state := st.State()
+	if asJSON {
+		printJSON(&checkReport{c.Name, c.Address, c.Type, state, tr.steps, st, st.exports})
+		return checkExitCode(state)
+	}
+
+	typ := c.Type
+	if len(typ) == 0 {
+		typ = "http"
+	}
+	fmt.Printf("\n%s (%s) %s\n", c.Name, typ, c.Address)
+	want := http.StatusOK
+	if c.Expect != 0 {
+		want = c.Expect
+	}
+	if st.StatusCode == want {
+		fmt.Printf("  status      %d, expected %d: ok\n", st.StatusCode, want)
+	} else {
+		fmt.Printf("  status      %d, expected %d: FAILED\n", st.StatusCode, want)
+	}
+	if len(st.Error) > 0 {
+		fmt.Printf("  error       %s\n", st.Error)
+	}
+	if len(st.Proto) > 0 {
+		fmt.Printf("  protocol    %s\n", st.Proto)
+	}
+	if len(st.RemoteIP) > 0 {
+		fmt.Printf("  remote IP   %s\n", st.RemoteIP)
+	}
+	if len(st.TLSVersion) > 0 {
+		fmt.Printf("  TLS         %s, issuer %s\n", st.TLSVersion, st.CertIssuer)
+	}
+	if r := st.Cert; r != nil {
+		fmt.Printf("  certificate %s, expires %s (in %s)\n", r.Subject, formatTime(r.NotAfter), roundDuration(time.Until(r.NotAfter)))
+		if len(r.OCSP) > 0 {
+			fmt.Printf("  OCSP        %s, stapled: %t\n", r.OCSP, r.Stapled)
+		}
+		for _, w := range r.Warnings {
+			fmt.Printf("  warning     %s\n", w)
+		}
+	}
+	threshold := func(label string, d time.Duration, max string) {
+		switch limit := parseThreshold(max); {
+		case limit <= 0:
+			fmt.Printf("  %-11s %s\n", label, humanizeLatency(d))
+		case d > limit:
+			fmt.Printf("  %-11s %s, max %s: SLOW\n", label, humanizeLatency(d), limit)
+		default:
+			fmt.Printf("  %-11s %s, max %s: ok\n", label, humanizeLatency(d), limit)
+		}
+	}
+	if st.TTFB > 0 {
+		threshold("TTFB", st.TTFB, c.MaxTTFB)
+	}
+	threshold("total", st.Latency, c.MaxTotal)
+	for _, d := range st.Details {
+		fmt.Printf("  detail      %s\n", d)
+	}
+	var names []string
+	for k := range st.exports {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		fmt.Printf("  export      %s = %s\n", k, st.exports[k])
+	}
+	if len(st.Degraded) > 0 {
+		fmt.Printf("  degraded    %s\n", st.Degraded)
+	}
+
+	fmt.Printf("\n%s\n", state)
+	return checkExitCode(state)
+}
+
+func checkExitCode(state State) int {
+	switch state {
+	case StateUp:
+		return 0
+	case StateDegraded:
+		return 1
+	}
+	return 2
+}
+
+func runCheckMode() {
+	c, err := checkResource()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	os.Exit(RunCheck(c, *jsonOutput))
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// Command output: tables for people, JSON (-json) for scripts.
+///////////////////////////////////////////////////////////////////////////////
+
+func printJSON(v interface{}) {
+	b, err := json.MarshalIndent(v, "", " ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(string(b))
+}
+
+func printList(arr []*apiStatus) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "NAME\tSTATE\tCODE\tCHECKED\tLATENCY\tADDRESS\n")
+	for _, a := range arr {
+		code, checked := "-", "-"
+		if !a.When.IsZero() {
+			code, checked = fmt.Sprint(a.StatusCode), formatTime(a.When)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", a.Name, a.State, code, checked, humanizeLatency(a.Latency), a.Address)
+	}
+	w.Flush()
+}
+
+func printPreview(result *PreviewResult) {
+	if !result.Transition {
+		fmt.Printf("%s -> %s doesn't notify\n", *fromState, *toState)
+	} else if len(result.Deliveries) == 0 {
+		fmt.Printf("%s -> %s: no notifier matches %s\n", *fromState, *toState, *sName)
+	}
+	for _, d := range result.Deliveries {
+		fmt.Printf("%s\t%s\t%s\n", d.Notifier, d.Recipient, d.Message)
+	}
+}
+
+func printOnCall(arr []*OnCall) {
+	for _, oc := range arr {
+		if oc.Current == nil {
+			fmt.Printf("%s: nobody\n", oc.Rotation)
+			continue
+		}
+		fmt.Printf("%s: %s, %s takes over at %s\n", oc.Rotation, oc.Current.Name, oc.Next.Name, oc.Handoff.Format(time.RFC1123))
+	}
+}
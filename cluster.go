@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// A cluster: instances listed in -peers, sharing resources through -kv,
+// shard checks by rendezvous hashing of resource names over live members.
+// A member polls others every clusterEvery: one which doesn't answer is
+// dropped and its resources move to the rest, statuses of resources checked
+// elsewhere are copied, so each member shows all of them. Only an owner of
+// a resource notifies of it.
+///////////////////////////////////////////////////////////////////////////////
+
+var (
+	peerURLs = flag.String("peers", "", "Base URLs of all instances sharing resources, e.g. http://a:18080,http://b:18080, checks are sharded among live ones.")
+	selfURL  = flag.String("self", "", "A base URL of this instance, one of -peers.")
+)
+
+// clusterEvery is how often members poll each other.
+const clusterEvery = 5 * time.Second
+
+var clusterClient = &http.Client{Timeout: 2 * time.Second}
+
+type cluster struct {
+	self  string
+	peers []string // other members
+
+	m     sync.Mutex
+	alive []string // sorted, self included
+}
+
+// newCluster returns a cluster of -peers, nil if there are none.
+func newCluster() (*cluster, error) {
+	if len(*peerURLs) == 0 {
+		return nil, nil
+	}
+	self := strings.TrimSuffix(*selfURL, "/")
+	c := &cluster{self: self, alive: []string{self}}
+	found := false
+	for _, el := range strings.Split(*peerURLs, ",") {
+		el = strings.TrimSuffix(strings.TrimSpace(el), "/")
+		if el == self {
+			found = true
+		} else if len(el) > 0 {
+			c.peers = append(c.peers, el)
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("-self %q isn't one of -peers", *selfURL)
+	}
+	return c, nil
+}
+
+// owns reports whether this member checks a resource, always true without
+// a cluster.
+func (c *cluster) owns(name string) bool {
+	if c == nil {
+		return true
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+	var owner string
+	var best uint64
+	for _, member := range c.alive {
+		h := sha256.Sum256([]byte(member + "\x00" + name))
+		if sum := binary.BigEndian.Uint64(h[:]); len(owner) == 0 || sum > best {
+			owner, best = member, sum
+		}
+	}
+	return owner == c.self
+}
+
+func (c *cluster) members() []string {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.alive
+}
+
+type shardStatus struct {
+	Name    string
+	Address string
+	Status  *Status
+}
+
+// Shard returns statuses of checked resources this member owns.
+func (s *StatusChecker) Shard() []*shardStatus {
+	ret := []*shardStatus{}
+	for _, el := range s.Snapshot() {
+		if s.cluster.owns(el.Name) && el.Status.Checked() {
+			ret = append(ret, &shardStatus{el.Name, el.Address, el.Status})
+		}
+	}
+	return ret
+}
+
+// fetchShard returns statuses a peer owns.
+func fetchShard(peer string) ([]*shardStatus, error) {
+	req, err := http.NewRequest("GET", peer+"/api/shard", nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(*apiKey) > 0 {
+		req.Header.Set("Authorization", "Bearer "+*apiKey)
+	}
+	resp, err := clusterClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s", resp.Status)
+	}
+	var ret []*shardStatus
+	return ret, json.NewDecoder(resp.Body).Decode(&ret)
+}
+
+// runCluster polls peers every clusterEvery until ctx is done.
+func (s *StatusChecker) runCluster(ctx context.Context) {
+	t := time.NewTicker(clusterEvery)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		s.pollCluster()
+	}
+}
+
+// pollCluster updates live members and copies statuses they own.
+func (s *StatusChecker) pollCluster() {
+	c := s.cluster
+	shards := make([][]*shardStatus, len(c.peers))
+	var wg sync.WaitGroup
+	for i, peer := range c.peers {
+		wg.Add(1)
+		go func(i int, peer string) {
+			defer wg.Done()
+			var err error
+			if shards[i], err = fetchShard(peer); err != nil {
+				slog.Debug("Peer unreachable", "peer", peer, "error", err)
+			}
+		}(i, peer)
+	}
+	wg.Wait()
+
+	alive := []string{c.self}
+	for i, peer := range c.peers {
+		if shards[i] != nil {
+			alive = append(alive, peer)
+		}
+	}
+	sort.Strings(alive)
+	c.m.Lock()
+	changed := strings.Join(alive, ",") != strings.Join(c.alive, ",")
+	c.alive = alive
+	c.m.Unlock()
+	if changed {
+		slog.Info("Cluster changed", "members", strings.Join(alive, ","))
+	}
+
+	s.statusMutex.Lock()
+	for _, shard := range shards {
+		for _, el := range shard {
+			old, ok := s.statuses[el.Address]
+			if ok && !c.owns(el.Name) && el.Status.When.After(old.When) {
+				s.statuses[el.Address] = el.Status
+			}
+		}
+	}
+	s.statusMutex.Unlock()
+}
+
+func RegisterShardHandler(sc *StatusChecker) {
+	describeAPI(&apiOperation{
+		Method: "GET", Path: "/api/shard", Summary: "Statuses of resources checked by this cluster member",
+		Responses: map[int]interface{}{http.StatusOK: []*shardStatus{}},
+	})
+	http.HandleFunc("/api/shard", func(rw http.ResponseWriter, req *http.Request) {
+		writeJSON(rw, http.StatusOK, sc.Shard())
+	})
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// A conf.d part: -config may be a directory of *.json and *.yaml fragments,
+// e.g. one per team. Fragments are merged in an order of names and each
+// resource is saved back to a fragment it came from, ones added through an
+// API to apiFragment.
+///////////////////////////////////////////////////////////////////////////////
+
+const apiFragment = "api.json"
+
+func isFragment(name string) bool {
+	return strings.HasSuffix(name, ".json") || isYAML(name)
+}
+
+func loadConfigDir(dir string) (*Config, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	config := NewConfig()
+	config.fragments = make(map[string]*Config)
+	origin := make(map[string]string) // a fragment by a resource name
+	for _, e := range entries {
+		if e.IsDir() || !isFragment(e.Name()) {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		fc, err := LoadConfig(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range fc.Configs {
+			if f, ok := origin[c.Name]; ok {
+				return nil, fmt.Errorf("%s: resource %q is already in %s", path, c.Name, f)
+			}
+			origin[c.Name] = path
+			c.file = path
+		}
+		mergeConfig(config, fc)
+		fc.Configs = nil
+		config.fragments[path] = fc
+	}
+	return config, nil
+}
+
+// mergeConfig appends lists of src to dst and sets other settings src has.
+func mergeConfig(dst, src *Config) {
+	d, s := reflect.ValueOf(dst).Elem(), reflect.ValueOf(src).Elem()
+	for i := 0; i < d.NumField(); i++ {
+		if !d.Type().Field(i).IsExported() {
+			continue
+		}
+		f := s.Field(i)
+		switch {
+		case f.Kind() == reflect.Slice:
+			d.Field(i).Set(reflect.AppendSlice(d.Field(i), f))
+		case !f.IsZero():
+			d.Field(i).Set(f)
+		}
+	}
+}
+
+// saveDir saves resources of a config to their fragments of dir, a fragment
+// keeps its own settings.
+func (c *Config) saveDir(dir string) error {
+	byFile := make(map[string][]*ResConf)
+	for _, el := range c.Configs {
+		f := el.file
+		if len(f) == 0 {
+			f = filepath.Join(dir, apiFragment)
+		}
+		byFile[f] = append(byFile[f], el)
+	}
+	var files []string
+	for f := range c.fragments {
+		files = append(files, f)
+	}
+	for f := range byFile {
+		if _, ok := c.fragments[f]; !ok {
+			files = append(files, f)
+		}
+	}
+	sort.Strings(files)
+	var errs []string
+	for _, f := range files {
+		fc := &Config{}
+		if s, ok := c.fragments[f]; ok {
+			*fc = *s
+		}
+		fc.Configs = byFile[f]
+		if fc.Configs == nil {
+			fc.Configs = make([]*ResConf, 0)
+		}
+		if err := fc.Save(f); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// CORS: browser dashboards hosted elsewhere may read the API of allowed
+// origins. Preflight requests are answered before a login is required.
+///////////////////////////////////////////////////////////////////////////////
+
+type CORSConf struct {
+	Origins []string // e.g. https://dash.example.com, * for any
+	Methods []string `json:",omitempty"` // GET and HEAD if empty
+	Headers []string `json:",omitempty"` // Authorization, X-Api-Key and Content-Type if empty
+	// Credentials allows cookies, e.g. of a login, not with * origins.
+	Credentials bool   `json:",omitempty"`
+	MaxAge      string `json:",omitempty"` // of a cached preflight, e.g. 1h
+}
+
+func (c *CORSConf) allowed(origin string) bool {
+	for _, o := range c.Origins {
+		if o == origin || o == "*" && !c.Credentials {
+			return true
+		}
+	}
+	return false
+}
+
+// CORS wraps h so allowed origins may read responses, h is unchanged if c
+// is nil.
+func CORS(c *CORSConf, h http.Handler) http.Handler {
+	if c == nil || len(c.Origins) == 0 {
+		return h
+	}
+	methods := c.Methods
+	if len(methods) == 0 {
+		methods = []string{"GET", "HEAD"}
+	}
+	headers := c.Headers
+	if len(headers) == 0 {
+		headers = []string{"Authorization", "X-Api-Key", "Content-Type"}
+	}
+	maxAge := parseThreshold(c.MaxAge)
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		origin := req.Header.Get("Origin")
+		if len(origin) == 0 {
+			h.ServeHTTP(rw, req)
+			return
+		}
+		rw.Header().Add("Vary", "Origin")
+		if !c.allowed(origin) {
+			h.ServeHTTP(rw, req)
+			return
+		}
+		rw.Header().Set("Access-Control-Allow-Origin", origin)
+		if c.Credentials {
+			rw.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+		if req.Method == "OPTIONS" && len(req.Header.Get("Access-Control-Request-Method")) > 0 {
+			rw.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+			rw.Header().Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+			if maxAge > 0 {
+				rw.Header().Set("Access-Control-Max-Age", fmt.Sprint(int(maxAge.Seconds())))
+			}
+			rw.WriteHeader(http.StatusNoContent)
+			return
+		}
+		h.ServeHTTP(rw, req)
+	})
+}
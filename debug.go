@@ -0,0 +1,77 @@
+package main
+
+import (
+	"expvar"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/pprof"
+	"strings"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// Debug endpoints: pprof profiles and expvar variables (/debug/vars, with
+// statusmonitor's own under "statusmonitor") are served only on a separate
+// -debug-addr listener, never on the status page. If there are API keys an
+// admin one is required.
+///////////////////////////////////////////////////////////////////////////////
+
+var debugAddr = flag.String("debug-addr", "", "An address of pprof and expvar endpoints, e.g. localhost:6060, none if empty.")
+
+// publishVars publishes checker stats as an expvar variable.
+func (s *StatusChecker) publishVars() {
+	expvar.Publish("statusmonitor", expvar.Func(func() interface{} {
+		s.m.Lock()
+		n := len(s.config.Configs)
+		s.m.Unlock()
+		return map[string]interface{}{
+			"Resources": n,
+			"Workers":   s.pool.stats(),
+			"Queue":     s.queue.stats(),
+			"Timers":    s.timers.stats(),
+		}
+	}))
+}
+
+// debugHandler serves pprof and expvar to admins, or anyone if there are no
+// keys.
+func debugHandler(keys []*ApiKey) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	if len(keys) == 0 {
+		slog.Warn("No API keys, anyone can read debug endpoints", "address", *debugAddr)
+		return mux
+	}
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		k := findKey(keys, requestKey(req))
+		if k == nil {
+			rw.Header().Set("WWW-Authenticate", `Bearer realm="statusmonitor"`)
+			http.Error(rw, "API key required", http.StatusUnauthorized)
+			return
+		}
+		if c := k.caller(); !c.Role.allows(RoleAdmin) {
+			slog.Warn("Denied", "method", req.Method, "path", req.URL.Path, "caller", c.Name, "role", c.Role, "needs", RoleAdmin)
+			http.Error(rw, fmt.Sprintf("%s role required", RoleAdmin), http.StatusForbidden)
+			return
+		}
+		mux.ServeHTTP(rw, req)
+	})
+}
+
+// hideDebug wraps h so endpoints net/http/pprof and expvar register on the
+// default mux aren't served by it.
+func hideDebug(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if strings.HasPrefix(req.URL.Path, "/debug/") {
+			http.NotFound(rw, req)
+			return
+		}
+		h.ServeHTTP(rw, req)
+	})
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// A demo mode: local fake endpoints (healthy, slow, failing and flapping)
+// and checks of them, to explore the UI, alerts and history without real
+// targets.
+///////////////////////////////////////////////////////////////////////////////
+
+var demo = flag.Bool("demo", false, "Check local fake endpoints instead of a config, -interval is 10s unless set.")
+
+// flapEvery is how long the flapping endpoint stays UP or DOWN.
+const flapEvery = 2 * time.Minute
+
+func demoHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthy", func(rw http.ResponseWriter, req *http.Request) {
+		fmt.Fprintln(rw, "ok")
+	})
+	mux.HandleFunc("/slow", func(rw http.ResponseWriter, req *http.Request) {
+		time.Sleep(1200 * time.Millisecond)
+		fmt.Fprintln(rw, "ok, eventually")
+	})
+	mux.HandleFunc("/failing", func(rw http.ResponseWriter, req *http.Request) {
+		http.Error(rw, "always failing", http.StatusServiceUnavailable)
+	})
+	mux.HandleFunc("/flapping", func(rw http.ResponseWriter, req *http.Request) {
+		if time.Now().Unix()/int64(flapEvery.Seconds())%2 == 1 {
+			http.Error(rw, "down for a while", http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintln(rw, "up for a while")
+	})
+	return mux
+}
+
+// DemoConfig starts fake endpoints and returns a config checking them.
+func DemoConfig() (*Config, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	go http.Serve(l, demoHandler())
+	base := "http://" + l.Addr().String()
+	slog.Info("Demo endpoints", "address", base)
+
+	tags := map[string]string{"demo": ""}
+	c := NewConfig()
+	c.Add(&ResConf{Name: "healthy", Address: base + "/healthy", Tags: tags})
+	c.Add(&ResConf{Name: "slow", Address: base + "/slow", Tags: tags, MaxTTFB: "500ms"})
+	c.Add(&ResConf{Name: "failing", Address: base + "/failing", Tags: tags})
+	c.Add(&ResConf{Name: "flapping", Address: base + "/flapping", Tags: tags})
+
+	explicit := false
+	flag.Visit(func(f *flag.Flag) { explicit = explicit || f.Name == "interval" })
+	if !explicit {
+		*interval = 10 * time.Second
+	}
+	return c, nil
+}
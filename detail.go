@@ -0,0 +1,75 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// A check detail page.
+///////////////////////////////////////////////////////////////////////////////
+
+const detailTmplStr = `
+<html><head><title>{{.Conf.Name}}</title></head>
+<style type="text/css">
+table, th, td {
+	border: 1px solid black;
+}
+</style>
+<body>
+<p><a href="/status">Status</a> | <a href="/watch?name={{.Conf.Name}}">Obserwacja</a> | <a href="/incidents?name={{.Conf.Name}}">Incydenty</a></p>
+<table>
+<tr><td>Nazwa</td><td>{{.Conf.Name}}</td></tr>
+<tr><td>Adres</td><td>{{redactAddress .Conf.Address}}</td></tr>
+{{if not .Conf.Expires.IsZero}}<tr><td>Wygasa</td><td>{{formatTime .Conf.Expires}}</td></tr>{{end}}
+{{with .Conf.Discovered}}<tr><td>Wykryty przez</td><td>{{.}}</td></tr>{{end}}
+{{if .Conf.Private}}<tr><td>Prywatny</td><td>tylko kody i czasy</td></tr>{{end}}
+{{range $k, $v := .Conf.Tags}}<tr><td>Tag</td><td>{{$k}}{{if $v}}={{$v}}{{end}}</td></tr>
+{{end}}
+{{if .Coverage}}<tr><td>Dostępność (24h)</td><td><span style="color: {{uptimeColor .Uptime}}">{{printf "%.2f" .Uptime}}%</span>, dane z {{printf "%.1f" .Coverage}}% czasu</td></tr>{{end}}
+{{if not .Status.Checked}}
+<tr><td>Status</td><td>oczekuje na pierwsze sprawdzenie</td></tr>
+{{else}}{{with .Status}}
+<tr><td>Ostatnio sprawdzony</td><td>{{formatTime .When}}</td></tr>
+<tr><td>Status</td><td>{{.StatusCode}} {{.State}}{{if .Slow}} (wolno: {{.Slow}}){{end}}{{if .Degraded}} ({{.Degraded}}){{end}}</td></tr>
+{{range .Details}}<tr><td>Szczegóły</td><td>{{.}}</td></tr>
+{{end}}
+{{if .Error}}<tr><td>Błąd</td><td>{{.Error}}</td></tr>{{end}}
+<tr><td>TTFB</td><td>{{.TTFB}}</td></tr>
+<tr><td>Czas</td><td>{{.Latency}}</td></tr>
+<tr><td>HTTP</td><td>{{or .Proto "-"}}</td></tr>
+<tr><td>TLS</td><td>{{or .TLSVersion "-"}}</td></tr>
+<tr><td>Wystawca certyfikatu</td><td>{{or .CertIssuer "-"}}</td></tr>
+{{with .Cert}}
+<tr><td>Certyfikat ważny do</td><td>{{formatTime .NotAfter}}</td></tr>
+{{if .OCSP}}<tr><td>OCSP</td><td>{{.OCSP}}{{if .Stapled}} (stapled){{end}}</td></tr>{{end}}
+{{range .Warnings}}<tr><td>Ostrzeżenie</td><td>{{.}}</td></tr>{{end}}
+{{end}}
+<tr><td>IP</td><td>{{or .RemoteIP "-"}}</td></tr>
+{{end}}{{end}}
+</table>
+</body>
+</html>
+`
+
+var detailTmpl = page("check", detailTmplStr)
+
+func RegisterDetailHandler(sc *StatusChecker) {
+	http.HandleFunc("/check", func(rw http.ResponseWriter, req *http.Request) {
+		conf, st := sc.Lookup(req.FormValue("name"))
+		if conf == nil {
+			http.NotFound(rw, req)
+			return
+		}
+		data := struct {
+			Conf             *ResConf
+			Status           *Status
+			Uptime, Coverage float64
+		}{Conf: conf, Status: st}
+		data.Uptime, data.Coverage = sc.Uptime(conf.Address, 24*time.Hour)
+		if err := detailTmpl.Execute(rw, data); err != nil {
+			slog.Error("Template render", "error", err)
+		}
+	})
+}
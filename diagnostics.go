@@ -0,0 +1,202 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// A diagnostics part: bundles recent results, a sanitized config, logs and
+// internal metrics of a check into a zip, e.g. for a vendor support ticket.
+///////////////////////////////////////////////////////////////////////////////
+
+var startTime = time.Now()
+
+// logBuffer keeps the last lines written to a log.
+type logBuffer struct {
+	m     sync.Mutex
+	lines []string
+	size  int
+}
+
+var serverLog = &logBuffer{size: 5000}
+
+func (l *logBuffer) Write(p []byte) (int, error) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		l.lines = append(l.lines, line)
+	}
+	if len(l.lines) > l.size {
+		l.lines = l.lines[len(l.lines)-l.size:]
+	}
+	return len(p), nil
+}
+
+// Grep returns lines containing any of substrs.
+func (l *logBuffer) Grep(substrs ...string) []string {
+	l.m.Lock()
+	defer l.m.Unlock()
+	var ret []string
+	for _, line := range l.lines {
+		for _, s := range substrs {
+			if strings.Contains(line, s) {
+				ret = append(ret, line)
+				break
+			}
+		}
+	}
+	return ret
+}
+
+type DiagnosticsRequest struct {
+	Name  string
+	Since time.Duration
+}
+
+type diagnosticsMetrics struct {
+	Uptime      string
+	Resources   int
+	QueueLen    int
+	QueueCap    int
+	Queue       map[string]schedulerStats
+	Workers     int
+	Timers      timerStats
+	Watches     int
+	Subscribers int
+	Notifiers   int
+	CheckTypes  []string
+	Pacers      map[string]pacerStats `json:",omitempty"`
+	Goroutines  int
+	HeapAlloc   uint64
+}
+
+var secretKey = regexp.MustCompile(`(?i)key|token|secret|password|authorization|credential`)
+
+// sanitize replaces values of secret looking keys and credentials of URLs
+// in a JSON-like value.
+func sanitize(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, el := range t {
+			if s, ok := el.(string); ok && len(s) > 0 && secretKey.MatchString(k) {
+				t[k] = "REDACTED"
+			} else if ok && (strings.Contains(s, "://") || addressPassword.MatchString(s)) {
+				t[k] = redactAddress(s)
+			} else {
+				t[k] = sanitize(el)
+			}
+		}
+	case []interface{}:
+		for i, el := range t {
+			t[i] = sanitize(el)
+		}
+	}
+	return v
+}
+
+func (s *StatusChecker) metrics() *diagnosticsMetrics {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	s.m.Lock()
+	m := &diagnosticsMetrics{
+		Uptime:     time.Since(startTime).String(),
+		Resources:  len(s.config.Configs),
+		QueueCap:   queueSize,
+		Workers:    s.pool.stats().Workers,
+		Watches:    len(s.watches),
+		Notifiers:  len(s.router.channels),
+		CheckTypes: checkTypes(),
+		Goroutines: runtime.NumGoroutine(),
+		HeapAlloc:  ms.HeapAlloc,
+	}
+	s.m.Unlock()
+	m.Timers = s.timers.stats()
+	m.Queue = s.queue.stats()
+	for _, st := range m.Queue {
+		m.QueueLen += st.Waiting
+	}
+	m.Pacers = make(map[string]pacerStats)
+	for name, p := range s.pacers {
+		m.Pacers[name] = p.stats()
+	}
+	s.subMutex.Lock()
+	m.Subscribers = len(s.subs)
+	s.subMutex.Unlock()
+	return m
+}
+
+// Diagnostics returns a zip archive for a resource with a given name.
+func (s *StatusChecker) Diagnostics(name string, since time.Duration) ([]byte, error) {
+	conf, _ := s.Lookup(name)
+	if conf == nil {
+		return nil, fmt.Errorf("no resource named %q", name)
+	}
+
+	s.m.Lock()
+	b, err := json.Marshal(s.config)
+	s.m.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	var config map[string]interface{}
+	if err := json.Unmarshal(b, &config); err != nil {
+		return nil, err
+	}
+	// A resource goes through JSON too, so sanitize sees its fields.
+	var resource interface{}
+	if b, err = json.Marshal(redactConf(conf)); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &resource); err != nil {
+		return nil, err
+	}
+	config["Configs"] = []interface{}{resource}
+
+	files := []struct {
+		name string
+		v    interface{}
+	}{
+		{"results.json", s.History(conf.Address, time.Now().Add(-since))},
+		{"config.json", sanitize(config)},
+		{"metrics.json", s.metrics()},
+	}
+	buf := &bytes.Buffer{}
+	z := zip.NewWriter(buf)
+	for _, f := range files {
+		w, err := z.Create(f.name)
+		if err != nil {
+			return nil, err
+		}
+		b, err := json.MarshalIndent(f.v, "", " ")
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(b); err != nil {
+			return nil, err
+		}
+	}
+	w, err := z.Create("log.txt")
+	if err != nil {
+		return nil, err
+	}
+	if conf.Private {
+		fmt.Fprintln(w, "Logs are not collected for a private resource.")
+	} else {
+		address := redactConf(conf).Address
+		for _, line := range serverLog.Grep(conf.Name, conf.Address) {
+			fmt.Fprintln(w, strings.ReplaceAll(line, conf.Address, address))
+		}
+	}
+	if err := z.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
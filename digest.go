@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"net/smtp"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// Daily/weekly digests: a summary of uptime, incidents and the slowest
+// resources, sent independently of real-time alerts.
+///////////////////////////////////////////////////////////////////////////////
+
+const (
+	DigestDaily  = "daily"
+	DigestWeekly = "weekly"
+)
+
+type DigestConf struct {
+	Period  string // DigestDaily or DigestWeekly
+	At      string `json:",omitempty"` // a local time of day, e.g. 08:00, midnight if empty
+	Weekday string `json:",omitempty"` // of a weekly digest, Monday if empty
+	// A digest is emailed, POSTed as JSON or both.
+	SMTP *SMTPConf `json:",omitempty"`
+	URL  string    `json:",omitempty"`
+}
+
+type SMTPConf struct {
+	Addr     string // host:port
+	User     string `json:",omitempty"`
+	Password string `json:",omitempty"`
+	From     string
+	To       []string
+}
+
+type digestStats struct {
+	Name         string
+	Checks       int
+	Up           int
+	Incidents    int
+	TotalLatency time.Duration
+	MaxLatency   time.Duration
+	uptime       uptime
+	downSince    time.Time
+	// LongestOutage includes an outage ongoing when a digest is taken.
+	LongestOutage time.Duration `json:",omitempty"`
+
+	// Computed when a digest is taken.
+	Uptime     float64 // percent of time with data
+	Coverage   float64 // percent of the digest period with data
+	AvgLatency time.Duration
+}
+
+type Digest struct {
+	conf  *DigestConf
+	m     sync.Mutex
+	since time.Time
+	stats map[string]*digestStats
+}
+
+func NewDigest(c *DigestConf) *Digest {
+	return &Digest{conf: c, since: time.Now(), stats: make(map[string]*digestStats)}
+}
+
+func (c *Config) Digests() []*Digest {
+	var ret []*Digest
+	for _, d := range c.Digest {
+		ret = append(ret, NewDigest(d))
+	}
+	return ret
+}
+
+// record adds a result to a digest, incident is set if the result started
+// an outage.
+func (d *Digest) record(c *ResConf, st *Status, incident bool) {
+	d.m.Lock()
+	defer d.m.Unlock()
+	s, ok := d.stats[c.Name]
+	if !ok {
+		s = &digestStats{Name: c.Name}
+		d.stats[c.Name] = s
+	}
+	s.Checks++
+	if st.State() != StateDown {
+		s.Up++
+	}
+	s.uptime.add(st)
+	if st.State() == StateDown {
+		if s.downSince.IsZero() {
+			s.downSince = st.When
+		}
+		s.outage(st.When)
+	} else if !s.downSince.IsZero() {
+		s.outage(st.When)
+		s.downSince = time.Time{}
+	}
+	if incident {
+		s.Incidents++
+	}
+	s.TotalLatency += st.Latency
+	if st.Latency > s.MaxLatency {
+		s.MaxLatency = st.Latency
+	}
+}
+
+func (s *digestStats) outage(until time.Time) {
+	if d := until.Sub(s.downSince); d > s.LongestOutage {
+		s.LongestOutage = d
+	}
+}
+
+// next returns when a digest is due after t.
+func (d *Digest) next(t time.Time) time.Time {
+	at, err := time.Parse("15:04", d.conf.At)
+	if err != nil && len(d.conf.At) > 0 {
+		slog.Warn("Digest bad At", "at", d.conf.At, "error", err)
+	}
+	n := time.Date(t.Year(), t.Month(), t.Day(), at.Hour(), at.Minute(), 0, 0, time.Local)
+	if d.conf.Period == DigestWeekly {
+		wd := time.Monday
+		for i := time.Sunday; i <= time.Saturday; i++ {
+			if strings.EqualFold(i.String(), d.conf.Weekday) {
+				wd = i
+			}
+		}
+		n = n.AddDate(0, 0, (int(wd)-int(n.Weekday())+7)%7)
+		if !n.After(t) {
+			n = n.AddDate(0, 0, 7)
+		}
+		return n
+	}
+	if !n.After(t) {
+		n = n.AddDate(0, 0, 1)
+	}
+	return n
+}
+
+func (d *Digest) run() {
+	for {
+		time.Sleep(time.Until(d.next(time.Now())))
+		if err := d.send(); err != nil {
+			slog.Error("Digest", "error", err)
+		}
+	}
+}
+
+type digestPayload struct {
+	Period    string
+	From      time.Time
+	To        time.Time
+	Resources []*digestStats
+	Slowest   []*digestStats
+	Text      string `json:"text"` // e.g. for Slack compatible webhooks
+}
+
+// take returns a digest of results since the last one and starts a new one.
+func (d *Digest) take() *digestPayload {
+	d.m.Lock()
+	p := &digestPayload{Period: d.conf.Period, From: d.since, To: time.Now()}
+	for _, s := range d.stats {
+		s.Uptime, s.Coverage = s.uptime.percents(d.since, p.To)
+		s.AvgLatency = s.TotalLatency / time.Duration(s.Checks)
+		if !s.downSince.IsZero() {
+			s.outage(p.To)
+		}
+		p.Resources = append(p.Resources, s)
+	}
+	d.since = p.To
+	d.stats = make(map[string]*digestStats)
+	d.m.Unlock()
+
+	sort.Slice(p.Resources, func(i, j int) bool { return p.Resources[i].Name < p.Resources[j].Name })
+	p.Slowest = append(p.Slowest, p.Resources...)
+	sort.Slice(p.Slowest, func(i, j int) bool { return p.Slowest[i].AvgLatency > p.Slowest[j].AvgLatency })
+	if len(p.Slowest) > 5 {
+		p.Slowest = p.Slowest[:5]
+	}
+	p.Text = p.text()
+	return p
+}
+
+func (p *digestPayload) text() string {
+	b := &bytes.Buffer{}
+	fmt.Fprintf(b, "statusmonitor %s digest %s - %s\n\nUptime:\n", p.Period, formatTime(p.From), formatTime(p.To))
+	w := tabwriter.NewWriter(b, 0, 4, 2, ' ', 0)
+	for _, s := range p.Resources {
+		fmt.Fprintf(w, "  %s\t%.2f%%\t%d incidents", s.Name, s.Uptime, s.Incidents)
+		if s.LongestOutage > 0 {
+			fmt.Fprintf(w, ", longest %s", roundDuration(s.LongestOutage))
+		}
+		fmt.Fprintf(w, "\t%.1f%% data coverage\n", s.Coverage)
+	}
+	w.Flush()
+	fmt.Fprintf(b, "\nSlowest:\n")
+	w = tabwriter.NewWriter(b, 0, 4, 2, ' ', 0)
+	for _, s := range p.Slowest {
+		fmt.Fprintf(w, "  %s\tavg %s\tmax %s\n", s.Name, roundDuration(s.AvgLatency), roundDuration(s.MaxLatency))
+	}
+	w.Flush()
+	return b.String()
+}
+
+func (d *Digest) send() error {
+	p := d.take()
+	var errs []string
+	if c := d.conf.SMTP; c != nil {
+		if err := sendMail(c, fmt.Sprintf("statusmonitor %s digest", p.Period), p.Text); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(d.conf.URL) > 0 {
+		if err := postJSON(d.conf.URL, p, nil); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	slog.Info("Sent digest", "period", p.Period)
+	return nil
+}
+
+func sendMail(c *SMTPConf, subject, body string) error {
+	var auth smtp.Auth
+	if len(c.User) > 0 {
+		host := c.Addr
+		if i := strings.LastIndex(host, ":"); i >= 0 {
+			host = host[:i]
+		}
+		auth = smtp.PlainAuth("", c.User, c.Password, host)
+	}
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s",
+		c.From, strings.Join(c.To, ", "), subject, strings.Replace(body, "\n", "\r\n", -1))
+	return smtp.SendMail(c.Addr, auth, c.From, c.To, []byte(msg))
+}
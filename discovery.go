@@ -0,0 +1,67 @@
+package main
+
+import (
+	"log/slog"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// Discovery: resources created from a source, e.g. Docker, instead of a
+// config. Each source syncs its whole set, so resources it no longer finds
+// are removed. Discovered resources are never saved to a config and are
+// left alone by a reload.
+///////////////////////////////////////////////////////////////////////////////
+
+// Discovered returns a source of a discovered resource, empty for ones of
+// a config.
+func (c *ResConf) Discovered() string {
+	return c.discovered
+}
+
+// syncDiscovered adds, updates and removes resources of a source to match
+// confs. A resource of a config or another source with the same name wins.
+func (s *StatusChecker) syncDiscovered(source string, confs []*ResConf) {
+	s.m.Lock()
+	live := make(map[string]*ResConf)
+	for _, el := range s.config.Configs {
+		live[el.Name] = el
+	}
+	s.m.Unlock()
+
+	seen := make(map[string]bool)
+	for _, c := range confs {
+		c.discovered = source
+		if seen[c.Name] {
+			slog.Warn("Discovery duplicated resource", "source", source, "resource", c.Name)
+			continue
+		}
+		seen[c.Name] = true
+		old, ok := live[c.Name]
+		switch {
+		case !ok:
+			s.Add(c)
+		case old.discovered != source:
+			slog.Warn("Discovery resource already exists", "source", source, "resource", c.Name)
+		case !sameResource(old, c):
+			if err := s.Update(c.Name, c); err != nil {
+				slog.Error("Discovery", "source", source, "error", err)
+			}
+		}
+	}
+	for name, el := range live {
+		if el.discovered == source && !seen[name] {
+			s.Remove(func(el *ResConf) bool { return el.Name == name })
+		}
+	}
+}
+
+// withoutDiscovered returns a copy of a config to save.
+func (c *Config) withoutDiscovered() *Config {
+	cp := *c
+	cp.Configs = make([]*ResConf, 0, len(c.Configs))
+	for _, el := range c.Configs {
+		if len(el.discovered) == 0 {
+			cp.Configs = append(cp.Configs, el)
+		}
+	}
+	return &cp
+}
@@ -0,0 +1,141 @@
+//go:build !nodns
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// A DNS propagation check: a record (Address is its name) is queried at
+// several resolvers and disagreement is reported, e.g. to follow a DNS
+// change propagating after a cutover.
+///////////////////////////////////////////////////////////////////////////////
+
+// defaultResolvers are used if a resource has no Resolvers, an empty one is
+// a resolver of checks, see ResConf.resolver.
+var defaultResolvers = []string{"8.8.8.8", "1.1.1.1", ""}
+
+func init() {
+	RegisterChecker("dns", checkDNS)
+}
+
+func lookupRecord(ctx context.Context, r *net.Resolver, typ, name string) ([]string, error) {
+	var ret []string
+	switch strings.ToUpper(typ) {
+	case "", "A", "AAAA":
+		network := "ip4"
+		if strings.EqualFold(typ, "AAAA") {
+			network = "ip6"
+		}
+		ips, err := r.LookupIP(ctx, network, name)
+		if err != nil {
+			return nil, err
+		}
+		for _, ip := range ips {
+			ret = append(ret, ip.String())
+		}
+	case "CNAME":
+		cname, err := r.LookupCNAME(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, cname)
+	case "MX":
+		mxs, err := r.LookupMX(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		for _, mx := range mxs {
+			ret = append(ret, fmt.Sprintf("%d %s", mx.Pref, mx.Host))
+		}
+	case "NS":
+		nss, err := r.LookupNS(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		for _, ns := range nss {
+			ret = append(ret, ns.Host)
+		}
+	case "TXT":
+		txts, err := r.LookupTXT(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		ret = txts
+	default:
+		return nil, fmt.Errorf("unsupported record type %s", typ)
+	}
+	sort.Strings(ret)
+	return ret, nil
+}
+
+func checkDNS(c *ResConf) *Status {
+	st := &Status{When: time.Now()}
+	resolvers := c.Resolvers
+	if len(resolvers) == 0 {
+		resolvers = defaultResolvers
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	type answer struct {
+		records []string
+		err     error
+	}
+	answers := make([]chan answer, len(resolvers))
+	for i, addr := range resolvers {
+		answers[i] = make(chan answer, 1)
+		go func(addr string, ret chan answer) {
+			if len(addr) == 0 {
+				addr = c.resolver()
+			}
+			records, err := lookupRecord(ctx, newResolver(addr), c.Record, c.Address)
+			ret <- answer{records, err}
+		}(addr, answers[i])
+	}
+
+	distinct := make(map[string]bool)
+	var failed int
+	var lastErr error
+	for i, addr := range resolvers {
+		a := <-answers[i]
+		if len(addr) == 0 {
+			addr = c.resolver()
+		}
+		if len(addr) == 0 {
+			addr = "system"
+		}
+		if a.err != nil {
+			failed++
+			lastErr = a.err
+			st.Details = append(st.Details, fmt.Sprintf("%s: %s", addr, a.err))
+			continue
+		}
+		key := strings.Join(a.records, ", ")
+		distinct[key] = true
+		st.Details = append(st.Details, fmt.Sprintf("%s: %s", addr, key))
+	}
+	st.Latency = time.Since(st.When)
+
+	switch {
+	case failed == len(resolvers):
+		st.StatusCode = UnknownError
+		st.Error = c.errorText(lastErr)
+	case len(distinct) > 1:
+		st.StatusCode = http.StatusOK
+		st.Degraded = fmt.Sprintf("resolvers disagree (%d different answers)", len(distinct))
+	case failed > 0:
+		st.StatusCode = http.StatusOK
+		st.Degraded = fmt.Sprintf("%d of %d resolvers failed", failed, len(resolvers))
+	default:
+		st.StatusCode = http.StatusOK
+	}
+	return st
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// Docker discovery: running containers with a statusmonitor.url label are
+// checked, they're synced again on every container start and stop.
+// Labels:
+//   statusmonitor.url       an address to check, required
+//   statusmonitor.name      a resource name, the container name if empty
+//   statusmonitor.interval  e.g. 30s
+//   statusmonitor.expect    a status code of a healthy response
+//   statusmonitor.group     see Groups
+///////////////////////////////////////////////////////////////////////////////
+
+const dockerLabel = "statusmonitor."
+
+var (
+	dockerDiscovery = flag.Bool("docker", false, "Check containers with statusmonitor.* labels of a local Docker daemon.")
+	dockerHost      = flag.String("docker-host", os.Getenv("DOCKER_HOST"), "A Docker daemon, unix:///var/run/docker.sock if empty.")
+)
+
+type dockerContainer struct {
+	Names  []string
+	Labels map[string]string
+}
+
+type docker struct {
+	client *http.Client
+	base   string
+}
+
+func newDocker(host string) (*docker, error) {
+	if len(host) == 0 {
+		host = "unix:///var/run/docker.sock"
+	}
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "unix":
+		tr := &http.Transport{DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", u.Path)
+		}}
+		return &docker{&http.Client{Transport: tr}, "http://docker"}, nil
+	case "tcp", "http":
+		return &docker{&http.Client{}, "http://" + u.Host}, nil
+	}
+	return nil, fmt.Errorf("unsupported Docker host %s", host)
+}
+
+func (d *docker) get(path string, filters map[string][]string) (*http.Response, error) {
+	f, err := json.Marshal(filters)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := d.client.Get(d.base + path + "?filters=" + url.QueryEscape(string(f)))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		b, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(b)))
+	}
+	return resp, nil
+}
+
+// resources returns resources of labeled running containers.
+func (d *docker) resources() ([]*ResConf, error) {
+	resp, err := d.get("/containers/json", map[string][]string{"label": {dockerLabel + "url"}})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var containers []*dockerContainer
+	if err := json.NewDecoder(resp.Body).Decode(&containers); err != nil {
+		return nil, err
+	}
+	var ret []*ResConf
+	for _, c := range containers {
+		ret = append(ret, c.resource())
+	}
+	return ret, nil
+}
+
+func (c *dockerContainer) resource() *ResConf {
+	label := func(name string) string { return c.Labels[dockerLabel+name] }
+	r := &ResConf{
+		Name:     label("name"),
+		Address:  label("url"),
+		Interval: label("interval"),
+		Group:    label("group"),
+	}
+	if len(r.Name) == 0 && len(c.Names) > 0 {
+		r.Name = strings.TrimPrefix(c.Names[0], "/")
+	}
+	if e := label("expect"); len(e) > 0 {
+		var err error
+		if r.Expect, err = strconv.Atoi(e); err != nil {
+			slog.Warn("Discovery bad label", "source", "docker", "resource", r.Name, "label", dockerLabel+"expect", "value", e)
+		}
+	}
+	return r
+}
+
+// discoverDocker syncs containers now and after every change.
+func (s *StatusChecker) discoverDocker(d *docker) {
+	for {
+		// Subscribed first, so no change is missed while listing.
+		resp, err := d.get("/events", map[string][]string{
+			"type":  {"container"},
+			"event": {"start", "die"},
+			"label": {dockerLabel + "url"},
+		})
+		if err == nil {
+			err = s.syncDocker(d, json.NewDecoder(resp.Body))
+			resp.Body.Close()
+		}
+		slog.Error("Discovery", "source", "docker", "error", err)
+		time.Sleep(10 * time.Second)
+	}
+}
+
+// syncDocker syncs containers after each event, it returns when a stream of
+// events fails.
+func (s *StatusChecker) syncDocker(d *docker, events *json.Decoder) error {
+	for {
+		confs, err := d.resources()
+		if err != nil {
+			return err
+		}
+		s.syncDiscovered("docker", confs)
+		var ev struct{ Action string }
+		if err := events.Decode(&ev); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// Environment variables in a config: ${VAR} in any value, e.g. an address,
+// a credential or a webhook URL, is replaced when a config is loaded. A
+// saved config keeps references, never their values. ${resource.variable}
+// with a dot is an inter-check variable, see varRef.
+///////////////////////////////////////////////////////////////////////////////
+
+var envRef = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandEnv replaces references in values of a config, it fails if a
+// variable isn't set.
+func (c *Config) expandEnv() error {
+	var missing []string
+	c.unexpanded = make(map[string]string)
+	mapStrings(reflect.ValueOf(c), func(s string) string {
+		if !envRef.MatchString(s) {
+			return s
+		}
+		ret := envRef.ReplaceAllStringFunc(s, func(ref string) string {
+			name := envRef.FindStringSubmatch(ref)[1]
+			v, ok := os.LookupEnv(name)
+			if !ok {
+				missing = append(missing, name)
+			}
+			return v
+		})
+		c.unexpanded[ret] = s
+		return ret
+	})
+	if len(missing) > 0 {
+		return fmt.Errorf("environment variables not set: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// withReferences returns a copy of a config with values expanded from
+// references replaced back with them.
+func (c *Config) withReferences() (*Config, error) {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	ret := &Config{}
+	if err := json.Unmarshal(b, ret); err != nil {
+		return nil, err
+	}
+	mapStrings(reflect.ValueOf(ret), func(s string) string {
+		if ref, ok := c.unexpanded[s]; ok {
+			return ref
+		}
+		return s
+	})
+	return ret, nil
+}
+
+// mapStrings replaces strings in exported fields, slices and map values
+// reachable from v with results of f.
+func mapStrings(v reflect.Value, f func(string) string) {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if !v.IsNil() {
+			mapStrings(v.Elem(), f)
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() {
+				mapStrings(v.Field(i), f)
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			mapStrings(v.Index(i), f)
+		}
+	case reflect.Map:
+		if v.Type().Elem().Kind() != reflect.String {
+			for _, k := range v.MapKeys() {
+				mapStrings(v.MapIndex(k), f)
+			}
+			return
+		}
+		for _, k := range v.MapKeys() {
+			s := v.MapIndex(k).String()
+			if r := f(s); r != s {
+				v.SetMapIndex(k, reflect.ValueOf(r).Convert(v.Type().Elem()))
+			}
+		}
+	case reflect.String:
+		if v.CanSet() {
+			v.SetString(f(v.String()))
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// An exec check: a command of -exec-dir, Address is its name and arguments,
+// runs without a shell and its exit code is a state like of a Nagios
+// plugin: 0 UP, 1 DEGRADED, 2 and others DOWN. A first line of its output
+// (without performance data after |) is a reason, next ones are details.
+///////////////////////////////////////////////////////////////////////////////
+
+var (
+	execDir     = flag.String("exec-dir", "", "A directory of commands of exec checks, exec checks are disabled if empty.")
+	execTimeout = flag.Duration("exec-timeout", 30*time.Second, "A timeout of exec checks, a command is killed after it.")
+)
+
+// maxExecOutput is how much of a command output is kept.
+const maxExecOutput = 4096
+
+func init() {
+	RegisterChecker("exec", checkExec)
+}
+
+func validateExec(addr string) error {
+	if len(*execDir) == 0 {
+		return fmt.Errorf("exec checks are disabled, see -exec-dir")
+	}
+	args := strings.Fields(addr)
+	if len(args) == 0 || strings.ContainsAny(args[0], `/\`) || args[0] == ".." {
+		return fmt.Errorf("%q: a command must be a name of a file in -exec-dir", addr)
+	}
+	return nil
+}
+
+// limitedBuffer keeps the beginning of what's written to it.
+type limitedBuffer struct {
+	bytes.Buffer
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := maxExecOutput - b.Len(); room > 0 {
+		b.Buffer.Write(p[:min(len(p), room)])
+	}
+	return len(p), nil
+}
+
+func checkExec(c *ResConf) *Status {
+	st := &Status{When: time.Now()}
+	if err := validateExec(c.Address); err != nil {
+		st.StatusCode = UnknownError
+		st.Error = err.Error()
+		return st
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *execTimeout)
+	defer cancel()
+	args := strings.Fields(c.Address)
+	cmd := exec.CommandContext(ctx, filepath.Join(*execDir, args[0]), args[1:]...)
+	cmd.Dir = *execDir
+	var out limitedBuffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	err := cmd.Run()
+	st.Latency = time.Since(st.When)
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	reason, _, _ := strings.Cut(lines[0], "|")
+	reason = strings.TrimSpace(reason)
+	if !c.Private {
+		st.Details = lines[1:]
+	}
+	code := 0
+	var exitErr *exec.ExitError
+	switch {
+	case ctx.Err() != nil:
+		st.StatusCode = UnknownError
+		st.Error = c.Name + ": timeout after " + execTimeout.String()
+		return st
+	case errors.As(err, &exitErr):
+		code = exitErr.ExitCode()
+	case err != nil:
+		st.StatusCode = UnknownError
+		st.Error = c.errorText(err)
+		return st
+	}
+	if len(reason) == 0 || c.Private {
+		reason = fmt.Sprintf("exit code %d", code)
+	}
+	switch code {
+	case 0:
+		st.StatusCode = http.StatusOK
+	case 1:
+		st.StatusCode = http.StatusOK
+		st.Degraded = reason
+	default:
+		st.StatusCode = UnknownError
+		st.Error = reason
+	}
+	return st
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// Export and import of resources, e.g. to migrate them between instances:
+// -mode export writes resources of a running server as a config and
+// -mode import adds resources of a config to a server, updating ones with
+// the same name. Discovered resources aren't exported, ${VAR} references
+// are kept as they were in a config.
+///////////////////////////////////////////////////////////////////////////////
+
+const AuditImport = "import"
+
+// Export returns configured resources.
+func (s *StatusChecker) Export() ([]*ResConf, error) {
+	s.m.Lock()
+	config, err := s.config.withoutDiscovered().withReferences()
+	s.m.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return config.Configs, nil
+}
+
+// Import adds resources and updates existing ones with the same name,
+// other resources are kept. Nothing is changed if any resource is invalid.
+func (s *StatusChecker) Import(confs []*ResConf, c *Caller, from string) (*ReloadResult, error) {
+	names := make(map[string]bool)
+	for _, cfg := range confs {
+		if names[cfg.Name] {
+			return nil, fmt.Errorf("resource %q is repeated", cfg.Name)
+		}
+		names[cfg.Name] = true
+		if err := cfg.validate(); err != nil {
+			return nil, fmt.Errorf("%s: %s", cfg.Name, err)
+		}
+	}
+
+	ret := &ReloadResult{Added: []string{}, Removed: []string{}, Updated: []string{}}
+	for _, cfg := range confs {
+		old, _ := s.Lookup(cfg.Name)
+		switch {
+		case old == nil:
+			s.Add(cfg)
+			audit(c, from, AuditAdd, cfg.Name, redactConf(cfg))
+			ret.Added = append(ret.Added, cfg.Name)
+		case !sameResource(old, cfg):
+			if err := s.Update(cfg.Name, cfg); err != nil {
+				return ret, err
+			}
+			audit(c, from, AuditUpdate, cfg.Name, redactConf(cfg))
+			ret.Updated = append(ret.Updated, cfg.Name)
+		}
+	}
+	audit(c, from, AuditImport, "", ret)
+	return ret, nil
+}
+
+// writeExport writes resources as a config to a file, YAML if it has a YAML
+// extension, or to stdout if path is empty.
+func writeExport(path string, confs []*ResConf) error {
+	config := &Config{Configs: confs}
+	var b []byte
+	var err error
+	if isYAML(path) {
+		b, err = marshalYAML(config)
+	} else {
+		b, err = json.MarshalIndent(config, "", " ")
+	}
+	if err != nil {
+		return err
+	}
+	if len(path) == 0 {
+		_, err = os.Stdout.Write(append(b, '\n'))
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0644)
+}
+
+// readImport reads resources of a config file, stdin if path is "-".
+func readImport(path string) ([]*ResConf, error) {
+	var b []byte
+	var err error
+	if path == "-" {
+		b, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		b, err = ioutil.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	config, err := parseConfig(path, b)
+	if err != nil {
+		return nil, err
+	}
+	return config.Configs, nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// Address families: a resource's Family makes a check connect over IPv4 or
+// IPv6 only, or over both separately, reporting the worse result with each
+// one in details, so IPv6-only breakage isn't masked by a dual-stack
+// fallback.
+///////////////////////////////////////////////////////////////////////////////
+
+const (
+	FamilyIPv4 = "ipv4"
+	FamilyIPv6 = "ipv6"
+	FamilyBoth = "both"
+)
+
+var familyNames = map[string]string{FamilyIPv4: "IPv4", FamilyIPv6: "IPv6"}
+
+func (c *ResConf) validateFamily() error {
+	switch c.Family {
+	case FamilyIPv4, FamilyIPv6, FamilyBoth:
+	default:
+		return fmt.Errorf("unknown family %q, use ipv4, ipv6 or both", c.Family)
+	}
+	if len(c.Type) > 0 && c.Type != "http" && c.Type != "keepalive" && c.Type != "tcp" {
+		return fmt.Errorf("only http, keepalive and tcp checks can choose a family")
+	}
+	if c.Protocol == ProtocolHTTP3 {
+		return fmt.Errorf("http3 can't choose a family")
+	}
+	return nil
+}
+
+// dialNetwork returns a network of a family, e.g. tcp6 for tcp and ipv6.
+func dialNetwork(network, family string) string {
+	switch family {
+	case FamilyIPv4:
+		return network + "4"
+	case FamilyIPv6:
+		return network + "6"
+	}
+	return network
+}
+
+// checkFamilies checks c over IPv4 and IPv6.
+func checkFamilies(f Checker, c *ResConf) *Status {
+	var ret *Status
+	var details []string
+	for _, family := range []string{FamilyIPv4, FamilyIPv6} {
+		fc := *c
+		fc.Family = family
+		st := f(&fc)
+		name := familyNames[family]
+		if len(st.Error) > 0 {
+			details = append(details, fmt.Sprintf("%s: %s, %s", name, st.State(), st.Error))
+			st.Error = name + ": " + st.Error
+		} else {
+			details = append(details, fmt.Sprintf("%s: %s %d in %s", name, st.State(), st.StatusCode, humanizeLatency(st.Latency)))
+		}
+		if ret == nil || st.State() > ret.State() {
+			ret = st
+		}
+	}
+	ret.Details = append(details, ret.Details...)
+	return ret
+}
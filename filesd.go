@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// File discovery: target files in the format of Prometheus file_sd, JSON or
+// YAML, e.g. rewritten by provisioning tools, are watched and their targets
+// checked:
+//   [{"targets": ["db1:5432", "https://web1/health"], "labels": {"team": "core"}}]
+// A host:port target is checked with TCP, or with HTTP if a group has
+// __scheme__ or __path__ labels, and a URL with HTTP. A resource is named
+// by its address, other labels are tags.
+///////////////////////////////////////////////////////////////////////////////
+
+var targetFiles = flag.String("targets", "", "Comma separated file_sd target files, JSON or YAML, watched for changes.")
+
+// targetsPoll is how often target files are checked for changes.
+const targetsPoll = 10 * time.Second
+
+type targetGroup struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels,omitempty"`
+}
+
+func (g *targetGroup) resources() []*ResConf {
+	var ret []*ResConf
+	tags := make(map[string]string)
+	for k, v := range g.Labels {
+		if !strings.HasPrefix(k, "__") {
+			tags[k] = v
+		}
+	}
+	if len(tags) == 0 {
+		tags = nil
+	}
+	scheme, path := g.Labels["__scheme__"], g.Labels["__path__"]
+	for _, t := range g.Targets {
+		r := &ResConf{Address: t, Tags: tags}
+		switch {
+		case strings.Contains(t, "://"):
+		case len(scheme) > 0 || len(path) > 0:
+			if len(scheme) == 0 {
+				scheme = "http"
+			}
+			r.Address = scheme + "://" + t + "/" + strings.TrimPrefix(path, "/")
+		default:
+			r.Type = "tcp"
+		}
+		r.Name = r.Address
+		ret = append(ret, r)
+	}
+	return ret
+}
+
+func loadTargets(path string) ([]*ResConf, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var groups []*targetGroup
+	if isYAML(path) {
+		err = unmarshalYAML(b, &groups)
+	} else {
+		err = json.Unmarshal(b, &groups)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var ret []*ResConf
+	for _, g := range groups {
+		ret = append(ret, g.resources()...)
+	}
+	return ret, nil
+}
+
+// discoverFile syncs targets of a file whenever it changes, a broken file
+// keeps resources as they are.
+func (s *StatusChecker) discoverFile(path string) {
+	var last time.Time
+	for ; ; time.Sleep(targetsPoll) {
+		fi, err := os.Stat(path)
+		if err != nil {
+			slog.Error("Discovery", "source", "file "+path, "error", err)
+			continue
+		}
+		if fi.ModTime().Equal(last) {
+			continue
+		}
+		last = fi.ModTime()
+		confs, err := loadTargets(path)
+		if err != nil {
+			slog.Error("Discovery", "source", "file "+path, "error", err)
+			continue
+		}
+		s.syncDiscovered("file "+path, confs)
+	}
+}
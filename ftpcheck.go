@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"time"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// An FTP check: a banner of an ftp://host[:port] Address is read and, if it
+// has a user (e.g. ftp://anonymous@host), a login is tried with its
+// password, "anonymous@" if none. SFTP checks need an SSH client and are
+// built with -tags sftp, see sftpcheck.go.
+///////////////////////////////////////////////////////////////////////////////
+
+const ftpTimeout = 10 * time.Second
+
+func init() {
+	RegisterChecker("ftp", checkFTP)
+}
+
+// validateFTP checks an ftp or sftp address.
+func validateFTP(scheme, addr string) error {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != scheme {
+		return fmt.Errorf("%s: scheme must be %s", addr, scheme)
+	}
+	if len(u.Hostname()) == 0 {
+		return fmt.Errorf("%s: no host", addr)
+	}
+	return nil
+}
+
+func checkFTP(c *ResConf) *Status {
+	st := &Status{When: time.Now()}
+	fail := func(step string, err error) *Status {
+		st.Latency = time.Since(st.When)
+		st.StatusCode = UnknownError
+		st.Error = step + ": " + c.errorText(err)
+		return st
+	}
+	u, err := url.Parse(c.Address)
+	if err != nil {
+		return fail("connect", err)
+	}
+	d := &net.Dialer{Timeout: ftpTimeout, Resolver: newResolver(c.resolver())}
+	conn, err := d.Dial(dialNetwork("tcp", c.Family), urlHost(u, "21"))
+	if err != nil {
+		return fail("connect", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(ftpTimeout))
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		st.RemoteIP = addr.IP.String()
+	}
+
+	tp := textproto.NewConn(conn)
+	_, banner, err := tp.ReadResponse(220)
+	if err != nil {
+		return fail("banner", err)
+	}
+	st.TTFB = time.Since(st.When)
+	if !c.Private {
+		st.Details = append(st.Details, "banner: "+strings.SplitN(banner, "\n", 2)[0])
+	}
+	if u.User != nil {
+		pass, ok := u.User.Password()
+		if !ok {
+			pass = "anonymous@"
+		}
+		// 230 is a login without a password.
+		code, msg, err := ftpCmd(tp, "USER %s", u.User.Username())
+		if err == nil && code == 331 {
+			code, msg, err = ftpCmd(tp, "PASS %s", pass)
+		}
+		if err == nil && code != 230 {
+			err = &textproto.Error{Code: code, Msg: msg}
+		}
+		if err != nil {
+			return fail("login", err)
+		}
+		st.Details = append(st.Details, "logged in as "+u.User.Username())
+	}
+	ftpCmd(tp, "QUIT")
+	st.Latency = time.Since(st.When)
+	st.StatusCode = http.StatusOK
+	return st
+}
+
+// ftpCmd sends a command and reads a reply of any code.
+func ftpCmd(tp *textproto.Conn, format string, args ...interface{}) (int, string, error) {
+	if _, err := tp.Cmd(format, args...); err != nil {
+		return 0, "", err
+	}
+	return tp.ReadResponse(0)
+}
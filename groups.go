@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// Group states: a group is as bad as its worst checked member. The status
+// page collapses healthy groups and a group with Alert is notified of as a
+// whole, its members are not.
+///////////////////////////////////////////////////////////////////////////////
+
+type groupView struct {
+	Name    string
+	Overall *Overall
+	Members []tmplHelper
+}
+
+// groupResources splits resources into groups ordered by their first
+// members, ungrouped resources are in groups with no Name.
+func groupResources(arr []tmplHelper) []*groupView {
+	var ret []*groupView
+	byName := make(map[string]*groupView)
+	for i, el := range arr {
+		name := el.Conf.Group
+		g, ok := byName[name]
+		if !ok || len(name) == 0 && ret[len(ret)-1] != g {
+			// Consecutive ungrouped resources share a group.
+			g = &groupView{Name: name}
+			byName[name] = g
+			ret = append(ret, g)
+		}
+		g.Members = append(g.Members, arr[i])
+	}
+	for _, g := range ret {
+		g.Overall = computeOverall(nil, g.Members)
+	}
+	return ret
+}
+
+type apiGroup struct {
+	Name string
+	*Overall
+	Members []string
+}
+
+// Groups returns states of groups.
+func (s *StatusChecker) Groups() []*apiGroup {
+	ret := []*apiGroup{}
+	for _, g := range groupResources(s.Snapshot()) {
+		if len(g.Name) == 0 {
+			continue
+		}
+		a := &apiGroup{Name: g.Name, Overall: g.Overall}
+		for _, el := range g.Members {
+			a.Members = append(a.Members, el.Name)
+		}
+		ret = append(ret, a)
+	}
+	return ret
+}
+
+// alertGroup returns a group of c notified of as a whole, if any.
+func (s *StatusChecker) alertGroup(c *ResConf) *GroupConf {
+	if len(c.Group) == 0 {
+		return nil
+	}
+	s.m.Lock()
+	defer s.m.Unlock()
+	for _, g := range s.config.Groups {
+		if g.Name == c.Group && g.Alert {
+			return g
+		}
+	}
+	return nil
+}
+
+// groupStatus is a status of a group as if it was a resource: DOWN with a
+// status code of a DOWN member or DEGRADED, nil if no member was checked.
+func groupStatus(members []tmplHelper, when time.Time) *Status {
+	o := computeOverall(nil, members)
+	st := &Status{When: when, StatusCode: http.StatusOK}
+	var down, degraded []string
+	for _, el := range members {
+		switch el.Status.State() {
+		case StateDown:
+			down = append(down, el.Name)
+			st.StatusCode = el.Status.StatusCode
+		case StateDegraded:
+			degraded = append(degraded, el.Name)
+		}
+	}
+	switch o.State {
+	case StateNeverChecked:
+		return nil
+	case StateDown:
+		st.Error = fmt.Sprintf("%d of %d members DOWN: %s", len(down), len(members), strings.Join(down, ", "))
+	case StateDegraded:
+		st.Degraded = fmt.Sprintf("%d of %d members DEGRADED: %s", len(degraded), len(members), strings.Join(degraded, ", "))
+	}
+	return st
+}
+
+// groupEvent returns an event of a group after a result of its member,
+// nil if there's nothing to notify.
+func (s *StatusChecker) groupEvent(g *GroupConf, when time.Time) *Event {
+	var members []tmplHelper
+	for _, el := range s.Snapshot() {
+		if el.Conf.Group == g.Name {
+			members = append(members, el)
+		}
+	}
+	cur := groupStatus(members, when)
+	if cur == nil {
+		return nil
+	}
+	s.statusMutex.Lock()
+	old := s.groupStatuses[g.Name]
+	s.groupStatuses[g.Name] = cur
+	s.statusMutex.Unlock()
+	conf := &ResConf{Name: g.Name, Address: "group:" + g.Name, Group: g.Name, Notify: g.Notify}
+	return s.event(conf, old, cur)
+}
+
+func RegisterGroupsHandler(sc *StatusChecker) {
+	describeAPI(&apiOperation{
+		Method: "GET", Path: "/api/groups", Summary: "List groups with states of their worst members",
+		Responses: map[int]interface{}{http.StatusOK: []*apiGroup{}},
+	})
+	http.HandleFunc("/api/groups", func(rw http.ResponseWriter, req *http.Request) {
+		writeJSON(rw, http.StatusOK, sc.Groups())
+	})
+}
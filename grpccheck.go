@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// A gRPC health check: grpc.health.v1.Health/Check of a Service (the whole
+// server if empty) is called at a grpc://host:port (h2c) or grpcs://
+// (TLS) Address, a resource is UP while it's SERVING. gRPC is spoken over
+// the HTTP/2 client with protobuf messages encoded by hand, so no gRPC
+// library is needed.
+///////////////////////////////////////////////////////////////////////////////
+
+const grpcTimeout = 10 * time.Second
+
+// Statuses of grpc.health.v1.HealthCheckResponse.
+var grpcServingStatuses = []string{"UNKNOWN", "SERVING", "NOT_SERVING", "SERVICE_UNKNOWN"}
+
+func init() {
+	RegisterChecker("grpc", checkGRPC)
+}
+
+func validateGRPC(addr string) error {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "grpc" && u.Scheme != "grpcs" {
+		return fmt.Errorf("%s: scheme must be grpc or grpcs", addr)
+	}
+	return validateTCP(u.Host)
+}
+
+// grpcFrame returns a length-prefixed gRPC message of a HealthCheckRequest.
+func grpcFrame(service string) []byte {
+	var msg []byte
+	if len(service) > 0 {
+		msg = append([]byte{0x0a}, binary.AppendUvarint(nil, uint64(len(service)))...)
+		msg = append(msg, service...)
+	}
+	frame := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+	return append(frame, msg...)
+}
+
+// grpcServingStatus decodes a status of a framed HealthCheckResponse.
+func grpcServingStatus(b []byte) (uint64, error) {
+	if len(b) < 5 {
+		return 0, fmt.Errorf("no response message")
+	}
+	if b[0] != 0 {
+		return 0, fmt.Errorf("compressed response")
+	}
+	n := binary.BigEndian.Uint32(b[1:5])
+	if int(n) > len(b)-5 {
+		return 0, fmt.Errorf("truncated response")
+	}
+	msg := b[5 : 5+n]
+	var status uint64 // UNKNOWN if absent
+	for len(msg) > 0 {
+		key, k := binary.Uvarint(msg)
+		if k <= 0 {
+			return 0, fmt.Errorf("malformed response")
+		}
+		msg = msg[k:]
+		switch key & 7 {
+		case 0:
+			v, k := binary.Uvarint(msg)
+			if k <= 0 {
+				return 0, fmt.Errorf("malformed response")
+			}
+			if key>>3 == 1 {
+				status = v
+			}
+			msg = msg[k:]
+		case 1:
+			msg = msg[min(8, len(msg)):]
+		case 2:
+			l, k := binary.Uvarint(msg)
+			if k <= 0 || l > uint64(len(msg)-k) {
+				return 0, fmt.Errorf("malformed response")
+			}
+			msg = msg[k+int(l):]
+		case 5:
+			msg = msg[min(4, len(msg)):]
+		default:
+			return 0, fmt.Errorf("malformed response")
+		}
+	}
+	return status, nil
+}
+
+func checkGRPC(c *ResConf) *Status {
+	st := &Status{When: time.Now()}
+	fail := func(err error) *Status {
+		st.StatusCode = UnknownError
+		st.Error = c.errorText(err)
+		return st
+	}
+	u, err := url.Parse(c.Address)
+	if err != nil {
+		return fail(err)
+	}
+	scheme := "http"
+	if u.Scheme == "grpcs" {
+		scheme = "https"
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), grpcTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", scheme+"://"+u.Host+"/grpc.health.v1.Health/Check", bytes.NewReader(grpcFrame(c.Service)))
+	if err != nil {
+		return fail(err)
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	req.Header.Set("Grpc-Timeout", fmt.Sprintf("%dS", int(grpcTimeout.Seconds())))
+	for k, v := range c.Headers {
+		req.Header.Set(k, v)
+	}
+	h2 := *c
+	h2.Protocol = ProtocolHTTP2
+	resp, err := clientFor(&h2).Do(withProxy(req, c))
+	if err != nil {
+		return fail(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	st.Latency = time.Since(st.When)
+	st.Proto = resp.Proto
+	if cs := resp.TLS; cs != nil {
+		st.TLSVersion = tls.VersionName(cs.Version)
+	}
+	if err != nil {
+		return fail(err)
+	}
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "application/grpc") {
+		st.StatusCode = resp.StatusCode
+		st.Error = fmt.Sprintf("not a gRPC response: %s", resp.Status)
+		return st
+	}
+	// A trailers-only response has a status in headers.
+	code, msg := resp.Trailer.Get("Grpc-Status"), resp.Trailer.Get("Grpc-Message")
+	if len(code) == 0 {
+		code, msg = resp.Header.Get("Grpc-Status"), resp.Header.Get("Grpc-Message")
+	}
+	if code != "0" {
+		st.StatusCode = UnknownError
+		st.Error = strings.TrimSpace(fmt.Sprintf("grpc-status %s %s", code, msg))
+		return st
+	}
+	status, err := grpcServingStatus(body)
+	if err != nil {
+		return fail(err)
+	}
+	name := fmt.Sprintf("status %d", status)
+	if status < uint64(len(grpcServingStatuses)) {
+		name = grpcServingStatuses[status]
+	}
+	st.Details = append(st.Details, name)
+	if status != 1 {
+		st.StatusCode = UnknownError
+		st.Error = name
+		return st
+	}
+	st.StatusCode = http.StatusOK
+	return st
+}
@@ -0,0 +1,556 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// gRPC over HTTP/2 served by net/http: h2c without TLS, h2 with it. Messages
+// are encoded by the schema in protobuf.go, there's no compression. A method
+// gets an AdminServer of its caller, so roles and audit are the ones of RPC.
+///////////////////////////////////////////////////////////////////////////////
+
+// Status codes of gRPC.
+const (
+	grpcOK                = 0
+	grpcUnknown           = 2
+	grpcInvalidArgument   = 3
+	grpcNotFound          = 5
+	grpcPermissionDenied  = 7
+	grpcResourceExhausted = 8
+	grpcUnimplemented     = 12
+	grpcUnavailable       = 14
+	grpcUnauthenticated   = 16
+)
+
+const grpcMaxMessage = 32 << 20
+
+type grpcError struct {
+	code int
+	msg  string
+}
+
+func (e *grpcError) Error() string {
+	if len(e.msg) == 0 {
+		return fmt.Sprintf("gRPC status %d", e.code)
+	}
+	return e.msg
+}
+
+// grpcCode returns a status code and a message of an error of a method.
+func grpcCode(err error) (int, string) {
+	var ge *grpcError
+	var re *roleError
+	switch {
+	case err == nil:
+		return grpcOK, ""
+	case errors.As(err, &ge):
+		return ge.code, ge.msg
+	case errors.Is(err, errKeyRequired):
+		return grpcUnauthenticated, err.Error()
+	case errors.As(err, &re):
+		return grpcPermissionDenied, err.Error()
+	}
+	return grpcUnknown, err.Error()
+}
+
+type grpcService struct {
+	Name    string // a full name, e.g. statusmonitor.admin.v1.AdminService
+	Methods []*grpcMethod
+}
+
+// grpcMethod is a unary method or a bidirectional stream if Stream is set.
+type grpcMethod struct {
+	Name    string
+	In, Out reflect.Type
+	Unary   func(a *AdminServer, in interface{}) (interface{}, error)
+	Stream  func(a *AdminServer, s *grpcStream) error
+}
+
+// isGRPC returns whether a request is a gRPC call.
+func isGRPC(req *http.Request) bool {
+	return req.ProtoMajor == 2 && strings.HasPrefix(req.Header.Get("Content-Type"), "application/grpc")
+}
+
+// RegisterGRPCHandler serves methods of services at /Service/Method.
+func RegisterGRPCHandler(sc *StatusChecker, services ...*grpcService) {
+	for _, s := range services {
+		for _, m := range s.Methods {
+			http.HandleFunc("/"+s.Name+"/"+m.Name, grpcHandler(sc, m))
+		}
+	}
+}
+
+func grpcHandler(sc *StatusChecker, m *grpcMethod) http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		ct := req.Header.Get("Content-Type")
+		if req.Method != "POST" || !isGRPC(req) || ct != "application/grpc" && ct != "application/grpc+proto" {
+			http.Error(rw, "gRPC over HTTP/2 required", http.StatusUnsupportedMediaType)
+			return
+		}
+		rw.Header().Set("Content-Type", "application/grpc")
+		rw.WriteHeader(http.StatusOK)
+		a := &AdminServer{sc, callerOf(req), req.RemoteAddr}
+		var err error
+		if m.Stream != nil {
+			http.NewResponseController(rw).Flush()
+			// A stopping server ends streams, reads of a body don't
+			// watch a context.
+			stop := context.AfterFunc(req.Context(), func() { req.Body.Close() })
+			err = m.Stream(a, &grpcStream{req.Body, rw})
+			stop()
+			if req.Context().Err() != nil {
+				err = &grpcError{grpcUnavailable, "server stopping"}
+			}
+		} else {
+			in := reflect.New(m.In).Interface()
+			if err = grpcRecv(req.Body, in); err == io.EOF {
+				err = &grpcError{grpcInvalidArgument, "no request message"}
+			}
+			var out interface{}
+			if err == nil {
+				out, err = m.Unary(a, in)
+			}
+			if err == nil {
+				err = grpcSend(rw, out)
+			}
+		}
+		code, msg := grpcCode(err)
+		if code != grpcOK {
+			slog.Warn("gRPC", "method", req.URL.Path, "from", req.RemoteAddr, "code", code, "error", msg)
+		}
+		rw.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(code))
+		if len(msg) > 0 {
+			rw.Header().Set(http.TrailerPrefix+"Grpc-Message", grpcEscape(msg))
+		}
+	}
+}
+
+// grpcEscape percent-encodes a message as grpc-message needs.
+func grpcEscape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c < ' ' || c > '~' || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// grpcRecv reads a length-prefixed message, io.EOF if there are no more.
+func grpcRecv(r io.Reader, m interface{}) error {
+	var prefix [5]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return &grpcError{grpcInvalidArgument, "truncated message"}
+		}
+		return err
+	}
+	if prefix[0] != 0 {
+		return &grpcError{grpcUnimplemented, "compression isn't supported"}
+	}
+	n := binary.BigEndian.Uint32(prefix[1:])
+	if n > grpcMaxMessage {
+		return &grpcError{grpcResourceExhausted, fmt.Sprintf("message of %d bytes is over %d", n, grpcMaxMessage)}
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return &grpcError{grpcInvalidArgument, "truncated message"}
+	}
+	if err := pbUnmarshal(b, m); err != nil {
+		return &grpcError{grpcInvalidArgument, err.Error()}
+	}
+	return nil
+}
+
+// grpcSend writes a length-prefixed message and flushes it.
+func grpcSend(w io.Writer, m interface{}) error {
+	b, err := pbMarshal(m)
+	if err != nil {
+		return err
+	}
+	var prefix [5]byte
+	binary.BigEndian.PutUint32(prefix[1:], uint32(len(b)))
+	if _, err := w.Write(append(prefix[:], b...)); err != nil {
+		return err
+	}
+	if rw, ok := w.(http.ResponseWriter); ok {
+		return http.NewResponseController(rw).Flush()
+	}
+	return nil
+}
+
+// grpcStream is a bidirectional stream of a server.
+type grpcStream struct {
+	r io.Reader
+	w http.ResponseWriter
+}
+
+// Recv reads a message of a client, io.EOF after the last one.
+func (s *grpcStream) Recv(m interface{}) error {
+	return grpcRecv(s.r, m)
+}
+
+func (s *grpcStream) Send(m interface{}) error {
+	return grpcSend(s.w, m)
+}
+
+///////////////////////////////////////////////////////////////////////////////
+// A client of commands: h2c to commandAddr, h2 with -cert or -server-ca.
+///////////////////////////////////////////////////////////////////////////////
+
+type grpcClient struct {
+	base string // a URL of a server
+	hc   *http.Client
+}
+
+func dialGRPC() (*grpcClient, error) {
+	c, err := clientTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	tr := &http.Transport{Protocols: new(http.Protocols)}
+	base := "http://" + commandAddr()
+	if c == nil {
+		tr.Protocols.SetUnencryptedHTTP2(true)
+	} else {
+		tr.Protocols.SetHTTP2(true)
+		tr.TLSClientConfig = c
+		base = "https://" + commandAddr()
+	}
+	return &grpcClient{base, &http.Client{Transport: tr}}, nil
+}
+
+func (c *grpcClient) Close() error {
+	c.hc.CloseIdleConnections()
+	return nil
+}
+
+func (c *grpcClient) request(method string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest("POST", c.base+method, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	if len(*apiKey) > 0 {
+		req.Header.Set("Authorization", "Bearer "+*apiKey)
+	}
+	resp, err := c.hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusUnauthorized {
+			return nil, errors.New("API key required, use -key or STATUSMONITOR_KEY")
+		}
+		return nil, errors.New(resp.Status)
+	}
+	return resp, nil
+}
+
+// grpcStatus returns an error of a finished call, nil if it's OK.
+func grpcStatus(resp *http.Response) error {
+	h := resp.Trailer
+	if len(h.Get("Grpc-Status")) == 0 {
+		h = resp.Header // trailers only
+	}
+	code, err := strconv.Atoi(h.Get("Grpc-Status"))
+	if err != nil {
+		return errors.New("gRPC: no status of a call")
+	}
+	if code == grpcOK {
+		return nil
+	}
+	msg := h.Get("Grpc-Message")
+	if m, err := url.PathUnescape(msg); err == nil {
+		msg = m
+	}
+	return &grpcError{code, msg}
+}
+
+// Invoke calls a unary method, e.g. /statusmonitor.admin.v1.AdminService/List.
+func (c *grpcClient) Invoke(method string, in, out interface{}) error {
+	var body bytes.Buffer
+	if err := grpcSend(&body, in); err != nil {
+		return err
+	}
+	resp, err := c.request(method, &body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	rerr := grpcRecv(resp.Body, out)
+	io.Copy(io.Discard, resp.Body)
+	if err := grpcStatus(resp); err != nil {
+		return err
+	}
+	if rerr == io.EOF {
+		return errors.New("gRPC: no response message")
+	}
+	return rerr
+}
+
+///////////////////////////////////////////////////////////////////////////////
+// Server reflection, v1 and v1alpha, so grpcurl and the like need no .proto
+// files: descriptors of registered files, see registerProto.
+///////////////////////////////////////////////////////////////////////////////
+
+type pbReflectionRequest struct {
+	Host string
+	// One of:
+	FileByFilename            *string
+	FileContainingSymbol      *string
+	FileContainingExtension   *pbExtensionRequest
+	AllExtensionNumbersOfType *string
+	ListServices              *string
+}
+
+type pbExtensionRequest struct {
+	ContainingType  string
+	ExtensionNumber int32
+}
+
+type pbReflectionResponse struct {
+	ValidHost       string
+	OriginalRequest *pbReflectionRequest
+	// One of:
+	FileDescriptorResponse      *pbFileDescriptorResponse
+	AllExtensionNumbersResponse *pbExtensionNumberResponse
+	ListServicesResponse        *pbListServiceResponse
+	ErrorResponse               *pbReflectionError
+}
+
+type pbFileDescriptorResponse struct {
+	FileDescriptorProto [][]byte
+}
+
+type pbExtensionNumberResponse struct {
+	BaseTypeName    string
+	ExtensionNumber []int32
+}
+
+type pbListServiceResponse struct {
+	Service []*pbServiceResponse
+}
+
+type pbServiceResponse struct {
+	Name string
+}
+
+type pbReflectionError struct {
+	ErrorCode    int32
+	ErrorMessage string
+}
+
+var reflectionMethods = []*grpcMethod{{Name: "ServerReflectionInfo", In: reflect.TypeOf(pbReflectionRequest{}),
+	Out: reflect.TypeOf(pbReflectionResponse{}), Stream: serverReflectionInfo}}
+
+var (
+	reflectionService      = &grpcService{Name: "grpc.reflection.v1.ServerReflection", Methods: reflectionMethods}
+	reflectionAlphaService = &grpcService{Name: "grpc.reflection.v1alpha.ServerReflection", Methods: reflectionMethods}
+)
+
+func init() {
+	registerProto(&pbFile{Name: "grpc/reflection/v1/reflection.proto", Package: "grpc.reflection.v1",
+		Messages: []*pbMessage{
+			{Name: "ServerReflectionRequest", Type: reflect.TypeOf(pbReflectionRequest{}), Fields: []pbField{
+				{1, "host", "Host"}, {3, "file_by_filename", "FileByFilename"},
+				{4, "file_containing_symbol", "FileContainingSymbol"},
+				{5, "file_containing_extension", "FileContainingExtension"},
+				{6, "all_extension_numbers_of_type", "AllExtensionNumbersOfType"},
+				{7, "list_services", "ListServices"},
+			}, Oneofs: map[string][]int{"message_request": {3, 4, 5, 6, 7}}},
+			{Name: "ExtensionRequest", Type: reflect.TypeOf(pbExtensionRequest{}), Fields: []pbField{
+				{1, "containing_type", "ContainingType"}, {2, "extension_number", "ExtensionNumber"},
+			}},
+			{Name: "ServerReflectionResponse", Type: reflect.TypeOf(pbReflectionResponse{}), Fields: []pbField{
+				{1, "valid_host", "ValidHost"}, {2, "original_request", "OriginalRequest"},
+				{4, "file_descriptor_response", "FileDescriptorResponse"},
+				{5, "all_extension_numbers_response", "AllExtensionNumbersResponse"},
+				{6, "list_services_response", "ListServicesResponse"}, {7, "error_response", "ErrorResponse"},
+			}, Oneofs: map[string][]int{"message_response": {4, 5, 6, 7}}},
+			{Name: "FileDescriptorResponse", Type: reflect.TypeOf(pbFileDescriptorResponse{}), Fields: []pbField{
+				{1, "file_descriptor_proto", "FileDescriptorProto"},
+			}},
+			{Name: "ExtensionNumberResponse", Type: reflect.TypeOf(pbExtensionNumberResponse{}), Fields: []pbField{
+				{1, "base_type_name", "BaseTypeName"}, {2, "extension_number", "ExtensionNumber"},
+			}},
+			{Name: "ListServiceResponse", Type: reflect.TypeOf(pbListServiceResponse{}), Fields: []pbField{
+				{1, "service", "Service"},
+			}},
+			{Name: "ServiceResponse", Type: reflect.TypeOf(pbServiceResponse{}), Fields: []pbField{{1, "name", "Name"}}},
+			{Name: "ErrorResponse", Type: reflect.TypeOf(pbReflectionError{}), Fields: []pbField{
+				{1, "error_code", "ErrorCode"}, {2, "error_message", "ErrorMessage"},
+			}},
+		},
+		Services: []*grpcService{reflectionService},
+	}, true)
+}
+
+func serverReflectionInfo(a *AdminServer, s *grpcStream) error {
+	for {
+		var req pbReflectionRequest
+		if err := s.Recv(&req); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := s.Send(reflectionResponse(&req)); err != nil {
+			return err
+		}
+	}
+}
+
+func reflectionResponse(req *pbReflectionRequest) *pbReflectionResponse {
+	resp := &pbReflectionResponse{ValidHost: req.Host, OriginalRequest: req}
+	var f *pbFile
+	var err *pbReflectionError
+	switch {
+	case req.FileByFilename != nil:
+		if f = protoFile(*req.FileByFilename); f == nil {
+			err = &pbReflectionError{grpcNotFound, fmt.Sprintf("file %q not found", *req.FileByFilename)}
+		}
+	case req.FileContainingSymbol != nil:
+		if f = protoFileOf(*req.FileContainingSymbol); f == nil {
+			err = &pbReflectionError{grpcNotFound, fmt.Sprintf("symbol %q not found", *req.FileContainingSymbol)}
+		}
+	case req.FileContainingExtension != nil:
+		err = &pbReflectionError{grpcNotFound, "no extensions"}
+	case req.AllExtensionNumbersOfType != nil:
+		if protoFileOf(*req.AllExtensionNumbersOfType) == nil {
+			err = &pbReflectionError{grpcNotFound, fmt.Sprintf("type %q not found", *req.AllExtensionNumbersOfType)}
+		} else {
+			resp.AllExtensionNumbersResponse = &pbExtensionNumberResponse{BaseTypeName: *req.AllExtensionNumbersOfType}
+		}
+	case req.ListServices != nil:
+		resp.ListServicesResponse = &pbListServiceResponse{}
+		for _, f := range pbFiles {
+			for _, s := range f.Services {
+				resp.ListServicesResponse.Service = append(resp.ListServicesResponse.Service, &pbServiceResponse{s.Name})
+			}
+		}
+	default:
+		err = &pbReflectionError{grpcInvalidArgument, "no request"}
+	}
+	if f != nil {
+		resp.FileDescriptorResponse = &pbFileDescriptorResponse{}
+		for _, f := range protoFileDeps(f) {
+			b, _ := pbMarshal(f.descriptor())
+			resp.FileDescriptorResponse.FileDescriptorProto = append(resp.FileDescriptorResponse.FileDescriptorProto, b)
+		}
+	}
+	resp.ErrorResponse = err
+	return resp
+}
+
+func protoFile(name string) *pbFile {
+	for _, f := range pbFiles {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// protoFileOf returns a file defining a full name of a service, a method,
+// a message or an enum.
+func protoFileOf(symbol string) *pbFile {
+	for _, f := range pbFiles {
+		for _, m := range f.Messages {
+			if m.full == symbol {
+				return f
+			}
+		}
+		for _, e := range f.Enums {
+			if e.full == symbol {
+				return f
+			}
+		}
+		for _, s := range f.Services {
+			if s.Name == symbol {
+				return f
+			}
+			for _, m := range s.Methods {
+				if s.Name+"."+m.Name == symbol {
+					return f
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// protoFileDeps returns a file with files it depends on, transitively.
+func protoFileDeps(f *pbFile) []*pbFile {
+	ret := []*pbFile{f}
+	for i := 0; i < len(ret); i++ {
+		for _, name := range ret[i].Deps {
+			if d := protoFile(name); d != nil && !containsFile(ret, d) {
+				ret = append(ret, d)
+			}
+		}
+	}
+	return ret
+}
+
+func containsFile(files []*pbFile, f *pbFile) bool {
+	for _, el := range files {
+		if el == f {
+			return true
+		}
+	}
+	return false
+}
+
+// grpcClientStream is a bidirectional stream of a client.
+type grpcClientStream struct {
+	w    *io.PipeWriter
+	resp *http.Response
+}
+
+// Stream opens a bidirectional stream of a method.
+func (c *grpcClient) Stream(method string) (*grpcClientStream, error) {
+	r, w := io.Pipe()
+	resp, err := c.request(method, r)
+	if err != nil {
+		w.Close()
+		return nil, err
+	}
+	return &grpcClientStream{w, resp}, nil
+}
+
+func (s *grpcClientStream) Send(m interface{}) error {
+	return grpcSend(s.w, m)
+}
+
+// Recv reads a message of a server, io.EOF after the last one of a call
+// which ended OK.
+func (s *grpcClientStream) Recv(m interface{}) error {
+	err := grpcRecv(s.resp.Body, m)
+	if err == io.EOF {
+		if err := grpcStatus(s.resp); err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+func (s *grpcClientStream) Close() error {
+	s.w.Close()
+	return s.resp.Body.Close()
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// Self-health: /healthz reports whether the monitor itself is alive (the
+// scheduler ticks, its locks aren't stuck) and /readyz whether it's ready to
+// serve (it's running, the queue isn't saturated, a config file is
+// reachable), e.g. for Kubernetes probes. They need no login.
+///////////////////////////////////////////////////////////////////////////////
+
+// lockTimeout is how long a lock may be waited for before it's stuck.
+const lockTimeout = 2 * time.Second
+
+type healthReport struct {
+	Status string            // ok or fail
+	Checks map[string]string // ok or why not
+}
+
+func newHealthReport(checks map[string]error) *healthReport {
+	r := &healthReport{Status: "ok", Checks: make(map[string]string)}
+	for name, err := range checks {
+		if err != nil {
+			r.Status = "fail"
+			r.Checks[name] = err.Error()
+		} else {
+			r.Checks[name] = "ok"
+		}
+	}
+	return r
+}
+
+// lastTicked returns when timers were last synced, zero if they weren't.
+func (s *StatusChecker) lastTicked() (time.Time, error) {
+	locked := make(chan time.Time, 1)
+	go func() {
+		s.m.Lock()
+		t := s.lastTick
+		s.m.Unlock()
+		s.statusMutex.Lock()
+		s.statusMutex.Unlock()
+		locked <- t
+	}()
+	select {
+	case t := <-locked:
+		return t, nil
+	case <-time.After(lockTimeout):
+		return time.Time{}, fmt.Errorf("locks held over %s", lockTimeout)
+	}
+}
+
+// Liveness checks that the scheduler ticks and locks can be taken.
+func (s *StatusChecker) Liveness() *healthReport {
+	t, err := s.lastTicked()
+	checks := map[string]error{"locks": err, "scheduler": nil}
+	if err == nil && !t.IsZero() && time.Since(t) > 2**interval+lockTimeout {
+		checks["scheduler"] = fmt.Errorf("last tick %s ago", roundDuration(time.Since(t)))
+	}
+	return newHealthReport(checks)
+}
+
+// Readiness checks that checking started, the queue has room and a config
+// file is reachable.
+func (s *StatusChecker) Readiness() *healthReport {
+	t, err := s.lastTicked()
+	checks := map[string]error{"locks": err, "scheduler": nil, "queue": nil}
+	if err == nil && t.IsZero() {
+		checks["scheduler"] = fmt.Errorf("not started")
+	}
+	if n := s.queue.size(); n >= queueSize {
+		checks["queue"] = fmt.Errorf("saturated, %d checks waiting", n)
+	}
+	if len(*configFilePath) > 0 {
+		_, err := os.Stat(*configFilePath)
+		checks["storage"] = err
+	}
+	return newHealthReport(checks)
+}
+
+func RegisterHealthHandlers(sc *StatusChecker) {
+	handle := func(path, summary string, f func() *healthReport) {
+		describeAPI(&apiOperation{
+			Method: "GET", Path: path, Summary: summary,
+			Responses: map[int]interface{}{http.StatusOK: &healthReport{}, http.StatusServiceUnavailable: &healthReport{}},
+		})
+		http.HandleFunc(path, func(rw http.ResponseWriter, req *http.Request) {
+			r := f()
+			code := http.StatusOK
+			if r.Status != "ok" {
+				code = http.StatusServiceUnavailable
+			}
+			writeJSON(rw, code, r)
+		})
+	}
+	handle("/healthz", "Liveness of the monitor itself", sc.Liveness)
+	handle("/readyz", "Readiness of the monitor itself", sc.Readiness)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// Heartbeat checks, a dead man's switch: a monitored system, e.g. a cron
+// job or a backup, POSTs to /heartbeat/<Token> every Interval and its
+// resource is DOWN once one is more than Grace late, or right after a POST
+// to /heartbeat/<Token>/fail. Heartbeats are kept in memory, after a start
+// a first one is awaited for a whole period.
+///////////////////////////////////////////////////////////////////////////////
+
+const heartbeatPath = "/heartbeat/"
+
+var validToken = regexp.MustCompile(`^[A-Za-z0-9_-]{16,}$`)
+
+type heartbeat struct {
+	when   time.Time
+	failed string // a reported failure, empty on success
+}
+
+// heartbeats by token.
+var (
+	heartbeatsMutex sync.Mutex
+	heartbeats      = make(map[string]*heartbeat)
+)
+
+func init() {
+	RegisterChecker("heartbeat", checkHeartbeat)
+}
+
+func validateHeartbeat(c *ResConf) error {
+	if !validToken.MatchString(c.Token) {
+		return fmt.Errorf("a heartbeat token needs 16 or more letters, digits, _ or -")
+	}
+	if len(c.Grace) > 0 {
+		if d, err := time.ParseDuration(c.Grace); err != nil || d < 0 {
+			return fmt.Errorf("bad grace %q", c.Grace)
+		}
+	}
+	return nil
+}
+
+func checkHeartbeat(c *ResConf) *Status {
+	st := &Status{When: time.Now()}
+	heartbeatsMutex.Lock()
+	hb := heartbeats[c.Token]
+	heartbeatsMutex.Unlock()
+
+	grace, _ := time.ParseDuration(c.Grace)
+	since := startTime
+	if hb != nil {
+		since = hb.when
+		st.Details = append(st.Details, fmt.Sprintf("last heartbeat %s ago", roundDuration(st.When.Sub(since))))
+	}
+	late := st.When.Sub(since) - c.interval()
+	switch {
+	case hb != nil && len(hb.failed) > 0:
+		st.StatusCode = UnknownError
+		st.Error = "failure reported: " + hb.failed
+	case late > grace && hb == nil:
+		st.StatusCode = UnknownError
+		st.Error = fmt.Sprintf("no heartbeat since a start %s ago", roundDuration(st.When.Sub(since)))
+	case late > grace:
+		st.StatusCode = UnknownError
+		st.Error = fmt.Sprintf("heartbeat %s late", roundDuration(late))
+	default:
+		st.StatusCode = http.StatusOK
+	}
+	return st
+}
+
+// Heartbeat records a heartbeat of a token, a failure if failed isn't empty,
+// and checks its resource right away. It returns false if there's no such
+// resource.
+func (s *StatusChecker) Heartbeat(token, failed string) bool {
+	s.m.Lock()
+	c := s.config.Find(func(el *ResConf) bool { return el.Type == "heartbeat" && el.Token == token })
+	s.m.Unlock()
+	if c == nil {
+		return false
+	}
+	heartbeatsMutex.Lock()
+	heartbeats[token] = &heartbeat{time.Now(), failed}
+	heartbeatsMutex.Unlock()
+	slog.Debug("Heartbeat", "resource", c.Name, "failed", failed)
+	s.enqueue(c)
+	return true
+}
+
+// isHeartbeat reports whether a request is a heartbeat, it needs no key or
+// login, a token is a secret.
+func isHeartbeat(req *http.Request) bool {
+	return strings.HasPrefix(req.URL.Path, heartbeatPath)
+}
+
+func RegisterHeartbeatHandler(sc *StatusChecker) {
+	tokenParam := []apiParam{{Name: "token", In: "path"}}
+	describeAPI(&apiOperation{
+		Method: "POST", Path: "/heartbeat/{token}", Summary: "Report a heartbeat of a heartbeat resource", Params: tokenParam,
+		Responses: map[int]interface{}{http.StatusOK: nil, http.StatusNotFound: &apiError{}},
+	}, &apiOperation{
+		Method: "POST", Path: "/heartbeat/{token}/fail", Summary: "Report a failure, a body is its message", Params: tokenParam,
+		Responses: map[int]interface{}{http.StatusOK: nil, http.StatusNotFound: &apiError{}},
+	})
+	http.HandleFunc(heartbeatPath, func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method != "POST" {
+			writeError(rw, http.StatusMethodNotAllowed, fmt.Errorf("POST required"))
+			return
+		}
+		token := strings.TrimPrefix(req.URL.Path, heartbeatPath)
+		var failed string
+		if t, ok := strings.CutSuffix(token, "/fail"); ok {
+			b, _ := ioutil.ReadAll(io.LimitReader(req.Body, 1024))
+			token, failed = t, strings.TrimSpace(string(b))
+			if len(failed) == 0 {
+				failed = "no message"
+			}
+		}
+		if !validToken.MatchString(token) || !sc.Heartbeat(token, failed) {
+			writeError(rw, http.StatusNotFound, fmt.Errorf("no heartbeat resource"))
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+	})
+}
@@ -0,0 +1,27 @@
+//go:build http3
+
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// HTTP/3 probing over QUIC, built with -tags http3 and quic-go in GOPATH.
+///////////////////////////////////////////////////////////////////////////////
+
+func init() {
+	newHTTP3Transport = func() http.RoundTripper {
+		return &http3.Transport{
+			TLSClientConfig: &tls.Config{},
+			QUICConfig: &quic.Config{
+				HandshakeIdleTimeout: *httpTLSTimeout,
+				MaxIdleTimeout:       *httpIdleTimeout,
+			},
+		}
+	}
+}
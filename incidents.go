@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// Incidents: consecutive failures of a resource grouped into a record with
+// its start, end and errors, so an outage can be listed and linked to.
+///////////////////////////////////////////////////////////////////////////////
+
+const maxIncidents = 1000
+
+type Incident struct {
+	ID       int
+	Name     string
+	Address  string
+	Start    time.Time
+	End      time.Time     `json:",omitzero"` // zero while ongoing
+	Duration time.Duration // until now while ongoing
+	Checks   int           // failed checks
+	Error    string        `json:",omitempty"` // the first error, or a status code
+	LastErr  string        `json:",omitempty"` // the last one, if different
+}
+
+func incidentError(st *Status) string {
+	if len(st.Error) > 0 {
+		return st.Error
+	}
+	return http.StatusText(st.StatusCode)
+}
+
+// trackIncident opens, extends or closes an incident of a resource with
+// a new result, it must be called with statusMutex held.
+func (s *StatusChecker) trackIncident(c *ResConf, st *Status) {
+	in, open := s.openIncidents[c.Address]
+	if st.State() != StateDown {
+		if open {
+			s.closeIncident(c.Address, st.When)
+		}
+		return
+	}
+	if !open {
+		s.lastIncident++
+		in = &Incident{ID: s.lastIncident, Name: c.Name, Address: redactAddress(c.Address), Start: st.When, Error: incidentError(st)}
+		s.openIncidents[c.Address] = in
+		s.incidents = append(s.incidents, in)
+		if len(s.incidents) > maxIncidents {
+			s.incidents = s.incidents[len(s.incidents)-maxIncidents:]
+		}
+		slog.Warn("Incident opened", "id", in.ID, "resource", c.Name, "address", c.Address, "error", in.Error)
+	}
+	in.Checks++
+	if e := incidentError(st); e != in.Error {
+		in.LastErr = e
+	}
+}
+
+// closeIncident must be called with statusMutex held.
+func (s *StatusChecker) closeIncident(addr string, end time.Time) {
+	in, ok := s.openIncidents[addr]
+	if !ok {
+		return
+	}
+	in.End = end
+	delete(s.openIncidents, addr)
+	slog.Info("Incident closed", "id", in.ID, "resource", in.Name, "duration", roundDuration(end.Sub(in.Start)))
+}
+
+// Incidents returns incidents of a resource with a given name, or of all
+// resources if name is empty, the most recent first.
+func (s *StatusChecker) Incidents(name string) []Incident {
+	now := time.Now()
+	s.statusMutex.Lock()
+	defer s.statusMutex.Unlock()
+	ret := []Incident{}
+	for i := len(s.incidents) - 1; i >= 0; i-- {
+		in := *s.incidents[i]
+		if len(name) > 0 && in.Name != name {
+			continue
+		}
+		if in.End.IsZero() {
+			in.Duration = now.Sub(in.Start)
+		} else {
+			in.Duration = in.End.Sub(in.Start)
+		}
+		ret = append(ret, in)
+	}
+	return ret
+}
+
+const incidentsTmplStr = `
+<html><head><title>Incydenty</title></head>
+<style type="text/css">
+table, th, td {
+	border: 1px solid black;
+}
+</style>
+<body>
+<p><a href="/status">Status</a></p>
+<table>
+<tr>
+<td>#</td>
+<td>Nazwa</td>
+<td>Początek</td>
+<td>Koniec</td>
+<td>Czas trwania</td>
+<td>Nieudane sprawdzenia</td>
+<td>Błąd</td>
+</tr>
+{{range .}}
+<tr>
+<td>{{.ID}}</td>
+<td><a href="/check?name={{.Name}}">{{.Name}}</a></td>
+<td>{{formatTime .Start}}</td>
+<td>{{if .End.IsZero}}trwa{{else}}{{formatTime .End}}{{end}}</td>
+<td>{{roundDuration .Duration}}</td>
+<td>{{.Checks}}</td>
+<td>{{.Error}}{{if .LastErr}}, ostatnio: {{.LastErr}}{{end}}</td>
+</tr>
+{{end}}
+</table>
+</body>
+</html>
+`
+
+var incidentsTmpl = page("incidents", incidentsTmplStr)
+
+func RegisterIncidentsHandler(sc *StatusChecker) {
+	http.HandleFunc("/incidents", func(rw http.ResponseWriter, req *http.Request) {
+		if err := incidentsTmpl.Execute(rw, sc.Incidents(req.FormValue("name"))); err != nil {
+			slog.Error("Template render", "error", err)
+		}
+	})
+
+	describeAPI(&apiOperation{
+		Method: "GET", Path: "/api/incidents", Summary: "List incidents, the most recent first",
+		Params:    []apiParam{{Name: "name", In: "query", Doc: "of a single resource"}},
+		Responses: map[int]interface{}{http.StatusOK: []Incident{}},
+	})
+	http.HandleFunc("/api/incidents", func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(rw).Encode(sc.Incidents(req.FormValue("name"))); err != nil {
+			slog.Error("Incidents", "error", err)
+		}
+	})
+}
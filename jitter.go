@@ -0,0 +1,31 @@
+package main
+
+import (
+	"flag"
+	"math/rand"
+	"time"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// Jitter: periodic checks are spread over -jitter of their interval, each
+// resource at its own random offset kept for its lifetime, so a monitor
+// doesn't send all requests at once every interval. First checks aren't
+// delayed.
+///////////////////////////////////////////////////////////////////////////////
+
+var jitter = flag.Float64("jitter", 1, "Spread periodic checks over this fraction (0-1) of an interval, 0 checks all at once.")
+
+// offset returns a delay of periodic checks of c, it must be called with m
+// held.
+func (s *StatusChecker) offset(c *ResConf) time.Duration {
+	spread := time.Duration(min(max(*jitter, 0), 1) * float64(c.interval()))
+	if spread <= 0 {
+		return 0
+	}
+	d, ok := s.offsets[c.Name]
+	if !ok || d >= spread {
+		d = time.Duration(rand.Int63n(int64(spread)))
+		s.offsets[c.Name] = d
+	}
+	return d
+}
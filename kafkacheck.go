@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// A Kafka check: a broker at a kafka://host[:port] Address is asked for
+// metadata (API version 4, which never creates topics). With Topic the
+// topic should have partitions, each with a leader and at least MinISR
+// in-sync replicas. Plain connections only, no TLS or SASL.
+///////////////////////////////////////////////////////////////////////////////
+
+const (
+	kafkaTimeout  = 10 * time.Second
+	kafkaMetadata = 3
+	// kafkaMaxReply limits metadata replies.
+	kafkaMaxReply = 16 << 20
+	// An error code of a missing topic.
+	kafkaUnknownTopic = 3
+)
+
+func init() {
+	RegisterChecker("kafka", checkKafka)
+}
+
+func validateKafka(c *ResConf) error {
+	u, err := url.Parse(c.Address)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "kafka" {
+		return fmt.Errorf("%s: scheme must be kafka", c.Address)
+	}
+	if len(u.Hostname()) == 0 {
+		return fmt.Errorf("%s: no host", c.Address)
+	}
+	if c.MinISR > 0 && len(c.Topic) == 0 {
+		return fmt.Errorf("MinISR needs a Topic")
+	}
+	return nil
+}
+
+// kafkaReader decodes a response, the first error sticks and further reads
+// return zeros.
+type kafkaReader struct {
+	b   []byte
+	err error
+}
+
+func (r *kafkaReader) next(n int) []byte {
+	if r.err != nil || n < 0 || n > len(r.b) {
+		if r.err == nil {
+			r.err = fmt.Errorf("truncated response")
+		}
+		return make([]byte, 8)
+	}
+	b := r.b[:n]
+	r.b = r.b[n:]
+	return b
+}
+
+func (r *kafkaReader) int16() int16 { return int16(binary.BigEndian.Uint16(r.next(2))) }
+func (r *kafkaReader) int32() int32 { return int32(binary.BigEndian.Uint32(r.next(4))) }
+
+// str reads a nullable string, null is empty.
+func (r *kafkaReader) str() string {
+	n := r.int16()
+	if n < 0 {
+		return ""
+	}
+	return string(r.next(int(n)))
+}
+
+// array reads a length of an array, guarding against absurd ones.
+func (r *kafkaReader) array() int {
+	n := r.int32()
+	if int(n) > len(r.b) {
+		r.err = fmt.Errorf("truncated response")
+		return 0
+	}
+	return max(int(n), 0)
+}
+
+type kafkaPartition struct {
+	id, leader int32
+	isr        int
+}
+
+type kafkaTopic struct {
+	code       int16
+	partitions []kafkaPartition
+}
+
+// kafkaMetadataRequest returns a framed Metadata v4 request of topics, all
+// if there are none.
+func kafkaMetadataRequest(topics ...string) []byte {
+	b := []byte{0, 0, 0, 0}
+	b = binary.BigEndian.AppendUint16(b, kafkaMetadata)
+	b = binary.BigEndian.AppendUint16(b, 4)
+	b = binary.BigEndian.AppendUint32(b, 1) // a correlation ID
+	b = binary.BigEndian.AppendUint16(b, uint16(len("statusmonitor")))
+	b = append(b, "statusmonitor"...)
+	if len(topics) == 0 {
+		b = binary.BigEndian.AppendUint32(b, 0xffffffff) // null, all topics
+	} else {
+		b = binary.BigEndian.AppendUint32(b, uint32(len(topics)))
+		for _, t := range topics {
+			b = binary.BigEndian.AppendUint16(b, uint16(len(t)))
+			b = append(b, t...)
+		}
+	}
+	b = append(b, 0) // allow_auto_topic_creation
+	binary.BigEndian.PutUint32(b, uint32(len(b)-4))
+	return b
+}
+
+// kafkaReadMetadata reads a Metadata v4 response: brokers, a controller ID
+// and topics by name.
+func kafkaReadMetadata(conn io.Reader) (brokers []string, controller int32, topics map[string]*kafkaTopic, err error) {
+	var size [4]byte
+	if _, err := io.ReadFull(conn, size[:]); err != nil {
+		return nil, 0, nil, err
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n > kafkaMaxReply {
+		return nil, 0, nil, fmt.Errorf("a response of %d bytes is too big", n)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(conn, b); err != nil {
+		return nil, 0, nil, err
+	}
+	r := &kafkaReader{b: b}
+	r.int32() // a correlation ID
+	r.int32() // throttle_time_ms
+	for i, n := 0, r.array(); i < n; i++ {
+		r.int32() // node_id
+		host := r.str()
+		port := r.int32()
+		r.str() // rack
+		brokers = append(brokers, net.JoinHostPort(host, fmt.Sprint(port)))
+	}
+	r.str() // cluster_id
+	controller = r.int32()
+	topics = make(map[string]*kafkaTopic)
+	for i, n := 0, r.array(); i < n; i++ {
+		t := &kafkaTopic{code: r.int16()}
+		topics[r.str()] = t
+		r.next(1) // is_internal
+		for j, m := 0, r.array(); j < m; j++ {
+			r.int16() // error_code
+			p := kafkaPartition{id: r.int32(), leader: r.int32()}
+			r.next(4 * r.array()) // replica_nodes
+			p.isr = r.array()
+			r.next(4 * p.isr)
+			t.partitions = append(t.partitions, p)
+		}
+	}
+	return brokers, controller, topics, r.err
+}
+
+func checkKafka(c *ResConf) *Status {
+	st := &Status{When: time.Now()}
+	fail := func(step string, err error) *Status {
+		st.Latency = time.Since(st.When)
+		st.StatusCode = UnknownError
+		st.Error = step + ": " + c.errorText(err)
+		return st
+	}
+	u, err := url.Parse(c.Address)
+	if err != nil {
+		return fail("connect", err)
+	}
+	d := &net.Dialer{Timeout: kafkaTimeout, Resolver: newResolver(c.resolver())}
+	conn, err := d.Dial(dialNetwork("tcp", c.Family), urlHost(u, "9092"))
+	if err != nil {
+		return fail("connect", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(kafkaTimeout))
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		st.RemoteIP = addr.IP.String()
+	}
+	var topics []string
+	if len(c.Topic) > 0 {
+		topics = append(topics, c.Topic)
+	}
+	start := time.Now()
+	if _, err := conn.Write(kafkaMetadataRequest(topics...)); err != nil {
+		return fail("metadata", err)
+	}
+	brokers, controller, meta, err := kafkaReadMetadata(conn)
+	if err != nil {
+		return fail("metadata", err)
+	}
+	st.TTFB = time.Since(start)
+	st.Latency = time.Since(st.When)
+	st.Details = append(st.Details, fmt.Sprintf("%d brokers: %s, controller %d", len(brokers), strings.Join(brokers, ", "), controller))
+	st.StatusCode = http.StatusOK
+	if len(c.Topic) > 0 {
+		t := meta[c.Topic]
+		switch {
+		case t == nil || t.code == kafkaUnknownTopic:
+			st.StatusCode = UnknownError
+			st.Error = fmt.Sprintf("topic %s doesn't exist", c.Topic)
+			return st
+		case t.code != 0:
+			st.StatusCode = UnknownError
+			st.Error = fmt.Sprintf("topic %s: error code %d", c.Topic, t.code)
+			return st
+		case len(t.partitions) == 0:
+			st.StatusCode = UnknownError
+			st.Error = fmt.Sprintf("topic %s has no partitions", c.Topic)
+			return st
+		}
+		minISR := -1
+		for _, p := range t.partitions {
+			if p.leader < 0 {
+				st.StatusCode = UnknownError
+				st.Error = fmt.Sprintf("partition %d of %s has no leader", p.id, c.Topic)
+				return st
+			}
+			if minISR < 0 || p.isr < minISR {
+				minISR = p.isr
+			}
+			if p.isr < c.MinISR {
+				st.StatusCode = UnknownError
+				st.Error = fmt.Sprintf("partition %d of %s has %d in-sync replicas, %d needed", p.id, c.Topic, p.isr, c.MinISR)
+				return st
+			}
+		}
+		st.Details = append(st.Details, fmt.Sprintf("topic %s: %d partitions, min ISR %d", c.Topic, len(t.partitions), minISR))
+	}
+	st.Slow = c.slow(st)
+	return st
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+// kafkaResponse frames a Metadata v4 response body.
+func kafkaResponse(body []byte) []byte {
+	return append(binary.BigEndian.AppendUint32(nil, uint32(len(body))), body...)
+}
+
+func TestKafkaReadMetadata(t *testing.T) {
+	i16 := func(b []byte, v int16) []byte { return binary.BigEndian.AppendUint16(b, uint16(v)) }
+	i32 := func(b []byte, v int32) []byte { return binary.BigEndian.AppendUint32(b, uint32(v)) }
+	str := func(b []byte, s string) []byte { return append(i16(b, int16(len(s))), s...) }
+	var b []byte
+	b = i32(b, 1) // correlation_id
+	b = i32(b, 0) // throttle_time_ms
+	b = i32(b, 2) // brokers
+	b = str(i32(b, 1), "k1")
+	b = i16(i32(b, 9092), -1) // a null rack
+	b = str(i32(b, 2), "k2")
+	b = str(i32(b, 9093), "r")
+	b = str(b, "cluster")
+	b = i32(b, 2) // controller_id
+	b = i32(b, 2) // topics
+	b = str(i16(b, 0), "orders")
+	b = append(b, 0)
+	b = i32(b, 2) // partitions
+	b = i32(i32(i16(b, 0), 0), 1)
+	b = i32(i32(i32(b, 2), 1), 2) // replicas
+	b = i32(i32(i32(b, 2), 1), 2) // isr
+	b = i32(i32(i16(b, 0), 1), -1)
+	b = i32(i32(i32(b, 2), 1), 2)
+	b = i32(i32(b, 1), 2)
+	b = str(i16(b, kafkaUnknownTopic), "missing")
+	b = i32(append(b, 0), 0)
+	full := b
+
+	brokers, controller, topics, err := kafkaReadMetadata(bytes.NewReader(kafkaResponse(full)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"k1:9092", "k2:9093"}; !reflect.DeepEqual(brokers, want) {
+		t.Errorf("brokers %v, want %v", brokers, want)
+	}
+	if controller != 2 {
+		t.Errorf("controller %d, want 2", controller)
+	}
+	want := map[string]*kafkaTopic{
+		"orders":  {partitions: []kafkaPartition{{0, 1, 2}, {1, -1, 1}}},
+		"missing": {code: kafkaUnknownTopic},
+	}
+	if !reflect.DeepEqual(topics, want) {
+		t.Errorf("topics %v, want %v", topics, want)
+	}
+
+	tests := []struct {
+		name string
+		in   []byte
+	}{
+		{"empty", nil},
+		{"no body", kafkaResponse(nil)[:4]},
+		{"short body", kafkaResponse(full)[:100]},
+		{"too big", kafkaResponse(make([]byte, kafkaMaxReply+1))},
+		{"truncated header", kafkaResponse(full[:6])},
+		{"truncated broker", kafkaResponse(full[:20])},
+		{"truncated topic", kafkaResponse(full[:len(full)-6])},
+		{"over-long brokers", kafkaResponse(i32(full[:8:8], 1<<30))},
+		{"over-long string", kafkaResponse(i16(i32(full[:12:12], 1), 0x7fff))},
+		{"over-long partitions", kafkaResponse(i32(full[:69:69], 1<<30))},
+		{"over-long replicas", kafkaResponse(i32(full[:83:83], 1<<30))},
+		{"negative replicas", kafkaResponse(i32(full[:83:83], -2))},
+	}
+	for _, tt := range tests {
+		if _, _, _, err := kafkaReadMetadata(bytes.NewReader(tt.in)); err == nil {
+			t.Errorf("%s: no error", tt.name)
+		}
+	}
+}
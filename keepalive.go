@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"time"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// A keep-alive check: two sequential requests which should share one
+// connection, e.g. to monitor how a proxy or a load balancer handles
+// connections. A server not reusing a connection is DEGRADED.
+///////////////////////////////////////////////////////////////////////////////
+
+func init() {
+	RegisterChecker("keepalive", checkKeepAlive)
+}
+
+type keepAliveRound struct {
+	resp    *http.Response
+	reused  bool
+	conn    net.Conn
+	ttfb    time.Duration
+	latency time.Duration
+}
+
+func keepAliveRequest(client *http.Client, c *ResConf) (*keepAliveRound, error) {
+	method := c.Method
+	if len(method) == 0 {
+		method = "GET"
+	}
+	req, err := http.NewRequest(method, c.Address, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range c.Headers {
+		req.Header.Set(k, v)
+	}
+	r := &keepAliveRound{}
+	start := time.Now()
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			r.reused = info.Reused
+			r.conn = info.Conn
+		},
+		GotFirstResponseByte: func() { r.ttfb = time.Since(start) },
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+	if r.resp, err = client.Do(withProxy(req, c)); err != nil {
+		return nil, err
+	}
+	// A connection is reused only after a body is read.
+	_, err = io.Copy(ioutil.Discard, r.resp.Body)
+	r.resp.Body.Close()
+	r.latency = time.Since(start)
+	return r, err
+}
+
+func (r *keepAliveRound) String() string {
+	conn := "a new connection"
+	if r.reused {
+		conn = "a reused connection"
+	}
+	return fmt.Sprintf("%s on %s, TTFB %s, total %s", r.resp.Status, conn, humanizeLatency(r.ttfb), humanizeLatency(r.latency))
+}
+
+func checkKeepAlive(c *ResConf) *Status {
+	st := &Status{When: time.Now()}
+	if c.Expect != 0 && c.Expect != http.StatusOK {
+		st.Expected = c.Expect
+	}
+	// An own transport, so the first request can't reuse a connection of
+	// other checks.
+	tr := newCheckTransport(newResolver(c.resolver()), c.Family)
+	tr.DisableKeepAlives = false
+	tr.MaxIdleConnsPerHost = 1
+	defer tr.CloseIdleConnections()
+	client := &http.Client{Transport: tr}
+
+	first, err := keepAliveRequest(client, c)
+	if err != nil {
+		st.StatusCode = UnknownError
+		st.Error = c.errorText(err)
+		return st
+	}
+	st.StatusCode = first.resp.StatusCode
+	st.Proto = first.resp.Proto
+	if addr, ok := first.conn.RemoteAddr().(*net.TCPAddr); ok {
+		st.RemoteIP = addr.IP.String()
+	}
+	st.TTFB = first.ttfb
+	st.Latency = first.latency
+	st.Slow = c.slow(st)
+	st.Details = append(st.Details, "first: "+first.String())
+	if st.State() == StateDown {
+		return st
+	}
+
+	second, err := keepAliveRequest(client, c)
+	if err != nil {
+		st.Details = append(st.Details, "second: "+c.errorText(err))
+		st.Degraded = "second request failed"
+		return st
+	}
+	st.Details = append(st.Details, "second: "+second.String())
+	switch {
+	case second.resp.StatusCode != first.resp.StatusCode:
+		st.Degraded = fmt.Sprintf("second request returned %d", second.resp.StatusCode)
+	case !second.reused || second.conn != first.conn:
+		st.Degraded = "connection not reused"
+		if first.resp.Close {
+			st.Degraded += ", server sent Connection: close"
+		}
+	}
+	if first.latency > 0 {
+		st.Details = append(st.Details, fmt.Sprintf("second/first total: %.0f%%", 100*float64(second.latency)/float64(first.latency)))
+	}
+	return st
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// A key-value store config backend: a config is a value of a key in Consul
+// or etcd (v3, its JSON gateway), so a fleet of monitors shares centrally
+// managed resources. A key is watched and changes are applied like a
+// reload. Such a config is never saved, it's changed in a store.
+///////////////////////////////////////////////////////////////////////////////
+
+var (
+	kvType  = flag.String("kv", "", "A config store, consul or etcd, instead of -config.")
+	kvAddr  = flag.String("kv-addr", "", "An address of -kv, http://127.0.0.1:8500 (consul) or http://127.0.0.1:2379 (etcd) if empty.")
+	kvKey   = flag.String("kv-key", "statusmonitor/config", "A key of a config in -kv, YAML if it has a YAML extension.")
+	kvToken = flag.String("kv-token", os.Getenv("CONSUL_HTTP_TOKEN"), "An ACL token of consul or an auth token of etcd.")
+)
+
+// kvRetry is how long to wait after a store failed.
+const kvRetry = 10 * time.Second
+
+// kvClient has no timeout, requests wait for changes.
+var kvClient = &http.Client{}
+
+type kvStore interface {
+	// next returns a value of a key, the current one on a first call and
+	// then once it changes.
+	next() ([]byte, error)
+}
+
+func newKVStore() (kvStore, error) {
+	addr := strings.TrimSuffix(*kvAddr, "/")
+	switch *kvType {
+	case "consul":
+		if len(addr) == 0 {
+			addr = "http://127.0.0.1:8500"
+		}
+		return &consulKV{addr: addr, key: *kvKey, token: *kvToken}, nil
+	case "etcd":
+		if len(addr) == 0 {
+			addr = "http://127.0.0.1:2379"
+		}
+		return &etcdKV{addr: addr, key: *kvKey, token: *kvToken}, nil
+	}
+	return nil, fmt.Errorf("unknown -kv %q, use consul or etcd", *kvType)
+}
+
+// kvSource names a config in logs.
+func kvSource() string {
+	return fmt.Sprintf("%s key %s", *kvType, *kvKey)
+}
+
+// LoadKVConfig reads a current config from a store.
+func LoadKVConfig(kv kvStore) (*Config, error) {
+	b, err := kv.next()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", kvSource(), err)
+	}
+	return parseConfig(*kvKey, b)
+}
+
+// watchKV applies every change of a config in a store.
+func (s *StatusChecker) watchKV(kv kvStore) {
+	for {
+		b, err := kv.next()
+		if err == nil {
+			var config *Config
+			if config, err = parseConfig(*kvKey, b); err == nil {
+				s.apply(config, kvSource(), &Caller{Name: *kvType, Role: RoleAdmin}, "")
+				continue
+			}
+		}
+		slog.Error("Config store", "source", kvSource(), "error", err)
+		time.Sleep(kvRetry)
+	}
+}
+
+// consulKV waits for changes with blocking queries.
+type consulKV struct {
+	addr, key, token string
+	index            uint64 // X-Consul-Index of a returned value
+}
+
+func (c *consulKV) next() ([]byte, error) {
+	for {
+		u := fmt.Sprintf("%s/v1/kv/%s?raw", c.addr, c.key)
+		if c.index > 0 {
+			u += fmt.Sprintf("&index=%d&wait=5m", c.index)
+		}
+		req, err := http.NewRequest("GET", u, nil)
+		if err != nil {
+			return nil, err
+		}
+		if len(c.token) > 0 {
+			req.Header.Set("X-Consul-Token", c.token)
+		}
+		resp, err := kvClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		b, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(b))
+		}
+		index, err := strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad X-Consul-Index: %s", err)
+		}
+		switch {
+		case index < c.index:
+			// Consul was restored from a snapshot, start over.
+			c.index = 0
+		case index == c.index:
+			// A wait timed out.
+		default:
+			c.index = index
+			return b, nil
+		}
+	}
+}
+
+// etcdKV reads a key with a range request and waits for changes with a
+// watch starting after a revision of a returned value.
+type etcdKV struct {
+	addr, key, token string
+	revision         int64
+}
+
+type etcdKeyValue struct {
+	Value       []byte `json:"value"`
+	ModRevision int64  `json:"mod_revision,string"`
+}
+
+type etcdHeader struct {
+	Revision int64 `json:"revision,string"`
+}
+
+func (e *etcdKV) post(path string, v interface{}) (*http.Response, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", e.addr+path, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(e.token) > 0 {
+		req.Header.Set("Authorization", e.token)
+	}
+	resp, err := kvClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		b, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(b))
+	}
+	return resp, nil
+}
+
+func (e *etcdKV) next() ([]byte, error) {
+	if e.revision == 0 {
+		resp, err := e.post("/v3/kv/range", map[string]interface{}{"key": []byte(e.key)})
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		var r struct {
+			Header etcdHeader     `json:"header"`
+			Kvs    []etcdKeyValue `json:"kvs"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+			return nil, err
+		}
+		if len(r.Kvs) == 0 {
+			return nil, fmt.Errorf("no key %s", e.key)
+		}
+		e.revision = r.Header.Revision
+		return r.Kvs[0].Value, nil
+	}
+
+	resp, err := e.post("/v3/watch", map[string]interface{}{
+		"create_request": map[string]interface{}{"key": []byte(e.key), "start_revision": e.revision + 1},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var msg struct {
+			Result struct {
+				Canceled     bool   `json:"canceled"`
+				CancelReason string `json:"cancel_reason"`
+				Events       []struct {
+					Type string       `json:"type"`
+					Kv   etcdKeyValue `json:"kv"`
+				} `json:"events"`
+			} `json:"result"`
+		}
+		if err := dec.Decode(&msg); err != nil {
+			return nil, err
+		}
+		if msg.Result.Canceled {
+			// E.g. a revision was compacted, start over.
+			e.revision = 0
+			return nil, fmt.Errorf("watch canceled: %s", msg.Result.CancelReason)
+		}
+		for _, ev := range msg.Result.Events {
+			e.revision = ev.Kv.ModRevision
+			if ev.Type == "DELETE" {
+				slog.Warn("Config store key deleted, resources kept", "source", kvSource())
+				continue
+			}
+			return ev.Kv.Value, nil
+		}
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// An LDAP check: an anonymous bind, or a simple one as BindDN with Password,
+// at an ldap://host[:port] or ldaps:// (TLS) Address, then if the Address
+// has a base DN as its path, e.g. ldap://host/dc=example,dc=com, a base
+// search of it which should find the entry. Just enough BER is spoken for
+// these two operations.
+///////////////////////////////////////////////////////////////////////////////
+
+const ldapTimeout = 10 * time.Second
+
+// BER tags of LDAP operations and fields.
+const (
+	berInteger    = 0x02
+	berOctets     = 0x04
+	berEnum       = 0x0a
+	berSequence   = 0x30
+	ldapBind      = 0x60
+	ldapBindResp  = 0x61
+	ldapUnbind    = 0x42
+	ldapSearch    = 0x63
+	ldapEntry     = 0x64
+	ldapDone      = 0x65
+	ldapSimple    = 0x80 // [0] a simple bind password
+	ldapPresent   = 0x87 // [7] a present filter
+	ldapMaxResult = 1 << 20
+)
+
+// ldapResults names result codes of failures worth telling apart.
+var ldapResults = map[int]string{
+	1:  "operationsError",
+	32: "noSuchObject",
+	48: "inappropriateAuthentication",
+	49: "invalidCredentials",
+	50: "insufficientAccessRights",
+	51: "busy",
+	52: "unavailable",
+	53: "unwillingToPerform",
+}
+
+func init() {
+	RegisterChecker("ldap", checkLDAP)
+}
+
+func validateLDAP(c *ResConf) error {
+	u, err := url.Parse(c.Address)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "ldap" && u.Scheme != "ldaps" {
+		return fmt.Errorf("%s: scheme must be ldap or ldaps", c.Address)
+	}
+	if len(u.Hostname()) == 0 {
+		return fmt.Errorf("%s: no host", c.Address)
+	}
+	if len(c.Password) > 0 && len(c.BindDN) == 0 {
+		return fmt.Errorf("a Password needs a BindDN")
+	}
+	return nil
+}
+
+// ber encodes a TLV.
+func ber(tag byte, content ...[]byte) []byte {
+	var body []byte
+	for _, c := range content {
+		body = append(body, c...)
+	}
+	b := []byte{tag}
+	switch n := len(body); {
+	case n < 0x80:
+		b = append(b, byte(n))
+	case n <= 0xff:
+		b = append(b, 0x81, byte(n))
+	case n <= 0xffff:
+		b = append(b, 0x82, byte(n>>8), byte(n))
+	default:
+		b = append(b, 0x84, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+	return append(b, body...)
+}
+
+// berInt encodes a small non-negative integer.
+func berInt(tag byte, v int) []byte {
+	b := []byte{byte(v)}
+	for v >>= 8; v > 0; v >>= 8 {
+		b = append([]byte{byte(v)}, b...)
+	}
+	if b[0]&0x80 != 0 {
+		b = append([]byte{0}, b...)
+	}
+	return ber(tag, b)
+}
+
+// berRead reads a TLV, returning its tag and content.
+func berRead(r io.ByteReader, max int) (byte, []byte, error) {
+	tag, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	l, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	n := int(l)
+	if l&0x80 != 0 {
+		if l&0x7f > 4 {
+			return 0, nil, fmt.Errorf("malformed BER length")
+		}
+		n = 0
+		for i := 0; i < int(l&0x7f); i++ {
+			b, err := r.ReadByte()
+			if err != nil {
+				return 0, nil, err
+			}
+			n = n<<8 | int(b)
+		}
+	}
+	if n > max {
+		return 0, nil, fmt.Errorf("a message of %d bytes is too big", n)
+	}
+	content := make([]byte, n)
+	for i := range content {
+		if content[i], err = r.ReadByte(); err != nil {
+			return 0, nil, err
+		}
+	}
+	return tag, content, nil
+}
+
+// ldapRead reads a message, returning an operation tag and its content.
+func ldapRead(r *bufio.Reader) (byte, []byte, error) {
+	tag, msg, err := berRead(r, ldapMaxResult)
+	if err != nil {
+		return 0, nil, err
+	}
+	if tag != berSequence {
+		return 0, nil, fmt.Errorf("unexpected tag %#x", tag)
+	}
+	mr := strings.NewReader(string(msg))
+	if _, _, err := berRead(mr, len(msg)); err != nil { // a message ID
+		return 0, nil, err
+	}
+	return berRead(mr, len(msg))
+}
+
+// ldapResult returns an error of an LDAPResult unless it's a success.
+func ldapResult(op []byte) error {
+	r := strings.NewReader(string(op))
+	tag, b, err := berRead(r, len(op))
+	if err != nil || tag != berEnum || len(b) == 0 || len(b) > 4 {
+		return fmt.Errorf("malformed result")
+	}
+	code := 0
+	for _, c := range b {
+		code = code<<8 | int(c)
+	}
+	if code == 0 {
+		return nil
+	}
+	name := ldapResults[code]
+	if len(name) == 0 {
+		name = fmt.Sprintf("result code %d", code)
+	}
+	if _, _, err := berRead(r, len(op)); err != nil { // matchedDN
+		return fmt.Errorf("%s (malformed result: %v)", name, err)
+	}
+	_, diag, err := berRead(r, len(op))
+	if err != nil {
+		return fmt.Errorf("%s (malformed result: %v)", name, err)
+	}
+	if len(diag) > 0 {
+		return fmt.Errorf("%s: %s", name, diag)
+	}
+	return fmt.Errorf("%s", name)
+}
+
+func checkLDAP(c *ResConf) *Status {
+	st := &Status{When: time.Now()}
+	fail := func(step string, err error) *Status {
+		st.Latency = time.Since(st.When)
+		st.StatusCode = UnknownError
+		st.Error = step + ": " + c.errorText(err)
+		return st
+	}
+	u, err := url.Parse(c.Address)
+	if err != nil {
+		return fail("connect", err)
+	}
+	d := &net.Dialer{Timeout: ldapTimeout, Resolver: newResolver(c.resolver())}
+	var conn net.Conn
+	if u.Scheme == "ldaps" {
+		conn, err = tls.DialWithDialer(d, dialNetwork("tcp", c.Family), urlHost(u, "636"), &tls.Config{ServerName: u.Hostname()})
+	} else {
+		conn, err = d.Dial(dialNetwork("tcp", c.Family), urlHost(u, "389"))
+	}
+	if err != nil {
+		return fail("connect", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(ldapTimeout))
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		st.RemoteIP = addr.IP.String()
+	}
+	if tc, ok := conn.(*tls.Conn); ok {
+		cs := tc.ConnectionState()
+		st.TLSVersion = tls.VersionName(cs.Version)
+		st.Cert = certReport(c, &cs)
+	}
+	r := bufio.NewReader(conn)
+
+	bind := ber(ldapBind, berInt(berInteger, 3), ber(berOctets, []byte(c.BindDN)), ber(ldapSimple, []byte(c.Password)))
+	if _, err := conn.Write(ber(berSequence, berInt(berInteger, 1), bind)); err != nil {
+		return fail("bind", err)
+	}
+	tag, op, err := ldapRead(r)
+	if err == nil && tag != ldapBindResp {
+		err = fmt.Errorf("unexpected response %#x", tag)
+	}
+	if err == nil {
+		err = ldapResult(op)
+	}
+	if err != nil {
+		return fail("bind", err)
+	}
+	who := "anonymous"
+	if len(c.BindDN) > 0 {
+		who = c.BindDN
+	}
+	st.Details = append(st.Details, "bound as "+who)
+
+	if base := strings.TrimPrefix(u.Path, "/"); len(base) > 0 {
+		// A base scope search of any entry, without attributes.
+		start := time.Now()
+		search := ber(ldapSearch,
+			ber(berOctets, []byte(base)),
+			berInt(berEnum, 0), berInt(berEnum, 0), // base scope, no alias dereferencing
+			berInt(berInteger, 1), berInt(berInteger, int(ldapTimeout.Seconds())),
+			ber(0x01, []byte{0}), // types only: false
+			ber(ldapPresent, []byte("objectClass")),
+			ber(berSequence, ber(berOctets, []byte("1.1"))))
+		if _, err := conn.Write(ber(berSequence, berInt(berInteger, 2), search)); err != nil {
+			return fail("search", err)
+		}
+		entries := 0
+		for {
+			tag, op, err := ldapRead(r)
+			if err != nil {
+				return fail("search", err)
+			}
+			if tag == ldapEntry {
+				entries++
+				continue
+			}
+			if tag != ldapDone {
+				continue
+			}
+			if err := ldapResult(op); err != nil {
+				return fail("search", err)
+			}
+			break
+		}
+		if entries == 0 {
+			return fail("search", fmt.Errorf("%s not found", base))
+		}
+		st.TTFB = time.Since(start)
+	}
+	conn.Write(ber(berSequence, berInt(berInteger, 3), []byte{ldapUnbind, 0}))
+	st.Latency = time.Since(st.When)
+	st.Slow = c.slow(st)
+	st.StatusCode = http.StatusOK
+	return st
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBerRead(t *testing.T) {
+	long := strings.Repeat("x", 300)
+	tests := []struct {
+		name    string
+		in      []byte
+		max     int
+		tag     byte
+		content string
+		err     bool
+	}{
+		{"short", ber(berOctets, []byte("cn=a")), 100, berOctets, "cn=a", false},
+		{"empty", ber(berSequence), 100, berSequence, "", false},
+		{"long form", ber(berOctets, []byte(long)), 1000, berOctets, long, false},
+		{"no length", []byte{berOctets}, 100, 0, "", true},
+		{"truncated length", []byte{berOctets, 0x82, 0x01}, 1000, 0, "", true},
+		{"truncated content", []byte{berOctets, 5, 'a', 'b'}, 100, 0, "", true},
+		{"too many length bytes", []byte{berOctets, 0x85, 0, 0, 0, 0, 1, 'a'}, 100, 0, "", true},
+		{"over max", ber(berOctets, []byte(long)), 299, 0, "", true},
+		{"over max length", []byte{berOctets, 0x84, 0x7f, 0xff, 0xff, 0xff}, ldapMaxResult, 0, "", true},
+	}
+	for _, tt := range tests {
+		tag, content, err := berRead(strings.NewReader(string(tt.in)), tt.max)
+		if (err != nil) != tt.err {
+			t.Errorf("%s: error %v, want error %v", tt.name, err, tt.err)
+			continue
+		}
+		if tag != tt.tag || string(content) != tt.content {
+			t.Errorf("%s: got %#x %q, want %#x %q", tt.name, tag, content, tt.tag, tt.content)
+		}
+	}
+}
+
+func TestLdapResult(t *testing.T) {
+	result := func(code int) []byte { return berInt(berEnum, code) }
+	cat := func(parts ...[]byte) []byte {
+		var b []byte
+		for _, p := range parts {
+			b = append(b, p...)
+		}
+		return b
+	}
+	dn, diag := ber(berOctets), ber(berOctets, []byte("bad password"))
+	tests := []struct {
+		name string
+		in   []byte
+		want string // empty for a success
+	}{
+		{"success", cat(result(0), dn, ber(berOctets)), ""},
+		{"success without fields", result(0), ""},
+		{"diagnostic", cat(result(49), dn, diag), "invalidCredentials: bad password"},
+		{"no diagnostic", cat(result(32), dn, ber(berOctets)), "noSuchObject"},
+		{"unknown code", cat(result(80), dn, ber(berOctets)), "result code 80"},
+		{"two byte code", cat(result(4096), dn, ber(berOctets)), "result code 4096"},
+		{"empty", nil, "malformed result"},
+		{"not an enum", cat(ber(berOctets, []byte{49}), dn, diag), "malformed result"},
+		{"empty code", cat(ber(berEnum), dn, diag), "malformed result"},
+		{"over-long code", cat(ber(berEnum, []byte{1, 0, 0, 0, 0}), dn, diag), "malformed result"},
+		{"no matched DN", result(49), "invalidCredentials (malformed result: EOF)"},
+		{"truncated matched DN", cat(result(49), []byte{berOctets, 2, 'c'}), "invalidCredentials (malformed result: EOF)"},
+		{"no diagnostic field", cat(result(49), dn), "invalidCredentials (malformed result: EOF)"},
+		{"truncated diagnostic", cat(result(49), dn, []byte{berOctets, 0x81}), "invalidCredentials (malformed result: EOF)"},
+		{"over-long diagnostic", cat(result(49), dn, []byte{berOctets, 0x7f, 'b', 'a', 'd'}), "invalidCredentials (malformed result: a message of 127 bytes is too big)"},
+	}
+	for _, tt := range tests {
+		err := ldapResult(tt.in)
+		got := ""
+		if err != nil {
+			got = err.Error()
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
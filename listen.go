@@ -0,0 +1,59 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// Listeners: pages and the read-only API are served at -addr. With an admin
+// address, RPC, changes and the audit log are served only there, so e.g.
+// pages may listen on a public interface and admin on a private one.
+///////////////////////////////////////////////////////////////////////////////
+
+var adminAddr = flag.String("admin-addr", "", "An address of RPC and changes, -addr if empty. Commands are sent to it if set.")
+
+type ListenConf struct {
+	Addr      string `json:",omitempty"` // -addr, unless the flag is set
+	AdminAddr string `json:",omitempty"` // -admin-addr, unless the flag is set
+}
+
+// setListenAddrs sets unset -addr and -admin-addr from a config.
+func setListenAddrs(c *ListenConf) {
+	if c == nil {
+		return
+	}
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	if !set["addr"] && len(c.Addr) > 0 {
+		*addr = c.Addr
+	}
+	if !set["admin-addr"] && len(c.AdminAddr) > 0 {
+		*adminAddr = c.AdminAddr
+	}
+}
+
+// adminOnly returns whether a request is served only at an admin address.
+func adminOnly(req *http.Request) bool {
+	return mutating(req.Method) && req.URL.Path != "/login" && req.URL.Path != "/login/callback" && !isHeartbeat(req) ||
+		req.URL.Path == "/api/audit"
+}
+
+// statusOnly wraps h so admin requests are not found.
+func statusOnly(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if adminOnly(req) {
+			http.NotFound(rw, req)
+			return
+		}
+		h.ServeHTTP(rw, req)
+	})
+}
+
+// commandAddr is where commands are sent.
+func commandAddr() string {
+	if len(*adminAddr) > 0 {
+		return *adminAddr
+	}
+	return *addr
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// A log file: with -log-file a server logs to a file instead of stderr. The
+// file is rotated once it grows over -log-max-size or gets older than
+// -log-max-age, previous ones are kept as <file>.1 (the newest) to <file>.N.
+///////////////////////////////////////////////////////////////////////////////
+
+var (
+	logFile    = flag.String("log-file", "", "A file a server logs to instead of stderr.")
+	logMaxSize = flag.Int("log-max-size", 100, "Rotate -log-file once it's bigger (MB), 0 to rotate by age only.")
+	logMaxAge  = flag.Duration("log-max-age", 24*time.Hour, "Rotate -log-file once it's older, 0 to rotate by size only.")
+	logBackups = flag.Int("log-backups", 7, "How many rotated log files are kept.")
+)
+
+type rotatingFile struct {
+	path    string
+	maxSize int64
+	maxAge  time.Duration
+	backups int
+
+	m      sync.Mutex
+	f      *os.File
+	size   int64
+	opened time.Time
+}
+
+func openLogFile(path string) (*rotatingFile, error) {
+	r := &rotatingFile{
+		path:    path,
+		maxSize: int64(*logMaxSize) << 20,
+		maxAge:  *logMaxAge,
+		backups: *logBackups,
+	}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f, r.size, r.opened = f, fi.Size(), time.Now()
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	full := r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize
+	old := r.maxAge > 0 && time.Since(r.opened) > r.maxAge
+	if full || old {
+		if err := r.rotate(); err != nil {
+			// Keep logging to the current file rather than losing lines.
+			fmt.Fprintf(os.Stderr, "Rotating %s: %s\n", r.path, err)
+			r.opened = time.Now()
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// rotate shifts <file>.1..N-1 to .2..N, renames a current file to .1 and
+// opens a new one. The current file is opened again if it can't be renamed.
+func (r *rotatingFile) rotate() error {
+	r.f.Close()
+	var err error
+	if r.backups > 0 {
+		os.Remove(fmt.Sprintf("%s.%d", r.path, r.backups))
+		for i := r.backups - 1; i >= 1; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", r.path, i), fmt.Sprintf("%s.%d", r.path, i+1))
+		}
+		err = os.Rename(r.path, r.path+".1")
+	} else {
+		err = os.Remove(r.path)
+	}
+	if errOpen := r.open(); errOpen != nil {
+		return errOpen
+	}
+	return err
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// A logging part: a server logs with log/slog, records have fields like
+// resource, address, state and latency, as text or JSON lines (-log-format)
+// for Loki or ELK. Every check result is logged at the debug level. Logs
+// can go to syslog or journald instead, with priorities of their levels.
+///////////////////////////////////////////////////////////////////////////////
+
+var (
+	logLevel   = flag.String("log-level", "info", "A minimal level of server logs: debug (every check), info, warn or error.")
+	logFormat  = flag.String("log-format", "text", "A format of server logs: text or json.")
+	syslogAddr = flag.String("syslog", "", "Log to syslog: local for a local daemon or e.g. udp://host:514.")
+	journald   = flag.Bool("journald", false, "Log to the systemd journal.")
+)
+
+// setupLogging sends logs, including ones of the log package, to w or to
+// syslog or journald if they're set, and to buf in any case.
+func setupLogging(w, buf io.Writer) error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(*logLevel)); err != nil {
+		return fmt.Errorf("bad -log-level: %s", err)
+	}
+	if *logFormat != "text" && *logFormat != "json" {
+		return fmt.Errorf("unknown -log-format %q, use text or json", *logFormat)
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	newHandler := func(w io.Writer) slog.Handler {
+		if *logFormat == "json" {
+			return slog.NewJSONHandler(w, opts)
+		}
+		return slog.NewTextHandler(w, opts)
+	}
+
+	var sinks [4]io.Writer
+	var err error
+	switch {
+	case len(*syslogAddr) > 0 && *journald:
+		return fmt.Errorf("-syslog and -journald can't be used together")
+	case len(*syslogAddr) > 0:
+		sinks, err = syslogWriters(*syslogAddr)
+	case *journald:
+		sinks, err = journaldWriters()
+	default:
+		slog.SetDefault(slog.New(newHandler(io.MultiWriter(w, buf))))
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	// Syslog and journald add their own timestamps.
+	opts.ReplaceAttr = func(groups []string, a slog.Attr) slog.Attr {
+		if len(groups) == 0 && a.Key == slog.TimeKey {
+			return slog.Attr{}
+		}
+		return a
+	}
+	var h leveledHandler
+	for i, sink := range sinks {
+		h[i] = newHandler(io.MultiWriter(sink, buf))
+	}
+	slog.SetDefault(slog.New(h))
+	return nil
+}
+
+// leveledHandler passes records to handlers by their levels: debug, info,
+// warn and error, e.g. writing with different syslog priorities.
+type leveledHandler [4]slog.Handler
+
+func (h leveledHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h[0].Enabled(ctx, level)
+}
+
+func (h leveledHandler) Handle(ctx context.Context, r slog.Record) error {
+	switch {
+	case r.Level >= slog.LevelError:
+		return h[3].Handle(ctx, r)
+	case r.Level >= slog.LevelWarn:
+		return h[2].Handle(ctx, r)
+	case r.Level >= slog.LevelInfo:
+		return h[1].Handle(ctx, r)
+	}
+	return h[0].Handle(ctx, r)
+}
+
+func (h leveledHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	for i := range h {
+		h[i] = h[i].WithAttrs(attrs)
+	}
+	return h
+}
+
+func (h leveledHandler) WithGroup(name string) slog.Handler {
+	for i := range h {
+		h[i] = h[i].WithGroup(name)
+	}
+	return h
+}
+
+// fatal logs an error and exits.
+func fatal(msg string, args ...interface{}) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
@@ -0,0 +1,510 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// A login part: with Login in the config the web UI needs a user/password
+// (a form or basic auth) or an OpenID Connect login. A logged in user gets
+// a signed session cookie. Requests with an API key and RPC pass as before.
+///////////////////////////////////////////////////////////////////////////////
+
+const (
+	sessionCookie   = "statusmonitor_session"
+	oidcStateCookie = "statusmonitor_oidc_state"
+)
+
+// Sources of identities of sessions, "<source>:<user>": roles of a user
+// come from its source only, so an OIDC user can't pose as a local one.
+const (
+	identityLocal = "local"
+	identityOIDC  = "oidc"
+)
+
+type LoginConf struct {
+	Users []*LoginUser `json:",omitempty"`
+	OIDC  *OIDCConf    `json:",omitempty"`
+	// Public path prefixes served without a login, e.g. /badge/.
+	Public []string `json:",omitempty"`
+	// Session is how long a login lasts, 12h if empty.
+	Session string `json:",omitempty"`
+	// Secret signs session cookies, a random one (sessions end with a
+	// restart) if empty.
+	Secret string `json:",omitempty"`
+}
+
+type LoginUser struct {
+	Name string
+	// Password is a hash of -mode hashpw, "scrypt:...". Plain and
+	// unsalted "sha256:<hex>" ones still work but are warned about.
+	Password string
+	Role     Role `json:",omitempty"` // viewer if empty
+}
+
+type OIDCConf struct {
+	Issuer       string // e.g. https://accounts.google.com
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string // e.g. https://status.example.com/login/callback
+	// Emails and Domains allow users by e-mail, anyone the issuer
+	// authenticates is allowed if both are empty.
+	Emails  []string `json:",omitempty"`
+	Domains []string `json:",omitempty"`
+	// Roles of users by e-mail, Role (viewer if empty) of others.
+	Roles map[string]Role `json:",omitempty"`
+	Role  Role            `json:",omitempty"`
+}
+
+type oidcEndpoints struct {
+	Authorization string `json:"authorization_endpoint"`
+	Token         string `json:"token_endpoint"`
+	UserInfo      string `json:"userinfo_endpoint"`
+}
+
+type loginHandler struct {
+	conf    *LoginConf
+	keys    []*ApiKey
+	secret  []byte
+	session time.Duration
+	next    http.Handler
+	// Roles of OIDC users by a lower case e-mail.
+	oidcRoles map[string]Role
+
+	m    sync.Mutex
+	oidc *oidcEndpoints // discovered on the first use
+	// verified are MACs of user passwords checked before, so basic auth
+	// doesn't run scrypt on each request.
+	verified map[string]bool
+}
+
+// RequireLogin wraps h so pages need a login if c is set.
+func RequireLogin(c *LoginConf, keys []*ApiKey, h http.Handler) http.Handler {
+	if c == nil {
+		return h
+	}
+	l := &loginHandler{conf: c, keys: keys, next: h, secret: []byte(c.Secret), session: 12 * time.Hour,
+		verified: make(map[string]bool)}
+	for _, u := range c.Users {
+		if err := checkRole(u.Role); err != nil {
+			log.Fatalf("Login user %s: %s", u.Name, err)
+		}
+		if !strings.HasPrefix(u.Password, "scrypt:") {
+			slog.Warn("Login user password isn't an scrypt hash, see -mode hashpw", "user", u.Name)
+		}
+	}
+	l.oidcRoles = make(map[string]Role)
+	if c.OIDC != nil {
+		for email, r := range c.OIDC.Roles {
+			if err := checkRole(r); err != nil {
+				log.Fatalf("OIDC user %s: %s", email, err)
+			}
+			l.oidcRoles[strings.ToLower(email)] = r
+		}
+		if err := checkRole(c.OIDC.Role); err != nil {
+			log.Fatalf("OIDC: %s", err)
+		}
+	}
+	if d := parseThreshold(c.Session); d > 0 {
+		l.session = d
+	}
+	if len(l.secret) == 0 {
+		l.secret = make([]byte, 32)
+		if _, err := rand.Read(l.secret); err != nil {
+			log.Fatal(err)
+		}
+	}
+	return l
+}
+
+func (l *loginHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	switch req.URL.Path {
+	case "/login":
+		l.login(rw, req)
+		return
+	case "/login/callback":
+		l.callback(rw, req)
+		return
+	case "/healthz", "/readyz":
+		// Probes don't log in.
+		l.next.ServeHTTP(rw, req)
+		return
+	case "/logout":
+		http.SetCookie(rw, &http.Cookie{Name: sessionCookie, Path: "/", MaxAge: -1})
+		http.Redirect(rw, req, "/login", http.StatusSeeOther)
+		return
+	}
+	if isHeartbeat(req) {
+		l.next.ServeHTTP(rw, req)
+		return
+	}
+	if c, ok := l.allowed(req); ok {
+		if c != nil {
+			req = withCaller(req, c)
+		}
+		l.next.ServeHTTP(rw, req)
+		return
+	}
+	if strings.HasPrefix(req.URL.Path, "/api/") || req.Header.Get("Accept") == "text/event-stream" || isGRPC(req) {
+		rw.Header().Set("WWW-Authenticate", `Basic realm="statusmonitor"`)
+		http.Error(rw, "login required", http.StatusUnauthorized)
+		return
+	}
+	http.Redirect(rw, req, "/login?next="+url.QueryEscape(req.URL.RequestURI()), http.StatusSeeOther)
+}
+
+// allowed returns a logged in user of a request, if any, and whether it may
+// go on.
+func (l *loginHandler) allowed(req *http.Request) (*Caller, bool) {
+	if user, pass, ok := req.BasicAuth(); ok && l.checkPassword(user, pass) {
+		return l.caller(identityLocal + ":" + user), true
+	}
+	if c, err := req.Cookie(sessionCookie); err == nil {
+		if caller := l.caller(l.sessionUser(c.Value)); caller != nil {
+			return caller, true
+		}
+	}
+	// RPC and API key holders are handled by RequireKey.
+	if req.Method == "CONNECT" || findKey(l.keys, requestKey(req)) != nil {
+		return nil, true
+	}
+	for _, p := range l.conf.Public {
+		if strings.HasPrefix(req.URL.Path, p) {
+			return nil, true
+		}
+	}
+	return nil, false
+}
+
+// caller returns a logged in user of an identity with a role of its
+// source, a viewer if not given one, nil if the source doesn't know it.
+func (l *loginHandler) caller(identity string) *Caller {
+	source, user, _ := strings.Cut(identity, ":")
+	c := &Caller{user, RoleViewer}
+	switch source {
+	case identityLocal:
+		for _, u := range l.conf.Users {
+			if u.Name == user {
+				if len(u.Role) > 0 {
+					c.Role = u.Role
+				}
+				return c
+			}
+		}
+	case identityOIDC:
+		if o := l.conf.OIDC; o != nil {
+			if r, ok := l.oidcRoles[strings.ToLower(user)]; ok {
+				c.Role = r
+			} else if len(o.Role) > 0 {
+				c.Role = o.Role
+			}
+			return c
+		}
+	}
+	return nil
+}
+
+// dummyPassword is checked for unknown users, so they take as long as
+// known ones.
+const dummyPassword = "scrypt:32768:8:1:lwwcIUr2EMAJF1ARHGhUhA:MCCrM3qutw1SHcLyXhZB5vRsLrbco4tHbnJARWvLJb8"
+
+func (l *loginHandler) checkPassword(name, pass string) bool {
+	mac := l.sign(name + "|" + pass)
+	l.m.Lock()
+	ok := l.verified[mac]
+	l.m.Unlock()
+	if ok {
+		return true
+	}
+	var user *LoginUser
+	for _, u := range l.conf.Users {
+		if u.Name == name {
+			user = u
+		}
+	}
+	switch {
+	case user == nil:
+		checkPasswordHash(dummyPassword, pass)
+	case strings.HasPrefix(user.Password, "scrypt:"):
+		ok = checkPasswordHash(user.Password, pass)
+	case strings.HasPrefix(user.Password, "sha256:"):
+		want, _ := hex.DecodeString(strings.TrimPrefix(user.Password, "sha256:"))
+		got := sha256.Sum256([]byte(pass))
+		ok = subtle.ConstantTimeCompare(want, got[:]) == 1
+	default:
+		want, got := sha256.Sum256([]byte(user.Password)), sha256.Sum256([]byte(pass))
+		ok = subtle.ConstantTimeCompare(want[:], got[:]) == 1
+	}
+	if ok {
+		l.m.Lock()
+		l.verified[mac] = true
+		l.m.Unlock()
+	}
+	return ok
+}
+
+// A session is "<source>:<user>|<unix expiry>|<hmac>", base64 encoded.
+func (l *loginHandler) sign(payload string) string {
+	mac := hmac.New(sha256.New, l.secret)
+	io.WriteString(mac, payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (l *loginHandler) startSession(rw http.ResponseWriter, req *http.Request, user string) {
+	payload := fmt.Sprintf("%s|%d", user, time.Now().Add(l.session).Unix())
+	http.SetCookie(rw, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    base64.RawURLEncoding.EncodeToString([]byte(payload + "|" + l.sign(payload))),
+		Path:     "/",
+		MaxAge:   int(l.session.Seconds()),
+		HttpOnly: true,
+		Secure:   req.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	slog.Info("Login", "user", user, "from", req.RemoteAddr)
+}
+
+// sessionUser returns an identity of a valid session or "".
+func (l *loginHandler) sessionUser(v string) string {
+	b, err := base64.RawURLEncoding.DecodeString(v)
+	if err != nil {
+		return ""
+	}
+	s := string(b)
+	i := strings.LastIndex(s, "|")
+	if i < 0 || !hmac.Equal([]byte(s[i+1:]), []byte(l.sign(s[:i]))) {
+		return ""
+	}
+	fields := strings.Split(s[:i], "|")
+	if len(fields) != 2 {
+		return ""
+	}
+	exp, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil || time.Now().Unix() > exp {
+		return ""
+	}
+	return fields[0]
+}
+
+// nextPath returns a local path to go to after a login.
+func nextPath(req *http.Request) string {
+	next := req.FormValue("next")
+	// Browsers take a backslash for a slash, so "/\evil.com" is "//evil.com".
+	u, err := url.Parse(next)
+	if err != nil || len(u.Scheme) > 0 || len(u.Host) > 0 || strings.Contains(next, "\\") ||
+		!strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") {
+		return "/status"
+	}
+	return next
+}
+
+const loginTmplStr = `
+<html><head><title>Logowanie</title></head>
+<body>
+{{if .Error}}<p>{{.Error}}</p>{{end}}
+{{if .Users}}
+<form method="POST" action="/login">
+<input type="hidden" name="next" value="{{.Next}}">
+<p>Użytkownik: <input name="user"></p>
+<p>Hasło: <input name="password" type="password"></p>
+<p><input type="submit" value="Zaloguj"></p>
+</form>
+{{end}}
+{{if .OIDC}}<p><a href="/login?oidc=1&next={{.Next}}">Zaloguj przez {{.OIDC}}</a></p>{{end}}
+</body>
+</html>
+`
+
+var loginTmpl = page("login", loginTmplStr)
+
+func (l *loginHandler) login(rw http.ResponseWriter, req *http.Request) {
+	data := struct {
+		Error string
+		Next  string
+		Users bool
+		OIDC  string
+	}{Next: nextPath(req), Users: len(l.conf.Users) > 0}
+	if l.conf.OIDC != nil {
+		data.OIDC = l.conf.OIDC.Issuer
+		if req.FormValue("oidc") != "" {
+			l.redirectOIDC(rw, req, data.Next)
+			return
+		}
+	}
+	if req.Method == "POST" {
+		if user := req.FormValue("user"); l.checkPassword(user, req.FormValue("password")) {
+			l.startSession(rw, req, identityLocal+":"+user)
+			http.Redirect(rw, req, data.Next, http.StatusSeeOther)
+			return
+		}
+		slog.Warn("Failed login", "user", req.FormValue("user"), "from", req.RemoteAddr)
+		rw.WriteHeader(http.StatusUnauthorized)
+		data.Error = "Nieprawidłowy użytkownik lub hasło"
+	}
+	if err := loginTmpl.Execute(rw, data); err != nil {
+		slog.Error("Template render", "error", err)
+	}
+}
+
+func (l *loginHandler) endpoints() (*oidcEndpoints, error) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	if l.oidc != nil {
+		return l.oidc, nil
+	}
+	e := &oidcEndpoints{}
+	if err := getJSON(strings.TrimRight(l.conf.OIDC.Issuer, "/")+"/.well-known/openid-configuration", "", e); err != nil {
+		return nil, err
+	}
+	if len(e.Authorization) == 0 || len(e.Token) == 0 || len(e.UserInfo) == 0 {
+		return nil, errors.New("incomplete OpenID configuration")
+	}
+	l.oidc = e
+	return e, nil
+}
+
+func (l *loginHandler) redirectOIDC(rw http.ResponseWriter, req *http.Request, next string) {
+	e, err := l.endpoints()
+	if err != nil {
+		slog.Error("OIDC", "error", err)
+		http.Error(rw, "OIDC unavailable", http.StatusBadGateway)
+		return
+	}
+	b := make([]byte, 16)
+	rand.Read(b)
+	state := hex.EncodeToString(b)
+	http.SetCookie(rw, &http.Cookie{Name: oidcStateCookie, Value: state + "|" + next, Path: "/login", MaxAge: 600,
+		HttpOnly: true, Secure: req.TLS != nil, SameSite: http.SameSiteLaxMode})
+	q := url.Values{
+		"response_type": {"code"},
+		"client_id":     {l.conf.OIDC.ClientID},
+		"redirect_uri":  {l.conf.OIDC.RedirectURL},
+		"scope":         {"openid email profile"},
+		"state":         {state},
+	}
+	http.Redirect(rw, req, e.Authorization+"?"+q.Encode(), http.StatusFound)
+}
+
+// callback finishes an OIDC login, a user is identified by the userinfo
+// endpoint, so an ID token needn't be verified.
+func (l *loginHandler) callback(rw http.ResponseWriter, req *http.Request) {
+	c, err := req.Cookie(oidcStateCookie)
+	if l.conf.OIDC == nil || err != nil {
+		http.Error(rw, "no login in progress", http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(rw, &http.Cookie{Name: oidcStateCookie, Path: "/login", MaxAge: -1})
+	state, next := c.Value, "/status"
+	if i := strings.Index(state, "|"); i >= 0 {
+		state, next = state[:i], state[i+1:]
+	}
+	if subtle.ConstantTimeCompare([]byte(state), []byte(req.FormValue("state"))) != 1 {
+		http.Error(rw, "bad state", http.StatusBadRequest)
+		return
+	}
+	user, err := l.exchange(req.FormValue("code"))
+	if err != nil {
+		slog.Warn("Failed OIDC login", "from", req.RemoteAddr, "error", err)
+		http.Error(rw, "login failed", http.StatusForbidden)
+		return
+	}
+	l.startSession(rw, req, identityOIDC+":"+user)
+	req.Form.Set("next", next)
+	http.Redirect(rw, req, nextPath(req), http.StatusSeeOther)
+}
+
+func (l *loginHandler) exchange(code string) (string, error) {
+	e, err := l.endpoints()
+	if err != nil {
+		return "", err
+	}
+	c := l.conf.OIDC
+	resp, err := notifyClient.PostForm(e.Token, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {c.RedirectURL},
+		"client_id":     {c.ClientID},
+		"client_secret": {c.ClientSecret},
+	})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token endpoint: %s", resp.Status)
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&token); err != nil {
+		return "", err
+	}
+	var info struct {
+		Sub           string `json:"sub"`
+		Email         string `json:"email"`
+		EmailVerified *bool  `json:"email_verified"`
+	}
+	if err := getJSON(e.UserInfo, token.AccessToken, &info); err != nil {
+		return "", err
+	}
+	// Only a verified e-mail is a user name, as allow-lists and Roles
+	// trust it, one the issuer doesn't vouch for may be anyone's.
+	verified := info.EmailVerified != nil && *info.EmailVerified && len(info.Email) > 0
+	if len(c.Emails) == 0 && len(c.Domains) == 0 {
+		if verified {
+			return info.Email, nil
+		}
+		return info.Sub, nil
+	}
+	if !verified {
+		return "", fmt.Errorf("%q not verified", info.Email)
+	}
+	for _, m := range c.Emails {
+		if strings.EqualFold(m, info.Email) {
+			return info.Email, nil
+		}
+	}
+	for _, d := range c.Domains {
+		if strings.HasSuffix(strings.ToLower(info.Email), "@"+strings.ToLower(d)) {
+			return info.Email, nil
+		}
+	}
+	return "", fmt.Errorf("%q not allowed", info.Email)
+}
+
+// getJSON decodes a response of a GET with an optional bearer token.
+func getJSON(url, token string, v interface{}) error {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	if len(token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := notifyClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
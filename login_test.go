@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLoginIdentities(t *testing.T) {
+	hash, err := hashPassword("pw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got *Caller
+	h := RequireLogin(&LoginConf{
+		Users: []*LoginUser{
+			{Name: "admin", Password: hash, Role: RoleAdmin},
+			// sha256 of "password"
+			{Name: "old", Password: "sha256:5e884898da28047151d0e56f8dc6292773603d0d6aabbdd62a11ef721d1542d8"},
+		},
+		OIDC: &OIDCConf{Issuer: "https://id.example.com"},
+	}, nil, http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		got = callerOf(req)
+	}))
+	l := h.(*loginHandler)
+
+	for user, pass := range map[string]string{"admin": "pw", "old": "password"} {
+		if !l.checkPassword(user, pass) || !l.checkPassword(user, pass) || l.checkPassword(user, pass+"x") {
+			t.Errorf("passwords of %s", user)
+		}
+	}
+	if l.checkPassword("ghost", "pw") {
+		t.Errorf("an unknown user logged in")
+	}
+
+	tests := []struct {
+		identity string
+		want     Role // "" if no login
+	}{
+		{"local:admin", RoleAdmin},
+		{"oidc:admin", RoleViewer},
+		{"local:ghost", ""},
+		{"admin", ""},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		l.startSession(rec, httptest.NewRequest("POST", "/login", nil), tt.identity)
+		req := httptest.NewRequest("GET", "/status", nil)
+		req.AddCookie(rec.Result().Cookies()[0])
+		got = nil
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		switch {
+		case len(tt.want) == 0 && rec.Code != http.StatusSeeOther:
+			t.Errorf("%s: %d, want a redirect to a login", tt.identity, rec.Code)
+		case len(tt.want) > 0 && (got == nil || got.Role != tt.want || got.Name != "admin"):
+			t.Errorf("%s: %+v, want %s", tt.identity, got, tt.want)
+		}
+	}
+}
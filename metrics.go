@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// Prometheus metrics in the text exposition format.
+///////////////////////////////////////////////////////////////////////////////
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// promLabels formats label pairs k1, v1, k2, v2...
+func promLabels(kv ...string) string {
+	var parts []string
+	for i := 0; i+1 < len(kv); i += 2 {
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, kv[i], labelEscaper.Replace(kv[i+1])))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func promHeader(w *bufio.Writer, name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func RegisterMetricsHandler(sc *StatusChecker) {
+	http.HandleFunc("/metrics", func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w := bufio.NewWriter(rw)
+		defer w.Flush()
+
+		arr := sc.Snapshot()
+		o := sc.Overall()
+		promHeader(w, "statusmonitor_overall_state", "gauge", "Overall state: 0 never checked, 1 up, 2 degraded, 3 down.")
+		fmt.Fprintf(w, "statusmonitor_overall_state %d\n", o.State)
+		promHeader(w, "statusmonitor_overall_score", "gauge", "A healthy share of weights of checked resources.")
+		fmt.Fprintf(w, "statusmonitor_overall_score %g\n", o.Score)
+
+		promHeader(w, "statusmonitor_group_state", "gauge", "A state of the worst checked member of a group.")
+		for _, g := range sc.Groups() {
+			fmt.Fprintf(w, "statusmonitor_group_state%s %d\n", promLabels("group", g.Name), g.State)
+		}
+		promHeader(w, "statusmonitor_group_waiting_probes", "gauge", "Probes waiting for a group rate limit.")
+		for name, p := range sc.pacers {
+			fmt.Fprintf(w, "statusmonitor_group_waiting_probes%s %d\n", promLabels("group", name), p.stats().Waiting)
+		}
+		promHeader(w, "statusmonitor_group_deferred_probes_total", "counter", "Probes deferred by a group rate limit.")
+		for name, p := range sc.pacers {
+			fmt.Fprintf(w, "statusmonitor_group_deferred_probes_total%s %d\n", promLabels("group", name), p.stats().Deferred)
+		}
+		promHeader(w, "statusmonitor_group_skipped_probes_total", "counter", "Probes dropped as already waiting for a group rate limit.")
+		for name, p := range sc.pacers {
+			fmt.Fprintf(w, "statusmonitor_group_skipped_probes_total%s %d\n", promLabels("group", name), p.stats().Skipped)
+		}
+
+		queue := sc.queue.stats()
+		promHeader(w, "statusmonitor_queue_waiting_checks", "gauge", "Checks waiting for a worker.")
+		for _, p := range priorities {
+			fmt.Fprintf(w, "statusmonitor_queue_waiting_checks%s %d\n", promLabels("priority", p), queue[p].Waiting)
+		}
+		promHeader(w, "statusmonitor_queue_deferred_checks_total", "counter", "Checks which waited for a higher priority check.")
+		for _, p := range priorities {
+			fmt.Fprintf(w, "statusmonitor_queue_deferred_checks_total%s %d\n", promLabels("priority", p), queue[p].Deferred)
+		}
+		promHeader(w, "statusmonitor_queue_skipped_checks_total", "counter", "Checks dropped as already waiting, i.e. a missed interval.")
+		for _, p := range priorities {
+			fmt.Fprintf(w, "statusmonitor_queue_skipped_checks_total%s %d\n", promLabels("priority", p), queue[p].Skipped)
+		}
+		promHeader(w, "statusmonitor_queue_shed_checks_total", "counter", "Checks shed from a full queue.")
+		for _, p := range priorities {
+			fmt.Fprintf(w, "statusmonitor_queue_shed_checks_total%s %d\n", promLabels("priority", p), queue[p].Shed)
+		}
+
+		workers := sc.pool.stats()
+		promHeader(w, "statusmonitor_workers", "gauge", "Running workers.")
+		fmt.Fprintf(w, "statusmonitor_workers %d\n", workers.Workers)
+		promHeader(w, "statusmonitor_busy_workers", "gauge", "Workers checking a resource.")
+		fmt.Fprintf(w, "statusmonitor_busy_workers %d\n", workers.Busy)
+
+		timers := sc.timers.stats()
+		promHeader(w, "statusmonitor_overdue_checks", "gauge", "Checks waiting for a worker or running past their next run.")
+		fmt.Fprintf(w, "statusmonitor_overdue_checks %d\n", timers.Overdue)
+		promHeader(w, "statusmonitor_skipped_runs_total", "counter", "Runs skipped as a previous check still ran.")
+		fmt.Fprintf(w, "statusmonitor_skipped_runs_total %d\n", timers.Skipped)
+
+		promHeader(w, "statusmonitor_dropped_events_total", "counter", "Notification events dropped as a queue was full.")
+		fmt.Fprintf(w, "statusmonitor_dropped_events_total %d\n", atomic.LoadInt64(&droppedEvents))
+
+		promHeader(w, "statusmonitor_rate_limited_requests_total", "counter", "Requests rejected by a rate limit.")
+		fmt.Fprintf(w, "statusmonitor_rate_limited_requests_total %d\n", atomic.LoadInt64(&rateLimited))
+
+		promHeader(w, "statusmonitor_resource_info", "gauge", "Tags of a resource as tag_* labels, always 1.")
+		for _, el := range arr {
+			kv := append([]string{"name", el.Name, "address", redactAddress(el.Address)}, tagLabels(el.Conf.Tags)...)
+			fmt.Fprintf(w, "statusmonitor_resource_info%s 1\n", promLabels(kv...))
+		}
+		promHeader(w, "statusmonitor_resource_state", "gauge", "Resource state: 0 never checked, 1 up, 2 degraded, 3 down, 4 invalid.")
+		for _, el := range arr {
+			fmt.Fprintf(w, "statusmonitor_resource_state%s %d\n", promLabels("name", el.Name, "address", redactAddress(el.Address)), el.Status.State())
+		}
+		promHeader(w, "statusmonitor_resource_status_code", "gauge", "A status code of the last check, negative on errors.")
+		for _, el := range arr {
+			if el.Status.State() != StateNeverChecked {
+				fmt.Fprintf(w, "statusmonitor_resource_status_code%s %d\n", promLabels("name", el.Name, "address", redactAddress(el.Address)), el.Status.StatusCode)
+			}
+		}
+		promHeader(w, "statusmonitor_resource_ttfb_seconds", "gauge", "Time to first byte of the last check.")
+		for _, el := range arr {
+			if s := el.Status.State(); s != StateNeverChecked && s != StateInvalid {
+				fmt.Fprintf(w, "statusmonitor_resource_ttfb_seconds%s %g\n", promLabels("name", el.Name, "address", redactAddress(el.Address)), el.Status.TTFB.Seconds())
+			}
+		}
+		promHeader(w, "statusmonitor_resource_latency_seconds", "gauge", "Total time of the last check, including a body read.")
+		for _, el := range arr {
+			if s := el.Status.State(); s != StateNeverChecked && s != StateInvalid {
+				fmt.Fprintf(w, "statusmonitor_resource_latency_seconds%s %g\n", promLabels("name", el.Name, "address", redactAddress(el.Address)), el.Status.Latency.Seconds())
+			}
+		}
+
+		if sc.cluster != nil {
+			promHeader(w, "statusmonitor_cluster_members", "gauge", "Live cluster members, this one included.")
+			fmt.Fprintf(w, "statusmonitor_cluster_members %d\n", len(sc.cluster.members()))
+		}
+
+		regions := sc.Regions()
+		promHeader(w, "statusmonitor_agent_last_report_seconds", "gauge", "Seconds since an agent of a region reported.")
+		for _, a := range regions.Agents {
+			fmt.Fprintf(w, "statusmonitor_agent_last_report_seconds%s %g\n", promLabels("region", a.Region), time.Since(a.LastSeen).Seconds())
+		}
+		promHeader(w, "statusmonitor_region_state", "gauge", "Resource state seen from a region: 0 never checked, 1 up, 2 degraded, 3 down.")
+		for _, el := range regions.Resources {
+			for r, state := range el.States {
+				if r != RegionLocal {
+					fmt.Fprintf(w, "statusmonitor_region_state%s %d\n", promLabels("name", el.Name, "address", el.Address, "region", r), state)
+				}
+			}
+		}
+	})
+}
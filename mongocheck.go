@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// A MongoDB check: every host of a mongodb:// or mongodb+srv:// connection
+// string Address is sent a hello. A replica set (replicaSet in the string
+// or reported by hosts) is DOWN without a primary and DEGRADED while some
+// members don't answer. hello needs no login, so credentials in the string
+// aren't used. Just enough of OP_MSG and BSON is spoken for hello.
+///////////////////////////////////////////////////////////////////////////////
+
+const (
+	mongoTimeout = 10 * time.Second
+	mongoOpMsg   = 2013
+	// mongoMaxReply limits replies, hello ones are small.
+	mongoMaxReply = 1 << 20
+)
+
+func init() {
+	RegisterChecker("mongodb", checkMongo)
+}
+
+func validateMongo(addr string) error {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "mongodb" && u.Scheme != "mongodb+srv" {
+		return fmt.Errorf("%s: scheme must be mongodb or mongodb+srv", addr)
+	}
+	if len(u.Host) == 0 {
+		return fmt.Errorf("%s: no host", addr)
+	}
+	return nil
+}
+
+// mongoHosts returns host:port of members of a connection string, looked
+// up in SRV records for mongodb+srv.
+func mongoHosts(ctx context.Context, u *url.URL, r *net.Resolver) ([]string, error) {
+	if u.Scheme == "mongodb+srv" {
+		_, srvs, err := r.LookupSRV(ctx, "mongodb", "tcp", u.Hostname())
+		if err != nil {
+			return nil, err
+		}
+		var hosts []string
+		for _, srv := range srvs {
+			hosts = append(hosts, net.JoinHostPort(strings.TrimSuffix(srv.Target, "."), fmt.Sprint(srv.Port)))
+		}
+		return hosts, nil
+	}
+	var hosts []string
+	for _, host := range strings.Split(u.Host, ",") {
+		if _, _, err := net.SplitHostPort(host); err != nil {
+			host = net.JoinHostPort(strings.Trim(host, "[]"), "27017")
+		}
+		hosts = append(hosts, host)
+	}
+	return hosts, nil
+}
+
+// bsonDoc encodes a document of string and int32 fields in order.
+func bsonDoc(fields ...interface{}) []byte {
+	b := []byte{0, 0, 0, 0}
+	for i := 0; i+1 < len(fields); i += 2 {
+		key := fields[i].(string)
+		switch v := fields[i+1].(type) {
+		case int:
+			b = append(append(append(b, 0x10), key...), 0)
+			b = binary.LittleEndian.AppendUint32(b, uint32(v))
+		case string:
+			b = append(append(append(b, 0x02), key...), 0)
+			b = binary.LittleEndian.AppendUint32(b, uint32(len(v)+1))
+			b = append(append(b, v...), 0)
+		}
+	}
+	b = append(b, 0)
+	binary.LittleEndian.PutUint32(b, uint32(len(b)))
+	return b
+}
+
+// bsonFields decodes top-level fields of a document: doubles, strings,
+// booleans and integers, others are skipped.
+func bsonFields(b []byte) (map[string]interface{}, error) {
+	malformed := fmt.Errorf("malformed BSON")
+	if len(b) < 5 || int(binary.LittleEndian.Uint32(b)) != len(b) {
+		return nil, malformed
+	}
+	fields := make(map[string]interface{})
+	b = b[4 : len(b)-1]
+	for len(b) > 0 {
+		t := b[0]
+		end := bytes.IndexByte(b[1:], 0)
+		if end < 0 {
+			return nil, malformed
+		}
+		key := string(b[1 : 1+end])
+		b = b[2+end:]
+		var n int
+		switch t {
+		case 0x01: // double
+			if len(b) >= 8 {
+				fields[key] = math.Float64frombits(binary.LittleEndian.Uint64(b))
+			}
+			n = 8
+		case 0x02: // string
+			if len(b) >= 4 {
+				n = 4 + int(binary.LittleEndian.Uint32(b))
+				if n <= len(b) && n > 4 {
+					fields[key] = string(b[4 : n-1])
+				}
+			}
+		case 0x03, 0x04: // document, array
+			if len(b) >= 4 {
+				n = int(binary.LittleEndian.Uint32(b))
+			}
+		case 0x05: // binary
+			if len(b) >= 4 {
+				n = 5 + int(binary.LittleEndian.Uint32(b))
+			}
+		case 0x07: // ObjectId
+			n = 12
+		case 0x08: // bool
+			if len(b) >= 1 {
+				fields[key] = b[0] == 1
+			}
+			n = 1
+		case 0x09, 0x11: // datetime, timestamp
+			n = 8
+		case 0x0A, 0x7F, 0xFF: // null, max and min keys
+		case 0x10: // int32
+			if len(b) >= 4 {
+				fields[key] = float64(int32(binary.LittleEndian.Uint32(b)))
+			}
+			n = 4
+		case 0x12: // int64
+			if len(b) >= 8 {
+				fields[key] = float64(int64(binary.LittleEndian.Uint64(b)))
+			}
+			n = 8
+		case 0x13: // decimal128
+			n = 16
+		default:
+			return nil, fmt.Errorf("unsupported BSON type %#x", t)
+		}
+		if n < 0 || n > len(b) {
+			return nil, malformed
+		}
+		b = b[n:]
+	}
+	return fields, nil
+}
+
+// mongoHello sends a hello with OP_MSG and returns fields of a reply.
+func mongoHello(conn net.Conn) (map[string]interface{}, error) {
+	doc := bsonDoc("hello", 1, "$db", "admin")
+	msg := make([]byte, 16, 21+len(doc))
+	binary.LittleEndian.PutUint32(msg[4:], 1) // a request ID
+	binary.LittleEndian.PutUint32(msg[12:], mongoOpMsg)
+	msg = append(msg, 0, 0, 0, 0, 0) // no flags, a body section
+	msg = append(msg, doc...)
+	binary.LittleEndian.PutUint32(msg, uint32(len(msg)))
+	if _, err := conn.Write(msg); err != nil {
+		return nil, err
+	}
+	var hdr [16]byte
+	if _, err := io.ReadFull(conn, hdr[:]); err != nil {
+		return nil, err
+	}
+	n := int(binary.LittleEndian.Uint32(hdr[:]))
+	if binary.LittleEndian.Uint32(hdr[12:]) != mongoOpMsg || n < 21 || n > mongoMaxReply {
+		return nil, fmt.Errorf("unexpected reply")
+	}
+	body := make([]byte, n-16)
+	if _, err := io.ReadFull(conn, body); err != nil {
+		return nil, err
+	}
+	if body[4] != 0 {
+		return nil, fmt.Errorf("unexpected reply section %d", body[4])
+	}
+	fields, err := bsonFields(body[5:])
+	if err != nil {
+		return nil, err
+	}
+	if ok, _ := fields["ok"].(float64); ok != 1 {
+		msg, _ := fields["errmsg"].(string)
+		return nil, fmt.Errorf("hello failed: %s", msg)
+	}
+	return fields, nil
+}
+
+// mongoRole returns a role of a member by its hello reply.
+func mongoRole(hello map[string]interface{}) string {
+	switch {
+	case hello["isWritablePrimary"] == true || hello["ismaster"] == true:
+		return "primary"
+	case hello["secondary"] == true:
+		return "secondary"
+	case hello["arbiterOnly"] == true:
+		return "arbiter"
+	case hello["msg"] == "isdbgrid":
+		return "mongos"
+	}
+	return "other"
+}
+
+func checkMongo(c *ResConf) *Status {
+	st := &Status{When: time.Now()}
+	u, err := url.Parse(c.Address)
+	if err != nil {
+		st.StatusCode = UnknownError
+		st.Error = c.errorText(err)
+		return st
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+	q := u.Query()
+	useTLS := q.Get("tls") == "true" || q.Get("ssl") == "true" || (u.Scheme == "mongodb+srv" && q.Get("tls") != "false")
+	r := newResolver(c.resolver())
+	hosts, err := mongoHosts(ctx, u, r)
+	if err != nil {
+		st.Latency = time.Since(st.When)
+		st.StatusCode = UnknownError
+		st.Error = "SRV lookup: " + c.errorText(err)
+		return st
+	}
+
+	setName := q.Get("replicaSet")
+	var primary string
+	var failed []string
+	for _, host := range hosts {
+		d := &net.Dialer{Resolver: r}
+		conn, err := d.DialContext(ctx, dialNetwork("tcp", c.Family), host)
+		if err == nil && useTLS {
+			hostname, _, _ := net.SplitHostPort(host)
+			conn = tls.Client(conn, &tls.Config{ServerName: hostname})
+		}
+		var hello map[string]interface{}
+		start := time.Now()
+		if err == nil {
+			deadline, _ := ctx.Deadline()
+			conn.SetDeadline(deadline)
+			hello, err = mongoHello(conn)
+			conn.Close()
+		}
+		if err != nil {
+			failed = append(failed, host)
+			st.Details = append(st.Details, fmt.Sprintf("%s: %s", host, c.errorText(err)))
+			continue
+		}
+		role := mongoRole(hello)
+		if name, _ := hello["setName"].(string); len(name) > 0 {
+			if len(setName) > 0 && name != setName {
+				failed = append(failed, host)
+				st.Details = append(st.Details, fmt.Sprintf("%s: a member of %s instead of %s", host, name, setName))
+				continue
+			}
+			setName = name
+		}
+		if role == "primary" || len(primary) == 0 && role == "mongos" {
+			primary = host
+			st.TTFB = time.Since(start)
+		}
+		if role == "primary" && len(setName) == 0 {
+			role = "standalone"
+		}
+		st.Details = append(st.Details, fmt.Sprintf("%s: %s", host, role))
+	}
+	st.Latency = time.Since(st.When)
+	switch {
+	case len(primary) == 0 && len(failed) == len(hosts):
+		st.StatusCode = UnknownError
+		st.Error = "all hosts failed"
+	case len(primary) == 0:
+		st.StatusCode = UnknownError
+		st.Error = fmt.Sprintf("replica set %s has no primary", setName)
+	default:
+		st.StatusCode = http.StatusOK
+		if len(failed) > 0 {
+			st.Degraded = fmt.Sprintf("%d of %d hosts failed", len(failed), len(hosts))
+		}
+		st.Slow = c.slow(st)
+	}
+	return st
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// An MQTT check: an MQTT 3.1.1 CONNECT is sent to an mqtt://[user:password@]
+// host[:port] or mqtts:// (TLS) Address and should be accepted. With Topic
+// a test message is published there with QoS 0 and should come back over a
+// subscription to it, a round trip reported as TTFB.
+///////////////////////////////////////////////////////////////////////////////
+
+const mqttTimeout = 10 * time.Second
+
+// Packet types of MQTT 3.1.1, shifted into a first byte.
+const (
+	mqttConnect    = 0x10
+	mqttConnack    = 0x20
+	mqttPublish    = 0x30
+	mqttSubscribe  = 0x82 // with reserved flags of SUBSCRIBE
+	mqttSuback     = 0x90
+	mqttDisconnect = 0xe0
+)
+
+// mqttRefusals are reasons of CONNACK return codes.
+var mqttRefusals = []string{
+	1: "unacceptable protocol version",
+	2: "identifier rejected",
+	3: "server unavailable",
+	4: "bad user name or password",
+	5: "not authorized",
+}
+
+func init() {
+	RegisterChecker("mqtt", checkMQTT)
+}
+
+func validateMQTT(addr string) error {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "mqtt" && u.Scheme != "mqtts" {
+		return fmt.Errorf("%s: scheme must be mqtt or mqtts", addr)
+	}
+	if len(u.Hostname()) == 0 {
+		return fmt.Errorf("%s: no host", addr)
+	}
+	return nil
+}
+
+// mqttString appends a length-prefixed string.
+func mqttString(b []byte, s string) []byte {
+	return append(binary.BigEndian.AppendUint16(b, uint16(len(s))), s...)
+}
+
+// mqttWrite writes a packet with a remaining length.
+func mqttWrite(w io.Writer, first byte, body []byte) error {
+	b := []byte{first}
+	n := len(body)
+	for {
+		d := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			d |= 0x80
+		}
+		b = append(b, d)
+		if n == 0 {
+			break
+		}
+	}
+	_, err := w.Write(append(b, body...))
+	return err
+}
+
+// mqttRead reads a packet, returning its first byte and body.
+func mqttRead(r *bufio.Reader) (byte, []byte, error) {
+	first, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	n, mult := 0, 1
+	for i := 0; ; i++ {
+		d, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		if i == 4 {
+			return 0, nil, fmt.Errorf("malformed packet length")
+		}
+		n += int(d&0x7f) * mult
+		mult *= 128
+		if d&0x80 == 0 {
+			break
+		}
+	}
+	if n > 1<<16 {
+		return 0, nil, fmt.Errorf("a packet of %d bytes is too big", n)
+	}
+	body := make([]byte, n)
+	_, err = io.ReadFull(r, body)
+	return first, body, err
+}
+
+// mqttAwait reads packets until one of a type, skipping others.
+func mqttAwait(r *bufio.Reader, typ byte) ([]byte, error) {
+	for {
+		first, body, err := mqttRead(r)
+		if err != nil {
+			return nil, err
+		}
+		if first&0xf0 == typ&0xf0 {
+			return body, nil
+		}
+	}
+}
+
+func checkMQTT(c *ResConf) *Status {
+	st := &Status{When: time.Now()}
+	fail := func(step string, err error) *Status {
+		st.Latency = time.Since(st.When)
+		st.StatusCode = UnknownError
+		st.Error = step + ": " + c.errorText(err)
+		return st
+	}
+	u, err := url.Parse(c.Address)
+	if err != nil {
+		return fail("connect", err)
+	}
+	d := &net.Dialer{Timeout: mqttTimeout, Resolver: newResolver(c.resolver())}
+	var conn net.Conn
+	if u.Scheme == "mqtts" {
+		conn, err = tls.DialWithDialer(d, dialNetwork("tcp", c.Family), urlHost(u, "8883"), &tls.Config{ServerName: u.Hostname()})
+	} else {
+		conn, err = d.Dial(dialNetwork("tcp", c.Family), urlHost(u, "1883"))
+	}
+	if err != nil {
+		return fail("connect", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(mqttTimeout))
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		st.RemoteIP = addr.IP.String()
+	}
+	if tc, ok := conn.(*tls.Conn); ok {
+		cs := tc.ConnectionState()
+		st.TLSVersion = tls.VersionName(cs.Version)
+		st.Cert = certReport(c, &cs)
+	}
+
+	var nonce [8]byte
+	rand.Read(nonce[:])
+	id := "statusmonitor-" + hex.EncodeToString(nonce[:])
+	// A clean session kept alive for 60s.
+	flags := byte(0x02)
+	body := mqttString(nil, "MQTT")
+	if u.User != nil {
+		flags |= 0x80
+		if _, ok := u.User.Password(); ok {
+			flags |= 0x40
+		}
+	}
+	body = append(body, 4, flags, 0, 60)
+	body = mqttString(body, id)
+	if u.User != nil {
+		body = mqttString(body, u.User.Username())
+		if pass, ok := u.User.Password(); ok {
+			body = mqttString(body, pass)
+		}
+	}
+	if err := mqttWrite(conn, mqttConnect, body); err != nil {
+		return fail("CONNECT", err)
+	}
+	r := bufio.NewReader(conn)
+	ack, err := mqttAwait(r, mqttConnack)
+	if err == nil && len(ack) < 2 {
+		err = fmt.Errorf("malformed CONNACK")
+	}
+	if err != nil {
+		return fail("CONNECT", err)
+	}
+	if code := int(ack[1]); code != 0 {
+		reason := fmt.Sprintf("refused with code %d", code)
+		if code < len(mqttRefusals) {
+			reason = "refused: " + mqttRefusals[code]
+		}
+		return fail("CONNECT", fmt.Errorf("%s", reason))
+	}
+	st.Details = append(st.Details, fmt.Sprintf("connected in %s", time.Since(st.When).Round(time.Millisecond)))
+
+	if len(c.Topic) > 0 {
+		sub := binary.BigEndian.AppendUint16(nil, 1) // a packet ID
+		sub = append(mqttString(sub, c.Topic), 0)
+		if err := mqttWrite(conn, mqttSubscribe, sub); err != nil {
+			return fail("SUBSCRIBE", err)
+		}
+		suback, err := mqttAwait(r, mqttSuback)
+		if err == nil && (len(suback) < 3 || suback[2] == 0x80) {
+			err = fmt.Errorf("refused")
+		}
+		if err != nil {
+			return fail("SUBSCRIBE", err)
+		}
+		start := time.Now()
+		if err := mqttWrite(conn, mqttPublish, append(mqttString(nil, c.Topic), id...)); err != nil {
+			return fail("PUBLISH", err)
+		}
+		// Retained and others' messages are skipped.
+		for {
+			msg, err := mqttAwait(r, mqttPublish)
+			if err != nil {
+				return fail("round trip", err)
+			}
+			if bytes.HasSuffix(msg, []byte(id)) {
+				break
+			}
+		}
+		st.TTFB = time.Since(start)
+	}
+	mqttWrite(conn, mqttDisconnect, nil)
+	st.Latency = time.Since(st.When)
+	st.Slow = c.slow(st)
+	st.StatusCode = http.StatusOK
+	return st
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// TLS of the server and mutual TLS of admin commands: with -client-ca RPC
+// and mutating requests need a client certificate issued by the CA, so only
+// operator machines can add or remove resources. Pages stay open.
+///////////////////////////////////////////////////////////////////////////////
+
+var (
+	tlsCert  = flag.String("tls-cert", "", "A server certificate (PEM), served with TLS if set.")
+	tlsKey   = flag.String("tls-key", "", "A key of -tls-cert.")
+	clientCA = flag.String("client-ca", "", "A CA (PEM) of client certificates required by admin commands, needs -tls-cert.")
+
+	adminCert = flag.String("cert", "", "A client certificate (PEM) of commands sent to server.")
+	adminKey  = flag.String("cert-key", "", "A key of -cert.")
+	serverCA  = flag.String("server-ca", "", "A CA (PEM) verifying a server of commands, system roots if empty.")
+)
+
+func loadCertPool(path string) (*x509.CertPool, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("%s: no certificates", path)
+	}
+	return pool, nil
+}
+
+// serverTLSConfig returns a config of a server, nil without -tls-cert or
+// -acme-domains. It's made once and shared by listeners, so there's one
+// ACME manager ordering certificates.
+func serverTLSConfig() (*tls.Config, error) {
+	c := &tls.Config{MinVersion: tls.VersionTLS12}
+	switch {
+	case len(*tlsCert) > 0 && len(*acmeDomains) > 0:
+		return nil, errors.New("-tls-cert and -acme-domains are exclusive")
+	case len(*tlsCert) > 0:
+		cert, err := tls.LoadX509KeyPair(*tlsCert, *tlsKey)
+		if err != nil {
+			return nil, err
+		}
+		c.Certificates = []tls.Certificate{cert}
+	case len(*acmeDomains) > 0:
+		c.GetCertificate = newACMEManager(strings.Split(*acmeDomains, ",")).GetCertificate
+		c.NextProtos = []string{"h2", "http/1.1", acmeALPN}
+	case len(*clientCA) > 0:
+		return nil, errors.New("-client-ca needs -tls-cert or -acme-domains")
+	default:
+		return nil, nil
+	}
+	var err error
+	if len(*clientCA) > 0 {
+		if c.ClientCAs, err = loadCertPool(*clientCA); err != nil {
+			return nil, err
+		}
+		// Verified if given, RequireClientCert decides which requests
+		// need one.
+		c.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return c, nil
+}
+
+// RequireClientCert wraps h so RPC and mutating requests need a client
+// certificate verified against -client-ca, if set.
+func RequireClientCert(h http.Handler) http.Handler {
+	if len(*clientCA) == 0 {
+		return h
+	}
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if mutating(req.Method) {
+			if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 {
+				slog.Warn("No client certificate", "method", req.Method, "path", req.URL.Path, "from", req.RemoteAddr)
+				http.Error(rw, "client certificate required", http.StatusForbidden)
+				return
+			}
+			slog.Info("Request", "method", req.Method, "path", req.URL.Path, "certificate", req.TLS.VerifiedChains[0][0].Subject)
+		}
+		h.ServeHTTP(rw, req)
+	})
+}
+
+// serve serves h at addr, with TLS if c isn't nil, until ctx is done, then
+// it waits up to -shutdown-timeout for requests in progress. Contexts of
+// requests are done with ctx, so streams like /api/events end.
+func serve(ctx context.Context, addr string, h http.Handler, c *tls.Config) error {
+	var err error
+	srv := &http.Server{
+		Addr:        addr,
+		Handler:     RequireClientCert(h),
+		TLSConfig:   c,
+		BaseContext: func(net.Listener) context.Context { return ctx },
+		// gRPC needs HTTP/2, h2c without TLS.
+		Protocols: new(http.Protocols),
+	}
+	srv.Protocols.SetHTTP1(true)
+	srv.Protocols.SetHTTP2(true)
+	srv.Protocols.SetUnencryptedHTTP2(true)
+	stopped := make(chan bool)
+	go func() {
+		<-ctx.Done()
+		sctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(sctx); err != nil {
+			slog.Warn("Requests in progress abandoned", "address", addr, "error", err)
+		}
+		close(stopped)
+	}()
+	if c == nil {
+		err = srv.ListenAndServe()
+	} else {
+		err = srv.ListenAndServeTLS("", "")
+	}
+	if err != http.ErrServerClosed {
+		return err
+	}
+	<-stopped
+	return nil
+}
+
+// dialServer connects to commandAddr, with TLS if -cert or -server-ca is
+// set.
+func dialServer() (net.Conn, error) {
+	c, err := clientTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	if c == nil {
+		return net.Dial("tcp", commandAddr())
+	}
+	return tls.Dial("tcp", commandAddr(), c)
+}
+
+// clientTLSConfig returns a config of commands, nil unless -cert or
+// -server-ca is set.
+func clientTLSConfig() (*tls.Config, error) {
+	if len(*adminCert) == 0 && len(*serverCA) == 0 {
+		return nil, nil
+	}
+	c := &tls.Config{MinVersion: tls.VersionTLS12}
+	if len(*adminCert) > 0 {
+		cert, err := tls.LoadX509KeyPair(*adminCert, *adminKey)
+		if err != nil {
+			return nil, err
+		}
+		c.Certificates = []tls.Certificate{cert}
+	}
+	if len(*serverCA) > 0 {
+		var err error
+		if c.RootCAs, err = loadCertPool(*serverCA); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
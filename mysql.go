@@ -0,0 +1,12 @@
+//go:build mysql
+
+package main
+
+import (
+	_ "github.com/go-sql-driver/mysql"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// MySQL checks, built with -tags mysql and go-sql-driver/mysql in GOPATH,
+// see sqlcheck.go.
+///////////////////////////////////////////////////////////////////////////////
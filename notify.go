@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// A notifications part.
+///////////////////////////////////////////////////////////////////////////////
+
+const (
+	NotifyAll        = "all"
+	NotifyRecoveries = "recoveries" // only when back UP, outages are in digests
+)
+
+// Event describes a change of a resource state or, if Reminder is set, that
+// a problem is still ongoing.
+type Event struct {
+	Conf     *ResConf
+	Old      *Status
+	New      *Status
+	Reminder bool
+	Since    time.Time // when a problem started, zero if unknown
+}
+
+// alertState tracks notifications about an ongoing problem of a resource.
+type alertState struct {
+	Since    time.Time
+	Notified time.Time
+	AckedBy  string
+	AckedAt  time.Time
+}
+
+type Notifier interface {
+	Notify(ev *Event) error
+	// Preview describes what Notify would send for ev without sending it.
+	Preview(ev *Event) []*Delivery
+}
+
+// Delivery is a single message a notifier sends.
+type Delivery struct {
+	Notifier  string
+	Recipient string
+	Message   string
+}
+
+// isTransition reports whether a change from old to cur is worth a
+// notification: a resource went DOWN, became slow in a different way or came
+// back UP after a problem.
+func isTransition(old, cur *Status) bool {
+	switch cur.State() {
+	case StateDown:
+		return old.State() != StateDown
+	case StateDegraded:
+		return old.State() != StateDegraded || old.Slow != cur.Slow || old.Degraded != cur.Degraded
+	case StateUp:
+		s := old.State()
+		return s == StateDown || s == StateDegraded
+	}
+	return false
+}
+
+// event returns an event worth notifying about a new result cur of
+// a resource, nil if there's none. Repeated results of an ongoing problem
+// are suppressed, unless a renotify interval passed since the last
+// notification.
+func (s *StatusChecker) event(conf *ResConf, old, cur *Status) *Event {
+	s.statusMutex.Lock()
+	defer s.statusMutex.Unlock()
+	a := s.alerts[conf.Address]
+	if isTransition(old, cur) {
+		ev := &Event{Conf: conf, Old: old, New: cur}
+		if cur.State() == StateUp {
+			if a != nil {
+				ev.Since = a.Since
+			}
+			delete(s.alerts, conf.Address)
+			return ev
+		}
+		if a == nil {
+			a = &alertState{Since: cur.When}
+			s.alerts[conf.Address] = a
+		}
+		a.Notified = cur.When
+		ev.Since = a.Since
+		return ev
+	}
+	renotify := s.renotify
+	if d := parseThreshold(conf.Renotify); d > 0 {
+		renotify = d
+	}
+	if a == nil || len(a.AckedBy) > 0 || renotify <= 0 || cur.State() == StateUp || cur.When.Sub(a.Notified) < renotify {
+		return nil
+	}
+	a.Notified = cur.When
+	return &Event{Conf: conf, Old: old, New: cur, Reminder: true, Since: a.Since}
+}
+
+// notifies reports whether ev is sent to notifiers under a notify policy
+// of c.
+func (c *ResConf) notifies(ev *Event) bool {
+	if c.NotifyPolicy == NotifyRecoveries {
+		return !ev.Reminder && ev.New.State() == StateUp
+	}
+	return true
+}
+
+// Channels builds all notifiers configured in c.
+func (c *Config) Channels() []*Channel {
+	var ch []*Channel
+	rotations := c.Rotations()
+	if c.Opsgenie != nil {
+		ch = append(ch, &Channel{channelName(c.Opsgenie.Name, "opsgenie"), false, NewOpsgenie(c.Opsgenie)})
+	}
+	for _, w := range c.Webhooks {
+		ch = append(ch, &Channel{channelName(w.Name, "webhook"), false, NewWebhook(w)})
+	}
+	if c.Twilio != nil {
+		ch = append(ch, &Channel{channelName(c.Twilio.Name, "twilio"), false, NewTwilio(c.Twilio, rotations)})
+	}
+	for _, p := range c.Push {
+		ch = append(ch, &Channel{p.Name, true, NewPush(p, rotations)})
+	}
+	return ch
+}
+
+func channelName(name, def string) string {
+	if len(name) > 0 {
+		return name
+	}
+	return def
+}
+
+// message is a short, human readable description of ev.
+func (ev *Event) message() string {
+	if ev.Reminder {
+		return fmt.Sprintf("%s is still %s, for %s", ev.Conf.Name, ev.New.State(), roundDuration(ev.New.When.Sub(ev.Since)))
+	}
+	switch ev.New.State() {
+	case StateDown:
+		if len(ev.New.Error) > 0 {
+			return fmt.Sprintf("%s is DOWN: %s", ev.Conf.Name, ev.New.Error)
+		}
+		return fmt.Sprintf("%s is DOWN (%d)", ev.Conf.Name, ev.New.StatusCode)
+	case StateDegraded:
+		if len(ev.New.Slow) == 0 {
+			return fmt.Sprintf("%s is DEGRADED: %s", ev.Conf.Name, ev.New.Degraded)
+		}
+		return fmt.Sprintf("%s is slow (%s): TTFB %s, total %s", ev.Conf.Name, ev.New.Slow, ev.New.TTFB, ev.New.Latency)
+	case StateUp:
+		if !ev.Since.IsZero() {
+			return fmt.Sprintf("%s is UP after %s", ev.Conf.Name, roundDuration(ev.New.When.Sub(ev.Since)))
+		}
+	}
+	return fmt.Sprintf("%s is %s", ev.Conf.Name, ev.New.State())
+}
+
+// notifyQueue is how many events wait for dispatch or for a channel, more
+// are dropped rather than hold up results.
+const notifyQueue = 100
+
+var droppedEvents int64 // events dropped as a queue was full, for metrics
+
+// queueEvent hands ev to dispatch without waiting, it's dropped if
+// notifications are behind.
+func (s *StatusChecker) queueEvent(ev *Event) {
+	select {
+	case s.events <- ev:
+	default:
+		atomic.AddInt64(&droppedEvents, 1)
+		slog.Error("Event dropped, notifications are behind", "resource", ev.Conf.Name, "state", ev.New.State())
+	}
+}
+
+// dispatch routes events to channels, each one with its own queue and
+// goroutine: a dead notifier, retrying with a backoff, holds up only itself
+// and still sees events of a resource in order.
+func (s *StatusChecker) dispatch() {
+	queues := make(map[*Channel]chan *Event)
+	for ev := range s.events {
+		slog.Info("Event", "resource", ev.Conf.Name, "address", ev.Conf.Address, "state", ev.New.State(), "message", ev.message())
+		for _, ch := range s.router.Route(ev.Conf) {
+			q, ok := queues[ch]
+			if !ok {
+				q = make(chan *Event, notifyQueue)
+				queues[ch] = q
+				go notifyChannel(ch, q)
+			}
+			select {
+			case q <- ev:
+			default:
+				atomic.AddInt64(&droppedEvents, 1)
+				slog.Error("Event dropped, channel is behind", "resource", ev.Conf.Name, "channel", ch.Name)
+			}
+		}
+	}
+}
+
+func notifyChannel(ch *Channel, q chan *Event) {
+	for ev := range q {
+		if err := ch.Notify(ev); err != nil {
+			slog.Error("Notify", "resource", ev.Conf.Name, "channel", ch.Name, "error", err)
+		}
+	}
+}
+
+var notifyClient = &http.Client{Timeout: 10 * time.Second}
+
+// postJSON sends v as a JSON body, any non 2xx response is an error.
+func postJSON(url string, v interface{}, header http.Header) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return postBody(url, b, header)
+}
+
+func postBody(url string, b []byte, header http.Header) error {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	for k, vs := range header {
+		req.Header[k] = vs
+	}
+	if req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := notifyClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s %s", url, resp.Status, body)
+	}
+	return nil
+}
+
+func roundDuration(d time.Duration) time.Duration {
+	if d > time.Minute {
+		return d.Round(time.Minute)
+	}
+	return d.Round(time.Second)
+}
+
+func basicAuth(user, password string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password))
+}
+
+// hasTag reports whether a resource has a tag, tag is either "key" or
+// "key=value".
+func (c *ResConf) hasTag(tag string) bool {
+	for k, v := range c.Tags {
+		if tag == k || tag == k+"="+v {
+			return true
+		}
+	}
+	return false
+}
+
+// retry calls f up to 1+retries times with an exponential backoff starting
+// at a second, the last error is returned.
+func retry(retries int, f func() error) error {
+	err := f()
+	backoff := time.Second
+	for i := 0; i < retries && err != nil; i++ {
+		slog.Warn("Retrying", "backoff", backoff, "error", err)
+		time.Sleep(backoff)
+		backoff *= 2
+		err = f()
+	}
+	return err
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testNotifier passes events to a channel, blocking while nobody reads.
+type testNotifier chan *Event
+
+func (n testNotifier) Notify(ev *Event) error {
+	n <- ev
+	return nil
+}
+
+func (n testNotifier) Preview(ev *Event) []*Delivery {
+	return nil
+}
+
+func TestDispatchDeadChannel(t *testing.T) {
+	sc := NewStatusChecker(&Config{})
+	dead, live := make(testNotifier), make(testNotifier, 2*notifyQueue)
+	sc.router = &Router{channels: []*Channel{{"dead", false, dead}, {"live", false, live}}}
+	go sc.dispatch()
+
+	dropped := atomic.LoadInt64(&droppedEvents)
+	conf := &ResConf{Name: "web", Address: "http://example.com/"}
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 3*notifyQueue; i++ {
+			sc.queueEvent(&Event{Conf: conf, Old: &Status{}, New: &Status{When: time.Now(), StatusCode: 500}})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("a dead channel blocked results")
+	}
+	select {
+	case <-live:
+	case <-time.After(5 * time.Second):
+		t.Fatal("a dead channel held up another one")
+	}
+	if atomic.LoadInt64(&droppedEvents) == dropped {
+		t.Errorf("no events dropped")
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// An NTP check: an SNTP query is sent to an ntp://host[:port] Address, its
+// offset, round trip and stratum are reported. A resource is DEGRADED when
+// the offset is over MaxOffset (100ms if empty) and DOWN when the server
+// is unsynchronized or sends a kiss-o'-death.
+///////////////////////////////////////////////////////////////////////////////
+
+const (
+	ntpTimeout       = 5 * time.Second
+	defaultMaxOffset = 100 * time.Millisecond
+	// ntpEpochOffset is seconds from 1900, the NTP epoch, to 1970.
+	ntpEpochOffset = 2208988800
+)
+
+func init() {
+	RegisterChecker("ntp", checkNTP)
+}
+
+func validateNTP(c *ResConf) error {
+	u, err := url.Parse(c.Address)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "ntp" {
+		return fmt.Errorf("%s: scheme must be ntp", c.Address)
+	}
+	if len(u.Hostname()) == 0 {
+		return fmt.Errorf("%s: no host", c.Address)
+	}
+	if len(c.MaxOffset) > 0 {
+		if d, err := time.ParseDuration(c.MaxOffset); err != nil || d <= 0 {
+			return fmt.Errorf("bad max offset %q", c.MaxOffset)
+		}
+	}
+	return nil
+}
+
+// ntpTime decodes a 64-bit NTP timestamp.
+func ntpTime(b []byte) time.Time {
+	sec := int64(binary.BigEndian.Uint32(b)) - ntpEpochOffset
+	frac := int64(binary.BigEndian.Uint32(b[4:]))
+	return time.Unix(sec, frac*1e9>>32)
+}
+
+// appendNTPTime encodes a 64-bit NTP timestamp.
+func appendNTPTime(b []byte, t time.Time) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(t.Unix()+ntpEpochOffset))
+	return binary.BigEndian.AppendUint32(b, uint32(int64(t.Nanosecond())<<32/1e9))
+}
+
+func checkNTP(c *ResConf) *Status {
+	st := &Status{When: time.Now()}
+	fail := func(err error) *Status {
+		st.Latency = time.Since(st.When)
+		st.StatusCode = UnknownError
+		st.Error = c.errorText(err)
+		return st
+	}
+	u, err := url.Parse(c.Address)
+	if err != nil {
+		return fail(err)
+	}
+	d := &net.Dialer{Timeout: ntpTimeout, Resolver: newResolver(c.resolver())}
+	conn, err := d.Dial(dialNetwork("udp", c.Family), urlHost(u, "123"))
+	if err != nil {
+		return fail(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(ntpTimeout))
+	if addr, ok := conn.RemoteAddr().(*net.UDPAddr); ok {
+		st.RemoteIP = addr.IP.String()
+	}
+
+	// Version 4, client mode, a transmit time to be echoed as an origin.
+	t1 := time.Now()
+	req := make([]byte, 40, 48)
+	req[0] = 0x23
+	req = appendNTPTime(req, t1)
+	if _, err := conn.Write(req); err != nil {
+		return fail(err)
+	}
+	resp := make([]byte, 48)
+	for {
+		n, err := conn.Read(resp)
+		if err != nil {
+			return fail(err)
+		}
+		// Stray and spoofed replies don't echo the transmit time.
+		if n >= 48 && string(resp[24:32]) == string(req[40:48]) {
+			break
+		}
+	}
+	t4 := time.Now()
+	t2, t3 := ntpTime(resp[32:40]), ntpTime(resp[40:48])
+	offset := (t2.Sub(t1) + t3.Sub(t4)) / 2
+	delay := t4.Sub(t1) - t3.Sub(t2)
+	stratum := resp[1]
+	st.Latency = time.Since(st.When)
+	st.TTFB = delay
+	if stratum == 0 {
+		return fail(fmt.Errorf("kiss-o'-death %q", resp[12:16]))
+	}
+	if resp[0]>>6 == 3 {
+		return fail(fmt.Errorf("the server is unsynchronized"))
+	}
+	st.Details = append(st.Details, fmt.Sprintf("offset %s, stratum %d, round trip %s", offset.Round(time.Microsecond), stratum, delay.Round(time.Microsecond)))
+	st.StatusCode = http.StatusOK
+	maxOffset := defaultMaxOffset
+	if len(c.MaxOffset) > 0 {
+		maxOffset = parseThreshold(c.MaxOffset)
+	}
+	if offset > maxOffset || -offset > maxOffset {
+		st.Degraded = fmt.Sprintf("offset %s over %s", offset.Round(time.Millisecond), maxOffset)
+	}
+	return st
+}
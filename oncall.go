@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// On-call rotations: members take turns (weekly by default) and notifiers
+// addressing "oncall:<rotation>" reach whoever is on call.
+///////////////////////////////////////////////////////////////////////////////
+
+const onCallPrefix = "oncall:"
+
+type Contact struct {
+	Name         string
+	Phone        string `json:",omitempty"` // for Twilio
+	PushoverUser string `json:",omitempty"`
+}
+
+type RotationConf struct {
+	Name    string
+	Start   time.Time // a handoff to the first member
+	Every   string    `json:",omitempty"` // 168h if empty
+	Members []*Contact
+}
+
+type OnCall struct {
+	Rotation string
+	Current  *Contact
+	Next     *Contact
+	Handoff  time.Time // when Next takes over
+}
+
+func (r *RotationConf) every() time.Duration {
+	if d := parseThreshold(r.Every); d > 0 {
+		return d
+	}
+	return 7 * 24 * time.Hour
+}
+
+// At returns who is on call at t.
+func (r *RotationConf) At(t time.Time) *OnCall {
+	if len(r.Members) == 0 {
+		return &OnCall{Rotation: r.Name}
+	}
+	every := r.every()
+	n := int64(t.Sub(r.Start) / every)
+	if t.Before(r.Start) {
+		n--
+	}
+	handoff := r.Start.Add(time.Duration(n+1) * every)
+	l := int64(len(r.Members))
+	i := (n%l + l) % l
+	return &OnCall{r.Name, r.Members[i], r.Members[(i+1)%l], handoff}
+}
+
+type Rotations map[string]*RotationConf
+
+func (c *Config) Rotations() Rotations {
+	r := make(Rotations)
+	for _, rc := range c.OnCall {
+		r[rc.Name] = rc
+	}
+	return r
+}
+
+// resolve replaces "oncall:<rotation>" targets with a field of a current
+// on-call member, other targets are returned as they are.
+func (r Rotations) resolve(targets []string, field func(*Contact) string) []string {
+	var ret []string
+	for _, t := range targets {
+		if !strings.HasPrefix(t, onCallPrefix) {
+			ret = append(ret, t)
+			continue
+		}
+		rc, ok := r[strings.TrimPrefix(t, onCallPrefix)]
+		if !ok {
+			slog.Warn("Unknown on-call rotation", "rotation", t)
+			continue
+		}
+		oc := rc.At(time.Now())
+		if oc.Current == nil || len(field(oc.Current)) == 0 {
+			slog.Warn("No contact on call", "field", t, "rotation", rc.Name)
+			continue
+		}
+		ret = append(ret, field(oc.Current))
+	}
+	return ret
+}
+
+// OnCall returns who is on call now in each rotation.
+func (s *StatusChecker) OnCall() []*OnCall {
+	s.m.Lock()
+	defer s.m.Unlock()
+	now := time.Now()
+	ret := make([]*OnCall, 0, len(s.config.OnCall))
+	for _, rc := range s.config.OnCall {
+		ret = append(ret, rc.At(now))
+	}
+	return ret
+}
+
+func RegisterOnCallHandler(sc *StatusChecker) {
+	describeAPI(&apiOperation{
+		Method: "GET", Path: "/api/oncall", Summary: "Who is on call in each rotation",
+		Responses: map[int]interface{}{http.StatusOK: []*OnCall{}},
+	})
+	http.HandleFunc("/api/oncall", func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(rw).Encode(sc.OnCall()); err != nil {
+			slog.Error("On-call", "error", err)
+		}
+	})
+}
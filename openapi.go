@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// OpenAPI import: checks generated from operations of an OpenAPI 3 or
+// Swagger 2 spec (JSON) to bootstrap monitoring of an API.
+///////////////////////////////////////////////////////////////////////////////
+
+type openAPISpec struct {
+	Servers []struct {
+		URL string `json:"url"`
+	} `json:"servers"`
+	// Swagger 2.
+	Host     string   `json:"host"`
+	BasePath string   `json:"basePath"`
+	Schemes  []string `json:"schemes"`
+
+	Paths map[string]map[string]json.RawMessage `json:"paths"`
+}
+
+type openAPIOperation struct {
+	OperationID string   `json:"operationId"`
+	Summary     string   `json:"summary"`
+	Tags        []string `json:"tags"`
+	Parameters  []struct {
+		In       string `json:"in"`
+		Required bool   `json:"required"`
+	} `json:"parameters"`
+	RequestBody *struct {
+		Required bool `json:"required"`
+	} `json:"requestBody"`
+	Responses map[string]json.RawMessage `json:"responses"`
+}
+
+var (
+	openAPIMethods  = []string{"get", "head", "options", "delete", "post", "put", "patch"}
+	healthOperation = regexp.MustCompile(`(?i)health|status|ping|ready|live`)
+)
+
+// base returns a URL of an API, the first server of a spec.
+func (s *openAPISpec) base() string {
+	if len(s.Servers) > 0 {
+		return s.Servers[0].URL
+	}
+	if len(s.Host) == 0 {
+		return ""
+	}
+	scheme := "https"
+	if len(s.Schemes) > 0 {
+		scheme = s.Schemes[0]
+	}
+	return scheme + "://" + s.Host + s.BasePath
+}
+
+// checkable reports whether an operation can be checked without
+// arguments: no path parameters, required parameters or a request body.
+func (op *openAPIOperation) checkable(path string) bool {
+	if strings.Contains(path, "{") {
+		return false
+	}
+	for _, p := range op.Parameters {
+		if p.Required && p.In != "header" {
+			return false
+		}
+	}
+	return op.RequestBody == nil || !op.RequestBody.Required
+}
+
+// expected returns the lowest 2xx status code of an operation, 200 if none
+// is defined.
+func (op *openAPIOperation) expected() int {
+	var codes []int
+	for k := range op.Responses {
+		if code, err := strconv.Atoi(k); err == nil && code >= 200 && code < 300 {
+			codes = append(codes, code)
+		}
+	}
+	if len(codes) == 0 {
+		return 200
+	}
+	sort.Ints(codes)
+	return codes[0]
+}
+
+// importOpenAPI generates resources from a spec at path. Operations are
+// selected by ids in ops, "all" selects every GET which can be checked, by
+// default GETs look like health checks are. base overrides a spec's URL.
+func importOpenAPI(path, base string, ops []string) ([]*ResConf, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	spec := &openAPISpec{}
+	if err := json.Unmarshal(b, spec); err != nil {
+		return nil, fmt.Errorf("%s: %s (only JSON specs are supported)", path, err)
+	}
+	if len(base) == 0 {
+		base = spec.base()
+	}
+	if len(base) == 0 {
+		return nil, fmt.Errorf("%s: no server URL, use -saddr", path)
+	}
+	base = strings.TrimSuffix(base, "/")
+	selected := make(map[string]bool)
+	for _, id := range ops {
+		selected[id] = true
+	}
+
+	var paths []string
+	for p := range spec.Paths {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	var ret []*ResConf
+	for _, p := range paths {
+		for _, method := range openAPIMethods {
+			raw, ok := spec.Paths[p][method]
+			if !ok {
+				continue
+			}
+			op := &openAPIOperation{}
+			if err := json.Unmarshal(raw, op); err != nil {
+				return nil, fmt.Errorf("%s %s: %s", method, p, err)
+			}
+			switch {
+			case len(ops) == 0:
+				if method != "get" || !healthOperation.MatchString(p+" "+op.OperationID+" "+op.Summary) {
+					continue
+				}
+			case selected["all"]:
+				if method != "get" {
+					continue
+				}
+			case !selected[op.OperationID]:
+				continue
+			}
+			name := op.OperationID
+			if len(name) == 0 {
+				name = strings.ToUpper(method) + " " + p
+			}
+			if !op.checkable(p) {
+				if selected[op.OperationID] {
+					return nil, fmt.Errorf("%s needs arguments, it can't be checked", name)
+				}
+				continue
+			}
+			c := &ResConf{Name: name, Address: base + p}
+			if method != "get" {
+				c.Method = strings.ToUpper(method)
+			}
+			if code := op.expected(); code != 200 {
+				c.Expect = code
+			}
+			if len(op.Tags) > 0 {
+				c.Tags = map[string]string{"openapi": op.Tags[0]}
+			}
+			ret = append(ret, c)
+		}
+	}
+	return ret, nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// An Opsgenie notifier: creates an alert when a resource goes DOWN or is
+// slow and closes it when it's back UP. Slow TTFB and slow total time are
+// separate alerts.
+///////////////////////////////////////////////////////////////////////////////
+
+const opsgenieDefaultURL = "https://api.opsgenie.com"
+
+type OpsgenieConf struct {
+	Name     string `json:",omitempty"` // a channel name, opsgenie if empty
+	ApiKey   string
+	ApiURL   string `json:",omitempty"` // e.g. https://api.eu.opsgenie.com
+	Priority string `json:",omitempty"` // P1-P5, P3 if empty
+	Rules    []*OpsgenieRule
+}
+
+// OpsgenieRule routes alerts of resources having Tag ("key" or "key=value")
+// to Teams. The first matching rule with a Priority sets the priority.
+type OpsgenieRule struct {
+	Tag      string
+	Teams    []string `json:",omitempty"`
+	Priority string   `json:",omitempty"`
+}
+
+type Opsgenie struct {
+	conf *OpsgenieConf
+}
+
+func NewOpsgenie(c *OpsgenieConf) *Opsgenie {
+	return &Opsgenie{c}
+}
+
+type opsgenieResponder struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+type opsgenieAlert struct {
+	Message     string              `json:"message"`
+	Alias       string              `json:"alias"`
+	Description string              `json:"description,omitempty"`
+	Responders  []opsgenieResponder `json:"responders,omitempty"`
+	Tags        []string            `json:"tags,omitempty"`
+	Priority    string              `json:"priority,omitempty"`
+	Source      string              `json:"source"`
+}
+
+type opsgenieClose struct {
+	Source string `json:"source"`
+	Note   string `json:"note,omitempty"`
+}
+
+func (o *Opsgenie) Notify(ev *Event) error {
+	if ev.Reminder {
+		// Opsgenie has its own escalations, an open alert is enough.
+		return nil
+	}
+	if alias := opsgenieAlias(ev.Conf, ev.Old); alias != "" {
+		path := fmt.Sprintf("/v2/alerts/%s/close?identifierType=alias", url.PathEscape(alias))
+		note := fmt.Sprintf("Resource is %s", ev.New.State())
+		if err := o.post(path, &opsgenieClose{"statusmonitor", note}); err != nil {
+			return err
+		}
+	}
+	if opsgenieAlias(ev.Conf, ev.New) != "" {
+		return o.post("/v2/alerts", o.alert(ev))
+	}
+	return nil
+}
+
+func (o *Opsgenie) Preview(ev *Event) []*Delivery {
+	if ev.Reminder {
+		return nil
+	}
+	var ret []*Delivery
+	if alias := opsgenieAlias(ev.Conf, ev.Old); alias != "" {
+		ret = append(ret, &Delivery{"opsgenie", "alias " + alias, "close"})
+	}
+	if opsgenieAlias(ev.Conf, ev.New) != "" {
+		a := o.alert(ev)
+		var teams []string
+		for _, r := range a.Responders {
+			teams = append(teams, r.Name)
+		}
+		recipient := "default"
+		if len(teams) > 0 {
+			recipient = "teams " + strings.Join(teams, ", ")
+		}
+		if len(a.Priority) > 0 {
+			recipient += " (" + a.Priority + ")"
+		}
+		ret = append(ret, &Delivery{"opsgenie", recipient, a.Message + ": " + a.Description})
+	}
+	return ret
+}
+
+func (o *Opsgenie) alert(ev *Event) *opsgenieAlert {
+	a := &opsgenieAlert{
+		Message:     fmt.Sprintf("%s is DOWN", ev.Conf.Name),
+		Alias:       opsgenieAlias(ev.Conf, ev.New),
+		Description: fmt.Sprintf("%s returned status %d at %s", redactAddress(ev.Conf.Address), ev.New.StatusCode, ev.New.When.Format("02-01-2006 15:04:05")),
+		Priority:    o.conf.Priority,
+		Source:      "statusmonitor",
+	}
+	if ev.New.State() == StateDegraded && len(ev.New.Slow) == 0 {
+		a.Message = fmt.Sprintf("%s is DEGRADED", ev.Conf.Name)
+		a.Description = fmt.Sprintf("%s: %s", redactAddress(ev.Conf.Address), ev.New.Degraded)
+	} else if ev.New.State() == StateDegraded {
+		a.Message = fmt.Sprintf("%s is slow (%s)", ev.Conf.Name, ev.New.Slow)
+		a.Description = fmt.Sprintf("%s TTFB %s (max %s), total %s (max %s)", redactAddress(ev.Conf.Address),
+			ev.New.TTFB, ev.Conf.MaxTTFB, ev.New.Latency, ev.Conf.MaxTotal)
+	}
+	teams := make(map[string]bool)
+	priority := ""
+	for _, r := range o.conf.Rules {
+		if !ev.Conf.hasTag(r.Tag) {
+			continue
+		}
+		for _, t := range r.Teams {
+			if !teams[t] {
+				teams[t] = true
+				a.Responders = append(a.Responders, opsgenieResponder{t, "team"})
+			}
+		}
+		if priority == "" {
+			priority = r.Priority
+		}
+	}
+	if priority != "" {
+		a.Priority = priority
+	}
+	for k, v := range ev.Conf.Tags {
+		if v == "" {
+			a.Tags = append(a.Tags, k)
+		} else {
+			a.Tags = append(a.Tags, k+":"+v)
+		}
+	}
+	sort.Strings(a.Tags)
+	return a
+}
+
+func (o *Opsgenie) post(path string, v interface{}) error {
+	base := o.conf.ApiURL
+	if base == "" {
+		base = opsgenieDefaultURL
+	}
+	h := http.Header{}
+	h.Set("Authorization", "GenieKey "+o.conf.ApiKey)
+	return postJSON(base+path, v, h)
+}
+
+// opsgenieAlias identifies an alert for a resource in a given status, it's
+// empty if the status needs no alert.
+func opsgenieAlias(c *ResConf, st *Status) string {
+	switch st.State() {
+	case StateDown:
+		return "statusmonitor:" + c.Name
+	case StateDegraded:
+		if len(st.Slow) == 0 {
+			return "statusmonitor:" + c.Name + ":degraded"
+		}
+		return "statusmonitor:" + c.Name + ":" + st.Slow
+	}
+	return ""
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// An overall state of the whole instance, a single "is this environment
+// healthy" signal for upstream systems.
+///////////////////////////////////////////////////////////////////////////////
+
+const (
+	OverallWorst    = "worst"
+	OverallWeighted = "weighted"
+)
+
+type OverallConf struct {
+	Mode string // OverallWorst (default) or OverallWeighted
+	// In the weighted mode a DEGRADED resource counts as half healthy and the
+	// instance is DEGRADED if a healthy share of weights is below Degraded
+	// (1 if 0) and DOWN if below Down (0.5 if 0).
+	Degraded float64 `json:",omitempty"`
+	Down     float64 `json:",omitempty"`
+}
+
+type Overall struct {
+	State        State
+	Score        float64 // a healthy share of weights of checked resources
+	Up           int
+	Degraded     int
+	Down         int
+	NeverChecked int
+	Invalid      int `json:",omitempty"`
+}
+
+func (c *ResConf) weight() float64 {
+	if c.Weight > 0 {
+		return c.Weight
+	}
+	return 1
+}
+
+func computeOverall(c *OverallConf, arr []tmplHelper) *Overall {
+	if c == nil {
+		c = &OverallConf{}
+	}
+	o := &Overall{}
+	var total, healthy float64
+	for _, el := range arr {
+		st := el.Status.State()
+		w := el.Conf.weight()
+		switch st {
+		case StateUp:
+			o.Up++
+			healthy += w
+		case StateDegraded:
+			o.Degraded++
+			healthy += w / 2
+		case StateDown:
+			o.Down++
+		case StateInvalid:
+			o.Invalid++
+			continue
+		default:
+			o.NeverChecked++
+			continue
+		}
+		total += w
+		if st > o.State {
+			o.State = st
+		}
+	}
+	if total == 0 {
+		o.State = StateNeverChecked
+		return o
+	}
+	o.Score = healthy / total
+	if c.Mode == OverallWeighted {
+		degraded, down := c.Degraded, c.Down
+		if degraded == 0 {
+			degraded = 1
+		}
+		if down == 0 {
+			down = 0.5
+		}
+		switch {
+		case o.Score < down:
+			o.State = StateDown
+		case o.Score < degraded:
+			o.State = StateDegraded
+		default:
+			o.State = StateUp
+		}
+	}
+	return o
+}
+
+func (s *StatusChecker) Overall() *Overall {
+	s.m.Lock()
+	c := s.config.Overall
+	s.m.Unlock()
+	return computeOverall(c, s.Snapshot())
+}
+
+func RegisterOverallHandler(sc *StatusChecker) {
+	describeAPI(&apiOperation{
+		Method: "GET", Path: "/api/overall", Summary: "A single state of the whole instance",
+		Responses: map[int]interface{}{http.StatusOK: &Overall{}, http.StatusServiceUnavailable: &Overall{}},
+	})
+	http.HandleFunc("/api/overall", func(rw http.ResponseWriter, req *http.Request) {
+		o := sc.Overall()
+		rw.Header().Set("Content-Type", "application/json")
+		if o.State == StateDown {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		}
+		if err := json.NewEncoder(rw).Encode(o); err != nil {
+			slog.Error("Overall", "error", err)
+		}
+	})
+}
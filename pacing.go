@@ -0,0 +1,127 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// Request pacing per group: a group may limit probes per second across its
+// members, e.g. to respect a partner API's rate limit. Probes over a budget
+// wait in a group queue.
+///////////////////////////////////////////////////////////////////////////////
+
+type GroupConf struct {
+	Name    string
+	MaxRate float64 `json:",omitempty"` // probes per second, unlimited if 0
+	// Alert notifies of a group state instead of states of its members.
+	Alert  bool     `json:",omitempty"`
+	Notify []string `json:",omitempty"` // names of notification channels of group alerts
+}
+
+type pacer struct {
+	every time.Duration
+	out   *scheduler
+	wake  chan bool
+
+	m       sync.Mutex
+	next    time.Time // when a next probe may go
+	waiting []*ResConf
+	pending map[*ResConf]bool
+	// Probes which had to wait and ones dropped as already waiting.
+	deferred int64
+	skipped  int64
+}
+
+func newPacer(rate float64, out *scheduler) *pacer {
+	return &pacer{
+		every:   time.Duration(float64(time.Second) / rate),
+		out:     out,
+		wake:    make(chan bool, 1),
+		pending: make(map[*ResConf]bool),
+	}
+}
+
+// Pacers builds pacers of rate limited groups.
+func (c *Config) Pacers(out *scheduler) map[string]*pacer {
+	ret := make(map[string]*pacer)
+	for _, g := range c.Groups {
+		if g.MaxRate > 0 {
+			ret[g.Name] = newPacer(g.MaxRate, out)
+		}
+	}
+	return ret
+}
+
+func (p *pacer) push(c *ResConf) {
+	p.m.Lock()
+	defer p.m.Unlock()
+	if p.pending[c] {
+		p.skipped++
+		return
+	}
+	if len(p.waiting) > 0 || time.Now().Before(p.next) {
+		p.deferred++
+	}
+	p.waiting = append(p.waiting, c)
+	p.pending[c] = true
+	select {
+	case p.wake <- true:
+	default:
+	}
+}
+
+func (p *pacer) pop() (*ResConf, time.Duration) {
+	p.m.Lock()
+	defer p.m.Unlock()
+	if len(p.waiting) == 0 {
+		return nil, 0
+	}
+	c := p.waiting[0]
+	p.waiting = p.waiting[1:]
+	delete(p.pending, c)
+	now := time.Now()
+	wait := p.next.Sub(now)
+	if wait < 0 {
+		wait = 0
+	}
+	p.next = now.Add(wait + p.every)
+	return c, wait
+}
+
+func (p *pacer) run() {
+	for range p.wake {
+		for {
+			c, wait := p.pop()
+			if c == nil {
+				break
+			}
+			time.Sleep(wait)
+			p.out.push(c)
+		}
+	}
+}
+
+type pacerStats struct {
+	Waiting  int
+	Deferred int64
+	Skipped  int64
+}
+
+func (p *pacer) stats() pacerStats {
+	p.m.Lock()
+	defer p.m.Unlock()
+	return pacerStats{len(p.waiting), p.deferred, p.skipped}
+}
+
+// enqueue schedules a check of c, respecting a rate limit of its group.
+func (s *StatusChecker) enqueue(c *ResConf) {
+	if c.validate() != nil {
+		return
+	}
+	if p, ok := s.pacers[c.Group]; ok && len(c.Group) > 0 {
+		p.push(c)
+		return
+	}
+	s.queue.push(c)
+}
@@ -0,0 +1,42 @@
+package main
+
+import "fmt"
+
+///////////////////////////////////////////////////////////////////////////////
+// Pausing: an operator stops checks of a resource, e.g. during maintenance,
+// without removing it. Its last status stays until it's resumed.
+///////////////////////////////////////////////////////////////////////////////
+
+const (
+	AuditPause  = "pause"
+	AuditResume = "resume"
+)
+
+type PauseRequest struct {
+	Name   string
+	Resume bool // resumes checks of a paused resource
+}
+
+func (a *AdminServer) Pause(args PauseRequest, result *ResConf) error {
+	if err := a.allow(RoleOperator); err != nil {
+		return err
+	}
+	conf, _ := a.sc.Lookup(args.Name)
+	if conf == nil {
+		return fmt.Errorf("no resource named %q", args.Name)
+	}
+	cfg, err := patchResource(conf, []byte(fmt.Sprintf(`{"Paused":%t}`, !args.Resume)))
+	if err != nil {
+		return err
+	}
+	if err := a.sc.Update(args.Name, cfg); err != nil {
+		return err
+	}
+	action := AuditPause
+	if args.Resume {
+		action = AuditResume
+	}
+	audit(a.caller, a.from, action, args.Name, args)
+	*result = *redactConf(cfg)
+	return nil
+}
@@ -0,0 +1,12 @@
+//go:build postgres
+
+package main
+
+import (
+	_ "github.com/lib/pq"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// PostgreSQL checks, built with -tags postgres and lib/pq in GOPATH, see
+// sqlcheck.go.
+///////////////////////////////////////////////////////////////////////////////
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// An alert routing dry run: shows what notifiers would send for
+// a hypothetical state change of a resource, without sending anything.
+///////////////////////////////////////////////////////////////////////////////
+
+type PreviewRequest struct {
+	Name string
+	From string // UP, DEGRADED, DOWN or NEVER_CHECKED
+	To   string
+	Slow string // SlowTTFB or SlowTotal for DEGRADED, SlowTTFB if empty
+}
+
+type PreviewResult struct {
+	Transition bool // false if the change doesn't notify at all
+	Deliveries []*Delivery
+}
+
+func parseState(s string) (State, error) {
+	for _, st := range []State{StateNeverChecked, StateUp, StateDegraded, StateDown} {
+		if strings.EqualFold(s, st.String()) {
+			return st, nil
+		}
+	}
+	return StateNeverChecked, fmt.Errorf("unknown state %q", s)
+}
+
+// hypotheticalStatus returns a made up status in a given state.
+func hypotheticalStatus(st State, slow string) *Status {
+	now := time.Now()
+	switch st {
+	case StateUp:
+		return &Status{When: now, StatusCode: http.StatusOK}
+	case StateDegraded:
+		if slow == "" {
+			slow = SlowTTFB
+		}
+		return &Status{When: now, StatusCode: http.StatusOK, Slow: slow}
+	case StateDown:
+		return &Status{When: now, StatusCode: http.StatusServiceUnavailable}
+	}
+	return &Status{}
+}
+
+func (s *StatusChecker) Preview(req PreviewRequest) (*PreviewResult, error) {
+	conf, _ := s.Lookup(req.Name)
+	if conf == nil {
+		return nil, fmt.Errorf("no resource named %q", req.Name)
+	}
+	from, err := parseState(req.From)
+	if err != nil {
+		return nil, err
+	}
+	to, err := parseState(req.To)
+	if err != nil {
+		return nil, err
+	}
+	ev := &Event{Conf: conf, Old: hypotheticalStatus(from, ""), New: hypotheticalStatus(to, req.Slow)}
+	ret := &PreviewResult{Transition: isTransition(ev.Old, ev.New)}
+	if !ret.Transition || !conf.notifies(ev) {
+		return ret, nil
+	}
+	for _, ch := range s.router.Route(conf) {
+		for _, d := range ch.Preview(ev) {
+			if d.Notifier != ch.Name {
+				d.Notifier = ch.Name + " (" + d.Notifier + ")"
+			}
+			ret.Deliveries = append(ret.Deliveries, d)
+		}
+	}
+	return ret, nil
+}
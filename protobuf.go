@@ -0,0 +1,627 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// Protobuf encoding of Go structs by a schema: a message names a Go type and
+// numbers its fields, wire types follow from Go types of the fields (see
+// pbDescType), so gRPC needs no generated code. The same schema describes
+// files to gRPC reflection. Durations are int64 nanoseconds, a time.Time a
+// google.protobuf.Timestamp and a pointer to a scalar a field with presence.
+///////////////////////////////////////////////////////////////////////////////
+
+// pbField is a field of a message: a number, a proto name and a Go struct
+// field, or a method of a computed field which is only encoded.
+type pbField struct {
+	Num  int
+	Name string
+	Go   string
+}
+
+type pbMessage struct {
+	Name   string // in a file's package, e.g. ResConf
+	Type   reflect.Type
+	Fields []pbField
+	Oneofs map[string][]int // field numbers by a oneof name
+
+	full  string
+	byNum map[int]*pbField
+}
+
+type pbEnum struct {
+	Name   string
+	Type   reflect.Type
+	Values []string // by number
+
+	full string
+}
+
+// pbFile is a .proto file of messages, enums and services.
+type pbFile struct {
+	Name     string
+	Package  string
+	Deps     []string
+	Messages []*pbMessage
+	Enums    []*pbEnum
+	Services []*grpcService
+}
+
+// Descriptor types of fields.
+const (
+	pbTypeDouble  = 1
+	pbTypeInt64   = 3
+	pbTypeInt32   = 5
+	pbTypeBool    = 8
+	pbTypeString  = 9
+	pbTypeMessage = 11
+	pbTypeBytes   = 12
+	pbTypeEnum    = 14
+)
+
+var (
+	pbMessages = make(map[reflect.Type]*pbMessage)
+	pbEnums    = make(map[reflect.Type]*pbEnum)
+	pbFiles    []*pbFile // served by reflection
+
+	errPBMalformed = errors.New("malformed protobuf message")
+)
+
+type pbTimestamp struct {
+	Seconds int64
+	Nanos   int32
+}
+
+type pbEmpty struct{}
+
+type pbFieldMask struct {
+	Paths []string
+}
+
+func init() {
+	registerProto(&pbFile{Name: "google/protobuf/timestamp.proto", Package: "google.protobuf",
+		Messages: []*pbMessage{{Name: "Timestamp", Type: reflect.TypeOf(pbTimestamp{}), Fields: []pbField{
+			{1, "seconds", "Seconds"}, {2, "nanos", "Nanos"},
+		}}}}, true)
+	registerProto(&pbFile{Name: "google/protobuf/empty.proto", Package: "google.protobuf",
+		Messages: []*pbMessage{{Name: "Empty", Type: reflect.TypeOf(pbEmpty{})}}}, true)
+	registerProto(&pbFile{Name: "google/protobuf/field_mask.proto", Package: "google.protobuf",
+		Messages: []*pbMessage{{Name: "FieldMask", Type: reflect.TypeOf(pbFieldMask{}), Fields: []pbField{
+			{1, "paths", "Paths"},
+		}}}}, true)
+	registerProto(descriptorProto, false)
+}
+
+// registerProto registers messages and enums of a file, served by
+// reflection if served is set.
+func registerProto(f *pbFile, served bool) {
+	for _, e := range f.Enums {
+		e.full = f.Package + "." + e.Name
+		pbEnums[e.Type] = e
+	}
+	for _, m := range f.Messages {
+		m.full = f.Package + "." + m.Name
+		m.byNum = make(map[int]*pbField)
+		for i := range m.Fields {
+			m.byNum[m.Fields[i].Num] = &m.Fields[i]
+		}
+		pbMessages[m.Type] = m
+	}
+	if served {
+		pbFiles = append(pbFiles, f)
+	}
+}
+
+func pbAppendVarint(b []byte, num int, x uint64) []byte {
+	return binary.AppendUvarint(binary.AppendUvarint(b, uint64(num)<<3), x)
+}
+
+func pbAppendBytes(b []byte, num int, v []byte) []byte {
+	b = binary.AppendUvarint(binary.AppendUvarint(b, uint64(num)<<3|2), uint64(len(v)))
+	return append(b, v...)
+}
+
+// pbMarshal encodes a pointer to a struct of a registered message.
+func pbMarshal(v interface{}) ([]byte, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || pbMessages[rv.Type().Elem()] == nil {
+		return nil, fmt.Errorf("protobuf: %T isn't a message", v)
+	}
+	return pbAppendMessage(nil, rv.Elem()), nil
+}
+
+func pbAppendMessage(b []byte, v reflect.Value) []byte {
+	m := pbMessages[v.Type()]
+	if m == nil {
+		panic(fmt.Sprintf("protobuf: %s isn't a message", v.Type()))
+	}
+	if !v.CanAddr() {
+		c := reflect.New(v.Type()).Elem()
+		c.Set(v)
+		v = c
+	}
+	for _, f := range m.Fields {
+		fv := v.FieldByName(f.Go)
+		if !fv.IsValid() {
+			fv = v.Addr().MethodByName(f.Go).Call(nil)[0]
+		}
+		b = pbAppendField(b, f.Num, fv)
+	}
+	return b
+}
+
+// pbAppendField appends a field unless it has a zero value without
+// presence.
+func pbAppendField(b []byte, num int, v reflect.Value) []byte {
+	switch {
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() != reflect.Uint8:
+		for i := 0; i < v.Len(); i++ {
+			b = pbAppendValue(b, num, v.Index(i))
+		}
+	case v.Kind() == reflect.Map:
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+		for _, k := range keys {
+			b = pbAppendBytes(b, num, pbAppendValue(pbAppendValue(nil, 1, k), 2, v.MapIndex(k)))
+		}
+	case v.Kind() == reflect.Ptr:
+		if !v.IsNil() {
+			b = pbAppendValue(b, num, v.Elem())
+		}
+	case !v.IsZero():
+		b = pbAppendValue(b, num, v)
+	}
+	return b
+}
+
+// pbAppendValue appends a field of one value, a zero one too.
+func pbAppendValue(b []byte, num int, v reflect.Value) []byte {
+	if v.Type() == timeType {
+		t := v.Interface().(time.Time)
+		ts := pbTimestamp{t.Unix(), int32(t.Nanosecond())}
+		return pbAppendBytes(b, num, pbAppendMessage(nil, reflect.ValueOf(&ts).Elem()))
+	}
+	switch v.Kind() {
+	case reflect.String:
+		b = binary.AppendUvarint(binary.AppendUvarint(b, uint64(num)<<3|2), uint64(v.Len()))
+		return append(b, v.String()...)
+	case reflect.Slice:
+		return pbAppendBytes(b, num, v.Bytes())
+	case reflect.Bool:
+		x := uint64(0)
+		if v.Bool() {
+			x = 1
+		}
+		return pbAppendVarint(b, num, x)
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		return pbAppendVarint(b, num, uint64(v.Int()))
+	case reflect.Float64:
+		b = binary.AppendUvarint(b, uint64(num)<<3|1)
+		return binary.LittleEndian.AppendUint64(b, math.Float64bits(v.Float()))
+	case reflect.Ptr:
+		return pbAppendValue(b, num, v.Elem())
+	case reflect.Struct:
+		return pbAppendBytes(b, num, pbAppendMessage(nil, v))
+	}
+	panic(fmt.Sprintf("protobuf: %s isn't supported", v.Type()))
+}
+
+// pbUnmarshal decodes a message into a pointer to a struct of a registered
+// message. Unknown fields are skipped.
+func pbUnmarshal(b []byte, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || pbMessages[rv.Type().Elem()] == nil {
+		return fmt.Errorf("protobuf: %T isn't a message", v)
+	}
+	return pbDecodeMessage(b, rv.Elem())
+}
+
+// pbNext reads a field at the start of b: its number, wire type and value,
+// in raw if it's length-delimited.
+func pbNext(b []byte) (num, wire int, x uint64, raw, rest []byte, err error) {
+	key, n := binary.Uvarint(b)
+	if n <= 0 || key>>3 == 0 || key>>3 > math.MaxInt32 {
+		return 0, 0, 0, nil, nil, errPBMalformed
+	}
+	b = b[n:]
+	num, wire = int(key>>3), int(key&7)
+	switch wire {
+	case 0:
+		if x, n = binary.Uvarint(b); n <= 0 {
+			return 0, 0, 0, nil, nil, errPBMalformed
+		}
+		b = b[n:]
+	case 1:
+		if len(b) < 8 {
+			return 0, 0, 0, nil, nil, errPBMalformed
+		}
+		x, b = binary.LittleEndian.Uint64(b), b[8:]
+	case 2:
+		l, n := binary.Uvarint(b)
+		if n <= 0 || l > uint64(len(b)-n) {
+			return 0, 0, 0, nil, nil, errPBMalformed
+		}
+		raw, b = b[n:n+int(l)], b[n+int(l):]
+	case 5:
+		if len(b) < 4 {
+			return 0, 0, 0, nil, nil, errPBMalformed
+		}
+		x, b = uint64(binary.LittleEndian.Uint32(b)), b[4:]
+	default:
+		return 0, 0, 0, nil, nil, fmt.Errorf("protobuf: wire type %d isn't supported", wire)
+	}
+	return num, wire, x, raw, b, nil
+}
+
+func pbDecodeMessage(b []byte, v reflect.Value) error {
+	m := pbMessages[v.Type()]
+	for len(b) > 0 {
+		num, wire, x, raw, rest, err := pbNext(b)
+		if err != nil {
+			return err
+		}
+		b = rest
+		f := m.byNum[num]
+		if f == nil {
+			continue
+		}
+		fv := v.FieldByName(f.Go)
+		if !fv.IsValid() {
+			continue // computed
+		}
+		if err := pbDecodeField(fv, wire, x, raw); err != nil {
+			return fmt.Errorf("%s: %s", f.Name, err)
+		}
+	}
+	return nil
+}
+
+// pbPacked is true of Go types of repeated fields which may be packed.
+func pbPacked(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Bool, reflect.Int, reflect.Int32, reflect.Int64, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+func pbDecodeField(v reflect.Value, wire int, x uint64, raw []byte) error {
+	switch {
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() != reflect.Uint8:
+		el := reflect.New(v.Type().Elem()).Elem()
+		if wire == 2 && pbPacked(el.Type()) {
+			for len(raw) > 0 {
+				if el.Kind() == reflect.Float64 {
+					if len(raw) < 8 {
+						return errPBMalformed
+					}
+					el.SetFloat(math.Float64frombits(binary.LittleEndian.Uint64(raw)))
+					raw = raw[8:]
+				} else {
+					x, n := binary.Uvarint(raw)
+					if n <= 0 {
+						return errPBMalformed
+					}
+					if err := pbDecodeValue(el, 0, x, nil); err != nil {
+						return err
+					}
+					raw = raw[n:]
+				}
+				v.Set(reflect.Append(v, el))
+			}
+			return nil
+		}
+		if err := pbDecodeValue(el, wire, x, raw); err != nil {
+			return err
+		}
+		v.Set(reflect.Append(v, el))
+		return nil
+	case v.Kind() == reflect.Map:
+		if wire != 2 {
+			return fmt.Errorf("wire type %d of a map", wire)
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+		k, el := reflect.New(v.Type().Key()).Elem(), reflect.New(v.Type().Elem()).Elem()
+		for len(raw) > 0 {
+			num, wire, x, r, rest, err := pbNext(raw)
+			if err != nil {
+				return err
+			}
+			raw = rest
+			switch num {
+			case 1:
+				err = pbDecodeValue(k, wire, x, r)
+			case 2:
+				err = pbDecodeValue(el, wire, x, r)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		v.SetMapIndex(k, el)
+		return nil
+	}
+	return pbDecodeValue(v, wire, x, raw)
+}
+
+// pbDecodeValue decodes one value, a message is merged into v.
+func pbDecodeValue(v reflect.Value, wire int, x uint64, raw []byte) error {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+	want := 0
+	switch v.Kind() {
+	case reflect.Float64:
+		want = 1
+	case reflect.String, reflect.Slice, reflect.Struct:
+		want = 2
+	}
+	if wire != want {
+		return fmt.Errorf("wire type %d of %s", wire, v.Type())
+	}
+	if v.Type() == timeType {
+		var ts pbTimestamp
+		if err := pbDecodeMessage(raw, reflect.ValueOf(&ts).Elem()); err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(time.Unix(ts.Seconds, int64(ts.Nanos))))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(string(raw))
+	case reflect.Slice:
+		v.SetBytes(append([]byte{}, raw...))
+	case reflect.Bool:
+		v.SetBool(x != 0)
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		v.SetInt(int64(x))
+	case reflect.Float64:
+		v.SetFloat(math.Float64frombits(x))
+	case reflect.Struct:
+		if pbMessages[v.Type()] == nil {
+			return fmt.Errorf("%s isn't a message", v.Type())
+		}
+		return pbDecodeMessage(raw, v)
+	default:
+		return fmt.Errorf("%s isn't supported", v.Type())
+	}
+	return nil
+}
+
+///////////////////////////////////////////////////////////////////////////////
+// Descriptors of files, as protoc would make them, for gRPC reflection.
+///////////////////////////////////////////////////////////////////////////////
+
+type pbFileDescriptorProto struct {
+	Name        string
+	Package     string
+	Dependency  []string
+	MessageType []*pbDescriptorProto
+	EnumType    []*pbEnumDescriptorProto
+	Service     []*pbServiceDescriptorProto
+	Syntax      string
+}
+
+type pbDescriptorProto struct {
+	Name       string
+	Field      []*pbFieldDescriptorProto
+	NestedType []*pbDescriptorProto
+	Options    *pbMessageOptions
+	OneofDecl  []*pbOneofDescriptorProto
+}
+
+type pbFieldDescriptorProto struct {
+	Name       string
+	Number     int32
+	Label      int32
+	Type       int32
+	TypeName   string
+	OneofIndex *int32
+	JSONName   string
+}
+
+type pbMessageOptions struct {
+	MapEntry bool
+}
+
+type pbOneofDescriptorProto struct {
+	Name string
+}
+
+type pbEnumDescriptorProto struct {
+	Name  string
+	Value []*pbEnumValueDescriptorProto
+}
+
+type pbEnumValueDescriptorProto struct {
+	Name   string
+	Number int32
+}
+
+type pbServiceDescriptorProto struct {
+	Name   string
+	Method []*pbMethodDescriptorProto
+}
+
+type pbMethodDescriptorProto struct {
+	Name            string
+	InputType       string
+	OutputType      string
+	ClientStreaming bool
+	ServerStreaming bool
+}
+
+var descriptorProto = &pbFile{Name: "google/protobuf/descriptor.proto", Package: "google.protobuf",
+	Messages: []*pbMessage{
+		{Name: "FileDescriptorProto", Type: reflect.TypeOf(pbFileDescriptorProto{}), Fields: []pbField{
+			{1, "name", "Name"}, {2, "package", "Package"}, {3, "dependency", "Dependency"},
+			{4, "message_type", "MessageType"}, {5, "enum_type", "EnumType"}, {6, "service", "Service"},
+			{12, "syntax", "Syntax"},
+		}},
+		{Name: "DescriptorProto", Type: reflect.TypeOf(pbDescriptorProto{}), Fields: []pbField{
+			{1, "name", "Name"}, {2, "field", "Field"}, {3, "nested_type", "NestedType"}, {7, "options", "Options"},
+			{8, "oneof_decl", "OneofDecl"},
+		}},
+		{Name: "FieldDescriptorProto", Type: reflect.TypeOf(pbFieldDescriptorProto{}), Fields: []pbField{
+			{1, "name", "Name"}, {3, "number", "Number"}, {4, "label", "Label"}, {5, "type", "Type"},
+			{6, "type_name", "TypeName"}, {9, "oneof_index", "OneofIndex"}, {10, "json_name", "JSONName"},
+		}},
+		{Name: "MessageOptions", Type: reflect.TypeOf(pbMessageOptions{}), Fields: []pbField{
+			{7, "map_entry", "MapEntry"},
+		}},
+		{Name: "OneofDescriptorProto", Type: reflect.TypeOf(pbOneofDescriptorProto{}), Fields: []pbField{
+			{1, "name", "Name"},
+		}},
+		{Name: "EnumDescriptorProto", Type: reflect.TypeOf(pbEnumDescriptorProto{}), Fields: []pbField{
+			{1, "name", "Name"}, {2, "value", "Value"},
+		}},
+		{Name: "EnumValueDescriptorProto", Type: reflect.TypeOf(pbEnumValueDescriptorProto{}), Fields: []pbField{
+			{1, "name", "Name"}, {2, "number", "Number"},
+		}},
+		{Name: "ServiceDescriptorProto", Type: reflect.TypeOf(pbServiceDescriptorProto{}), Fields: []pbField{
+			{1, "name", "Name"}, {2, "method", "Method"},
+		}},
+		{Name: "MethodDescriptorProto", Type: reflect.TypeOf(pbMethodDescriptorProto{}), Fields: []pbField{
+			{1, "name", "Name"}, {2, "input_type", "InputType"}, {3, "output_type", "OutputType"},
+			{5, "client_streaming", "ClientStreaming"}, {6, "server_streaming", "ServerStreaming"},
+		}},
+	}}
+
+// pbDescType returns a descriptor type of a Go type of a single value, with
+// a full name of a message or an enum.
+func pbDescType(t reflect.Type) (int32, string) {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == timeType {
+		t = reflect.TypeOf(pbTimestamp{})
+	}
+	if e := pbEnums[t]; e != nil {
+		return pbTypeEnum, "." + e.full
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return pbTypeString, ""
+	case reflect.Bool:
+		return pbTypeBool, ""
+	case reflect.Int, reflect.Int32:
+		return pbTypeInt32, ""
+	case reflect.Int64:
+		return pbTypeInt64, ""
+	case reflect.Float64:
+		return pbTypeDouble, ""
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return pbTypeBytes, ""
+		}
+	case reflect.Struct:
+		if m := pbMessages[t]; m != nil {
+			return pbTypeMessage, "." + m.full
+		}
+	}
+	panic(fmt.Sprintf("protobuf: %s isn't supported", t))
+}
+
+// fieldType returns a Go type of a field of the message.
+func (m *pbMessage) fieldType(f pbField) reflect.Type {
+	if sf, ok := m.Type.FieldByName(f.Go); ok {
+		return sf.Type
+	}
+	meth, ok := reflect.PointerTo(m.Type).MethodByName(f.Go)
+	if !ok {
+		panic(fmt.Sprintf("protobuf: %s has no %s", m.Type, f.Go))
+	}
+	return meth.Type.Out(0)
+}
+
+// pbCamel returns a CamelCase name of a snake_case one, lowerCamel if
+// lower is set.
+func pbCamel(name string, lower bool) string {
+	parts := strings.Split(name, "_")
+	for i, p := range parts {
+		if len(p) > 0 && (i > 0 || !lower) {
+			parts[i] = strings.ToUpper(p[:1]) + p[1:]
+		}
+	}
+	return strings.Join(parts, "")
+}
+
+func (m *pbMessage) descriptor() *pbDescriptorProto {
+	d := &pbDescriptorProto{Name: m.Name}
+	oneofs := make(map[int]int32)
+	var names []string
+	for name := range m.Oneofs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		d.OneofDecl = append(d.OneofDecl, &pbOneofDescriptorProto{name})
+		for _, num := range m.Oneofs[name] {
+			oneofs[num] = int32(i)
+		}
+	}
+	for _, f := range m.Fields {
+		fd := &pbFieldDescriptorProto{Name: f.Name, Number: int32(f.Num), Label: 1, JSONName: pbCamel(f.Name, true)}
+		t := m.fieldType(f)
+		switch {
+		case t.Kind() == reflect.Map:
+			entry := &pbDescriptorProto{Name: pbCamel(f.Name, false) + "Entry", Options: &pbMessageOptions{true}}
+			for i, et := range []reflect.Type{t.Key(), t.Elem()} {
+				name := []string{"key", "value"}[i]
+				typ, typeName := pbDescType(et)
+				entry.Field = append(entry.Field, &pbFieldDescriptorProto{Name: name, Number: int32(i + 1), Label: 1,
+					Type: typ, TypeName: typeName, JSONName: name})
+			}
+			d.NestedType = append(d.NestedType, entry)
+			fd.Label, fd.Type, fd.TypeName = 3, pbTypeMessage, "."+m.full+"."+entry.Name
+		case t.Kind() == reflect.Slice && t.Elem().Kind() != reflect.Uint8:
+			fd.Label = 3
+			fd.Type, fd.TypeName = pbDescType(t.Elem())
+		default:
+			fd.Type, fd.TypeName = pbDescType(t)
+		}
+		if i, ok := oneofs[f.Num]; ok {
+			fd.OneofIndex = &i
+		}
+		d.Field = append(d.Field, fd)
+	}
+	return d
+}
+
+func (f *pbFile) descriptor() *pbFileDescriptorProto {
+	d := &pbFileDescriptorProto{Name: f.Name, Package: f.Package, Dependency: f.Deps, Syntax: "proto3"}
+	for _, m := range f.Messages {
+		d.MessageType = append(d.MessageType, m.descriptor())
+	}
+	for _, e := range f.Enums {
+		ed := &pbEnumDescriptorProto{Name: e.Name}
+		for i, v := range e.Values {
+			ed.Value = append(ed.Value, &pbEnumValueDescriptorProto{v, int32(i)})
+		}
+		d.EnumType = append(d.EnumType, ed)
+	}
+	for _, s := range f.Services {
+		sd := &pbServiceDescriptorProto{Name: strings.TrimPrefix(s.Name, f.Package+".")}
+		for _, m := range s.Methods {
+			_, in := pbDescType(m.In)
+			_, out := pbDescType(m.Out)
+			sd.Method = append(sd.Method, &pbMethodDescriptorProto{m.Name, in, out, m.Stream != nil, m.Stream != nil})
+		}
+		d.Service = append(d.Service, sd)
+	}
+	return d
+}
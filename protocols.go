@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// Forced HTTP protocols: a resource's Protocol makes a check use only
+// HTTP/1.1, HTTP/2 (h2c for http URLs) or HTTP/3, so e.g. a broken h2
+// config isn't hidden by a fallback. A check is DOWN if another protocol
+// is negotiated. HTTP/3 needs a build with -tags http3, see http3.go.
+///////////////////////////////////////////////////////////////////////////////
+
+const (
+	ProtocolHTTP1 = "http1"
+	ProtocolHTTP2 = "http2"
+	ProtocolHTTP3 = "http3"
+)
+
+// protocolMajor maps protocols to response ProtoMajor.
+var protocolMajor = map[string]int{ProtocolHTTP1: 1, ProtocolHTTP2: 2, ProtocolHTTP3: 3}
+
+// newHTTP3Transport returns an HTTP/3 transport, it's nil unless HTTP/3 is
+// compiled in.
+var newHTTP3Transport func() http.RoundTripper
+
+// protocolTransport returns a transport forcing a protocol, negotiating one
+// if it's empty, see newCheckTransport.
+func protocolTransport(protocol string, r *net.Resolver, family string) http.RoundTripper {
+	if protocol == ProtocolHTTP3 {
+		return newHTTP3Transport()
+	}
+	tr := newCheckTransport(r, family)
+	switch protocol {
+	case ProtocolHTTP1:
+		tr.Protocols = new(http.Protocols)
+		tr.Protocols.SetHTTP1(true)
+	case ProtocolHTTP2:
+		tr.Protocols = new(http.Protocols)
+		tr.Protocols.SetHTTP2(true)
+		tr.Protocols.SetUnencryptedHTTP2(true)
+	}
+	return tr
+}
+
+// validateProtocol checks if a protocol can be forced for an address.
+func validateProtocol(protocol, address, resolver string) error {
+	if _, ok := protocolMajor[protocol]; !ok {
+		return fmt.Errorf("unknown protocol %q, use http1, http2 or http3", protocol)
+	}
+	if protocol != ProtocolHTTP3 {
+		return nil
+	}
+	if newHTTP3Transport == nil {
+		return fmt.Errorf("http3 isn't compiled in, build with -tags http3")
+	}
+	if len(resolver) > 0 {
+		return fmt.Errorf("http3 can't use a resolver")
+	}
+	if u, err := url.Parse(address); err == nil && u.Scheme != "https" {
+		return fmt.Errorf("http3 needs an https address")
+	}
+	return nil
+}
+
+// checkProtocol returns an error if a response came over another protocol
+// than c forces.
+func checkProtocol(c *ResConf, resp *http.Response) error {
+	want, ok := protocolMajor[c.Protocol]
+	if !ok || resp.ProtoMajor == want {
+		return nil
+	}
+	return fmt.Errorf("negotiated %s instead of %s", resp.Proto, c.Protocol)
+}
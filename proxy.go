@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// Proxies of checks: HTTP checks go through a resource's Proxy, -proxy or
+// proxies of HTTP_PROXY, HTTPS_PROXY and NO_PROXY, in this order. A proxy
+// is an http, https or socks5 URL, none connects directly.
+///////////////////////////////////////////////////////////////////////////////
+
+var proxyAddr = flag.String("proxy", "", "A proxy of checks, e.g. http://proxy:3128 or socks5://proxy:1080, none to ignore HTTP_PROXY and NO_PROXY.")
+
+// ProxyNone makes checks connect directly.
+const ProxyNone = "none"
+
+type proxyKey struct{}
+
+// parseProxy parses a proxy, it returns nil for none.
+func parseProxy(s string) (*url.URL, error) {
+	if s == ProxyNone {
+		return nil, nil
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("bad proxy: %s", err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("bad proxy %q, use an http, https or socks5 URL", s)
+	}
+	if len(u.Host) == 0 {
+		return nil, fmt.Errorf("bad proxy %q, no host", s)
+	}
+	return u, nil
+}
+
+// checkProxy returns a proxy of a request, see withProxy.
+func checkProxy(req *http.Request) (*url.URL, error) {
+	if p, ok := req.Context().Value(proxyKey{}).(string); ok {
+		return parseProxy(p)
+	}
+	if len(*proxyAddr) > 0 {
+		return parseProxy(*proxyAddr)
+	}
+	return http.ProxyFromEnvironment(req)
+}
+
+// withProxy makes a request of a check of c use its proxy, if it has one.
+func withProxy(req *http.Request, c *ResConf) *http.Request {
+	if len(c.Proxy) == 0 {
+		return req
+	}
+	return req.WithContext(context.WithValue(req.Context(), proxyKey{}, c.Proxy))
+}
+
+// validateProxy checks if c can be checked through its proxy.
+func (c *ResConf) validateProxy() error {
+	if len(c.Type) > 0 && c.Type != "http" && c.Type != "keepalive" {
+		return fmt.Errorf("only http checks can use a proxy")
+	}
+	if c.Protocol == ProtocolHTTP3 && c.Proxy != ProxyNone {
+		return fmt.Errorf("http3 can't go through a proxy")
+	}
+	_, err := parseProxy(c.Proxy)
+	return err
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// Push notifications via self-hosted ntfy or Gotify servers and Pushover.
+// A push channel is used only for resources routed to it explicitly.
+///////////////////////////////////////////////////////////////////////////////
+
+const (
+	PushNtfy     = "ntfy"
+	PushGotify   = "gotify"
+	PushPushover = "pushover"
+
+	pushoverURL = "https://api.pushover.net/1/messages.json"
+)
+
+type PushConf struct {
+	Name string
+	Type string // ntfy, gotify or pushover
+	URL  string `json:",omitempty"` // a server, e.g. https://ntfy.sh
+	// Topic is a ntfy topic.
+	Topic string `json:",omitempty"`
+	// Token is a ntfy access token, a Gotify application token or
+	// a Pushover application token.
+	Token string `json:",omitempty"`
+	// User is a Pushover user key or oncall:<rotation>.
+	User string `json:",omitempty"`
+}
+
+type Push struct {
+	conf      *PushConf
+	rotations Rotations
+}
+
+func NewPush(c *PushConf, r Rotations) *Push {
+	return &Push{c, r}
+}
+
+// users returns Pushover user keys.
+func (p *Push) users() []string {
+	return p.rotations.resolve([]string{p.conf.User}, func(c *Contact) string { return c.PushoverUser })
+}
+
+func (p *Push) Preview(ev *Event) []*Delivery {
+	recipient := p.conf.URL
+	if len(p.conf.Topic) > 0 {
+		recipient += "/" + p.conf.Topic
+	}
+	if p.conf.Type == PushPushover {
+		recipient = "user " + strings.Join(p.users(), ", ")
+	}
+	return []*Delivery{{p.conf.Type, recipient, ev.message()}}
+}
+
+func (p *Push) Notify(ev *Event) error {
+	title := fmt.Sprintf("%s is %s", ev.Conf.Name, ev.New.State())
+	msg := ev.message()
+	// DOWN is urgent, anything else is informational.
+	urgent := ev.New.State() == StateDown
+
+	h := http.Header{}
+	switch p.conf.Type {
+	case PushNtfy:
+		h.Set("Content-Type", "text/plain")
+		h.Set("Title", title)
+		if urgent {
+			h.Set("Priority", "5")
+			h.Set("Tags", "rotating_light")
+		}
+		if len(p.conf.Token) > 0 {
+			h.Set("Authorization", "Bearer "+p.conf.Token)
+		}
+		return postBody(strings.TrimRight(p.conf.URL, "/")+"/"+url.PathEscape(p.conf.Topic), []byte(msg), h)
+	case PushGotify:
+		priority := 5
+		if urgent {
+			priority = 8
+		}
+		u := strings.TrimRight(p.conf.URL, "/") + "/message?token=" + url.QueryEscape(p.conf.Token)
+		return postJSON(u, map[string]interface{}{
+			"title":    title,
+			"message":  msg,
+			"priority": priority,
+		}, h)
+	case PushPushover:
+		form := url.Values{}
+		form.Set("token", p.conf.Token)
+		users := p.users()
+		if len(users) == 0 {
+			return fmt.Errorf("no Pushover user of %s", p.conf.Name)
+		}
+		form.Set("user", users[0])
+		form.Set("title", title)
+		form.Set("message", msg)
+		if urgent {
+			form.Set("priority", "1")
+		}
+		u := p.conf.URL
+		if u == "" {
+			u = pushoverURL
+		}
+		h.Set("Content-Type", "application/x-www-form-urlencoded")
+		return postBody(u, []byte(form.Encode()), h)
+	}
+	return fmt.Errorf("unknown push type %q of %s", p.conf.Type, p.conf.Name)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// Rate limiting per client IP: a token bucket for all requests and a
+// stricter one for admin requests (mutating, RPC and logins), protecting
+// the monitor from being overwhelmed or brute-forced.
+///////////////////////////////////////////////////////////////////////////////
+
+type RateLimitConf struct {
+	Rate  float64 // requests per second of a client IP
+	Burst int     `json:",omitempty"` // Rate (at least 1) if 0
+	// AdminRate and AdminBurst limit admin requests, Rate and Burst if 0.
+	AdminRate  float64 `json:",omitempty"`
+	AdminBurst int     `json:",omitempty"`
+	// Exempt networks, e.g. 10.0.0.0/8.
+	Exempt []string `json:",omitempty"`
+}
+
+// limitIdle is how long a bucket of a quiet client is kept.
+const limitIdle = 10 * time.Minute
+
+var rateLimited int64 // requests rejected, for metrics
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type limiter struct {
+	rate  float64
+	burst float64
+
+	m       sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+func newLimiter(rate float64, burst int) *limiter {
+	if burst <= 0 {
+		burst = int(math.Max(1, math.Ceil(rate)))
+	}
+	return &limiter{rate: rate, burst: float64(burst), buckets: make(map[string]*bucket), swept: time.Now()}
+}
+
+// allow takes a token of ip, it returns how long to wait for one if there
+// are none.
+func (l *limiter) allow(ip string) (bool, time.Duration) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	now := time.Now()
+	if now.Sub(l.swept) > limitIdle {
+		for k, b := range l.buckets {
+			if now.Sub(b.last) > limitIdle {
+				delete(l.buckets, k)
+			}
+		}
+		l.swept = now
+	}
+	b, ok := l.buckets[ip]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[ip] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+func adminRequest(req *http.Request) bool {
+	return mutating(req.Method) || req.URL.Path == "/login" || req.URL.Path == "/login/callback"
+}
+
+// RateLimit wraps h so clients over a limit get 429 Too Many Requests, all
+// requests pass if c is nil.
+func RateLimit(c *RateLimitConf, h http.Handler) http.Handler {
+	if c == nil || c.Rate <= 0 {
+		return h
+	}
+	var exempt []*net.IPNet
+	for _, s := range c.Exempt {
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			log.Fatalf("RateLimit: bad Exempt %q: %s", s, err)
+		}
+		exempt = append(exempt, n)
+	}
+	all := newLimiter(c.Rate, c.Burst)
+	admin := all
+	if c.AdminRate > 0 {
+		admin = newLimiter(c.AdminRate, c.AdminBurst)
+	}
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		host, _, err := net.SplitHostPort(req.RemoteAddr)
+		if err != nil {
+			host = req.RemoteAddr
+		}
+		if ip := net.ParseIP(host); ip != nil {
+			for _, n := range exempt {
+				if n.Contains(ip) {
+					h.ServeHTTP(rw, req)
+					return
+				}
+			}
+		}
+		ok, wait := all.allow(host)
+		if ok && admin != all && adminRequest(req) {
+			ok, wait = admin.allow(host)
+		}
+		if !ok {
+			atomic.AddInt64(&rateLimited, 1)
+			rw.Header().Set("Retry-After", fmt.Sprint(int(math.Ceil(wait.Seconds()))))
+			http.Error(rw, "too many requests", http.StatusTooManyRequests)
+			return
+		}
+		h.ServeHTTP(rw, req)
+	})
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// A Redis check: a redis://[user:password@]host[:port] or rediss:// (TLS)
+// Address is connected to, AUTHed if it has a password and sent a PING,
+// which should be answered with PONG. A round trip of the PING is a command
+// latency, reported as TTFB, so MaxTTFB applies to it.
+///////////////////////////////////////////////////////////////////////////////
+
+const redisTimeout = 10 * time.Second
+
+func init() {
+	RegisterChecker("redis", checkRedis)
+}
+
+func validateRedis(addr string) error {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "redis" && u.Scheme != "rediss" {
+		return fmt.Errorf("%s: scheme must be redis or rediss", addr)
+	}
+	if len(u.Hostname()) == 0 {
+		return fmt.Errorf("%s: no host", addr)
+	}
+	return nil
+}
+
+// redisCmd sends a command as RESP bulk strings and reads a simple reply,
+// an error reply is returned as an error.
+func redisCmd(conn net.Conn, r *bufio.Reader, args ...string) (string, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := conn.Write([]byte(b.String())); err != nil {
+		return "", err
+	}
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	switch {
+	case strings.HasPrefix(line, "+"):
+		return line[1:], nil
+	case strings.HasPrefix(line, "-"):
+		return "", fmt.Errorf("%s", line[1:])
+	}
+	return "", fmt.Errorf("unexpected reply %.20q", line)
+}
+
+func checkRedis(c *ResConf) *Status {
+	st := &Status{When: time.Now()}
+	fail := func(step string, err error) *Status {
+		st.Latency = time.Since(st.When)
+		st.StatusCode = UnknownError
+		st.Error = step + ": " + c.errorText(err)
+		return st
+	}
+	u, err := url.Parse(c.Address)
+	if err != nil {
+		return fail("connect", err)
+	}
+	d := &net.Dialer{Timeout: redisTimeout, Resolver: newResolver(c.resolver())}
+	var conn net.Conn
+	if u.Scheme == "rediss" {
+		conn, err = tls.DialWithDialer(d, dialNetwork("tcp", c.Family), urlHost(u, "6379"), &tls.Config{ServerName: u.Hostname()})
+	} else {
+		conn, err = d.Dial(dialNetwork("tcp", c.Family), urlHost(u, "6379"))
+	}
+	if err != nil {
+		return fail("connect", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(redisTimeout))
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		st.RemoteIP = addr.IP.String()
+	}
+	if tc, ok := conn.(*tls.Conn); ok {
+		cs := tc.ConnectionState()
+		st.TLSVersion = tls.VersionName(cs.Version)
+		st.Cert = certReport(c, &cs)
+	}
+
+	r := bufio.NewReader(conn)
+	if pass, ok := u.User.Password(); ok {
+		// An ACL user, default is a password of Redis before 6.
+		args := []string{"AUTH", u.User.Username(), pass}
+		if len(args[1]) == 0 || args[1] == "default" {
+			args = []string{"AUTH", pass}
+		}
+		if _, err := redisCmd(conn, r, args...); err != nil {
+			return fail("AUTH", err)
+		}
+	}
+	start := time.Now()
+	reply, err := redisCmd(conn, r, "PING")
+	if err != nil {
+		return fail("PING", err)
+	}
+	if reply != "PONG" {
+		return fail("PING", fmt.Errorf("%.20q instead of PONG", reply))
+	}
+	st.TTFB = time.Since(start)
+	st.Latency = time.Since(st.When)
+	st.Slow = c.slow(st)
+	st.StatusCode = http.StatusOK
+	return st
+}
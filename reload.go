@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// A reload part: on SIGHUP or a POST to /-/reload a config file is read
+// again and resources are added, removed and updated to match it, results
+// of unchanged and updated ones are kept. Other settings need a restart.
+///////////////////////////////////////////////////////////////////////////////
+
+const AuditReload = "reload"
+
+type ReloadResult struct {
+	Added   []string
+	Removed []string
+	Updated []string
+}
+
+// Reload applies resources of a config file to the live set. Resources
+// with Expires, e.g. added with a TTL, and discovered ones are kept even
+// if the file doesn't have them.
+func (s *StatusChecker) Reload(c *Caller, from string) (*ReloadResult, error) {
+	if len(*configFilePath) == 0 {
+		return nil, fmt.Errorf("no config file to reload")
+	}
+	config, err := LoadConfig(*configFilePath)
+	if err != nil {
+		return nil, err
+	}
+	return s.apply(config, *configFilePath, c, from), nil
+}
+
+// apply adds, removes and updates resources to match a config loaded from
+// a source.
+func (s *StatusChecker) apply(config *Config, source string, c *Caller, from string) *ReloadResult {
+	s.m.Lock()
+	live := make(map[string]*ResConf)
+	for _, el := range s.config.Configs {
+		live[el.Name] = el
+	}
+	if !sameSettings(s.config, config) {
+		slog.Warn("Reload: settings other than resources changed, they need a restart")
+	}
+	s.m.Unlock()
+
+	ret := &ReloadResult{Added: []string{}, Removed: []string{}, Updated: []string{}}
+	for _, cfg := range config.Configs {
+		old, ok := live[cfg.Name]
+		delete(live, cfg.Name)
+		if !ok {
+			s.Add(cfg)
+			audit(c, from, AuditAdd, cfg.Name, redactConf(cfg))
+			ret.Added = append(ret.Added, cfg.Name)
+		} else if !sameResource(old, cfg) {
+			if err := s.Update(cfg.Name, cfg); err != nil {
+				slog.Error("Reload", "error", err)
+				continue
+			}
+			audit(c, from, AuditUpdate, cfg.Name, redactConf(cfg))
+			ret.Updated = append(ret.Updated, cfg.Name)
+		}
+	}
+	for name, el := range live {
+		if !el.Expires.IsZero() || len(el.discovered) > 0 {
+			continue
+		}
+		if s.Remove(func(el *ResConf) bool { return el.Name == name }) {
+			audit(c, from, AuditRemove, name, nil)
+			ret.Removed = append(ret.Removed, name)
+		}
+	}
+	sort.Strings(ret.Removed)
+	audit(c, from, AuditReload, "", ret)
+	slog.Info("Reloaded", "source", source,
+		"added", len(ret.Added), "removed", len(ret.Removed), "updated", len(ret.Updated))
+	return ret
+}
+
+func sameResource(a, b *ResConf) bool {
+	ja, errA := json.Marshal(a)
+	jb, errB := json.Marshal(b)
+	return errA == nil && errB == nil && string(ja) == string(jb) && a.file == b.file
+}
+
+// sameSettings compares configs without their resources.
+func sameSettings(a, b *Config) bool {
+	ca, cb := *a, *b
+	ca.Configs, cb.Configs = nil, nil
+	ja, errA := json.Marshal(&ca)
+	jb, errB := json.Marshal(&cb)
+	return errA == nil && errB == nil && string(ja) == string(jb)
+}
+
+// reloadOnHangup reloads a config on every SIGHUP.
+func (s *StatusChecker) reloadOnHangup() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+	for range c {
+		if _, err := s.Reload(&Caller{Name: "SIGHUP", Role: RoleAdmin}, ""); err != nil {
+			slog.Error("Reload", "error", err)
+		}
+	}
+}
+
+func RegisterReloadHandler(sc *StatusChecker) {
+	describeAPI(&apiOperation{
+		Method: "POST", Path: "/-/reload", Summary: "Reload resources from a config file",
+		Responses: map[int]interface{}{http.StatusOK: &ReloadResult{}, http.StatusInternalServerError: &apiError{}},
+	})
+	http.HandleFunc("/-/reload", func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method != "POST" {
+			rw.Header().Set("Allow", "POST")
+			writeError(rw, http.StatusMethodNotAllowed, fmt.Errorf("%s not allowed", req.Method))
+			return
+		}
+		ret, err := sc.Reload(callerOf(req), req.RemoteAddr)
+		if err != nil {
+			writeError(rw, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(rw, http.StatusOK, ret)
+	})
+}
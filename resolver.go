@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// DNS resolvers of checks: a resource's Resolver or -resolver replaces the
+// system resolver, e.g. to see what a public resolver answers instead of
+// split-horizon DNS. A resolver is an address (port 53 by default),
+// tls://address for DNS over TLS (853) or an https URL for DNS over HTTPS.
+///////////////////////////////////////////////////////////////////////////////
+
+var dnsResolver = flag.String("resolver", "", "A DNS resolver of checks instead of the system one, e.g. 1.1.1.1, tls://1.1.1.1 or https://dns.google/dns-query.")
+
+// dohClient sends DNS over HTTPS queries, a DoH server itself is resolved
+// by the system resolver.
+var dohClient = &http.Client{Timeout: 10 * time.Second}
+
+// resolver returns a resolver of checks of c, empty for the system one.
+func (c *ResConf) resolver() string {
+	if len(c.Resolver) > 0 {
+		return c.Resolver
+	}
+	return *dnsResolver
+}
+
+func validateResolver(addr string) error {
+	switch {
+	case len(addr) == 0:
+		return nil
+	case strings.HasPrefix(addr, "https://"):
+		if u, err := url.Parse(addr); err != nil || len(u.Host) == 0 {
+			return fmt.Errorf("bad resolver %q", addr)
+		}
+		return nil
+	case strings.HasPrefix(addr, "tls://"):
+		addr = strings.TrimPrefix(addr, "tls://")
+	case strings.Contains(addr, "://"):
+		return fmt.Errorf("bad resolver %q, use an address, tls:// or https://", addr)
+	}
+	if len(addr) == 0 || strings.ContainsAny(addr, "/ ") {
+		return fmt.Errorf("bad resolver %q", addr)
+	}
+	return nil
+}
+
+// withPort adds a default port to an address without one.
+func withPort(addr, port string) string {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return net.JoinHostPort(strings.Trim(addr, "[]"), port)
+	}
+	return addr
+}
+
+// newResolver returns a resolver of an address, see validateResolver.
+func newResolver(addr string) *net.Resolver {
+	var dial func(ctx context.Context, network, _ string) (net.Conn, error)
+	switch {
+	case len(addr) == 0:
+		return net.DefaultResolver
+	case strings.HasPrefix(addr, "https://"):
+		dial = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return &dohConn{ctx: ctx, url: addr}, nil
+		}
+	case strings.HasPrefix(addr, "tls://"):
+		addr = withPort(strings.TrimPrefix(addr, "tls://"), "853")
+		dial = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			d := tls.Dialer{}
+			return d.DialContext(ctx, "tcp", addr)
+		}
+	default:
+		addr = withPort(addr, "53")
+		dial = func(ctx context.Context, network, _ string) (net.Conn, error) {
+			d := net.Dialer{}
+			return d.DialContext(ctx, network, addr)
+		}
+	}
+	return &net.Resolver{PreferGo: true, Dial: dial}
+}
+
+// dohConn sends a DNS query written to it as a DNS over HTTPS request and
+// reads its response. Like over TCP, messages are prefixed with lengths.
+type dohConn struct {
+	ctx  context.Context
+	url  string
+	req  bytes.Buffer
+	resp *bytes.Reader
+}
+
+func (c *dohConn) Write(b []byte) (int, error) {
+	return c.req.Write(b)
+}
+
+func (c *dohConn) Read(b []byte) (int, error) {
+	if c.resp == nil {
+		if err := c.roundTrip(); err != nil {
+			return 0, err
+		}
+	}
+	return c.resp.Read(b)
+}
+
+func (c *dohConn) roundTrip() error {
+	q := c.req.Bytes()
+	if len(q) < 2 {
+		return errors.New("no DNS query")
+	}
+	req, err := http.NewRequestWithContext(c.ctx, "POST", c.url, bytes.NewReader(q[2:]))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+	resp, err := dohClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", c.url, resp.Status)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 0xffff))
+	if err != nil {
+		return err
+	}
+	c.resp = bytes.NewReader(append([]byte{byte(len(body) >> 8), byte(len(body))}, body...))
+	return nil
+}
+
+func (c *dohConn) Close() error                       { return nil }
+func (c *dohConn) LocalAddr() net.Addr                { return dohAddr(c.url) }
+func (c *dohConn) RemoteAddr() net.Addr               { return dohAddr(c.url) }
+func (c *dohConn) SetDeadline(t time.Time) error      { return nil }
+func (c *dohConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *dohConn) SetWriteDeadline(t time.Time) error { return nil }
+
+type dohAddr string
+
+func (a dohAddr) Network() string { return "https" }
+func (a dohAddr) String() string  { return string(a) }
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// A REST admin API part: resources managed with plain HTTP and JSON, the
+// same operations as the RPC admin interface.
+///////////////////////////////////////////////////////////////////////////////
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+func writeJSON(rw http.ResponseWriter, code int, v interface{}) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(code)
+	if err := json.NewEncoder(rw).Encode(v); err != nil {
+		slog.Error("Write JSON", "error", err)
+	}
+}
+
+func writeError(rw http.ResponseWriter, code int, err error) {
+	writeJSON(rw, code, &apiError{err.Error()})
+}
+
+// conflict returns an error if a resource other than self has a name or an
+// address of c.
+func (s *StatusChecker) conflict(c, self *ResConf) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	for _, el := range s.config.Configs {
+		switch {
+		case el == self:
+		case el.Name == c.Name:
+			return fmt.Errorf("resource %q already exists", c.Name)
+		case el.Address == c.Address:
+			return fmt.Errorf("%s is already checked as %q", c.Address, el.Name)
+		}
+	}
+	return nil
+}
+
+// patchResource returns a copy of c with fields present in a JSON object
+// changed, Tags, Headers and Export are merged. It's validated.
+func patchResource(c *ResConf, fields []byte) (*ResConf, error) {
+	// A deep copy, fields are decoded over it.
+	var cfg ResConf
+	b, err := json.Marshal(c)
+	if err == nil {
+		err = json.Unmarshal(b, &cfg)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(fields, &cfg); err != nil {
+		return nil, err
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+type apiResource struct {
+	*ResConf
+	State  State
+	Status *Status `json:",omitempty"` // nil if never checked
+}
+
+// newAPIResource returns a resource as seen by a caller of req, redacted
+// (see redactConf) unless it's an admin, as only admins export resources.
+func newAPIResource(req *http.Request, c *ResConf, st *Status) *apiResource {
+	if !callerAllows(req, RoleAdmin) {
+		c = redactConf(c)
+	}
+	r := &apiResource{ResConf: c, State: st.State()}
+	if st.Checked() {
+		r.Status = st
+	}
+	return r
+}
+
+func RegisterResourcesHandler(sc *StatusChecker) {
+	nameParam := []apiParam{{Name: "name", In: "path"}}
+	describeAPI(&apiOperation{
+		Method: "GET", Path: "/api/resources", Summary: "List resources, credentials redacted for non-admins",
+		Params:    []apiParam{{Name: "tag", In: "query", Doc: "key or key=value, repeated to match all"}},
+		Responses: map[int]interface{}{http.StatusOK: []*apiResource{}},
+	}, &apiOperation{
+		Method: "POST", Path: "/api/resources", Summary: "Add a resource", Body: &ResConf{},
+		Responses: map[int]interface{}{http.StatusCreated: &ResConf{}, http.StatusBadRequest: &apiError{}, http.StatusConflict: &apiError{}},
+	}, &apiOperation{
+		Method: "GET", Path: "/api/resources/{name}", Summary: "Get a resource with its last status, credentials redacted for non-admins", Params: nameParam,
+		Responses: map[int]interface{}{http.StatusOK: &apiResource{}, http.StatusNotFound: &apiError{}},
+	}, &apiOperation{
+		Method: "PATCH", Path: "/api/resources/{name}", Summary: "Change fields of a resource present in a body", Params: nameParam, Body: &ResConf{},
+		Responses: map[int]interface{}{http.StatusOK: &ResConf{}, http.StatusBadRequest: &apiError{}, http.StatusNotFound: &apiError{}, http.StatusConflict: &apiError{}},
+	}, &apiOperation{
+		Method: "DELETE", Path: "/api/resources/{name}", Summary: "Remove a resource", Params: nameParam,
+		Responses: map[int]interface{}{http.StatusNoContent: nil, http.StatusNotFound: &apiError{}},
+	})
+	// GET lists resources, POST adds one.
+	http.HandleFunc("/api/resources", func(rw http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case "GET":
+			ret := []*apiResource{}
+			for _, el := range tagged(sc.Snapshot(), req.URL.Query()["tag"]) {
+				ret = append(ret, newAPIResource(req, el.Conf, el.Status))
+			}
+			writeJSON(rw, http.StatusOK, ret)
+		case "POST":
+			cfg := &ResConf{}
+			if err := json.NewDecoder(req.Body).Decode(cfg); err != nil {
+				writeError(rw, http.StatusBadRequest, err)
+				return
+			}
+			if len(cfg.Name) == 0 {
+				writeError(rw, http.StatusBadRequest, fmt.Errorf("no name"))
+				return
+			}
+			if err := cfg.validate(); err != nil {
+				writeError(rw, http.StatusBadRequest, err)
+				return
+			}
+			if err := sc.conflict(cfg, nil); err != nil {
+				writeError(rw, http.StatusConflict, err)
+				return
+			}
+			sc.Add(cfg)
+			audit(callerOf(req), req.RemoteAddr, AuditAdd, cfg.Name, redactConf(cfg))
+			rw.Header().Set("Location", "/api/resources/"+cfg.Name)
+			writeJSON(rw, http.StatusCreated, cfg)
+		default:
+			rw.Header().Set("Allow", "GET, POST")
+			writeError(rw, http.StatusMethodNotAllowed, fmt.Errorf("%s not allowed", req.Method))
+		}
+	})
+
+	// GET returns a resource with its last status, PATCH changes fields
+	// present in a body (Tags, Headers and Export are merged) and DELETE
+	// removes it.
+	http.HandleFunc("/api/resources/", func(rw http.ResponseWriter, req *http.Request) {
+		name := strings.TrimPrefix(req.URL.Path, "/api/resources/")
+		conf, st := sc.Lookup(name)
+		if conf == nil {
+			writeError(rw, http.StatusNotFound, fmt.Errorf("no resource named %q", name))
+			return
+		}
+		switch req.Method {
+		case "GET":
+			writeJSON(rw, http.StatusOK, newAPIResource(req, conf, st))
+		case "PATCH":
+			b, err := ioutil.ReadAll(req.Body)
+			if err != nil {
+				writeError(rw, http.StatusBadRequest, err)
+				return
+			}
+			cfg, err := patchResource(conf, b)
+			if err != nil {
+				writeError(rw, http.StatusBadRequest, err)
+				return
+			}
+			if err := sc.conflict(cfg, conf); err != nil {
+				writeError(rw, http.StatusConflict, err)
+				return
+			}
+			if err := sc.Update(name, cfg); err != nil {
+				writeError(rw, http.StatusNotFound, err)
+				return
+			}
+			audit(callerOf(req), req.RemoteAddr, AuditUpdate, name, redactConf(cfg))
+			writeJSON(rw, http.StatusOK, cfg)
+		case "DELETE":
+			if !sc.Remove(func(el *ResConf) bool { return el == conf }) {
+				writeError(rw, http.StatusNotFound, fmt.Errorf("no resource named %q", name))
+				return
+			}
+			audit(callerOf(req), req.RemoteAddr, AuditRemove, name, nil)
+			rw.WriteHeader(http.StatusNoContent)
+		default:
+			rw.Header().Set("Allow", "GET, PATCH, DELETE")
+			writeError(rw, http.StatusMethodNotAllowed, fmt.Errorf("%s not allowed", req.Method))
+		}
+	})
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/rpc"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// Roles of API keys and users: viewers read, operators also acknowledge and
+// watch, admins also add, change and remove resources. A key is an admin and
+// a user a viewer unless given a Role.
+///////////////////////////////////////////////////////////////////////////////
+
+type Role string
+
+const (
+	RoleViewer   Role = "viewer"
+	RoleOperator Role = "operator"
+	RoleAdmin    Role = "admin"
+	// RoleAgent reads like a viewer and reports results, see runAgent.
+	RoleAgent Role = "agent"
+)
+
+// level of a role, -1 if unknown.
+func (r Role) level() int {
+	switch r {
+	case RoleViewer, RoleAgent:
+		return 0
+	case RoleOperator:
+		return 1
+	case RoleAdmin:
+		return 2
+	}
+	return -1
+}
+
+func (r Role) allows(need Role) bool {
+	return r.level() >= 0 && r.level() >= need.level()
+}
+
+func checkRole(r Role) error {
+	if len(r) > 0 && r.level() < 0 {
+		return fmt.Errorf("unknown role %q", r)
+	}
+	return nil
+}
+
+// Caller is who makes a request, a key or a logged in user.
+type Caller struct {
+	Name string
+	Role Role
+}
+
+type callerKey struct{}
+
+func withCaller(req *http.Request, c *Caller) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), callerKey{}, c))
+}
+
+// callerOf returns a caller of a request, nil if it's anonymous.
+func callerOf(req *http.Request) *Caller {
+	c, _ := req.Context().Value(callerKey{}).(*Caller)
+	return c
+}
+
+// requiredRole of a request, RPC and gRPC methods are checked by
+// AdminServer.
+func requiredRole(req *http.Request) Role {
+	switch {
+	case req.URL.Path == "/api/audit":
+		return RoleAdmin
+	case !mutating(req.Method), req.Method == "CONNECT", isGRPC(req):
+		return RoleViewer
+	case req.URL.Path == "/api/ack":
+		return RoleOperator
+	}
+	return RoleAdmin
+}
+
+// callerAllows returns whether a caller of a request has a role, an
+// anonymous one has all of them only if there are no keys.
+func callerAllows(req *http.Request, need Role) bool {
+	if c := callerOf(req); c != nil {
+		return c.Role.allows(need)
+	}
+	return openAccess
+}
+
+var errKeyRequired = errors.New("API key required")
+
+type roleError struct {
+	need Role
+}
+
+func (e *roleError) Error() string {
+	return fmt.Sprintf("%s role required", e.need)
+}
+
+// allows returns whether a caller of an RPC connection has a role, an
+// anonymous one has all of them only if there are no keys.
+func (a *AdminServer) allows(need Role) bool {
+	if a.caller == nil {
+		return openAccess
+	}
+	return a.caller.Role.allows(need)
+}
+
+// allow returns an error unless a caller has a role, see allows.
+func (a *AdminServer) allow(need Role) error {
+	switch {
+	case a.allows(need):
+		return nil
+	case a.caller == nil:
+		return errKeyRequired
+	}
+	slog.Warn("RPC denied", "caller", a.caller.Name, "role", a.caller.Role, "needs", need)
+	return &roleError{need}
+}
+
+// allowAgent checks if a caller may act as an agent: an agent or an admin,
+// as resources may hold credentials.
+func (a *AdminServer) allowAgent() error {
+	if a.caller != nil && a.caller.Role == RoleAgent {
+		return nil
+	}
+	return a.allow(RoleAdmin)
+}
+
+// RegisterAdminHandler serves the RPC admin interface like rpc.HandleHTTP,
+// with an AdminServer per connection knowing its caller.
+func RegisterAdminHandler(sc *StatusChecker) {
+	http.HandleFunc(rpc.DefaultRPCPath, func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method != "CONNECT" {
+			http.Error(rw, "CONNECT required", http.StatusMethodNotAllowed)
+			return
+		}
+		conn, _, err := rw.(http.Hijacker).Hijack()
+		if err != nil {
+			slog.Error("RPC hijacking", "from", req.RemoteAddr, "error", err)
+			return
+		}
+		io.WriteString(conn, "HTTP/1.0 200 Connected to Go RPC\n\n")
+		srv := rpc.NewServer()
+		srv.Register(&AdminServer{sc, callerOf(req), req.RemoteAddr})
+		srv.ServeConn(conn)
+	})
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"log/slog"
+	"path"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// A notification routing part: decides which channels get events of
+// a resource, e.g. database checks page the DBA channel while website checks
+// go to web-ops.
+///////////////////////////////////////////////////////////////////////////////
+
+// Route sends events of matching resources to Channels. All non empty
+// criteria must match, a route without criteria matches every resource.
+type Route struct {
+	Name     string `json:",omitempty"` // a resource name pattern, e.g. db-*
+	Tag      string `json:",omitempty"` // "key" or "key=value"
+	Group    string `json:",omitempty"`
+	Channels []string
+}
+
+func (r *Route) Match(c *ResConf) bool {
+	if len(r.Name) > 0 {
+		if ok, err := path.Match(r.Name, c.Name); !ok || err != nil {
+			return false
+		}
+	}
+	if len(r.Tag) > 0 && !c.hasTag(r.Tag) {
+		return false
+	}
+	if len(r.Group) > 0 && r.Group != c.Group {
+		return false
+	}
+	return true
+}
+
+// Channel is a named notifier. An OptIn channel gets only events of
+// resources routed to it.
+type Channel struct {
+	Name  string
+	OptIn bool
+	Notifier
+}
+
+type Router struct {
+	routes   []*Route
+	channels []*Channel
+}
+
+func NewRouter(c *Config) *Router {
+	r := &Router{c.Routes, c.Channels()}
+	names := make(map[string]bool)
+	for _, ch := range r.channels {
+		names[ch.Name] = true
+	}
+	for _, rt := range r.routes {
+		for _, n := range rt.Channels {
+			if !names[n] {
+				slog.Warn("Route to unknown channel", "channel", n)
+			}
+		}
+	}
+	return r
+}
+
+// Route returns channels for a resource: ones listed in its Notify and ones
+// of matching routes. Without any routes all but opt-in channels are used.
+func (r *Router) Route(c *ResConf) []*Channel {
+	want := make(map[string]bool)
+	for _, n := range c.Notify {
+		want[n] = true
+	}
+	for _, rt := range r.routes {
+		if rt.Match(c) {
+			for _, n := range rt.Channels {
+				want[n] = true
+			}
+		}
+	}
+	var ret []*Channel
+	for _, ch := range r.channels {
+		if want[ch.Name] || (len(r.routes) == 0 && !ch.OptIn) {
+			ret = append(ret, ch)
+		}
+	}
+	return ret
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"time"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// Result sampling: a history of a frequently checked resource keeps one
+// aggregated result per ResConf.Sample period (min/max/avg latency, error
+// count) instead of every raw result.
+///////////////////////////////////////////////////////////////////////////////
+
+type Sample struct {
+	Period     time.Duration
+	Count      int
+	Errors     int // DOWN results
+	MinLatency time.Duration
+	MaxLatency time.Duration
+
+	totalLatency, totalTTFB time.Duration
+}
+
+// upShare returns a share of results which weren't DOWN.
+func (s *Sample) upShare() float64 {
+	return float64(s.Count-s.Errors) / float64(s.Count)
+}
+
+// addHistory appends a result to a history of a resource, it must be called
+// with statusMutex held.
+func (s *StatusChecker) addHistory(c *ResConf, st *Status) {
+	h := s.history[c.Address]
+	if period := parseThreshold(c.Sample); period > 0 {
+		bucket := st.When.Truncate(period)
+		if n := len(h); n > 0 && h[n-1].Sample != nil && h[n-1].When.Equal(bucket) {
+			// A copy, a previous one may be read by History callers.
+			agg, sm := *h[n-1], *h[n-1].Sample
+			agg.Sample = &sm
+			agg.merge(st)
+			h[n-1] = &agg
+			return
+		}
+		agg := &Status{When: bucket, Sample: &Sample{Period: period, MinLatency: st.Latency}}
+		agg.merge(st)
+		st = agg
+	}
+	h = append(h, st)
+	if len(h) > historySize {
+		h = h[len(h)-historySize:]
+	}
+	s.history[c.Address] = h
+}
+
+// merge adds a raw result to a sample, its status code and error are of the
+// last result.
+func (agg *Status) merge(st *Status) {
+	sm := agg.Sample
+	sm.Count++
+	if st.State() == StateDown {
+		sm.Errors++
+	}
+	if st.Latency < sm.MinLatency {
+		sm.MinLatency = st.Latency
+	}
+	if st.Latency > sm.MaxLatency {
+		sm.MaxLatency = st.Latency
+	}
+	sm.totalLatency += st.Latency
+	sm.totalTTFB += st.TTFB
+	agg.Latency = sm.totalLatency / time.Duration(sm.Count)
+	agg.TTFB = sm.totalTTFB / time.Duration(sm.Count)
+	agg.StatusCode, agg.Expected, agg.Error = st.StatusCode, st.Expected, st.Error
+	agg.Slow, agg.Degraded = st.Slow, st.Degraded
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// Config saving: a config is written to a temporary file, synced and renamed
+// over the old one, so a crash mid-save never leaves a truncated config.
+// Previous versions are kept as <config>.1 (the newest) to <config>.N. A
+// config is saved shortly after a change, periodically and on interruption.
+///////////////////////////////////////////////////////////////////////////////
+
+var configBackups = flag.Int("config-backups", 3, "How many previous versions of a saved config are kept.")
+
+// writeConfigFile atomically replaces a file at path with b, an unchanged
+// file isn't touched.
+func writeConfigFile(path string, b []byte) error {
+	mode := os.FileMode(0644)
+	old, err := ioutil.ReadFile(path)
+	if err == nil {
+		if bytes.Equal(old, b) {
+			return nil
+		}
+		if fi, err := os.Stat(path); err == nil {
+			mode = fi.Mode().Perm()
+		}
+		if err := backupConfig(path, old, mode); err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	dir, name := filepath.Split(path)
+	f, err := ioutil.TempFile(dir, "."+name+".tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp) // fails after the rename
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(mode); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	// The rename itself survives a crash once a directory is synced.
+	if d, err := os.Open(filepath.Join(dir, ".")); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// backupConfig rotates backups of a config at path and saves old as the
+// newest one.
+func backupConfig(path string, old []byte, mode os.FileMode) error {
+	if *configBackups <= 0 {
+		return nil
+	}
+	for i := *configBackups - 1; i > 0; i-- {
+		err := os.Rename(fmt.Sprintf("%s.%d", path, i), fmt.Sprintf("%s.%d", path, i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return ioutil.WriteFile(path+".1", old, mode)
+}
+
+var autosaveEvery = flag.Duration("autosave", 5*time.Minute, "How often a config is saved, besides shortly after a change and on interruption. 0 saves only on interruption.")
+
+// autosaveDelay batches changes made at once, e.g. by a reload.
+const autosaveDelay = 2 * time.Second
+
+// changed marks a config for saving, it never blocks.
+func (s *StatusChecker) changed() {
+	select {
+	case s.dirty <- true:
+	default:
+	}
+}
+
+// autosave saves a config shortly after changes and every -autosave, so
+// resources added through an API survive a crash or a SIGKILL.
+func (s *StatusChecker) autosave() {
+	if *autosaveEvery <= 0 || len(*configFilePath) == 0 {
+		return
+	}
+	t := time.NewTicker(*autosaveEvery)
+	defer t.Stop()
+	for {
+		select {
+		case <-s.dirty:
+			time.Sleep(autosaveDelay)
+			// Changes made while sleeping are saved now.
+			select {
+			case <-s.dirty:
+			default:
+			}
+		case <-t.C:
+		}
+		if err := s.save(); err != nil {
+			slog.Error("Autosave", "error", err)
+		}
+	}
+}
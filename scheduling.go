@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// Priority scheduling: checks wait for workers in a queue per priority,
+// higher ones go first. When the queue is full the newest lowest priority
+// checks are shed, critical ones never are, so an overloaded monitor still
+// covers what matters most.
+///////////////////////////////////////////////////////////////////////////////
+
+const (
+	PriorityCritical = "critical"
+	PriorityHigh     = "high"
+	PriorityNormal   = "normal"
+	PriorityLow      = "low"
+)
+
+// priorities from the highest.
+var priorities = []string{PriorityCritical, PriorityHigh, PriorityNormal, PriorityLow}
+
+// queueSize is how many checks may wait for workers.
+const queueSize = 200
+
+func priorityIndex(p string) (int, error) {
+	if len(p) == 0 {
+		p = PriorityNormal
+	}
+	for i, el := range priorities {
+		if el == p {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown priority %q", p)
+}
+
+type queued struct {
+	conf     *ResConf
+	deferred bool // a higher priority check went first
+}
+
+type scheduler struct {
+	m       sync.Mutex
+	ready   *sync.Cond
+	waiting [][]*queued // by priority index
+	pending map[*ResConf]bool
+	closed  bool // no more checks are popped
+	stops   int  // how many workers should stop, see stopOne
+	// By priority index: checks which waited for a higher priority one,
+	// dropped as already waiting and shed from a full queue.
+	deferred []int64
+	skipped  []int64
+	shed     []int64
+}
+
+func newScheduler() *scheduler {
+	s := &scheduler{
+		waiting:  make([][]*queued, len(priorities)),
+		pending:  make(map[*ResConf]bool),
+		deferred: make([]int64, len(priorities)),
+		skipped:  make([]int64, len(priorities)),
+		shed:     make([]int64, len(priorities)),
+	}
+	s.ready = sync.NewCond(&s.m)
+	return s
+}
+
+func (s *scheduler) len() int {
+	n := 0
+	for _, q := range s.waiting {
+		n += len(q)
+	}
+	return n
+}
+
+func (s *scheduler) push(c *ResConf) {
+	p, _ := priorityIndex(c.Priority)
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.closed {
+		return
+	}
+	if s.pending[c] {
+		s.skipped[p]++
+		return
+	}
+	if s.len() >= queueSize {
+		// Shed the newest check of the lowest priority, c included. A
+		// critical one is queued anyway.
+		low := len(priorities) - 1
+		for len(s.waiting[low]) == 0 && low > p {
+			low--
+		}
+		if low > p {
+			q := s.waiting[low]
+			last := q[len(q)-1]
+			s.waiting[low] = q[:len(q)-1]
+			delete(s.pending, last.conf)
+			s.shed[low]++
+			slog.Warn("Queue full, shed a check", "resource", last.conf.Name)
+		} else if p > 0 {
+			s.shed[p]++
+			slog.Warn("Queue full, shed a check", "resource", c.Name)
+			return
+		}
+	}
+	s.waiting[p] = append(s.waiting[p], &queued{conf: c})
+	s.pending[c] = true
+	s.ready.Signal()
+}
+
+// pop waits for a check of the highest priority, it returns nil once the
+// scheduler is closed or a worker should stop.
+func (s *scheduler) pop() *ResConf {
+	s.m.Lock()
+	defer s.m.Unlock()
+	for s.len() == 0 && !s.closed && s.stops == 0 {
+		s.ready.Wait()
+	}
+	if s.closed {
+		return nil
+	}
+	if s.stops > 0 {
+		s.stops--
+		return nil
+	}
+	for p, q := range s.waiting {
+		if len(q) == 0 {
+			continue
+		}
+		c := q[0].conf
+		s.waiting[p] = q[1:]
+		delete(s.pending, c)
+		for lower := p + 1; lower < len(priorities); lower++ {
+			for _, el := range s.waiting[lower] {
+				if !el.deferred {
+					el.deferred = true
+					s.deferred[lower]++
+				}
+			}
+		}
+		return c
+	}
+	return nil
+}
+
+// closeOn stops the scheduler when ctx is done, waiting checks are dropped
+// and workers return.
+func (s *scheduler) closeOn(ctx context.Context) {
+	<-ctx.Done()
+	s.m.Lock()
+	s.closed = true
+	s.m.Unlock()
+	s.ready.Broadcast()
+}
+
+// stopOne makes one waiting worker stop.
+func (s *scheduler) stopOne() {
+	s.m.Lock()
+	s.stops++
+	s.m.Unlock()
+	s.ready.Signal()
+}
+
+// size returns how many checks wait for workers.
+func (s *scheduler) size() int {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.len()
+}
+
+type schedulerStats struct {
+	Waiting  int
+	Deferred int64
+	Skipped  int64
+	Shed     int64
+}
+
+// stats returns stats by priority.
+func (s *scheduler) stats() map[string]schedulerStats {
+	s.m.Lock()
+	defer s.m.Unlock()
+	ret := make(map[string]schedulerStats)
+	for i, p := range priorities {
+		ret[p] = schedulerStats{len(s.waiting[i]), s.deferred[i], s.skipped[i], s.shed[i]}
+	}
+	return ret
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+	"os"
+	"strconv"
+	"strings"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// Password hashes of login users: scrypt (RFC 7914) with a random salt,
+// "scrypt:<N>:<r>:<p>:<salt>:<hash>" with base64 salt and hash, made by
+// -mode hashpw.
+///////////////////////////////////////////////////////////////////////////////
+
+// Parameters of new hashes, 32 MiB and about 0.1s per check.
+const (
+	scryptN   = 1 << 15
+	scryptR   = 8
+	scryptP   = 1
+	scryptLen = 32
+)
+
+// scrypt derives a key of keyLen bytes from a password and a salt.
+func scrypt(password, salt []byte, n, r, p, keyLen int) ([]byte, error) {
+	if n < 2 || n&(n-1) != 0 || r < 1 || p < 1 || uint64(r)*uint64(p) >= 1<<30 || n > 1<<20 || r > 32 || p > 16 {
+		return nil, errors.New("bad scrypt parameters")
+	}
+	b, err := pbkdf2.Key(sha256.New, string(password), salt, 1, p*128*r)
+	if err != nil {
+		return nil, err
+	}
+	x := make([]uint32, 32*r)
+	v := make([]uint32, 32*r*n)
+	for i := 0; i < p; i++ {
+		block := b[i*128*r : (i+1)*128*r]
+		for j := range x {
+			x[j] = binary.LittleEndian.Uint32(block[j*4:])
+		}
+		scryptROMix(x, v, n, r)
+		for j := range x {
+			binary.LittleEndian.PutUint32(block[j*4:], x[j])
+		}
+	}
+	return pbkdf2.Key(sha256.New, string(password), b, 1, keyLen)
+}
+
+// scryptROMix mixes x of 2*r 64-byte blocks in place, with v of n copies.
+func scryptROMix(x, v []uint32, n, r int) {
+	y := make([]uint32, len(x))
+	for i := 0; i < n; i++ {
+		copy(v[i*len(x):], x)
+		scryptBlockMix(x, y, r)
+	}
+	for i := 0; i < n; i++ {
+		j := int(x[(2*r-1)*16]) & (n - 1)
+		for k, w := range v[j*len(x) : (j+1)*len(x)] {
+			x[k] ^= w
+		}
+		scryptBlockMix(x, y, r)
+	}
+}
+
+// scryptBlockMix mixes b in place, using y as a scratch space.
+func scryptBlockMix(b, y []uint32, r int) {
+	var t [16]uint32
+	copy(t[:], b[(2*r-1)*16:])
+	for i := 0; i < 2*r; i++ {
+		for k := range t {
+			t[k] ^= b[i*16+k]
+		}
+		salsa208(&t)
+		// Even blocks go to the first half, odd ones to the second.
+		copy(y[(i/2+(i%2)*r)*16:], t[:])
+	}
+	copy(b, y)
+}
+
+// salsa208 is the Salsa20/8 core.
+func salsa208(b *[16]uint32) {
+	x := *b
+	for i := 0; i < 8; i += 2 {
+		x[4] ^= bits.RotateLeft32(x[0]+x[12], 7)
+		x[8] ^= bits.RotateLeft32(x[4]+x[0], 9)
+		x[12] ^= bits.RotateLeft32(x[8]+x[4], 13)
+		x[0] ^= bits.RotateLeft32(x[12]+x[8], 18)
+		x[9] ^= bits.RotateLeft32(x[5]+x[1], 7)
+		x[13] ^= bits.RotateLeft32(x[9]+x[5], 9)
+		x[1] ^= bits.RotateLeft32(x[13]+x[9], 13)
+		x[5] ^= bits.RotateLeft32(x[1]+x[13], 18)
+		x[14] ^= bits.RotateLeft32(x[10]+x[6], 7)
+		x[2] ^= bits.RotateLeft32(x[14]+x[10], 9)
+		x[6] ^= bits.RotateLeft32(x[2]+x[14], 13)
+		x[10] ^= bits.RotateLeft32(x[6]+x[2], 18)
+		x[3] ^= bits.RotateLeft32(x[15]+x[11], 7)
+		x[7] ^= bits.RotateLeft32(x[3]+x[15], 9)
+		x[11] ^= bits.RotateLeft32(x[7]+x[3], 13)
+		x[15] ^= bits.RotateLeft32(x[11]+x[7], 18)
+
+		x[1] ^= bits.RotateLeft32(x[0]+x[3], 7)
+		x[2] ^= bits.RotateLeft32(x[1]+x[0], 9)
+		x[3] ^= bits.RotateLeft32(x[2]+x[1], 13)
+		x[0] ^= bits.RotateLeft32(x[3]+x[2], 18)
+		x[6] ^= bits.RotateLeft32(x[5]+x[4], 7)
+		x[7] ^= bits.RotateLeft32(x[6]+x[5], 9)
+		x[4] ^= bits.RotateLeft32(x[7]+x[6], 13)
+		x[5] ^= bits.RotateLeft32(x[4]+x[7], 18)
+		x[11] ^= bits.RotateLeft32(x[10]+x[9], 7)
+		x[8] ^= bits.RotateLeft32(x[11]+x[10], 9)
+		x[9] ^= bits.RotateLeft32(x[8]+x[11], 13)
+		x[10] ^= bits.RotateLeft32(x[9]+x[8], 18)
+		x[12] ^= bits.RotateLeft32(x[15]+x[14], 7)
+		x[13] ^= bits.RotateLeft32(x[12]+x[15], 9)
+		x[14] ^= bits.RotateLeft32(x[13]+x[12], 13)
+		x[15] ^= bits.RotateLeft32(x[14]+x[13], 18)
+	}
+	for i := range b {
+		b[i] += x[i]
+	}
+}
+
+// hashPassword returns a scrypt hash of a password with a random salt.
+func hashPassword(password string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key, err := scrypt([]byte(password), salt, scryptN, scryptR, scryptP, scryptLen)
+	if err != nil {
+		return "", err
+	}
+	enc := base64.RawStdEncoding
+	return fmt.Sprintf("scrypt:%d:%d:%d:%s:%s", scryptN, scryptR, scryptP, enc.EncodeToString(salt), enc.EncodeToString(key)), nil
+}
+
+// checkPasswordHash reports whether password matches a hash of
+// hashPassword.
+func checkPasswordHash(hash, password string) bool {
+	f := strings.Split(hash, ":")
+	if len(f) != 6 || f[0] != "scrypt" {
+		return false
+	}
+	var params [3]int
+	for i := range params {
+		v, err := strconv.Atoi(f[i+1])
+		if err != nil {
+			return false
+		}
+		params[i] = v
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(f[4])
+	if err != nil {
+		return false
+	}
+	want, err := base64.RawStdEncoding.DecodeString(f[5])
+	if err != nil || len(want) == 0 {
+		return false
+	}
+	got, err := scrypt([]byte(password), salt, params[0], params[1], params[2], len(want))
+	return err == nil && subtle.ConstantTimeCompare(got, want) == 1
+}
+
+// runHashPassword prints a hash of a password read from stdin, for -mode
+// hashpw.
+func runHashPassword() error {
+	fmt.Fprint(os.Stderr, "Password: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && len(line) == 0 {
+		return err
+	}
+	h, err := hashPassword(strings.TrimRight(line, "\r\n"))
+	if err != nil {
+		return err
+	}
+	fmt.Println(h)
+	return nil
+}
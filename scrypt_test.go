@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestScrypt(t *testing.T) {
+	// RFC 7914, section 12.
+	tests := []struct {
+		password, salt string
+		n, r, p        int
+		want           string
+	}{
+		{"", "", 16, 1, 1, "77d6576238657b203b19ca42c18a0497f16b4844e3074ae8dfdffa3fede21442" +
+			"fcd0069ded0948f8326a753a0fc81f17e8d3e0fb2e0d3628cf35e20c38d18906"},
+		{"password", "NaCl", 1024, 8, 16, "fdbabe1c9d3472007856e7190d01e9fe7c6ad7cbc8237830e77376634b373162" +
+			"2eaf30d92e22a3886ff109279d9830dac727afb94a83ee6d8360cbdfa2cc0640"},
+	}
+	for _, tt := range tests {
+		got, err := scrypt([]byte(tt.password), []byte(tt.salt), tt.n, tt.r, tt.p, 64)
+		if err != nil || hex.EncodeToString(got) != tt.want {
+			t.Errorf("scrypt(%q, %q) = %x, %v", tt.password, tt.salt, got, err)
+		}
+	}
+	if _, err := scrypt(nil, nil, 1000, 1, 1, 32); err == nil {
+		t.Errorf("N not a power of 2")
+	}
+
+	h, err := hashPassword("secret")
+	if err != nil || !strings.HasPrefix(h, "scrypt:32768:8:1:") {
+		t.Fatalf("hash %s, %v", h, err)
+	}
+	if !checkPasswordHash(h, "secret") || checkPasswordHash(h, "Secret") || checkPasswordHash("scrypt:16:1:1:AA:", "secret") {
+		t.Errorf("checks of %s", h)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// An SMTP check: a banner of an smtp://host:port or smtps:// (TLS from the
+// start) Address is read, EHLO sent and with StartTLS the connection is
+// upgraded and greeted again. A resource is DOWN at a step a server fails
+// with its reply, e.g. "EHLO: 421 too busy".
+///////////////////////////////////////////////////////////////////////////////
+
+const smtpTimeout = 10 * time.Second
+
+func init() {
+	RegisterChecker("smtp", checkSMTP)
+}
+
+func validateSMTP(c *ResConf) error {
+	u, err := url.Parse(c.Address)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "smtp" && u.Scheme != "smtps" {
+		return fmt.Errorf("%s: scheme must be smtp or smtps", c.Address)
+	}
+	if u.Scheme == "smtps" && c.StartTLS {
+		return fmt.Errorf("StartTLS needs an smtp address, smtps is TLS already")
+	}
+	return validateTCP(u.Host)
+}
+
+// smtpHello returns a name sent in EHLO.
+func smtpHello() string {
+	if name, err := os.Hostname(); err == nil && strings.Contains(name, ".") {
+		return name
+	}
+	return "localhost"
+}
+
+// smtpCmd sends a command and reads a reply with an expected code, it
+// returns lines of the reply.
+func smtpCmd(tp *textproto.Conn, expect int, format string, args ...interface{}) ([]string, error) {
+	if _, err := tp.Cmd(format, args...); err != nil {
+		return nil, err
+	}
+	_, msg, err := tp.ReadResponse(expect)
+	return strings.Split(msg, "\n"), err
+}
+
+func checkSMTP(c *ResConf) *Status {
+	st := &Status{When: time.Now()}
+	fail := func(step string, err error) *Status {
+		st.Latency = time.Since(st.When)
+		st.StatusCode = UnknownError
+		st.Error = step + ": " + c.errorText(err)
+		return st
+	}
+	u, err := url.Parse(c.Address)
+	if err != nil {
+		return fail("connect", err)
+	}
+	tlsConf := &tls.Config{ServerName: u.Hostname()}
+	d := &net.Dialer{Timeout: smtpTimeout, Resolver: newResolver(c.resolver())}
+	var conn net.Conn
+	if u.Scheme == "smtps" {
+		conn, err = tls.DialWithDialer(d, dialNetwork("tcp", c.Family), u.Host, tlsConf)
+	} else {
+		conn, err = d.Dial(dialNetwork("tcp", c.Family), u.Host)
+	}
+	if err != nil {
+		return fail("connect", err)
+	}
+	defer func() { conn.Close() }()
+	conn.SetDeadline(time.Now().Add(smtpTimeout))
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		st.RemoteIP = addr.IP.String()
+	}
+
+	tp := textproto.NewConn(conn)
+	_, banner, err := tp.ReadResponse(220)
+	if err != nil {
+		return fail("banner", err)
+	}
+	st.TTFB = time.Since(st.When)
+	if !c.Private {
+		st.Details = append(st.Details, "banner: "+strings.SplitN(banner, "\n", 2)[0])
+	}
+	hello := smtpHello()
+	ext, err := smtpCmd(tp, 250, "EHLO %s", hello)
+	if err != nil {
+		return fail("EHLO", err)
+	}
+	if c.StartTLS {
+		if !smtpHasExtension(ext, "STARTTLS") {
+			return fail("STARTTLS", fmt.Errorf("not offered"))
+		}
+		if _, err := smtpCmd(tp, 220, "STARTTLS"); err != nil {
+			return fail("STARTTLS", err)
+		}
+		tc := tls.Client(conn, tlsConf)
+		conn = tc
+		if err := tc.Handshake(); err != nil {
+			return fail("STARTTLS", err)
+		}
+		tp = textproto.NewConn(conn)
+		// A server forgets what it knew before TLS, so it's greeted again.
+		if ext, err = smtpCmd(tp, 250, "EHLO %s", hello); err != nil {
+			return fail("EHLO after STARTTLS", err)
+		}
+	}
+	if tc, ok := conn.(*tls.Conn); ok {
+		cs := tc.ConnectionState()
+		st.TLSVersion = tls.VersionName(cs.Version)
+		st.Cert = certReport(c, &cs)
+	}
+	if len(ext) > 1 {
+		st.Details = append(st.Details, "extensions: "+strings.Join(ext[1:], ", "))
+	}
+	smtpCmd(tp, 221, "QUIT")
+	st.Latency = time.Since(st.When)
+	st.StatusCode = http.StatusOK
+	return st
+}
+
+// smtpHasExtension checks EHLO reply lines for an extension.
+func smtpHasExtension(ext []string, name string) bool {
+	for _, line := range ext {
+		if f := strings.Fields(line); len(f) > 0 && strings.EqualFold(f[0], name) {
+			return true
+		}
+	}
+	return false
+}
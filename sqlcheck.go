@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// Database checks: a connection is opened with a DSN Address of a mysql or
+// postgres resource and SELECT 1 is run. Failures are reported by a step,
+// refused, auth or query, and the query time is TTFB, so MaxTTFB makes a
+// slow database DEGRADED. Drivers are compiled in with -tags mysql or
+// -tags postgres, see mysql.go and postgres.go.
+///////////////////////////////////////////////////////////////////////////////
+
+const sqlTimeout = 10 * time.Second
+
+// sqlDrivers are check types of database/sql drivers, registered if a
+// driver of a type is compiled in.
+var sqlDrivers = []string{"mysql", "postgres"}
+
+func init() {
+	for _, name := range sqlDrivers {
+		for _, driver := range sql.Drivers() {
+			if driver == name {
+				RegisterChecker(name, checkSQL)
+			}
+		}
+	}
+}
+
+// sqlStep names what a connection error means.
+func sqlStep(err error) string {
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return "refused"
+	}
+	// MySQL error 1045 and SQLSTATE 28000 or 28P01 of PostgreSQL.
+	msg := err.Error()
+	for _, s := range []string{"Access denied", "authentication failed", "28000", "28P01"} {
+		if strings.Contains(msg, s) {
+			return "auth"
+		}
+	}
+	return "connect"
+}
+
+func checkSQL(c *ResConf) *Status {
+	st := &Status{When: time.Now()}
+	fail := func(step string, err error) *Status {
+		st.Latency = time.Since(st.When)
+		st.StatusCode = UnknownError
+		st.Error = step + ": " + c.errorText(err)
+		return st
+	}
+	db, err := sql.Open(c.Type, c.Address)
+	if err != nil {
+		return fail("connect", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	ctx, cancel := context.WithTimeout(context.Background(), sqlTimeout)
+	defer cancel()
+	conn, err := db.Conn(ctx)
+	if err == nil {
+		defer conn.Close()
+		err = conn.PingContext(ctx)
+	}
+	if err != nil {
+		return fail(sqlStep(err), err)
+	}
+	start := time.Now()
+	var one int
+	if err := conn.QueryRowContext(ctx, "SELECT 1").Scan(&one); err != nil {
+		return fail("query", err)
+	}
+	if one != 1 {
+		return fail("query", fmt.Errorf("SELECT 1 returned %d", one))
+	}
+	st.TTFB = time.Since(start)
+	st.Latency = time.Since(st.When)
+	st.Slow = c.slow(st)
+	st.StatusCode = http.StatusOK
+	return st
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRedactDSN(t *testing.T) {
+	for dsn, want := range map[string]string{
+		"postgres://app:secret@db:5432/app?sslmode=disable": "postgres://REDACTED@db:5432/app?sslmode=disable",
+		"postgres://db/app?user=app&password=secret":        "postgres://db/app?user=app&password=REDACTED",
+		"host=db user=app password=secret dbname=app":       "host=db user=app password=REDACTED dbname=app",
+		"password='se cr\\'et' host=db":                     "password=REDACTED host=db",
+		"host=db PASSWORD = secret":                         "host=db PASSWORD = REDACTED",
+		"app:secret@tcp(db:3306)/app":                       "REDACTED@tcp(db:3306)/app",
+		"host=db user=app dbname=app":                       "host=db user=app dbname=app",
+	} {
+		c := &ResConf{Name: "db", Type: "postgres", Address: dsn}
+		if got := redactConf(c).Address; got != want || strings.Contains(got, "secret") {
+			t.Errorf("%s redacted as %s, want %s", dsn, got, want)
+		}
+	}
+	v := sanitize(map[string]interface{}{"Address": "host=db password=secret"}).(map[string]interface{})
+	if v["Address"] != "host=db password=REDACTED" {
+		t.Errorf("sanitized %v", v)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// DNS SRV discovery: a record is resolved periodically and each target is
+// checked, with a URL made from a template or with TCP. Targets are synced
+// as records change.
+///////////////////////////////////////////////////////////////////////////////
+
+type SRVConf struct {
+	Record string // e.g. _http._tcp.example.com
+	// URL of a target, {host} and {port} are replaced, e.g.
+	// http://{host}:{port}/health. A target is checked with TCP if empty.
+	URL   string `json:",omitempty"`
+	Name  string `json:",omitempty"` // a prefix of resource names, Record if empty
+	Group string `json:",omitempty"`
+	Every string `json:",omitempty"` // how often Record is resolved, 1m if empty
+}
+
+// resources returns checks of targets of a record.
+func (c *SRVConf) resources() ([]*ResConf, error) {
+	_, addrs, err := net.LookupSRV("", "", c.Record)
+	if err != nil {
+		return nil, err
+	}
+	name := c.Name
+	if len(name) == 0 {
+		name = c.Record
+	}
+	var ret []*ResConf
+	for _, a := range addrs {
+		host, port := strings.TrimSuffix(a.Target, "."), strconv.Itoa(int(a.Port))
+		hostPort := net.JoinHostPort(host, port)
+		r := &ResConf{Name: name + "@" + hostPort, Address: hostPort, Type: "tcp", Group: c.Group}
+		if len(c.URL) > 0 {
+			r.Type = ""
+			r.Address = strings.NewReplacer("{host}", host, "{port}", port).Replace(c.URL)
+		}
+		ret = append(ret, r)
+	}
+	return ret, nil
+}
+
+// discoverSRV syncs targets of a record, a failed lookup keeps resources as
+// they are.
+func (s *StatusChecker) discoverSRV(c *SRVConf) {
+	every := parseThreshold(c.Every)
+	if every <= 0 {
+		every = time.Minute
+	}
+	for {
+		confs, err := c.resources()
+		if err != nil {
+			slog.Error("Discovery", "source", "srv "+c.Record, "error", err)
+		} else {
+			s.syncDiscovered("srv "+c.Record, confs)
+		}
+		time.Sleep(every)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// An SSE stream of all check results, for clients simpler than WebSocket
+// ones: "result" events for every check and "transition" events when
+// a resource changes its state.
+///////////////////////////////////////////////////////////////////////////////
+
+type sseResult struct {
+	Name    string
+	Address string
+	Status  *Status
+}
+
+type sseTransition struct {
+	Name    string
+	Address string
+	Old     State
+	State   State
+}
+
+// sseFilter selects resources by ?name= (repeated), ?tag= (repeated, all
+// must match) and ?group=.
+type sseFilter struct {
+	names map[string]bool
+	tags  []string
+	group string
+}
+
+func newSSEFilter(req *http.Request) *sseFilter {
+	req.ParseForm()
+	f := &sseFilter{tags: req.Form["tag"], group: req.Form.Get("group")}
+	if names := req.Form["name"]; len(names) > 0 {
+		f.names = make(map[string]bool)
+		for _, n := range names {
+			f.names[n] = true
+		}
+	}
+	return f
+}
+
+func (f *sseFilter) match(c *ResConf) bool {
+	return (f.names == nil || f.names[c.Name]) && c.hasTags(f.tags) && (f.group == "" || c.Group == f.group)
+}
+
+func sseEvent(rw http.ResponseWriter, event string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(rw, "event: %s\ndata: %s\n\n", event, b)
+	return err
+}
+
+func RegisterEventsHandler(sc *StatusChecker) {
+	http.HandleFunc("/events", func(rw http.ResponseWriter, req *http.Request) {
+		flusher, ok := rw.(http.Flusher)
+		if !ok {
+			http.Error(rw, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+		f := newSSEFilter(req)
+		c, cancel := sc.subscribe()
+		defer cancel()
+		states := make(map[string]State)
+		for _, el := range sc.Snapshot() {
+			states[el.Address] = el.Status.State()
+		}
+
+		rw.Header().Set("Content-Type", "text/event-stream")
+		rw.Header().Set("Cache-Control", "no-cache")
+		flusher.Flush()
+
+		// Comments keep proxies from closing an idle stream.
+		keepalive := time.NewTicker(30 * time.Second)
+		defer keepalive.Stop()
+		for {
+			select {
+			case <-req.Context().Done():
+				return
+			case <-keepalive.C:
+				fmt.Fprint(rw, ": keepalive\n\n")
+				flusher.Flush()
+			case st := <-c:
+				if !f.match(st.conf) {
+					continue
+				}
+				if err := sseEvent(rw, "result", &sseResult{st.conf.Name, redactAddress(st.conf.Address), st.Status}); err != nil {
+					slog.Warn("Events", "error", err)
+					return
+				}
+				old, cur := states[st.conf.Address], st.Status.State()
+				if cur != old {
+					states[st.conf.Address] = cur
+					if err := sseEvent(rw, "transition", &sseTransition{st.conf.Name, redactAddress(st.conf.Address), old, cur}); err != nil {
+						slog.Warn("Events", "error", err)
+						return
+					}
+				}
+				flusher.Flush()
+			}
+		}
+	})
+}
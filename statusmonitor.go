@@ -1,17 +1,28 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"flag"
-	"html/template"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
+	"log/slog"
 	"net"
 	"net/http"
-	"net/rpc"
+	"net/http/httptrace"
+	"net/url"
 	"os"
 	"os/signal"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 )
 
@@ -21,17 +32,277 @@ const (
 	DnsUnrecognizedAddress = -3
 	DnsServerMisbehaving   = -4
 	DnsTooManyRedirects    = -5
+	InvalidAddress         = -6 // never checked, see ResConf.validate
 )
 
 type ResConf struct {
 	Name     string
 	Address  string
 	Interval string
+	Type     string `json:",omitempty"` // http if empty, see RegisterChecker
+	Method   string `json:",omitempty"` // of an HTTP request, GET if empty
+	Protocol string `json:",omitempty"` // forced http1, http2 or http3, negotiated if empty
+	Proxy    string `json:",omitempty"` // a proxy URL or none, -proxy if empty
+	Resolver string `json:",omitempty"` // of host names, -resolver if empty, see newResolver
+	Family   string `json:",omitempty"` // ipv4, ipv6 or both, any if empty
+	// Token and Grace of a heartbeat check, see checkHeartbeat.
+	Token string `json:",omitempty"`
+	Grace string `json:",omitempty"`
+	// Service of a grpc check, a whole server if empty.
+	Service string `json:",omitempty"`
+	// Message echoed and Ping answered in a websocket check.
+	Message  string `json:",omitempty"`
+	Ping     bool   `json:",omitempty"`
+	StartTLS bool   `json:",omitempty"` // of an smtp check
+	Topic    string `json:",omitempty"` // of a kafka or mqtt check
+	MinISR   int    `json:",omitempty"` // of partitions of Topic
+	// MaxOffset of an ntp check, defaultMaxOffset if empty.
+	MaxOffset string `json:",omitempty"`
+	// BindDN and Password of an ldap check, an anonymous bind if empty.
+	BindDN   string            `json:",omitempty"`
+	Password string            `json:",omitempty"`
+	Expect   int               `json:",omitempty"` // a status code of a healthy response, 200 if 0
+	Headers  map[string]string `json:",omitempty"` // of an HTTP request
+	Export   map[string]string `json:",omitempty"` // variables extracted from a response, see extract
+	Tags     map[string]string `json:",omitempty"`
+	MaxTTFB  string            `json:",omitempty"` // e.g. 500ms
+	MaxTotal string            `json:",omitempty"` // including a body read
+	Group    string            `json:",omitempty"`
+	Priority string            `json:",omitempty"` // under overload, normal if empty, see priorities
+	Notify   []string          `json:",omitempty"` // names of notification channels
+	Renotify string            `json:",omitempty"` // overrides Config.Renotify
+	Sample   string            `json:",omitempty"` // e.g. 1m, aggregates a history per period
+	// NotifyPolicy of known-flaky resources, NotifyAll if empty.
+	NotifyPolicy string `json:",omitempty"`
+	// CheckOCSP validates OCSP stapling and revocation of a certificate.
+	CheckOCSP bool `json:",omitempty"`
+	// Weight of a resource in a weighted overall state, 1 if 0.
+	Weight float64 `json:",omitempty"`
+	// Record and Resolvers of a dns check, A and defaultResolvers if empty.
+	Record    string   `json:",omitempty"`
+	Resolvers []string `json:",omitempty"`
+	// Expires, if set, is when an ephemeral resource is removed.
+	Expires time.Time `json:",omitzero"`
+	// Private checks hit endpoints with regulated data: only status codes
+	// and timings are kept, never anything derived from a response content
+	// or a full error message (it may include a URL with a query).
+	Private bool `json:",omitempty"`
+	// Paused resources aren't checked, see AdminServer.Pause.
+	Paused bool `json:",omitempty"`
+
+	file       string // a conf.d fragment it's saved to, see loadConfigDir
+	discovered string // a source of a discovered resource, see syncDiscovered
+}
+
+var validMethod = regexp.MustCompile(`^[A-Z]+$`)
+
+// validate checks if a resource can be checked at all, e.g. its URL has
+// a scheme, a host and a numeric port.
+func (c *ResConf) validate() error {
+	if _, ok := checkers[c.Type]; !ok {
+		return fmt.Errorf("unknown check type %q", c.Type)
+	}
+	if len(c.Address) == 0 {
+		return fmt.Errorf("no address")
+	}
+	if c.Private && len(c.Export) > 0 {
+		return fmt.Errorf("a private resource can't export variables")
+	}
+	if _, err := priorityIndex(c.Priority); err != nil {
+		return err
+	}
+	if len(c.Interval) > 0 {
+		if d, err := time.ParseDuration(c.Interval); err != nil || d <= 0 {
+			return fmt.Errorf("bad interval %q", c.Interval)
+		}
+	}
+	if len(c.Sample) > 0 {
+		if d, err := time.ParseDuration(c.Sample); err != nil || d <= 0 {
+			return fmt.Errorf("bad sample period %q", c.Sample)
+		}
+	}
+	if err := validateResolver(c.Resolver); err != nil {
+		return err
+	}
+	if len(c.Family) > 0 {
+		if err := c.validateFamily(); err != nil {
+			return err
+		}
+	}
+	if len(c.Proxy) > 0 {
+		if err := c.validateProxy(); err != nil {
+			return err
+		}
+	}
+	if c.Type == "heartbeat" {
+		return validateHeartbeat(c)
+	}
+	if c.Type == "exec" {
+		return validateExec(c.Address)
+	}
+	if c.Type == "grpc" {
+		return validateGRPC(c.Address)
+	}
+	if c.Type == "websocket" {
+		return validateWebSocket(c.Address)
+	}
+	if c.Type == "smtp" {
+		return validateSMTP(c)
+	}
+	if c.Type == "ftp" || c.Type == "sftp" {
+		return validateFTP(c.Type, c.Address)
+	}
+	if c.Type == "redis" {
+		return validateRedis(c.Address)
+	}
+	if c.Type == "mongodb" {
+		return validateMongo(c.Address)
+	}
+	if c.Type == "kafka" {
+		return validateKafka(c)
+	}
+	if c.Type == "mqtt" {
+		return validateMQTT(c.Address)
+	}
+	if c.Type == "ntp" {
+		return validateNTP(c)
+	}
+	if c.Type == "ldap" {
+		return validateLDAP(c)
+	}
+	if c.Type == "tcp" {
+		return validateTCP(c.Address)
+	}
+	if len(c.Type) > 0 && c.Type != "http" && c.Type != "keepalive" {
+		return nil
+	}
+	if len(c.Protocol) > 0 {
+		if c.Type == "keepalive" {
+			return fmt.Errorf("a keepalive check can't force a protocol")
+		}
+		if err := validateProtocol(c.Protocol, c.Address, c.Resolver); err != nil {
+			return err
+		}
+	}
+	if len(c.Method) > 0 && !validMethod.MatchString(c.Method) {
+		return fmt.Errorf("bad method %q", c.Method)
+	}
+	if c.Expect != 0 && (c.Expect < 100 || c.Expect > 599) {
+		return fmt.Errorf("bad expected status %d", c.Expect)
+	}
+	if c.chained() {
+		// Known once variables are expanded.
+		return nil
+	}
+	u, err := url.Parse(c.Address)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%s: scheme must be http or https", c.Address)
+	}
+	if len(u.Hostname()) == 0 {
+		return fmt.Errorf("%s: no host", c.Address)
+	}
+	if port := u.Port(); len(port) > 0 {
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return fmt.Errorf("%s: bad port %q", c.Address, port)
+		}
+	} else if strings.HasSuffix(u.Host, ":") {
+		return fmt.Errorf("%s: empty port", c.Address)
+	}
+	return nil
 }
 
+// initialStatus is a status of a resource before it's checked, an invalid
+// one is never checked.
+func initialStatus(c *ResConf) *Status {
+	if err := c.validate(); err != nil {
+		slog.Warn("Invalid resource", "resource", c.Name, "error", err)
+		return &Status{When: time.Now(), StatusCode: InvalidAddress, Error: err.Error()}
+	}
+	return &Status{}
+}
+
+const (
+	SlowTTFB  = "ttfb"
+	SlowTotal = "total"
+)
+
 type Status struct {
 	When       time.Time
 	StatusCode int
+	TTFB       time.Duration `json:",omitempty"`
+	Latency    time.Duration `json:",omitempty"` // total, including a body read
+	Slow       string        `json:",omitempty"` // SlowTTFB or SlowTotal
+	Degraded   string        `json:",omitempty"` // why DEGRADED, if not slow
+	Error      string        `json:",omitempty"`
+	Expected   int           `json:",omitempty"` // a healthy StatusCode, if not 200
+	Details    []string      `json:",omitempty"` // check type specific, e.g. DNS answers
+	Sample     *Sample       `json:",omitempty"` // of an aggregated result in a history
+
+	// Connection details of the last response.
+	Proto      string      `json:",omitempty"`
+	TLSVersion string      `json:",omitempty"`
+	CertIssuer string      `json:",omitempty"`
+	RemoteIP   string      `json:",omitempty"`
+	Cert       *CertReport `json:",omitempty"`
+
+	exports map[string]string // variables extracted for ResConf.Export
+}
+
+type State int
+
+const (
+	StateNeverChecked State = iota // pending a first check
+	StateUp
+	StateDegraded
+	StateDown
+	StateInvalid // a malformed resource, not checked
+)
+
+func (s State) String() string {
+	switch s {
+	case StateUp:
+		return "UP"
+	case StateDegraded:
+		return "DEGRADED"
+	case StateDown:
+		return "DOWN"
+	case StateInvalid:
+		return "INVALID"
+	}
+	return "NEVER_CHECKED"
+}
+
+func (s State) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Checked reports whether st is a result of a check, not a placeholder of
+// a new resource.
+func (st *Status) Checked() bool {
+	return st != nil && !st.When.IsZero()
+}
+
+func (st *Status) State() State {
+	if !st.Checked() {
+		return StateNeverChecked
+	}
+	if st.StatusCode == InvalidAddress {
+		return StateInvalid
+	}
+	want := http.StatusOK
+	if st.Expected != 0 {
+		want = st.Expected
+	}
+	if st.StatusCode != want {
+		return StateDown
+	}
+	if len(st.Slow) > 0 || len(st.Degraded) > 0 {
+		return StateDegraded
+	}
+	return StateUp
 }
 
 type ResConfStatus struct {
@@ -39,9 +310,80 @@ type ResConfStatus struct {
 	Status *Status // if > 0 then http.Response.StatusCode
 }
 
+// Checker probes a resource of some type. A successful check reports
+// http.StatusOK, whatever the type, or Status.Expected if it's set.
+type Checker func(c *ResConf) *Status
+
+var checkers = map[string]Checker{
+	"":     checkHTTP,
+	"http": checkHTTP,
+}
+
+// RegisterChecker makes a check type available, it's meant to be called
+// from init functions.
+func RegisterChecker(typ string, f Checker) {
+	checkers[typ] = f
+}
+
+// checkTypes returns check types compiled in, some are excluded by build
+// tags, e.g. nodns.
+func checkTypes() []string {
+	var ret []string
+	for typ := range checkers {
+		if len(typ) > 0 {
+			ret = append(ret, typ)
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
+
 func CheckStatus(c *ResConf) *Status {
-	st := &Status{time.Now(), 0}
-	resp, err := http.Get(c.Address)
+	f, ok := checkers[c.Type]
+	if !ok {
+		return &Status{When: time.Now(), StatusCode: UnknownError, Error: fmt.Sprintf("unknown check type %q", c.Type)}
+	}
+	var st *Status
+	if c.Family == FamilyBoth {
+		st = checkFamilies(f, c)
+	} else {
+		st = f(c)
+	}
+	if c.Private {
+		st.Details = nil
+	}
+	return st
+}
+
+func checkHTTP(c *ResConf) *Status {
+	st := &Status{When: time.Now()}
+	var firstByte time.Time
+	method := c.Method
+	if len(method) == 0 {
+		method = "GET"
+	}
+	if c.Expect != 0 && c.Expect != http.StatusOK {
+		st.Expected = c.Expect
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *httpCheckTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, method, c.Address, nil)
+	var resp *http.Response
+	if err == nil {
+		for k, v := range c.Headers {
+			req.Header.Set(k, v)
+		}
+		trace := &httptrace.ClientTrace{
+			GotConn: func(info httptrace.GotConnInfo) {
+				if addr, ok := info.Conn.RemoteAddr().(*net.TCPAddr); ok {
+					st.RemoteIP = addr.IP.String()
+				}
+			},
+			GotFirstResponseByte: func() { firstByte = time.Now() },
+		}
+		req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+		resp, err = clientFor(c).Do(withProxy(req, c))
+	}
 	if err != nil {
 		if dnserr, ok := err.(*net.DNSError); ok {
 			switch dnserr.Err {
@@ -56,27 +398,161 @@ func CheckStatus(c *ResConf) *Status {
 			}
 		}
 		st.StatusCode = UnknownError
-		log.Printf("Unknown error:  %s", err)
-	} else {
-		st.StatusCode = resp.StatusCode
+		st.Error = c.errorText(err)
+		slog.Warn("Check failed", "resource", c.Name, "address", c.Address, "error", st.Error)
+		return st
+	}
+	defer resp.Body.Close()
+	st.StatusCode = resp.StatusCode
+	st.Proto = resp.Proto
+	if err := checkProtocol(c, resp); err != nil {
+		st.StatusCode = UnknownError
+		st.Error = err.Error()
+		slog.Warn("Check failed", "resource", c.Name, "address", c.Address, "error", st.Error)
+		return st
+	}
+	if cs := resp.TLS; cs != nil {
+		st.TLSVersion = tls.VersionName(cs.Version)
+		if len(cs.PeerCertificates) > 0 {
+			st.CertIssuer = cs.PeerCertificates[0].Issuer.String()
+		}
+		st.Cert = certReport(c, cs)
+	}
+	// No first byte is traced e.g. over HTTP/3.
+	if !firstByte.IsZero() {
+		st.TTFB = firstByte.Sub(st.When)
+	}
+	var body []byte
+	r := io.LimitReader(resp.Body, *httpMaxBody)
+	if len(c.Export) > 0 && !c.Private {
+		body, err = ioutil.ReadAll(io.LimitReader(r, maxExportBody))
+	}
+	if err == nil {
+		_, err = io.Copy(ioutil.Discard, r)
+	}
+	st.Latency = time.Since(st.When)
+	if err != nil {
+		st.StatusCode = UnknownError
+		st.Error = "reading body: " + c.errorText(err)
+		slog.Warn("Check failed", "resource", c.Name, "address", c.Address, "error", st.Error)
+		return st
+	}
+	st.Slow = c.slow(st)
+	if len(c.Export) > 0 && !c.Private && st.State() != StateDown {
+		if err := c.extract(st, resp, body); err != nil {
+			st.Degraded = err.Error()
+		}
 	}
 	return st
 }
 
-func worker(c chan *ResConf, ret chan *ResConfStatus) {
-	for {
-		conf := <-c
-		status := CheckStatus(conf)
-		ret <- &ResConfStatus{conf, status}
+// errorText returns a message of err safe to keep for c, credentials of
+// an address in it redacted.
+func (c *ResConf) errorText(err error) string {
+	if !c.Private {
+		return strings.ReplaceAll(err.Error(), c.Address, redactAddress(c.Address))
+	}
+	if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+		return c.Name + ": timeout"
+	}
+	return c.Name + ": request failed"
+}
+
+// addressUserinfo matches credentials in a URL or a DSN address, e.g.
+// redis://:pw@host or user:pw@tcp(host:3306)/db.
+var addressUserinfo = regexp.MustCompile(`^([A-Za-z][A-Za-z0-9+.-]*://)?[^/@]+@`)
+
+// addressPassword matches a password in a key=value DSN of postgres, e.g.
+// host=db user=app password='a b', or in a query of a URL address.
+var addressPassword = regexp.MustCompile(`(?i)((?:^|\s)password\s*=\s*)('(?:[^'\\]|\\.)*'|\S*)|([?&]password=)[^&#]*`)
+
+// redactAddress returns addr with credentials in it redacted, an address
+// as shown to viewers, in metrics and in notifications.
+func redactAddress(addr string) string {
+	addr = addressUserinfo.ReplaceAllString(addr, "${1}REDACTED@")
+	return addressPassword.ReplaceAllString(addr, "${1}${3}REDACTED")
+}
+
+// redactConf returns a copy of c safe to show or keep: header values,
+// bind credentials and a password, also one in an address, are redacted.
+func redactConf(c *ResConf) *ResConf {
+	cp := *c
+	cp.Address = redactAddress(c.Address)
+	if len(c.Password) > 0 {
+		cp.Password = "REDACTED"
+	}
+	if len(c.BindDN) > 0 {
+		cp.BindDN = "REDACTED"
+	}
+	if len(c.Token) > 0 {
+		cp.Token = "REDACTED"
+	}
+	if len(c.Headers) > 0 {
+		cp.Headers = make(map[string]string)
+		for k := range c.Headers {
+			cp.Headers[k] = "REDACTED"
+		}
+	}
+	return &cp
+}
+
+// slow checks st against thresholds of c. A slow TTFB means a slow origin
+// while a slow total time with a fine TTFB means a slow transfer.
+func (c *ResConf) slow(st *Status) string {
+	if d := parseThreshold(c.MaxTTFB); d > 0 && st.TTFB > d {
+		return SlowTTFB
+	}
+	if d := parseThreshold(c.MaxTotal); d > 0 && st.Latency > d {
+		return SlowTotal
+	}
+	return ""
+}
+
+func parseThreshold(s string) time.Duration {
+	if len(s) == 0 {
+		return 0
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		slog.Warn("Bad threshold", "threshold", s, "error", err)
+		return 0
 	}
+	return d
 }
 
 type Config struct {
-	Configs []*ResConf
+	Configs  []*ResConf
+	Opsgenie *OpsgenieConf  `json:",omitempty"`
+	Webhooks []*WebhookConf `json:",omitempty"`
+	Twilio   *TwilioConf    `json:",omitempty"`
+	Push     []*PushConf    `json:",omitempty"`
+	Routes   []*Route       `json:",omitempty"`
+	Renotify string         `json:",omitempty"` // e.g. 2h, remind of an ongoing problem
+	Overall  *OverallConf   `json:",omitempty"`
+	Digest   []*DigestConf  `json:",omitempty"`
+	Groups   []*GroupConf   `json:",omitempty"`
+	// Retention is how long a history of a removed resource is kept.
+	Retention string          `json:",omitempty"`
+	OnCall    []*RotationConf `json:",omitempty"`
+	// ApiKeys authorize changes, see RequireKey.
+	ApiKeys []*ApiKey `json:",omitempty"`
+	// Login protects the web UI, see RequireLogin.
+	Login *LoginConf `json:",omitempty"`
+	// RateLimit of requests per client IP, see RateLimit.
+	RateLimit *RateLimitConf `json:",omitempty"`
+	// Listen addresses, flags override them.
+	Listen *ListenConf `json:",omitempty"`
+	// CORS of browser dashboards hosted elsewhere.
+	CORS *CORSConf `json:",omitempty"`
+	// SRV records whose targets are checked.
+	SRV []*SRVConf `json:",omitempty"`
+
+	fragments  map[string]*Config // by path of a conf.d fragment, without resources
+	unexpanded map[string]string  // values with environment variable references, see expandEnv
 }
 
 func NewConfig() *Config {
-	return &Config{make([]*ResConf, 0)}
+	return &Config{Configs: make([]*ResConf, 0)}
 }
 
 func (c *Config) Add(ac *ResConf) {
@@ -85,6 +561,15 @@ func (c *Config) Add(ac *ResConf) {
 
 type EqCmp func(*ResConf) bool
 
+func (c *Config) Find(eq EqCmp) *ResConf {
+	for _, el := range c.Configs {
+		if eq(el) {
+			return el
+		}
+	}
+	return nil
+}
+
 func (c *Config) Remove(eq EqCmp) *ResConf {
 	for i, el := range c.Configs {
 		if eq(el) {
@@ -95,21 +580,45 @@ func (c *Config) Remove(eq EqCmp) *ResConf {
 	return nil
 }
 
+// Save writes a config as JSON, or YAML if a path has a YAML extension. A
+// YAML file is left untouched if it has the same config, so its comments
+// are kept. A config of a directory is saved to its fragments.
 func (c *Config) Save(filepath string) error {
-	f, err := os.OpenFile(filepath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, os.ModePerm)
-	if err != nil {
-		return err
+	if fi, err := os.Stat(filepath); err == nil && fi.IsDir() {
+		return c.saveDir(filepath)
+	}
+	var b []byte
+	var err error
+	if isYAML(filepath) {
+		if old, err := LoadConfig(filepath); err == nil {
+			a, errA := json.Marshal(old)
+			b, errB := json.Marshal(c)
+			if errA == nil && errB == nil && bytes.Equal(a, b) {
+				return nil
+			}
+		}
+	}
+	if len(c.unexpanded) > 0 {
+		if c, err = c.withReferences(); err != nil {
+			return err
+		}
+	}
+	if isYAML(filepath) {
+		b, err = marshalYAML(c)
+	} else {
+		b, err = json.MarshalIndent(c, "", " ")
 	}
-	defer f.Close()
-	b, err := json.MarshalIndent(c, "", " ")
 	if err != nil {
 		return err
 	}
-	_, err = f.Write(b)
-	return err
+	return writeConfigFile(filepath, b)
 }
 
+// LoadConfig reads a config file or a conf.d directory of fragments.
 func LoadConfig(filePath string) (*Config, error) {
+	if fi, err := os.Stat(filePath); err == nil && fi.IsDir() {
+		return loadConfigDir(filePath)
+	}
 	f, err := os.Open(filePath)
 	if err != nil {
 		return nil, err
@@ -119,31 +628,101 @@ func LoadConfig(filePath string) (*Config, error) {
 	if err != nil {
 		return nil, err
 	}
+	return parseConfig(filePath, b)
+}
+
+// parseConfig decodes a config named e.g. by its path, YAML if a name has
+// a YAML extension or JSON.
+func parseConfig(filePath string, b []byte) (*Config, error) {
+	var err error
 	config := NewConfig()
-	if err = json.Unmarshal(b, config); err != nil {
-		return nil, err
+	if isYAML(filePath) {
+		err = unmarshalYAML(b, config)
+	} else {
+		err = json.Unmarshal(b, config)
+	}
+	if err == nil {
+		err = config.expandEnv()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", filePath, err)
 	}
 	return config, nil
 }
 
+// historySize is how many recent results are kept per resource.
+const historySize = 1000
+
 type StatusChecker struct {
 	config      *Config
-	queue       chan *ResConf
+	queue       *scheduler
 	statuses    map[string]*Status
+	history     map[string][]*Status // recent results, guarded by statusMutex
+	retired     map[string]time.Time // when to drop a history of removed resources
+	retention   time.Duration
+	watches     map[string]*watchState // guarded by m
 	m           *sync.Mutex
 	statusMutex *sync.Mutex
+
+	subs     map[chan *ResConfStatus]bool
+	subMutex *sync.Mutex
+
+	router *Router
+	events chan *Event
+	alerts map[string]*alertState // guarded by statusMutex
+	// groupStatuses of groups notified of as a whole, guarded by statusMutex.
+	groupStatuses map[string]*Status
+	renotify      time.Duration
+	digests       []*Digest
+	pacers        map[string]*pacer // by group name
+
+	incidents     []*Incident          // guarded by statusMutex
+	openIncidents map[string]*Incident // by address
+	lastIncident  int
+
+	vars map[string]map[string]string // exported by resource name, guarded by statusMutex
+
+	dirty    chan bool // a resource was changed, see autosave
+	lastTick time.Time // when timers were last synced, guarded by m
+	pool     workerPool
+	offsets  map[string]time.Duration // of periodic checks by name, guarded by m
+	timers   timers
+	regions  map[string]*agentRegion // reported by agents, guarded by statusMutex
+	cluster  *cluster                // nil unless -peers are set
 }
 
 func NewStatusChecker(c *Config) *StatusChecker {
 	if c == nil {
 		c = NewConfig()
 	}
+	queue := newScheduler()
 	return &StatusChecker{
-		c,
-		make(chan *ResConf, 200),
-		make(map[string]*Status),
-		&sync.Mutex{},
-		&sync.Mutex{},
+		config:      c,
+		queue:       queue,
+		statuses:    make(map[string]*Status),
+		history:     make(map[string][]*Status),
+		retired:     make(map[string]time.Time),
+		retention:   parseThreshold(c.Retention),
+		watches:     make(map[string]*watchState),
+		m:           &sync.Mutex{},
+		statusMutex: &sync.Mutex{},
+		subs:        make(map[chan *ResConfStatus]bool),
+		subMutex:    &sync.Mutex{},
+		router:      NewRouter(c),
+		events:      make(chan *Event, notifyQueue),
+		alerts:      make(map[string]*alertState),
+
+		groupStatuses: make(map[string]*Status),
+		renotify:      parseThreshold(c.Renotify),
+		digests:       c.Digests(),
+		pacers:        c.Pacers(queue),
+
+		openIncidents: make(map[string]*Incident),
+		vars:          make(map[string]map[string]string),
+		dirty:         make(chan bool, 1),
+		offsets:       make(map[string]time.Duration),
+		timers:        timers{byConf: make(map[*ResConf]*timer)},
+		regions:       make(map[string]*agentRegion),
 	}
 }
 
@@ -153,8 +732,14 @@ func (s *StatusChecker) Add(cfg *ResConf) bool {
 	defer s.m.Unlock()
 	defer s.statusMutex.Unlock()
 	s.config.Add(cfg)
-	s.statuses[cfg.Address] = &Status{}
-	log.Printf("Add %s (%s)", cfg.Name, cfg.Address)
+	s.statuses[cfg.Address] = initialStatus(cfg)
+	delete(s.retired, cfg.Address)
+	if cfg.Expires.IsZero() {
+		slog.Info("Add", "resource", cfg.Name, "address", cfg.Address)
+	} else {
+		slog.Info("Add", "resource", cfg.Name, "address", cfg.Address, "expires", cfg.Expires)
+	}
+	s.changed()
 	return true
 }
 
@@ -165,56 +750,192 @@ func (s *StatusChecker) Remove(eq EqCmp) bool {
 	defer s.statusMutex.Unlock()
 	el := s.config.Remove(eq)
 	if el == nil {
-		log.Printf("No element matching eq.")
+		slog.Info("No resource to remove")
 		return false
 	}
 	delete(s.statuses, el.Address)
-	log.Printf("Removed: %s (%s)", el.Name, el.Address)
+	s.retire(el.Address)
+	delete(s.alerts, el.Address)
+	s.closeIncident(el.Address, time.Now())
+	delete(s.vars, el.Name)
+	slog.Info("Removed", "resource", el.Name, "address", el.Address)
+	s.changed()
 	return true
 }
 
-func (s *StatusChecker) CloseNicely() {
+// Update replaces a resource with a given name, its results are kept unless
+// its address changes.
+func (s *StatusChecker) Update(name string, cfg *ResConf) error {
 	s.m.Lock()
+	s.statusMutex.Lock()
 	defer s.m.Unlock()
-	if err := s.config.Save(*configFilePath); err != nil {
-		log.Print(err)
+	defer s.statusMutex.Unlock()
+	for i, el := range s.config.Configs {
+		if el.Name != name {
+			continue
+		}
+		if len(cfg.file) == 0 {
+			cfg.file = el.file
+		}
+		if len(cfg.discovered) == 0 {
+			cfg.discovered = el.discovered
+		}
+		s.config.Configs[i] = cfg
+		if cfg.Address != el.Address {
+			delete(s.statuses, el.Address)
+			s.retire(el.Address)
+			delete(s.alerts, el.Address)
+			s.closeIncident(el.Address, time.Now())
+			delete(s.retired, cfg.Address)
+			s.statuses[cfg.Address] = initialStatus(cfg)
+		} else if s.statuses[el.Address].State() == StateInvalid || cfg.validate() != nil {
+			s.statuses[cfg.Address] = initialStatus(cfg)
+		}
+		if cfg.Name != name {
+			delete(s.vars, name)
+		}
+		slog.Info("Update", "resource", name, "name", cfg.Name, "address", cfg.Address)
+		s.changed()
+		return nil
+	}
+	return fmt.Errorf("no resource named %q", name)
+}
+
+// History returns recent results of a resource with an address, checked
+// not before since.
+func (s *StatusChecker) History(addr string, since time.Time) []*Status {
+	s.statusMutex.Lock()
+	defer s.statusMutex.Unlock()
+	var ret []*Status
+	for _, st := range s.history[addr] {
+		if !st.When.Before(since) {
+			ret = append(ret, st)
+		}
+	}
+	return ret
+}
+
+// Lookup returns a resource with a given name and its last status.
+func (s *StatusChecker) Lookup(name string) (*ResConf, *Status) {
+	s.m.Lock()
+	conf := s.config.Find(func(el *ResConf) bool { return el.Name == name })
+	s.m.Unlock()
+	if conf == nil {
+		return nil, nil
+	}
+	s.statusMutex.Lock()
+	defer s.statusMutex.Unlock()
+	return conf, s.statuses[conf.Address]
+}
+
+func (s *StatusChecker) CloseNicely() {
+	if err := s.save(); err != nil {
+		slog.Error("Save", "error", err)
 	}
 }
 
+// save writes a config to -config.
+func (s *StatusChecker) save() error {
+	if len(*configFilePath) == 0 {
+		// A signed bundle or no config at all.
+		return nil
+	}
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.config.withoutDiscovered().Save(*configFilePath)
+}
+
 func (s *StatusChecker) report(acs chan *ResConfStatus) {
 	for {
 		status := <-acs
 		s.statusMutex.Lock()
-		if _, ok := s.statuses[status.conf.Address]; ok {
+		old, ok := s.statuses[status.conf.Address]
+		if ok {
 			s.statuses[status.conf.Address] = status.Status
+			s.addHistory(status.conf, status.Status)
+			s.trackIncident(status.conf, status.Status)
+			s.exportVars(status.conf, status.Status)
 		}
 		s.statusMutex.Unlock()
+		s.publish(status)
+		if !ok {
+			continue
+		}
+		slog.Debug("Checked", "resource", status.conf.Name, "address", status.conf.Address,
+			"state", status.Status.State(), "status", status.Status.StatusCode, "latency", status.Status.Latency)
+		go s.runDependents(status.conf)
+		ev := s.event(status.conf, old, status.Status)
+		notify := ev
+		if g := s.alertGroup(status.conf); g != nil {
+			notify = s.groupEvent(g, status.Status.When)
+		}
+		if notify != nil && notify.Conf.notifies(notify) {
+			s.queueEvent(notify)
+		}
+		incident := ev != nil && !ev.Reminder && ev.New.State() == StateDown
+		for _, d := range s.digests {
+			d.record(status.conf, status.Status, incident)
+		}
+	}
+}
+
+// subscribe returns a channel receiving every check result. The returned
+// function must be called to stop the subscription.
+func (s *StatusChecker) subscribe() (chan *ResConfStatus, func()) {
+	c := make(chan *ResConfStatus, 16)
+	s.subMutex.Lock()
+	s.subs[c] = true
+	s.subMutex.Unlock()
+	return c, func() {
+		s.subMutex.Lock()
+		delete(s.subs, c)
+		s.subMutex.Unlock()
+	}
+}
+
+// publish never blocks, a slow subscriber just misses results.
+func (s *StatusChecker) publish(status *ResConfStatus) {
+	s.subMutex.Lock()
+	defer s.subMutex.Unlock()
+	for c := range s.subs {
+		select {
+		case c <- status:
+		default:
+		}
 	}
 }
 
-func (s *StatusChecker) Run(numWorkers int) {
+// Run checks resources every -interval until ctx is done, then it waits up
+// to -shutdown-timeout for checks in progress.
+func (s *StatusChecker) Run(ctx context.Context, numWorkers int) {
 	r := make(chan *ResConfStatus)
 	go s.report(r)
-	for i := 0; i < numWorkers; i++ {
-		go worker(s.queue, r)
+	go s.queue.closeOn(ctx)
+	go s.dispatch()
+	for _, d := range s.digests {
+		go d.run()
+	}
+	for _, p := range s.pacers {
+		go p.run()
+	}
+	go s.expireLoop()
+	go s.autosave()
+	s.pool.start(s, r, numWorkers, *maxWorkers)
+	if *maxWorkers > numWorkers {
+		go s.pool.autoscale(ctx)
 	}
 
 	s.m.Lock()
 	for _, ac := range s.config.Configs {
 		s.statusMutex.Lock()
-		s.statuses[ac.Address] = &Status{}
+		s.statuses[ac.Address] = initialStatus(ac)
 		s.statusMutex.Unlock()
-		s.queue <- ac
 	}
 	s.m.Unlock()
 
-	c := time.Tick(*interval)
-	for range c {
-		s.m.Lock()
-		for _, ac := range s.config.Configs {
-			s.queue <- ac
-		}
-		s.m.Unlock()
+	s.schedule(ctx)
+	if !s.pool.wait(*shutdownTimeout) {
+		slog.Warn("Checks in progress abandoned", "timeout", *shutdownTimeout)
 	}
 }
 
@@ -223,7 +944,9 @@ func (s *StatusChecker) Run(numWorkers int) {
 ///////////////////////////////////////////////////////////////////////////////
 
 type AdminServer struct {
-	sc *StatusChecker
+	sc     *StatusChecker
+	caller *Caller // nil if anonymous
+	from   string  // a remote address
 }
 
 type KeyType int
@@ -239,11 +962,21 @@ type RemoveRequest struct {
 }
 
 func (a *AdminServer) Add(cfg *ResConf, status *int) error {
+	if err := a.allow(RoleAdmin); err != nil {
+		return err
+	}
+	if err := cfg.validate(); err != nil {
+		return err
+	}
 	a.sc.Add(cfg)
+	audit(a.caller, a.from, AuditAdd, cfg.Name, redactConf(cfg))
 	return nil
 }
 
 func (a *AdminServer) Remove(args RemoveRequest, status *int) error {
+	if err := a.allow(RoleAdmin); err != nil {
+		return err
+	}
 	ok := false
 	switch args.Type {
 	case AddressKeyType:
@@ -253,10 +986,152 @@ func (a *AdminServer) Remove(args RemoveRequest, status *int) error {
 	}
 	if !ok {
 		*status = 1
+	} else {
+		audit(a.caller, a.from, AuditRemove, args.Key, args)
+	}
+	return nil
+}
+
+func (a *AdminServer) Watch(args WatchRequest, status *int) error {
+	if err := a.allow(RoleOperator); err != nil {
+		return err
 	}
+	if err := a.sc.Watch(args.Name, args.Every, args.For); err != nil {
+		return err
+	}
+	audit(a.caller, a.from, AuditWatch, args.Name, args)
 	return nil
 }
 
+func (a *AdminServer) Preview(args PreviewRequest, result *PreviewResult) error {
+	// Deliveries show recipients.
+	if err := a.allow(RoleOperator); err != nil {
+		return err
+	}
+	r, err := a.sc.Preview(args)
+	if err != nil {
+		return err
+	}
+	*result = *r
+	return nil
+}
+
+func (a *AdminServer) Ack(args AckRequest, status *int) error {
+	if err := a.allow(RoleOperator); err != nil {
+		return err
+	}
+	if err := a.sc.Ack(args.Name, args.User); err != nil {
+		return err
+	}
+	audit(a.caller, a.from, AuditAck, args.Name, args)
+	return nil
+}
+
+type UpdateRequest struct {
+	Name   string
+	Fields []byte // a JSON object of fields to change, as in PATCH /api/resources/{name}
+}
+
+func (a *AdminServer) Update(args UpdateRequest, result *ResConf) error {
+	if err := a.allow(RoleAdmin); err != nil {
+		return err
+	}
+	conf, _ := a.sc.Lookup(args.Name)
+	if conf == nil {
+		return fmt.Errorf("no resource named %q", args.Name)
+	}
+	cfg, err := patchResource(conf, args.Fields)
+	if err != nil {
+		return err
+	}
+	if err := a.sc.conflict(cfg, conf); err != nil {
+		return err
+	}
+	if err := a.sc.Update(args.Name, cfg); err != nil {
+		return err
+	}
+	audit(a.caller, a.from, AuditUpdate, args.Name, redactConf(cfg))
+	*result = *cfg
+	return nil
+}
+
+type ListRequest struct {
+	Tag string // "key" or "key=value", all resources if empty
+}
+
+// List returns resources with their states, addresses redacted.
+func (a *AdminServer) List(args ListRequest, result *[]*apiStatus) error {
+	if err := a.allow(RoleViewer); err != nil {
+		return err
+	}
+	var tags []string
+	if len(args.Tag) > 0 {
+		tags = append(tags, args.Tag)
+	}
+	*result = a.sc.StatusList(tags)
+	return nil
+}
+
+func (a *AdminServer) Export(args int, result *[]*ResConf) error {
+	// Headers may hold credentials.
+	if err := a.allow(RoleAdmin); err != nil {
+		return err
+	}
+	confs, err := a.sc.Export()
+	*result = confs
+	return err
+}
+
+type ImportRequest struct {
+	Configs []*ResConf
+}
+
+func (a *AdminServer) Import(args ImportRequest, result *ReloadResult) error {
+	if err := a.allow(RoleAdmin); err != nil {
+		return err
+	}
+	r, err := a.sc.Import(args.Configs, a.caller, a.from)
+	if r != nil {
+		*result = *r
+	}
+	return err
+}
+
+func (a *AdminServer) OnCall(args int, result *[]*OnCall) error {
+	// Shifts show phone numbers.
+	if err := a.allow(RoleOperator); err != nil {
+		return err
+	}
+	*result = a.sc.OnCall()
+	return nil
+}
+
+func (a *AdminServer) AgentConfig(args AgentHello, result *AgentConfig) error {
+	if err := a.allowAgent(); err != nil {
+		return err
+	}
+	*result = *a.sc.AgentConfigs()
+	return nil
+}
+
+func (a *AdminServer) AgentReport(args *AgentReport, status *int) error {
+	if err := a.allowAgent(); err != nil {
+		return err
+	}
+	a.sc.AgentReport(args)
+	*status = len(args.Results)
+	return nil
+}
+
+func (a *AdminServer) Diagnostics(args DiagnosticsRequest, archive *[]byte) error {
+	if err := a.allow(RoleOperator); err != nil {
+		return err
+	}
+	b, err := a.sc.Diagnostics(args.Name, args.Since)
+	*archive = b
+	return err
+}
+
 ///////////////////////////////////////////////////////////////////////////////
 // A HTML handler part.
 ///////////////////////////////////////////////////////////////////////////////
@@ -267,47 +1142,218 @@ const statusTmplStr = `
 table, th, td {
 	border: 1px solid black;
 }
+tr.down { background: #ffcdd2; }
+tr.degraded { background: #fff9c4; }
+tr.invalid { background: #e0e0e0; }
+tr.never_checked { color: #757575; }
+tr.group td { font-weight: bold; }
 </style>
 <body>
+<p><a href="/certs">Certyfikaty</a> | <a href="/incidents">Incydenty</a></p>
 <table>
 <tr>
 <td>Nazwa</td>
 <td>Adres</td>
+<td>Tagi</td>
 <td>Ostatnio sprawdzony</td>
 <td>Status</td>
+<td>TTFB</td>
+<td>Czas</td>
 </tr>
-{{ range . }}
-<tr>
-<td>{{.Name}}</td><td>{{.Address}}</td>
-{{with .Status}}
-<td>{{.When.Format "02-01-2006 15:04:05"}}</td>
-<td>{{.StatusCode}}</td>
-{{else}}
-<td> - </td><td>0</td>
-{{end}}
+{{ range $g := groups . }}
+{{if $g.Name}}<tr class="group {{stateClass $g.Overall.State}}" data-group-header="{{$g.Name}}">
+<td colspan="7"><a href="#" onclick="toggleGroup({{$g.Name}}); return false;">{{$g.Name}}</a>: <span class="group-state">{{$g.Overall.State}}</span>, {{$g.Overall.Up}} z {{len $g.Members}} działa</td>
+</tr>{{end}}
+{{ range $r := $g.Members }}
+<tr class="{{statusClass .Status}}" data-address="{{redactAddress .Address}}"{{if $g.Name}} data-group="{{$g.Name}}"{{if eq (stateClass $g.Overall.State) "up"}} hidden{{end}}{{end}}>
+<td><a href="/check?name={{.Name}}">{{.Name}}</a></td><td>{{redactAddress .Address}}</td>
+<td>{{range $k, $v := .Conf.Tags}}<a href="/status?tag={{$k}}{{if $v}}={{$v}}{{end}}">{{$k}}{{if $v}}={{$v}}{{end}}</a> {{end}}</td>
+{{if not $r.Status.Checked}}
+<td colspan="4">oczekuje na pierwsze sprawdzenie</td>
+{{else}}{{with .Status}}
+<td><time datetime="{{.When.Format "2006-01-02T15:04:05Z07:00"}}">{{formatTime .When}}</time> <span class="ago"></span></td>
+<td>{{.StatusCode}}{{if eq (statusClass .) "invalid"}} nieprawidłowy: {{.Error}}{{end}}{{if .Slow}} (wolno: {{.Slow}}){{end}}{{if .Degraded}} ({{.Degraded}}){{end}}{{if $r.AckedBy}} potwierdzony przez {{$r.AckedBy}}{{end}}</td>
+<td>{{humanizeLatency .TTFB}}</td><td>{{humanizeLatency .Latency}}</td>
+{{end}}{{end}}
 </tr>
 {{ end }}
+{{ end }}
 </table>
+<script>
+function ago(d) {
+	var s = Math.max(0, Math.round((Date.now() - d.getTime()) / 1000));
+	if (s < 60) return s + "s";
+	if (s < 3600) return Math.floor(s / 60) + "m";
+	if (s < 86400) return Math.floor(s / 3600) + "h";
+	return Math.floor(s / 86400) + "d";
+}
+function updateTimes() {
+	document.querySelectorAll("time").forEach(function(el) {
+		var d = new Date(el.getAttribute("datetime"));
+		{{if browserTimeZone}}el.textContent = d.toLocaleString();{{end}}
+		el.nextElementSibling.textContent = "(" + ago(d) + " temu)";
+	});
+}
+updateTimes();
+setInterval(updateTimes, 1000);
+function groupRows(name) {
+	return Array.prototype.filter.call(document.querySelectorAll("tr[data-group]"), function(row) {
+		return row.getAttribute("data-group") == name;
+	});
+}
+function toggleGroup(name) {
+	groupRows(name).forEach(function(row) { row.hidden = !row.hidden; });
+}
+// updateGroup sets a group state to the worst of its members.
+function updateGroup(name) {
+	var order = ["invalid", "never_checked", "up", "degraded", "down"], worst = "invalid";
+	groupRows(name).forEach(function(row) {
+		if (order.indexOf(row.className) > order.indexOf(worst)) worst = row.className;
+	});
+	document.querySelectorAll("tr[data-group-header]").forEach(function(row) {
+		if (row.getAttribute("data-group-header") != name) return;
+		row.className = "group " + worst;
+		row.querySelector(".group-state").textContent = worst.toUpperCase();
+	});
+}
+if (window.WebSocket) {
+	var ws = new WebSocket((location.protocol == "https:" ? "wss://" : "ws://") + location.host + "/ws");
+	ws.onmessage = function(e) {
+		var ch = JSON.parse(e.data);
+		if (ch.Snapshot) return;
+		document.querySelectorAll("tr[data-address]").forEach(function(row) {
+			if (row.getAttribute("data-address") != ch.Address) return;
+			if (row.cells.length < 7) {
+				location.reload();
+				return;
+			}
+			row.className = ch.State.toLowerCase();
+			var t = row.cells[3].querySelector("time");
+			if (t) t.setAttribute("datetime", ch.Status.When);
+			row.cells[4].textContent = ch.Status.StatusCode + (ch.State == "UP" ? "" : " " + ch.State);
+			if (row.hasAttribute("data-group")) updateGroup(row.getAttribute("data-group"));
+		});
+	};
+}
+</script>
 </body>
 </html>
 `
 
-var statusTmpl = template.Must(template.New("statuspage").Parse(statusTmplStr))
+var statusTmpl = page("status", statusTmplStr)
+
+// displayLocation is a time zone of times on the status page, nil means
+// times are converted by a viewer's browser.
+var displayLocation = time.Local
+
+func setDisplayLocation(name string) error {
+	switch name {
+	case "":
+		displayLocation = time.Local
+	case "browser":
+		displayLocation = nil
+	default:
+		loc, err := time.LoadLocation(name)
+		if err != nil {
+			return err
+		}
+		displayLocation = loc
+	}
+	return nil
+}
+
+func formatTime(t time.Time) string {
+	if displayLocation != nil {
+		t = t.In(displayLocation)
+	}
+	return t.Format("02-01-2006 15:04:05 MST")
+}
 
 type tmplHelper struct {
 	Name    string
 	Address string
 	Status  *Status
+	Conf    *ResConf
+	AckedBy string
+}
+
+// Snapshot returns all resources with their last statuses.
+func (s *StatusChecker) Snapshot() []tmplHelper {
+	s.m.Lock()
+	s.statusMutex.Lock()
+	defer s.m.Unlock()
+	defer s.statusMutex.Unlock()
+	arr := make([]tmplHelper, 0, len(s.config.Configs))
+	for _, c := range s.config.Configs {
+		h := tmplHelper{Name: c.Name, Address: c.Address, Status: s.statuses[c.Address], Conf: c}
+		if a, ok := s.alerts[c.Address]; ok {
+			h.AckedBy = a.AckedBy
+		}
+		arr = append(arr, h)
+	}
+	return arr
+}
+
+// apiStatus is a resource in /api/status, uptime and coverage are of the
+// last 24h.
+type apiStatus struct {
+	Name       string
+	Address    string
+	Type       string            `json:",omitempty"`
+	Group      string            `json:",omitempty"`
+	Tags       map[string]string `json:",omitempty"`
+	State      State
+	When       time.Time     `json:",omitzero"`
+	StatusCode int           `json:",omitempty"`
+	TTFB       time.Duration `json:",omitempty"`
+	Latency    time.Duration `json:",omitempty"`
+	Error      string        `json:",omitempty"`
+	Uptime     float64
+	Coverage   float64
+	AckedBy    string `json:",omitempty"`
+}
+
+// StatusList returns resources having all tags with their states.
+func (s *StatusChecker) StatusList(tags []string) []*apiStatus {
+	ret := []*apiStatus{}
+	for _, el := range tagged(s.Snapshot(), tags) {
+		a := &apiStatus{
+			Name:    el.Name,
+			Address: redactAddress(el.Address),
+			Type:    el.Conf.Type,
+			Group:   el.Conf.Group,
+			Tags:    el.Conf.Tags,
+			State:   el.Status.State(),
+			AckedBy: el.AckedBy,
+		}
+		if st := el.Status; st != nil {
+			a.When, a.StatusCode, a.TTFB, a.Latency, a.Error = st.When, st.StatusCode, st.TTFB, st.Latency, st.Error
+		}
+		a.Uptime, a.Coverage = s.Uptime(el.Address, 24*time.Hour)
+		ret = append(ret, a)
+	}
+	return ret
 }
 
 func RegisterStatusHandler(sc *StatusChecker) {
+	// ?tag=key or ?tag=key=value, repeated, shows resources having all tags.
 	http.HandleFunc("/status", func(rw http.ResponseWriter, req *http.Request) {
-		arr := make([]tmplHelper, 0)
-		for _, c := range sc.config.Configs {
-			arr = append(arr, tmplHelper{c.Name, c.Address, sc.statuses[c.Address]})
-		}
+		arr := tagged(sc.Snapshot(), req.URL.Query()["tag"])
 		if err := statusTmpl.Execute(rw, arr); err != nil {
-			log.Printf("Tmpl render: %s", err)
+			slog.Error("Template render", "error", err)
+		}
+	})
+
+	describeAPI(&apiOperation{
+		Method: "GET", Path: "/api/status", Summary: "List resources with their current state and 24h uptime",
+		Params:    []apiParam{{Name: "tag", In: "query", Doc: "key or key=value, repeated to match all"}},
+		Responses: map[int]interface{}{http.StatusOK: []*apiStatus{}},
+	})
+	http.HandleFunc("/api/status", func(rw http.ResponseWriter, req *http.Request) {
+		ret := sc.StatusList(req.URL.Query()["tag"])
+		rw.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(rw).Encode(ret); err != nil {
+			slog.Error("Status", "error", err)
 		}
 	})
 }
@@ -317,73 +1363,254 @@ func RegisterStatusHandler(sc *StatusChecker) {
 ///////////////////////////////////////////////////////////////////////////////
 
 var (
-	workers        = flag.Int("workers", 1, "How many worker threads to start.")
-	configFilePath = flag.String("config", "", "Config file.")
-	interval       = flag.Duration("interval", 60*time.Second, "How often check all pages.")
-	noRpc          = flag.Bool("norpc", false, "Don't set upt RPC server.")
+	workers         = flag.Int("workers", 1, "How many worker threads to start, a minimum with -max-workers.")
+	configFilePath  = flag.String("config", "", "Config file or a directory of *.json and *.yaml fragments.")
+	interval        = flag.Duration("interval", 60*time.Second, "How often check resources without their own Interval.")
+	noRpc           = flag.Bool("norpc", false, "Don't serve admin commands, neither gRPC nor RPC.")
+	rpcCompat       = flag.Bool("rpc", false, "Also serve (server) or use (commands) the net/rpc admin interface of older releases, gRPC otherwise.")
+	shutdownTimeout = flag.Duration("shutdown-timeout", 30*time.Second, "How long a stopping server waits for checks and requests in progress.")
 
-	mode = flag.String("mode", "server", "server|add|remove - add and remove send a command to server.")
+	mode = flag.String("mode", "server", "server|agent|add|remove|update|pause|list|check|export|import|watch|diagnostics|preview|oncall|ack|openapi|hashpw|keygen|sign - all but server, check, openapi, hashpw, keygen and sign send a command to server.")
 	addr = flag.String("addr", "localhost:18080", "A server where rpc will be exposed.")
 
-	sName = flag.String("sname", "", "A name for address.")
-	sAddr = flag.String("saddr", "", "A resource address to check.")
+	sName     = flag.String("sname", "", "A name for address.")
+	sAddr     = flag.String("saddr", "", "A resource address to check.")
+	user      = flag.String("user", os.Getenv("USER"), "Who acknowledges a problem in -mode ack.")
+	resume    = flag.Bool("resume", false, "For -mode pause, resume checks of a paused resource.")
+	ttl       = flag.Duration("ttl", 0, "For -mode add, remove the resource automatically after this time.")
+	tag       = flag.String("tag", "", "A key or key=value tag of resources in -mode list, all if empty.")
+	setFields = flag.String("set", "", `Fields changed by -mode update as a JSON object, e.g. {"Interval": "30s"}.`)
+
+	jsonOutput = flag.Bool("json", false, "Print results of client modes, e.g. list or check, as JSON.")
+
+	watchEvery = flag.Duration("watch-every", 5*time.Second, "How often check a watched resource.")
+	watchFor   = flag.Duration("watch-for", 15*time.Minute, "How long a watch lasts.")
+
+	since      = flag.Duration("since", 24*time.Hour, "How far back -mode diagnostics collects results.")
+	outPath    = flag.String("out", "", "An output file of -mode diagnostics, openapi or export (stdout if empty).")
+	inPath     = flag.String("in", "", "A config file of -mode import, - for stdin.")
+	specPath   = flag.String("spec", "", "An OpenAPI spec (JSON) for -mode openapi.")
+	operations = flag.String("ops", "", "Comma separated operation ids for -mode openapi, 'all' for all GETs, health checks if empty.")
+
+	fromState = flag.String("from", "UP", "A state before a hypothetical change for -mode preview.")
+	toState   = flag.String("to", "DOWN", "A state after a hypothetical change for -mode preview.")
+
+	timezone = flag.String("timezone", "", "A time zone of the status page, e.g. Europe/Warsaw. Server local if empty, 'browser' for a viewer's time zone.")
 )
 
 func main() {
 	flag.Parse()
+	if err := setupTransport(); err != nil {
+		log.Fatal(err)
+	}
 
 	if *mode == "server" {
+		var out io.Writer = os.Stderr
+		if len(*logFile) > 0 {
+			f, err := openLogFile(*logFile)
+			if err != nil {
+				log.Fatal(err)
+			}
+			out = f
+		}
+		if err := setupLogging(out, serverLog); err != nil {
+			log.Fatal(err)
+		}
+		if err := setDisplayLocation(*timezone); err != nil {
+			fatal(err.Error())
+		}
+		slog.Info("Check types", "types", checkTypes())
+		if len(*templateDir) > 0 {
+			if err := loadTemplates(os.DirFS(*templateDir), "."); err != nil {
+				fatal(err.Error())
+			}
+		}
 		var config *Config
+		var kv kvStore
 		var err error
-		if len(*configFilePath) > 0 {
+		if *demo {
+			if len(*bundlePath) > 0 || len(*configFilePath) > 0 || len(*kvType) > 0 {
+				fatal("-demo can't be used with -config, -bundle or -kv")
+			}
+			if config, err = DemoConfig(); err != nil {
+				fatal(err.Error())
+			}
+		} else if len(*bundlePath) > 0 {
+			bundle, err := LoadBundle(*bundlePath, *bundleKey)
+			if err != nil {
+				fatal(err.Error())
+			}
+			if err := loadTemplates(bundle.Templates, "."); err != nil {
+				fatal(err.Error())
+			}
+			config = bundle.Config
+			slog.Info("Loaded bundle", "path", *bundlePath, "version", bundle.Manifest.Version,
+				"created", bundle.Manifest.Created, "resources", len(config.Configs))
+		} else if len(*kvType) > 0 {
+			if len(*configFilePath) > 0 {
+				fatal("-kv can't be used with -config")
+			}
+			if kv, err = newKVStore(); err != nil {
+				fatal(err.Error())
+			}
+			if config, err = LoadKVConfig(kv); err != nil {
+				fatal(err.Error())
+			}
+			slog.Info("Loaded config", "source", kvSource(), "resources", len(config.Configs))
+		} else if len(*configFilePath) > 0 {
 			config, err = LoadConfig(*configFilePath)
 			if err != nil {
-				log.Fatal(err)
+				fatal(err.Error())
 			}
-			log.Printf("Loaded config from: %s with %d addresses", *configFilePath, len(config.Configs))
+			slog.Info("Loaded config", "source", *configFilePath, "resources", len(config.Configs))
 		}
 		sc := NewStatusChecker(config)
+		if sc.cluster, err = newCluster(); err != nil {
+			fatal(err.Error())
+		}
 		if *noRpc == false {
-			admin := &AdminServer{sc}
-			rpc.Register(admin)
-			rpc.HandleHTTP()
+			RegisterGRPCHandler(sc, adminService, agentService, reflectionService, reflectionAlphaService)
+			if *rpcCompat {
+				RegisterAdminHandler(sc)
+			}
 		}
 
 		RegisterStatusHandler(sc)
-		go http.ListenAndServe(*addr, nil)
-		log.Printf("Listening at: %s", *addr)
+		RegisterWatchHandler(sc)
+		RegisterDetailHandler(sc)
+		RegisterCertsHandler(sc)
+		RegisterOverallHandler(sc)
+		RegisterGroupsHandler(sc)
+		RegisterRegionsHandler(sc)
+		RegisterShardHandler(sc)
+		RegisterHeartbeatHandler(sc)
+		RegisterMetricsHandler(sc)
+		RegisterOnCallHandler(sc)
+		RegisterAckHandler(sc)
+		RegisterIncidentsHandler(sc)
+		RegisterBadgeHandler(sc)
+		RegisterResourcesHandler(sc)
+		RegisterWSHandler(sc)
+		RegisterEventsHandler(sc)
+		RegisterOpenAPIHandler(sc)
+		RegisterAuditHandler(sc)
+		RegisterReloadHandler(sc)
+		RegisterHealthHandlers(sc)
+		if len(*auditPath) > 0 {
+			if err := OpenAudit(*auditPath); err != nil {
+				fatal(err.Error())
+			}
+		}
+		keys := sc.config.ApiKeys
+		if len(*keysFilePath) > 0 {
+			fileKeys, err := LoadKeys(*keysFilePath)
+			if err != nil {
+				fatal(err.Error())
+			}
+			keys = append(keys, fileKeys...)
+		}
+		setListenAddrs(sc.config.Listen)
+		h := RequireLogin(sc.config.Login, keys, RequireKey(keys, http.DefaultServeMux))
+		h = RateLimit(sc.config.RateLimit, CORS(sc.config.CORS, hideDebug(h)))
+		// Servers stop after checks, so results of the last ones are served
+		// until then.
+		serving, stopServing := context.WithCancel(context.Background())
+		tlsConfig, err := serverTLSConfig()
+		if err != nil {
+			fatal(err.Error())
+		}
+		var servers sync.WaitGroup
+		listen := func(addr string, h http.Handler) {
+			servers.Add(1)
+			go func() {
+				defer servers.Done()
+				if err := serve(serving, addr, h, tlsConfig); err != nil {
+					fatal("Serve", "address", addr, "error", err)
+				}
+			}()
+		}
+		if len(*adminAddr) > 0 && *adminAddr != *addr {
+			listen(*adminAddr, h)
+			slog.Info("Admin listening", "address", *adminAddr)
+			h = statusOnly(h)
+		}
+		listen(*addr, h)
+		slog.Info("Listening", "address", *addr)
+		if len(*debugAddr) > 0 {
+			sc.publishVars()
+			listen(*debugAddr, debugHandler(keys))
+			slog.Info("Debug listening", "address", *debugAddr)
+		}
 
-		// Handle interruptions.
-		c := make(chan os.Signal, 1)
-		signal.Notify(c, os.Interrupt)
+		// Handle interruptions, a second one stops at once.
+		interrupted, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		ctx, cancel := context.WithCancel(context.Background())
 		go func() {
-			for range c {
-				// sig is a ^C, handle it
-				log.Printf("Interrupt... please be patient.")
-				if len(*configFilePath) > 0 {
-					log.Printf("saving config\n")
-					sc.CloseNicely()
-				}
-				os.Exit(0)
-			}
+			<-interrupted.Done()
+			stop()
+			slog.Info("Interrupt... please be patient.")
+			sdNotify("STOPPING=1")
+			cancel()
 		}()
+		go sc.reloadOnHangup()
+		if kv != nil {
+			go sc.watchKV(kv)
+		}
+		if *dockerDiscovery {
+			d, err := newDocker(*dockerHost)
+			if err != nil {
+				fatal(err.Error())
+			}
+			go sc.discoverDocker(d)
+		}
+		if len(*consulCatalog) > 0 {
+			go sc.discoverConsul()
+		}
+		for _, c := range sc.config.SRV {
+			go sc.discoverSRV(c)
+		}
+		if len(*targetFiles) > 0 {
+			for _, path := range strings.Split(*targetFiles, ",") {
+				go sc.discoverFile(path)
+			}
+		}
+		if sc.cluster != nil {
+			sc.pollCluster()
+			slog.Info("Cluster", "members", strings.Join(sc.cluster.members(), ","))
+			go sc.runCluster(ctx)
+		}
+		go sc.notifySystemd()
 
-		sc.Run(*workers)
+		sc.Run(ctx, *workers)
+		if len(*configFilePath) > 0 {
+			slog.Info("Saving config", "path", *configFilePath)
+			sc.CloseNicely()
+		}
+		stopServing()
+		servers.Wait()
+		slog.Info("Stopped")
 	} else if *mode == "add" {
-		client, err := rpc.DialHTTP("tcp", *addr)
+		client, err := dialAdmin()
 		if err != nil {
 			log.Fatal("dialing:", err)
 		}
 		// Synchronous call
-		ac := &ResConf{*sName, *sAddr, ""}
+		ac := &ResConf{Name: *sName, Address: *sAddr}
+		if *ttl > 0 {
+			ac.Expires = time.Now().Add(*ttl)
+		}
 		var reply int
 		err = client.Call("AdminServer.Add", ac, &reply)
 		if err != nil {
 			log.Fatal("AdminServer error:", err)
 		}
-		log.Printf("AdminServer.Add: %d\n", reply)
+		if *jsonOutput {
+			printJSON(ac)
+		} else {
+			log.Printf("AdminServer.Add: %d\n", reply)
+		}
 	} else if *mode == "remove" {
-		client, err := rpc.DialHTTP("tcp", *addr)
+		client, err := dialAdmin()
 		if err != nil {
 			log.Fatal("dialing:", err)
 		}
@@ -409,6 +1636,248 @@ func main() {
 		if err != nil {
 			log.Fatal("AdminServer error:", err)
 		}
-		log.Printf("AdminServer.Remove: %d\n", reply)
+		if *jsonOutput {
+			printJSON(map[string]interface{}{"Key": rr.Key, "Removed": reply == 0})
+		} else {
+			log.Printf("AdminServer.Remove: %d\n", reply)
+		}
+	} else if *mode == "watch" {
+		client, err := dialAdmin()
+		if err != nil {
+			log.Fatal("dialing:", err)
+		}
+		if len(*sName) == 0 {
+			log.Fatalf("For -mode watch one must specify -sname")
+		}
+		wr := WatchRequest{*sName, *watchEvery, *watchFor}
+		var reply int
+		err = client.Call("AdminServer.Watch", wr, &reply)
+		if err != nil {
+			log.Fatal("AdminServer error:", err)
+		}
+		if *jsonOutput {
+			printJSON(wr)
+		} else {
+			log.Printf("Watching %s, see http://%s/watch?name=%s", *sName, *addr, *sName)
+		}
+	} else if *mode == "diagnostics" {
+		client, err := dialAdmin()
+		if err != nil {
+			log.Fatal("dialing:", err)
+		}
+		if len(*sName) == 0 {
+			log.Fatalf("For -mode diagnostics one must specify -sname")
+		}
+		var archive []byte
+		err = client.Call("AdminServer.Diagnostics", DiagnosticsRequest{*sName, *since}, &archive)
+		if err != nil {
+			log.Fatal("AdminServer error:", err)
+		}
+		path := *outPath
+		if len(path) == 0 {
+			path = fmt.Sprintf("diagnostics-%s-%s.zip", *sName, time.Now().Format("20060102-150405"))
+		}
+		if err := ioutil.WriteFile(path, archive, 0644); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Diagnostics saved to: %s", path)
+	} else if *mode == "preview" {
+		client, err := dialAdmin()
+		if err != nil {
+			log.Fatal("dialing:", err)
+		}
+		if len(*sName) == 0 {
+			log.Fatalf("For -mode preview one must specify -sname")
+		}
+		var result PreviewResult
+		err = client.Call("AdminServer.Preview", PreviewRequest{*sName, *fromState, *toState, ""}, &result)
+		if err != nil {
+			log.Fatal("AdminServer error:", err)
+		}
+		if *jsonOutput {
+			printJSON(&result)
+		} else {
+			printPreview(&result)
+		}
+	} else if *mode == "ack" {
+		client, err := dialAdmin()
+		if err != nil {
+			log.Fatal("dialing:", err)
+		}
+		if len(*sName) == 0 {
+			log.Fatalf("For -mode ack one must specify -sname")
+		}
+		var reply int
+		ar := AckRequest{*sName, *user}
+		if err = client.Call("AdminServer.Ack", ar, &reply); err != nil {
+			log.Fatal("AdminServer error:", err)
+		}
+		if *jsonOutput {
+			printJSON(ar)
+		} else {
+			log.Printf("Acknowledged %s as %s", *sName, *user)
+		}
+	} else if *mode == "update" {
+		client, err := dialAdmin()
+		if err != nil {
+			log.Fatal("dialing:", err)
+		}
+		if len(*sName) == 0 {
+			log.Fatalf("For -mode update one must specify -sname")
+		}
+		fields := make(map[string]interface{})
+		if len(*setFields) > 0 {
+			if err := json.Unmarshal([]byte(*setFields), &fields); err != nil {
+				log.Fatalf("Bad -set: %s", err)
+			}
+		}
+		if len(*sAddr) > 0 {
+			fields["Address"] = *sAddr
+		}
+		if len(fields) == 0 {
+			log.Fatalf("For -mode update one must specify -saddr or -set")
+		}
+		b, err := json.Marshal(fields)
+		if err != nil {
+			log.Fatal(err)
+		}
+		var result ResConf
+		if err = client.Call("AdminServer.Update", UpdateRequest{*sName, b}, &result); err != nil {
+			log.Fatal("AdminServer error:", err)
+		}
+		if *jsonOutput {
+			printJSON(&result)
+		} else {
+			log.Printf("Updated %s: %s (%s)", *sName, result.Name, result.Address)
+		}
+	} else if *mode == "pause" {
+		client, err := dialAdmin()
+		if err != nil {
+			log.Fatal("dialing:", err)
+		}
+		if len(*sName) == 0 {
+			log.Fatalf("For -mode pause one must specify -sname")
+		}
+		var result ResConf
+		if err = client.Call("AdminServer.Pause", PauseRequest{*sName, *resume}, &result); err != nil {
+			log.Fatal("AdminServer error:", err)
+		}
+		if *jsonOutput {
+			printJSON(&result)
+		} else if *resume {
+			log.Printf("Resumed %s", *sName)
+		} else {
+			log.Printf("Paused %s, resume with -resume", *sName)
+		}
+	} else if *mode == "check" {
+		runCheckMode()
+	} else if *mode == "agent" {
+		runAgent()
+	} else if *mode == "list" {
+		client, err := dialAdmin()
+		if err != nil {
+			log.Fatal("dialing:", err)
+		}
+		var result []*apiStatus
+		if err = client.Call("AdminServer.List", ListRequest{*tag}, &result); err != nil {
+			log.Fatal("AdminServer error:", err)
+		}
+		if *jsonOutput {
+			printJSON(result)
+		} else {
+			printList(result)
+		}
+	} else if *mode == "export" {
+		client, err := dialAdmin()
+		if err != nil {
+			log.Fatal("dialing:", err)
+		}
+		var result []*ResConf
+		if err = client.Call("AdminServer.Export", 0, &result); err != nil {
+			log.Fatal("AdminServer error:", err)
+		}
+		if err := writeExport(*outPath, result); err != nil {
+			log.Fatal(err)
+		}
+		if len(*outPath) > 0 {
+			log.Printf("Exported %d resources to: %s", len(result), *outPath)
+		}
+	} else if *mode == "import" {
+		client, err := dialAdmin()
+		if err != nil {
+			log.Fatal("dialing:", err)
+		}
+		if len(*inPath) == 0 {
+			log.Fatalf("For -mode import one must specify -in, a config file or - for stdin")
+		}
+		confs, err := readImport(*inPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		var result ReloadResult
+		if err = client.Call("AdminServer.Import", ImportRequest{confs}, &result); err != nil {
+			log.Fatal("AdminServer error:", err)
+		}
+		if *jsonOutput {
+			printJSON(&result)
+		} else {
+			log.Printf("Imported %s: %d added, %d updated", *inPath, len(result.Added), len(result.Updated))
+		}
+	} else if *mode == "oncall" {
+		client, err := dialAdmin()
+		if err != nil {
+			log.Fatal("dialing:", err)
+		}
+		var result []*OnCall
+		if err = client.Call("AdminServer.OnCall", 0, &result); err != nil {
+			log.Fatal("AdminServer error:", err)
+		}
+		if *jsonOutput {
+			printJSON(result)
+		} else {
+			printOnCall(result)
+		}
+	} else if *mode == "hashpw" {
+		if err := runHashPassword(); err != nil {
+			log.Fatal(err)
+		}
+	} else if *mode == "keygen" {
+		if len(*outPath) == 0 {
+			log.Fatalf("For -mode keygen one must specify -out, a prefix of key files")
+		}
+		if err := GenerateBundleKey(*outPath); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Keys saved to: %s.pub and %s.key", *outPath, *outPath)
+	} else if *mode == "sign" {
+		if len(*bundlePath) == 0 || len(*bundleKey) == 0 {
+			log.Fatalf("For -mode sign one must specify -bundle and -bundle-key")
+		}
+		if err := SignBundle(*bundlePath, *bundleKey); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Signature saved to: %s.sig", *bundlePath)
+	} else if *mode == "openapi" {
+		if len(*specPath) == 0 {
+			log.Fatalf("For -mode openapi one must specify -spec")
+		}
+		var ids []string
+		if len(*operations) > 0 {
+			ids = strings.Split(*operations, ",")
+		}
+		confs, err := importOpenAPI(*specPath, *sAddr, ids)
+		if err != nil {
+			log.Fatal(err)
+		}
+		b, err := json.MarshalIndent(&Config{Configs: confs}, "", " ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(*outPath) == 0 {
+			fmt.Println(string(b))
+		} else if err := ioutil.WriteFile(*outPath, b, 0644); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Generated %d checks from %s", len(confs), *specPath)
 	}
 }
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// secretAddresses are addresses with a password "secret" in them.
+var secretAddresses = []string{
+	"redis://user:secret@db:6379/0",
+	"user:secret@tcp(db:3306)/app",
+}
+
+func TestRedactedAddresses(t *testing.T) {
+	var confs []*ResConf
+	for i, a := range secretAddresses {
+		confs = append(confs, &ResConf{Name: string(rune('a' + i)), Address: a})
+	}
+	sc := NewStatusChecker(&Config{Configs: confs})
+	var shown bytes.Buffer
+	for _, el := range sc.StatusList(nil) {
+		shown.WriteString(el.Address + "\n")
+	}
+	b, _ := json.Marshal(sc.Regions())
+	shown.Write(b)
+	if err := statusTmpl.Execute(&shown, sc.Snapshot()); err != nil {
+		t.Fatal(err)
+	}
+	st := &Status{When: time.Now(), StatusCode: 500}
+	for _, c := range confs {
+		b, err := (&Webhook{&WebhookConf{}}).payload(&Event{Conf: c, Old: &Status{}, New: st})
+		if err != nil {
+			t.Fatal(err)
+		}
+		shown.Write(b)
+	}
+	if strings.Contains(shown.String(), "secret") {
+		t.Errorf("a password shown:\n%s", shown.String())
+	}
+	if n := strings.Count(shown.String(), "REDACTED@"); n < 4*len(confs) {
+		t.Errorf("%d addresses redacted", n)
+	}
+}
+
+func TestCheckHTTPBody(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/endless":
+			for req.Context().Err() == nil {
+				if _, err := rw.Write(make([]byte, 1024)); err != nil {
+					return
+				}
+				rw.(http.Flusher).Flush()
+				time.Sleep(time.Millisecond)
+			}
+		case "/short":
+			rw.Header().Set("Content-Length", "100")
+			rw.Write([]byte("short"))
+		}
+	}))
+	defer srv.Close()
+	oldTimeout, oldMax := *httpCheckTimeout, *httpMaxBody
+	defer func() { *httpCheckTimeout, *httpMaxBody = oldTimeout, oldMax }()
+
+	*httpCheckTimeout, *httpMaxBody = time.Minute, 64<<10
+	if st := checkHTTP(&ResConf{Name: "big", Address: srv.URL + "/endless"}); st.State() != StateUp || st.TTFB <= 0 {
+		t.Errorf("a body over a limit: %+v", st)
+	}
+	*httpCheckTimeout, *httpMaxBody = 100*time.Millisecond, 1<<40
+	if st := checkHTTP(&ResConf{Name: "endless", Address: srv.URL + "/endless"}); st.State() != StateDown {
+		t.Errorf("an endless body: %+v", st)
+	}
+	if st := checkHTTP(&ResConf{Name: "short", Address: srv.URL + "/short"}); st.State() != StateDown || !strings.HasPrefix(st.Error, "reading body") {
+		t.Errorf("a short body: %+v", st)
+	}
+}
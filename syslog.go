@@ -0,0 +1,90 @@
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log/syslog"
+	"net"
+	"net/url"
+	"strings"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// Syslog and journald log writers, one per level: debug, info, warn and
+// error. Journald gets entries of its native protocol, so a message keeps
+// its priority and the identifier.
+///////////////////////////////////////////////////////////////////////////////
+
+const (
+	logIdentifier  = "statusmonitor"
+	journaldSocket = "/run/systemd/journal/socket"
+)
+
+// priorityWriter writes each record with a syslog priority.
+type priorityWriter func(string) error
+
+func (f priorityWriter) Write(p []byte) (int, error) {
+	if err := f(string(p)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// syslogWriters dial a local syslog daemon if addr is "local", a remote one
+// at a network://host:port address otherwise.
+func syslogWriters(addr string) ([4]io.Writer, error) {
+	var network, raddr string
+	if addr != "local" {
+		u, err := url.Parse(addr)
+		if err != nil || len(u.Scheme) == 0 || len(u.Host) == 0 {
+			return [4]io.Writer{}, fmt.Errorf("bad -syslog %q, use local or e.g. udp://host:514", addr)
+		}
+		network, raddr = u.Scheme, u.Host
+	}
+	w, err := syslog.Dial(network, raddr, syslog.LOG_DAEMON|syslog.LOG_INFO, logIdentifier)
+	if err != nil {
+		return [4]io.Writer{}, fmt.Errorf("syslog: %s", err)
+	}
+	return [4]io.Writer{priorityWriter(w.Debug), priorityWriter(w.Info), priorityWriter(w.Warning), priorityWriter(w.Err)}, nil
+}
+
+type journaldWriter struct {
+	conn     net.Conn
+	priority int
+}
+
+func journaldWriters() ([4]io.Writer, error) {
+	conn, err := net.Dial("unixgram", journaldSocket)
+	if err != nil {
+		return [4]io.Writer{}, fmt.Errorf("journald: %s", err)
+	}
+	return [4]io.Writer{
+		&journaldWriter{conn, 7}, // LOG_DEBUG
+		&journaldWriter{conn, 6}, // LOG_INFO
+		&journaldWriter{conn, 4}, // LOG_WARNING
+		&journaldWriter{conn, 3}, // LOG_ERR
+	}, nil
+}
+
+// Write sends a record as one entry, a multiline message is sent with its
+// length as the protocol requires.
+func (j *journaldWriter) Write(p []byte) (int, error) {
+	msg := strings.TrimSuffix(string(p), "\n")
+	var b strings.Builder
+	fmt.Fprintf(&b, "PRIORITY=%d\nSYSLOG_IDENTIFIER=%s\n", j.priority, logIdentifier)
+	if strings.Contains(msg, "\n") {
+		b.WriteString("MESSAGE\n")
+		binary.Write(&b, binary.LittleEndian, uint64(len(msg)))
+		b.WriteString(msg)
+		b.WriteString("\n")
+	} else {
+		fmt.Fprintf(&b, "MESSAGE=%s\n", msg)
+	}
+	if _, err := j.conn.Write([]byte(b.String())); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
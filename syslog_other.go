@@ -0,0 +1,16 @@
+//go:build windows || plan9
+
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+func syslogWriters(addr string) ([4]io.Writer, error) {
+	return [4]io.Writer{}, fmt.Errorf("-syslog isn't supported on this system")
+}
+
+func journaldWriters() ([4]io.Writer, error) {
+	return [4]io.Writer{}, fmt.Errorf("-journald isn't supported on this system")
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// systemd integration: under a Type=notify unit a server tells systemd it's
+// ready once it serves and, with WatchdogSec, sends keepalives while the
+// checker isn't stuck, so systemd restarts a hung monitor. Nothing is sent
+// without NOTIFY_SOCKET.
+///////////////////////////////////////////////////////////////////////////////
+
+// sdNotify sends a state, e.g. READY=1, to systemd.
+func sdNotify(state string) error {
+	name := os.Getenv("NOTIFY_SOCKET")
+	if len(name) == 0 {
+		return nil
+	}
+	if name[0] == '@' {
+		// An abstract socket.
+		name = "\x00" + name[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: name, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// watchdogInterval returns how often systemd expects keepalives, 0 if it
+// doesn't watch this process.
+func watchdogInterval() time.Duration {
+	if pid := os.Getenv("WATCHDOG_PID"); len(pid) > 0 && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// notifySystemd reports readiness and then sends keepalives at half of the
+// watchdog interval. A keepalive is sent after taking locks of the checker,
+// so a deadlock stops them.
+func (s *StatusChecker) notifySystemd() {
+	s.m.Lock()
+	n := len(s.config.Configs)
+	s.m.Unlock()
+	if err := sdNotify(fmt.Sprintf("READY=1\nSTATUS=Checking %d resources", n)); err != nil {
+		slog.Error("systemd notify", "error", err)
+		return
+	}
+	every := watchdogInterval() / 2
+	if every <= 0 {
+		return
+	}
+	for range time.Tick(every) {
+		s.m.Lock()
+		s.m.Unlock()
+		s.statusMutex.Lock()
+		s.statusMutex.Unlock()
+		if err := sdNotify("WATCHDOG=1"); err != nil {
+			slog.Error("systemd watchdog", "error", err)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"regexp"
+	"sort"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// Tags: key=value labels of resources, a tag filter ("key" or "key=value",
+// see hasTag) narrows the status page and APIs, notifications are routed
+// by them and metrics carry them in statusmonitor_resource_info.
+///////////////////////////////////////////////////////////////////////////////
+
+// hasTags returns whether a resource matches all tag filters.
+func (c *ResConf) hasTags(tags []string) bool {
+	for _, t := range tags {
+		if !c.hasTag(t) {
+			return false
+		}
+	}
+	return true
+}
+
+// tagged returns resources matching all tag filters.
+func tagged(arr []tmplHelper, tags []string) []tmplHelper {
+	if len(tags) == 0 {
+		return arr
+	}
+	ret := make([]tmplHelper, 0, len(arr))
+	for _, el := range arr {
+		if el.Conf.hasTags(tags) {
+			ret = append(ret, el)
+		}
+	}
+	return ret
+}
+
+var invalidLabelChar = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// tagLabels returns Prometheus label pairs of tags, sorted by a key, e.g.
+// tag_team and web for team=web.
+func tagLabels(tags map[string]string) []string {
+	var keys []string
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var ret []string
+	for _, k := range keys {
+		ret = append(ret, "tag_"+invalidLabelChar.ReplaceAllString(k, "_"), tags[k])
+	}
+	return ret
+}
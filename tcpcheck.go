@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// A TCP check: a connection to a host:port Address is opened and closed,
+// e.g. for a service without an HTTP endpoint.
+///////////////////////////////////////////////////////////////////////////////
+
+const tcpTimeout = 10 * time.Second
+
+func init() {
+	RegisterChecker("tcp", checkTCP)
+}
+
+func validateTCP(addr string) error {
+	if _, port, err := net.SplitHostPort(addr); err != nil {
+		return err
+	} else if len(port) == 0 {
+		return fmt.Errorf("%s: empty port", addr)
+	}
+	return nil
+}
+
+// urlHost returns host:port of a URL, with a default port if it has none.
+func urlHost(u *url.URL, port string) string {
+	if len(u.Port()) > 0 {
+		return u.Host
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+func checkTCP(c *ResConf) *Status {
+	st := &Status{When: time.Now()}
+	d := net.Dialer{Timeout: tcpTimeout, Resolver: newResolver(c.resolver())}
+	conn, err := d.Dial(dialNetwork("tcp", c.Family), c.Address)
+	st.Latency = time.Since(st.When)
+	if err != nil {
+		st.StatusCode = UnknownError
+		st.Error = c.errorText(err)
+		return st
+	}
+	defer conn.Close()
+	st.StatusCode = http.StatusOK
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		st.RemoteIP = addr.IP.String()
+	}
+	return st
+}
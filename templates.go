@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log/slog"
+	"path"
+	"strings"
+	"time"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// Page templates: built-in ones can be overridden with files from
+// -templates, e.g. status.html replaces the status page. All pages share
+// a library of formatting functions.
+///////////////////////////////////////////////////////////////////////////////
+
+var templateDir = flag.String("templates", "", "A directory with templates overriding built-in pages, e.g. status.html.")
+
+var tmplFuncs = template.FuncMap{
+	"formatTime":      formatTime,
+	"browserTimeZone": func() bool { return displayLocation == nil },
+	"roundDuration":   roundDuration,
+	"durationSince":   durationSince,
+	"humanizeBytes":   humanizeBytes,
+	"humanizeLatency": humanizeLatency,
+	"statusClass":     statusClass,
+	"stateClass":      stateClass,
+	"groups":          groupResources,
+	"uptimeColor":     uptimeColor,
+	"redactAddress":   redactAddress,
+}
+
+// pages are built-in templates by a name of a file overriding them.
+var pages = make(map[string]*template.Template)
+
+func page(name, text string) *template.Template {
+	t := template.Must(template.New(name).Funcs(tmplFuncs).Parse(text))
+	pages[name] = t
+	return t
+}
+
+// loadTemplates replaces built-in pages with files found in fsys, it must be
+// called before any page is rendered.
+func loadTemplates(fsys fs.FS, dir string) error {
+	for name, t := range pages {
+		b, err := fs.ReadFile(fsys, path.Join(dir, name+".html"))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if _, err := t.Parse(string(b)); err != nil {
+			return fmt.Errorf("%s.html: %s", name, err)
+		}
+		slog.Info("Template loaded", "name", name)
+	}
+	return nil
+}
+
+// durationSince returns time passed since t, rounded for display.
+func durationSince(t time.Time) time.Duration {
+	return roundDuration(time.Since(t))
+}
+
+// humanizeBytes formats a size in bytes, e.g. 1.5 MiB.
+func humanizeBytes(v interface{}) string {
+	var n float64
+	switch t := v.(type) {
+	case int:
+		n = float64(t)
+	case int64:
+		n = float64(t)
+	case uint64:
+		n = float64(t)
+	case float64:
+		n = t
+	default:
+		return fmt.Sprint(v)
+	}
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	i := 0
+	for ; n >= 1024 && i < len(units)-1; i++ {
+		n /= 1024
+	}
+	if i == 0 {
+		return fmt.Sprintf("%.0f %s", n, units[i])
+	}
+	return fmt.Sprintf("%.1f %s", n, units[i])
+}
+
+// humanizeLatency formats a latency with a precision fit for its size,
+// e.g. 850µs, 120ms or 1.25s.
+func humanizeLatency(d time.Duration) string {
+	switch {
+	case d <= 0:
+		return "-"
+	case d < time.Millisecond:
+		return d.Round(time.Microsecond).String()
+	case d < time.Second:
+		return d.Round(time.Millisecond).String()
+	}
+	return d.Round(10 * time.Millisecond).String()
+}
+
+// statusClass returns a CSS class of a status: up, degraded, down, invalid
+// or never_checked.
+func statusClass(st *Status) string {
+	return stateClass(st.State())
+}
+
+func stateClass(s State) string {
+	return strings.ToLower(s.String())
+}
+
+// uptimeColor returns a color of an uptime percentage.
+func uptimeColor(percent float64) string {
+	switch {
+	case percent >= 99.9:
+		return "#2e7d32"
+	case percent >= 99:
+		return "#f9a825"
+	}
+	return "#c62828"
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// Per-resource timers: each resource is checked every its Interval
+// (-interval if empty), right when it's added and then at its -jitter
+// offset. A run is skipped while a previous check of a resource still runs.
+// Checks which wait for a worker or run past their next time are overdue.
+///////////////////////////////////////////////////////////////////////////////
+
+// timersResolution is how often timers are synced with a config, an added
+// resource is checked at most this late.
+const timersResolution = time.Second
+
+// overdueAfter is how long a due check may wait for a worker.
+const overdueAfter = time.Second
+
+type timer struct {
+	every   time.Duration
+	next    time.Time // of a next run
+	due     time.Time // when a check waiting for a worker was due, zero if none
+	started time.Time // of a running check, zero if none
+}
+
+type timers struct {
+	m       sync.Mutex
+	byConf  map[*ResConf]*timer
+	skipped int64 // runs skipped as a previous check still ran
+}
+
+type timerStats struct {
+	Overdue int
+	Skipped int64
+}
+
+// interval returns how often c is checked.
+func (c *ResConf) interval() time.Duration {
+	if d, err := time.ParseDuration(c.Interval); err == nil && d > 0 {
+		return d
+	}
+	return *interval
+}
+
+func (t *timers) started(c *ResConf) {
+	t.m.Lock()
+	defer t.m.Unlock()
+	if tm, ok := t.byConf[c]; ok {
+		tm.due, tm.started = time.Time{}, time.Now()
+	}
+}
+
+func (t *timers) done(c *ResConf) {
+	t.m.Lock()
+	defer t.m.Unlock()
+	if tm, ok := t.byConf[c]; ok {
+		tm.started = time.Time{}
+	}
+}
+
+func (t *timers) stats() timerStats {
+	t.m.Lock()
+	defer t.m.Unlock()
+	now := time.Now()
+	ret := timerStats{Skipped: t.skipped}
+	for _, tm := range t.byConf {
+		waits := !tm.due.IsZero() && now.Sub(tm.due) > overdueAfter
+		runs := !tm.started.IsZero() && now.Sub(tm.started) > tm.every
+		if waits || runs {
+			ret.Overdue++
+		}
+	}
+	return ret
+}
+
+// schedule enqueues resources when they're due until ctx is done.
+func (s *StatusChecker) schedule(ctx context.Context) {
+	for {
+		wait := s.enqueueDue(time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// enqueueDue enqueues resources due at now and returns how long to wait for
+// a next one. Timers of removed resources are dropped.
+func (s *StatusChecker) enqueueDue(now time.Time) time.Duration {
+	var due []*ResConf
+	wait := timersResolution
+	t := &s.timers
+	s.m.Lock()
+	s.lastTick = now
+	t.m.Lock()
+	live := make(map[*ResConf]bool, len(s.config.Configs))
+	for _, c := range s.config.Configs {
+		if c.chained() || c.Paused || !s.cluster.owns(c.Name) {
+			continue
+		}
+		live[c] = true
+		tm, ok := t.byConf[c]
+		switch {
+		case !ok:
+			tm = &timer{every: c.interval(), due: now}
+			tm.next = now.Add(s.offset(c) + tm.every)
+			t.byConf[c] = tm
+			due = append(due, c)
+		case tm.next.After(now):
+		case !tm.started.IsZero():
+			t.skipped++
+		default:
+			if tm.due.IsZero() {
+				tm.due = tm.next
+			}
+			due = append(due, c)
+		}
+		for !tm.next.After(now) {
+			tm.next = tm.next.Add(tm.every)
+		}
+		wait = min(wait, tm.next.Sub(now))
+	}
+	for c := range t.byConf {
+		if !live[c] {
+			delete(t.byConf, c)
+		}
+	}
+	t.m.Unlock()
+	s.m.Unlock()
+
+	for _, c := range due {
+		s.enqueue(c)
+	}
+	return wait
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// A shared transport of checks: workers reuse keep-alive connections of one
+// tuned http.Transport instead of opening a socket per check, its knobs are
+// -http-* flags.
+///////////////////////////////////////////////////////////////////////////////
+
+var (
+	httpKeepAlive     = flag.Bool("http-keepalive", true, "Reuse connections between checks.")
+	httpMaxIdle       = flag.Int("http-max-idle-per-host", 4, "Idle connections kept per host.")
+	httpIdleTimeout   = flag.Duration("http-idle-timeout", 90*time.Second, "How long an idle connection is kept.")
+	httpDialTimeout   = flag.Duration("http-dial-timeout", 10*time.Second, "A timeout of connecting to a resource.")
+	httpTLSTimeout    = flag.Duration("http-tls-timeout", 10*time.Second, "A timeout of a TLS handshake.")
+	httpHeaderTimeout = flag.Duration("http-header-timeout", 30*time.Second, "A timeout of waiting for response headers, none if 0.")
+	httpCheckTimeout  = flag.Duration("http-check-timeout", time.Minute, "A timeout of a whole HTTP check, a body read included.")
+	httpMaxBody       = flag.Int64("http-max-body", 16<<20, "How many bytes of a body an HTTP check reads at most.")
+)
+
+// checkClient sends requests of checks, its transport is set up by
+// setupTransport.
+var checkClient = &http.Client{}
+
+// clients of checks by a forced protocol and a resolver, see clientFor.
+var (
+	clientsMutex sync.Mutex
+	clients      = make(map[clientKey]*http.Client)
+)
+
+type clientKey struct {
+	protocol string
+	resolver string
+	family   string
+}
+
+// newCheckTransport returns a transport configured by -http-* flags which
+// dials with r over a family, any if it's empty.
+func newCheckTransport(r *net.Resolver, family string) *http.Transport {
+	d := &net.Dialer{Timeout: *httpDialTimeout, KeepAlive: 30 * time.Second, Resolver: r}
+	return &http.Transport{
+		Proxy: checkProxy,
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return d.DialContext(ctx, dialNetwork(network, family), addr)
+		},
+		ForceAttemptHTTP2:     true,
+		DisableKeepAlives:     !*httpKeepAlive,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   *httpMaxIdle,
+		IdleConnTimeout:       *httpIdleTimeout,
+		TLSHandshakeTimeout:   *httpTLSTimeout,
+		ResponseHeaderTimeout: *httpHeaderTimeout,
+		ExpectContinueTimeout: time.Second,
+	}
+}
+
+// setupTransport makes checkClient use a shared transport, it must be
+// called once flags are parsed.
+func setupTransport() error {
+	if len(*proxyAddr) > 0 {
+		if _, err := parseProxy(*proxyAddr); err != nil {
+			return fmt.Errorf("-proxy: %s", err)
+		}
+	}
+	if err := validateResolver(*dnsResolver); err != nil {
+		return fmt.Errorf("-resolver: %s", err)
+	}
+	checkClient.Transport = protocolTransport("", newResolver(*dnsResolver), "")
+	clients[clientKey{"", *dnsResolver, ""}] = checkClient
+	return nil
+}
+
+// clientFor returns a client of checks of c, shared by resources with the
+// same protocol, resolver and family.
+func clientFor(c *ResConf) *http.Client {
+	key := clientKey{c.Protocol, c.resolver(), c.Family}
+	clientsMutex.Lock()
+	defer clientsMutex.Unlock()
+	client, ok := clients[key]
+	if !ok {
+		client = &http.Client{Transport: protocolTransport(key.protocol, newResolver(key.resolver), key.family)}
+		clients[key] = client
+	}
+	return client
+}
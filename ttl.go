@@ -0,0 +1,56 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// Ephemeral resources: removed automatically when they expire, e.g.
+// a temporary preview environment monitored for 48h. A history of removed
+// resources is kept for Config.Retention.
+///////////////////////////////////////////////////////////////////////////////
+
+func (c *ResConf) expired(now time.Time) bool {
+	return !c.Expires.IsZero() && !now.Before(c.Expires)
+}
+
+func (s *StatusChecker) expireLoop() {
+	for now := range time.Tick(time.Minute) {
+		s.expire(now)
+	}
+}
+
+func (s *StatusChecker) expire(now time.Time) {
+	var expired []*ResConf
+	s.m.Lock()
+	for _, c := range s.config.Configs {
+		if c.expired(now) {
+			expired = append(expired, c)
+		}
+	}
+	s.m.Unlock()
+	for _, c := range expired {
+		slog.Info("Expired", "resource", c.Name, "address", c.Address)
+		s.Remove(func(el *ResConf) bool { return el == c })
+	}
+
+	s.statusMutex.Lock()
+	defer s.statusMutex.Unlock()
+	for addr, t := range s.retired {
+		if !now.Before(t) {
+			delete(s.history, addr)
+			delete(s.retired, addr)
+		}
+	}
+}
+
+// retire schedules removal of a history of a removed resource, it must be
+// called with statusMutex held.
+func (s *StatusChecker) retire(addr string) {
+	if s.retention <= 0 {
+		delete(s.history, addr)
+		return
+	}
+	s.retired[addr] = time.Now().Add(s.retention)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// A Twilio SMS notifier.
+///////////////////////////////////////////////////////////////////////////////
+
+const twilioDefaultURL = "https://api.twilio.com"
+
+type TwilioConf struct {
+	Name       string `json:",omitempty"` // a channel name, twilio if empty
+	AccountSID string
+	AuthToken  string
+	From       string
+	To         []string // a phone number or oncall:<rotation>
+	// Tag limits SMS to resources having it ("key" or "key=value"), e.g.
+	// severity=critical. Every resource if empty.
+	Tag    string `json:",omitempty"`
+	ApiURL string `json:",omitempty"`
+}
+
+type Twilio struct {
+	conf      *TwilioConf
+	rotations Rotations
+}
+
+func NewTwilio(c *TwilioConf, r Rotations) *Twilio {
+	return &Twilio{c, r}
+}
+
+func (t *Twilio) to() []string {
+	return t.rotations.resolve(t.conf.To, func(c *Contact) string { return c.Phone })
+}
+
+func (t *Twilio) Preview(ev *Event) []*Delivery {
+	if len(t.conf.Tag) > 0 && !ev.Conf.hasTag(t.conf.Tag) {
+		return nil
+	}
+	var ret []*Delivery
+	for _, to := range t.to() {
+		ret = append(ret, &Delivery{"twilio", to, "statusmonitor: " + ev.message()})
+	}
+	return ret
+}
+
+func (t *Twilio) Notify(ev *Event) error {
+	if len(t.conf.Tag) > 0 && !ev.Conf.hasTag(t.conf.Tag) {
+		return nil
+	}
+	base := t.conf.ApiURL
+	if base == "" {
+		base = twilioDefaultURL
+	}
+	u := fmt.Sprintf("%s/2010-04-01/Accounts/%s/Messages.json", base, url.PathEscape(t.conf.AccountSID))
+	h := http.Header{}
+	h.Set("Content-Type", "application/x-www-form-urlencoded")
+	h.Set("Authorization", basicAuth(t.conf.AccountSID, t.conf.AuthToken))
+
+	var errs []string
+	for _, to := range t.to() {
+		form := url.Values{}
+		form.Set("From", t.conf.From)
+		form.Set("To", to)
+		form.Set("Body", "statusmonitor: "+ev.message())
+		if err := postBody(u, []byte(form.Encode()), h); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("twilio: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
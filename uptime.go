@@ -0,0 +1,99 @@
+package main
+
+import (
+	"time"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// Uptime math: a result counts until the next one, but when the monitor
+// was down or probes were skipped there's a gap of no data, neither UP nor
+// DOWN. Uptime is a share of covered time, coverage a share of a period.
+///////////////////////////////////////////////////////////////////////////////
+
+type uptime struct {
+	first, last *Status
+	covered     time.Duration
+	up          time.Duration
+}
+
+// covers returns how much of a gap after a result st covers: all of it,
+// unless it's over two check intervals (or sample periods), i.e. a probe
+// was missed.
+func covers(st *Status, gap time.Duration) time.Duration {
+	every := *interval
+	if st.Sample != nil && st.Sample.Period > every {
+		every = st.Sample.Period
+	}
+	switch {
+	case gap < 0:
+		return 0
+	case gap > 2*every:
+		return every
+	}
+	return gap
+}
+
+func (u *uptime) add(st *Status) {
+	if u.first == nil {
+		u.first = st
+	}
+	if u.last != nil {
+		u.count(u.last, covers(u.last, st.When.Sub(u.last.When)))
+	}
+	u.last = st
+}
+
+func (u *uptime) count(st *Status, d time.Duration) {
+	u.covered += d
+	if st.Sample != nil {
+		u.up += time.Duration(float64(d) * st.Sample.upShare())
+	} else if st.State() != StateDown {
+		u.up += d
+	}
+}
+
+// percents returns uptime and coverage of a period from-to, in percent.
+func (u *uptime) percents(from, to time.Time) (float64, float64) {
+	if u.first == nil || !to.After(from) {
+		return 0, 0
+	}
+	c := *u
+	// A time before the first result of the period is covered by it, the
+	// last one covers a time until the period end.
+	c.count(u.first, covers(u.first, u.first.When.Sub(from)))
+	c.count(u.last, covers(u.last, to.Sub(u.last.When)))
+	if c.covered == 0 {
+		return 0, 0
+	}
+	return 100 * float64(c.up) / float64(c.covered), 100 * float64(c.covered) / float64(to.Sub(from))
+}
+
+// Uptime returns uptime and coverage of a resource with an address during
+// a period before now, in percent. The period starts no earlier than the
+// monitor and its oldest kept result.
+func (s *StatusChecker) Uptime(addr string, period time.Duration) (float64, float64) {
+	to := time.Now()
+	from := to.Add(-period)
+	if from.Before(startTime) {
+		from = startTime
+	}
+	s.statusMutex.Lock()
+	defer s.statusMutex.Unlock()
+	return historyUptime(s.history[addr], from, to)
+}
+
+// historyUptime returns uptime and coverage of a history h during a period
+// from-to, in percent. The period starts no earlier than the oldest result
+// of a full history, older ones were dropped.
+func historyUptime(h []*Status, from, to time.Time) (float64, float64) {
+	if len(h) == historySize && from.Before(h[0].When) {
+		from = h[0].When
+	}
+	u := &uptime{}
+	for _, st := range h {
+		if !st.When.Before(from) {
+			u.add(st)
+		}
+	}
+	return u.percents(from, to)
+}
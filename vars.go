@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// Inter-check variables: a check exports values extracted from its response
+// (Export), other checks reference them as ${resource.variable} in Address
+// or Headers. A check referencing another resource runs right after it,
+// instead of every interval, so a chain of checks follows a workflow, e.g.
+// log in and then call an API with a token.
+///////////////////////////////////////////////////////////////////////////////
+
+var varRef = regexp.MustCompile(`\$\{([^}.]+)\.([^}]+)\}`)
+
+// maxExportBody limits how much of a response is read to extract variables.
+const maxExportBody = 1 << 20
+
+// dependsOn returns names of resources whose variables c references.
+func (c *ResConf) dependsOn() []string {
+	var ret []string
+	seen := make(map[string]bool)
+	refs := varRef.FindAllStringSubmatch(c.Address, -1)
+	for _, v := range c.Headers {
+		refs = append(refs, varRef.FindAllStringSubmatch(v, -1)...)
+	}
+	for _, m := range refs {
+		if !seen[m[1]] {
+			seen[m[1]] = true
+			ret = append(ret, m[1])
+		}
+	}
+	return ret
+}
+
+// chained resources are checked after resources they depend on.
+func (c *ResConf) chained() bool {
+	return len(c.dependsOn()) > 0
+}
+
+// expand returns a copy of c with variable references replaced.
+func (c *ResConf) expand(vars map[string]map[string]string) (*ResConf, error) {
+	if !c.chained() {
+		return c, nil
+	}
+	var missing []string
+	replace := func(s string) string {
+		return varRef.ReplaceAllStringFunc(s, func(ref string) string {
+			m := varRef.FindStringSubmatch(ref)
+			v, ok := vars[m[1]][m[2]]
+			if !ok {
+				missing = append(missing, m[1]+"."+m[2])
+			}
+			return v
+		})
+	}
+	ret := *c
+	ret.Address = replace(c.Address)
+	ret.Headers = make(map[string]string, len(c.Headers))
+	for k, v := range c.Headers {
+		ret.Headers[k] = replace(v)
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("variables not exported yet: %s", strings.Join(missing, ", "))
+	}
+	return &ret, nil
+}
+
+// check checks a resource with variables it references.
+func (s *StatusChecker) check(c *ResConf) *Status {
+	s.statusMutex.Lock()
+	conf, err := c.expand(s.vars)
+	s.statusMutex.Unlock()
+	if err != nil {
+		return &Status{When: time.Now(), StatusCode: UnknownError, Error: err.Error()}
+	}
+	return CheckStatus(conf)
+}
+
+// exportVars keeps variables exported by a result, it must be called with
+// statusMutex held.
+func (s *StatusChecker) exportVars(c *ResConf, st *Status) {
+	if len(st.exports) > 0 {
+		if s.vars[c.Name] == nil {
+			s.vars[c.Name] = make(map[string]string)
+		}
+		for k, v := range st.exports {
+			s.vars[c.Name][k] = v
+		}
+		st.exports = nil
+	}
+}
+
+// runDependents checks resources referencing variables of c.
+func (s *StatusChecker) runDependents(c *ResConf) {
+	s.m.Lock()
+	var next []*ResConf
+	for _, el := range s.config.Configs {
+		for _, name := range el.dependsOn() {
+			if name == c.Name && el != c {
+				next = append(next, el)
+				break
+			}
+		}
+	}
+	s.m.Unlock()
+	for _, el := range next {
+		s.enqueue(el)
+	}
+}
+
+// extract sets variables of st from a response as described by c.Export:
+// "json:path.to.0.field", "header:Name" or "regexp:expr" (the first group,
+// or the whole match). Variables which can't be extracted keep old values.
+func (c *ResConf) extract(st *Status, resp *http.Response, body []byte) error {
+	st.exports = make(map[string]string)
+	var errs []string
+	for name, spec := range c.Export {
+		kind, arg := spec, ""
+		if i := strings.Index(spec, ":"); i >= 0 {
+			kind, arg = spec[:i], spec[i+1:]
+		}
+		var v string
+		var err error
+		switch kind {
+		case "json":
+			v, err = jsonPath(body, arg)
+		case "header":
+			if v = resp.Header.Get(arg); len(v) == 0 {
+				err = fmt.Errorf("no header %s", arg)
+			}
+		case "regexp":
+			v, err = regexpMatch(body, arg)
+		default:
+			err = fmt.Errorf("unknown export %q", spec)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("export %s: %s", name, err))
+			continue
+		}
+		st.exports[name] = v
+	}
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func jsonPath(body []byte, path string) (string, error) {
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		return "", err
+	}
+	for _, key := range strings.Split(path, ".") {
+		switch t := v.(type) {
+		case map[string]interface{}:
+			el, ok := t[key]
+			if !ok {
+				return "", fmt.Errorf("no %s in %s", key, path)
+			}
+			v = el
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(t) {
+				return "", fmt.Errorf("no %s in %s", key, path)
+			}
+			v = t[i]
+		default:
+			return "", fmt.Errorf("no %s in %s", key, path)
+		}
+	}
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+	b, err := json.Marshal(v)
+	return string(b), err
+}
+
+func regexpMatch(body []byte, expr string) (string, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return "", err
+	}
+	m := re.FindSubmatch(body)
+	switch {
+	case m == nil:
+		return "", fmt.Errorf("no match of %s", expr)
+	case len(m) > 1:
+		return string(m[1]), nil
+	}
+	return string(m[0]), nil
+}
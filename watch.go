@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// A watch mode part: a resource checked every few seconds for a bounded
+// period, e.g. during a deploy, with results streamed to a browser.
+///////////////////////////////////////////////////////////////////////////////
+
+const (
+	minWatchEvery = time.Second
+	maxWatchFor   = time.Hour
+)
+
+type WatchRequest struct {
+	Name  string
+	Every time.Duration
+	For   time.Duration
+}
+
+type watchState struct {
+	Every time.Duration
+	Until time.Time
+}
+
+// Watch starts (or extends) a watch of a resource with a given name. The
+// regular checks continue untouched, a watch only adds extra ones.
+func (s *StatusChecker) Watch(name string, every, dur time.Duration) error {
+	if every < minWatchEvery {
+		every = minWatchEvery
+	}
+	if dur <= 0 || dur > maxWatchFor {
+		dur = maxWatchFor
+	}
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.config.Find(func(el *ResConf) bool { return el.Name == name }) == nil {
+		return fmt.Errorf("no resource named %q", name)
+	}
+	_, running := s.watches[name]
+	s.watches[name] = &watchState{every, time.Now().Add(dur)}
+	slog.Info("Watch", "resource", name, "every", every, "for", dur)
+	if !running {
+		go s.watch(name)
+	}
+	return nil
+}
+
+// watchUntil returns when a watch of a given resource ends or zero time
+// if it's not watched.
+func (s *StatusChecker) watchUntil(name string) time.Time {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if w, ok := s.watches[name]; ok {
+		return w.Until
+	}
+	return time.Time{}
+}
+
+func (s *StatusChecker) watch(name string) {
+	for {
+		s.m.Lock()
+		w := s.watches[name]
+		var conf *ResConf
+		if time.Now().Before(w.Until) {
+			conf = s.config.Find(func(el *ResConf) bool { return el.Name == name })
+		}
+		if conf == nil {
+			delete(s.watches, name)
+			s.m.Unlock()
+			slog.Info("Watch finished", "resource", name)
+			return
+		}
+		every := w.Every
+		s.m.Unlock()
+		s.enqueue(conf)
+		time.Sleep(every)
+	}
+}
+
+const watchTmplStr = `
+<html><head><title>Obserwacja: {{.Name}}</title></head>
+<style type="text/css">
+table, th, td {
+	border: 1px solid black;
+}
+</style>
+<body>
+<p>{{.Name}}: <span id="state">{{if .Until.IsZero}}nieobserwowany{{else}}obserwowany do {{.Until.Format "15:04:05"}}{{end}}</span></p>
+<table id="results">
+<tr>
+<td>Sprawdzony</td>
+<td>Status</td>
+</tr>
+</table>
+<script>
+var src = new EventSource("/watch/events?name=" + encodeURIComponent({{.Name}}));
+src.addEventListener("result", function(e) {
+	var st = JSON.parse(e.data);
+	var row = document.getElementById("results").insertRow(1);
+	row.insertCell(0).textContent = new Date(st.When).toLocaleTimeString();
+	row.insertCell(1).textContent = st.StatusCode;
+});
+src.addEventListener("done", function(e) {
+	document.getElementById("state").textContent = "obserwacja zakończona";
+	src.close();
+});
+</script>
+</body>
+</html>
+`
+
+var watchTmpl = page("watch", watchTmplStr)
+
+func RegisterWatchHandler(sc *StatusChecker) {
+	http.HandleFunc("/watch", func(rw http.ResponseWriter, req *http.Request) {
+		name := req.FormValue("name")
+		data := struct {
+			Name  string
+			Until time.Time
+		}{name, sc.watchUntil(name)}
+		if err := watchTmpl.Execute(rw, data); err != nil {
+			slog.Error("Template render", "error", err)
+		}
+	})
+
+	// An SSE stream of results for a watched resource. A "done" event is
+	// sent when the watch ends.
+	http.HandleFunc("/watch/events", func(rw http.ResponseWriter, req *http.Request) {
+		flusher, ok := rw.(http.Flusher)
+		if !ok {
+			http.Error(rw, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+		name := req.FormValue("name")
+		c, cancel := sc.subscribe()
+		defer cancel()
+
+		rw.Header().Set("Content-Type", "text/event-stream")
+		rw.Header().Set("Cache-Control", "no-cache")
+		flusher.Flush()
+
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-req.Context().Done():
+				return
+			case <-ticker.C:
+				if sc.watchUntil(name).IsZero() {
+					fmt.Fprint(rw, "event: done\ndata: {}\n\n")
+					flusher.Flush()
+					return
+				}
+			case st := <-c:
+				if st.conf.Name != name {
+					continue
+				}
+				b, err := json.Marshal(st.Status)
+				if err != nil {
+					slog.Error("Watch event", "error", err)
+					continue
+				}
+				fmt.Fprintf(rw, "event: result\ndata: %s\n\n", b)
+				flusher.Flush()
+			}
+		}
+	})
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// A generic webhook notifier: POSTs a JSON description of a state change to
+// an arbitrary URL.
+///////////////////////////////////////////////////////////////////////////////
+
+const webhookSignatureHeader = "X-Statusmonitor-Signature"
+
+type WebhookConf struct {
+	Name string `json:",omitempty"` // a channel name, webhook if empty
+	URL  string
+	// Secret, if set, is used to sign a body with HMAC-SHA256, the signature
+	// is sent hex encoded as "sha256=<hex>" in X-Statusmonitor-Signature.
+	Secret  string `json:",omitempty"`
+	Retries int    `json:",omitempty"` // 3 if 0, a negative means no retries
+}
+
+type Webhook struct {
+	conf *WebhookConf
+}
+
+func NewWebhook(c *WebhookConf) *Webhook {
+	return &Webhook{c}
+}
+
+type webhookPayload struct {
+	Resource   string            `json:"resource"`
+	Address    string            `json:"address"`
+	Tags       map[string]string `json:"tags,omitempty"`
+	OldState   string            `json:"old_state"`
+	NewState   string            `json:"new_state"`
+	Timestamp  time.Time         `json:"timestamp"`
+	StatusCode int               `json:"status_code"`
+	Error      string            `json:"error,omitempty"`
+	Slow       string            `json:"slow,omitempty"`
+	Degraded   string            `json:"degraded,omitempty"`
+	Reminder   bool              `json:"reminder,omitempty"`
+	Since      *time.Time        `json:"since,omitempty"` // when a problem started
+	TTFBMs     int64             `json:"ttfb_ms"`
+	LatencyMs  int64             `json:"latency_ms"`
+}
+
+func (w *Webhook) payload(ev *Event) ([]byte, error) {
+	p := &webhookPayload{
+		Resource:   ev.Conf.Name,
+		Address:    redactAddress(ev.Conf.Address),
+		Tags:       ev.Conf.Tags,
+		OldState:   ev.Old.State().String(),
+		NewState:   ev.New.State().String(),
+		Timestamp:  ev.New.When,
+		StatusCode: ev.New.StatusCode,
+		Error:      ev.New.Error,
+		Slow:       ev.New.Slow,
+		Degraded:   ev.New.Degraded,
+		Reminder:   ev.Reminder,
+		TTFBMs:     int64(ev.New.TTFB / time.Millisecond),
+		LatencyMs:  int64(ev.New.Latency / time.Millisecond),
+	}
+	if !ev.Since.IsZero() {
+		p.Since = &ev.Since
+	}
+	return json.Marshal(p)
+}
+
+func (w *Webhook) Preview(ev *Event) []*Delivery {
+	b, err := w.payload(ev)
+	if err != nil {
+		return []*Delivery{{"webhook", w.conf.URL, err.Error()}}
+	}
+	return []*Delivery{{"webhook", w.conf.URL, string(b)}}
+}
+
+func (w *Webhook) Notify(ev *Event) error {
+	b, err := w.payload(ev)
+	if err != nil {
+		return err
+	}
+	h := http.Header{}
+	if len(w.conf.Secret) > 0 {
+		mac := hmac.New(sha256.New, []byte(w.conf.Secret))
+		mac.Write(b)
+		h.Set(webhookSignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	retries := w.conf.Retries
+	if retries == 0 {
+		retries = 3
+	}
+	return retry(retries, func() error { return postBody(w.conf.URL, b, h) })
+}
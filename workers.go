@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// A worker pool: -workers workers check resources and with -max-workers the
+// pool grows while checks wait for busy workers and shrinks while workers
+// are idle. It never goes below what's needed to check every resource each
+// -interval at the average check time.
+///////////////////////////////////////////////////////////////////////////////
+
+var maxWorkers = flag.Int("max-workers", 0, "Scale workers between -workers and this many by load, a fixed -workers if 0.")
+
+// autoscaleEvery is how often a pool size is adjusted.
+const autoscaleEvery = 5 * time.Second
+
+type workerPool struct {
+	s   *StatusChecker
+	ret chan *ResConfStatus
+	wg  sync.WaitGroup
+
+	m        sync.Mutex
+	min, max int
+	n        int           // running workers
+	stopped  bool          // no workers are added, see wait
+	busy     int           // workers checking
+	avg      time.Duration // a moving average of check times
+}
+
+type workerStats struct {
+	Workers int
+	Busy    int
+}
+
+// start starts min workers.
+func (p *workerPool) start(s *StatusChecker, ret chan *ResConfStatus, min, max int) {
+	p.m.Lock()
+	p.s, p.ret = s, ret
+	p.min, p.max = min, max
+	if p.max < p.min {
+		p.max = p.min
+	}
+	p.m.Unlock()
+	p.add(min)
+}
+
+func (p *workerPool) add(n int) {
+	p.m.Lock()
+	defer p.m.Unlock()
+	if p.stopped {
+		return
+	}
+	p.n += n
+	p.wg.Add(n)
+	for i := 0; i < n; i++ {
+		go p.worker()
+	}
+}
+
+// worker checks resources until the queue is closed or asks it to stop.
+func (p *workerPool) worker() {
+	defer func() {
+		p.m.Lock()
+		p.n--
+		p.m.Unlock()
+		p.wg.Done()
+	}()
+	for {
+		conf := p.s.queue.pop()
+		if conf == nil {
+			return
+		}
+		p.m.Lock()
+		p.busy++
+		p.m.Unlock()
+		p.s.timers.started(conf)
+		start := time.Now()
+		status := p.s.check(conf)
+		p.s.timers.done(conf)
+		p.m.Lock()
+		p.busy--
+		if d := time.Since(start); p.avg == 0 {
+			p.avg = d
+		} else {
+			p.avg = (4*p.avg + d) / 5
+		}
+		p.m.Unlock()
+		p.ret <- &ResConfStatus{conf, status}
+	}
+}
+
+// wait waits for workers up to a timeout, it returns false if it timed out.
+func (p *workerPool) wait(timeout time.Duration) bool {
+	p.m.Lock()
+	p.stopped = true
+	p.m.Unlock()
+	done := make(chan bool)
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+func (p *workerPool) stats() workerStats {
+	p.m.Lock()
+	defer p.m.Unlock()
+	return workerStats{p.n, p.busy}
+}
+
+// autoscale adjusts a pool size every autoscaleEvery until ctx is done.
+func (p *workerPool) autoscale(ctx context.Context) {
+	t := time.NewTicker(autoscaleEvery)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		p.s.m.Lock()
+		resources := len(p.s.config.Configs)
+		p.s.m.Unlock()
+		depth := p.s.queue.size()
+
+		p.m.Lock()
+		n, busy := p.n, p.busy
+		want := n
+		switch {
+		case depth > 0 && busy >= n:
+			// Checks wait, grow by half.
+			want = n + max(1, n/2)
+		case depth == 0 && busy < n/2:
+			// Mostly idle, let half of idle workers go.
+			want = n - max(1, (n-busy)/2)
+		}
+		// Little's law: workers busy checking every resource each interval.
+		need := int(math.Ceil(float64(resources) * p.avg.Seconds() / interval.Seconds() * 1.25))
+		want = min(max(want, need, p.min), p.max)
+		p.m.Unlock()
+
+		switch {
+		case want > n:
+			p.add(want - n)
+		case want < n:
+			for i := 0; i < n-want; i++ {
+				p.s.queue.stopOne()
+			}
+		default:
+			continue
+		}
+		slog.Info("Workers scaled", "from", n, "to", want, "waiting", depth, "busy", busy)
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// A WebSocket part: /ws streams state changes of resources as they happen,
+// for the status page and external dashboards. Only as much of RFC 6455 as
+// a server pushing text messages needs.
+///////////////////////////////////////////////////////////////////////////////
+
+const (
+	wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+	wsText  = 0x1
+	wsClose = 0x8
+	wsPing  = 0x9
+	wsPong  = 0xA
+
+	wsPingEvery = 30 * time.Second
+	// wsMaxFrame limits frames read from clients, they only send control
+	// frames.
+	wsMaxFrame = 4096
+)
+
+// wsChange is a message sent on /ws.
+type wsChange struct {
+	Name     string
+	Address  string
+	State    State
+	Old      State
+	Snapshot bool `json:",omitempty"` // a state when a client connected
+	Status   *Status
+}
+
+type wsConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	m    sync.Mutex // guards writes
+}
+
+func wsAccept(key string) string {
+	h := sha1.Sum([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+func headerContains(h http.Header, name, token string) bool {
+	for _, v := range h[http.CanonicalHeaderKey(name)] {
+		for _, el := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(el), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// wsUpgrade completes a WebSocket handshake, an error is already reported
+// to a client.
+func wsUpgrade(rw http.ResponseWriter, req *http.Request) (*wsConn, error) {
+	key := req.Header.Get("Sec-WebSocket-Key")
+	if req.Method != "GET" || !headerContains(req.Header, "Connection", "upgrade") ||
+		!headerContains(req.Header, "Upgrade", "websocket") || len(key) == 0 {
+		http.Error(rw, "WebSocket upgrade required", http.StatusBadRequest)
+		return nil, errors.New("not a WebSocket request")
+	}
+	if req.Header.Get("Sec-WebSocket-Version") != "13" {
+		rw.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(rw, "unsupported WebSocket version", http.StatusUpgradeRequired)
+		return nil, errors.New("unsupported WebSocket version")
+	}
+	hj, ok := rw.(http.Hijacker)
+	if !ok {
+		http.Error(rw, "WebSocket unsupported", http.StatusInternalServerError)
+		return nil, errors.New("connection can't be hijacked")
+	}
+	conn, brw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+	brw.WriteString("Sec-WebSocket-Accept: " + wsAccept(key) + "\r\n\r\n")
+	if err := brw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &wsConn{conn: conn, r: brw.Reader}, nil
+}
+
+func (c *wsConn) write(opcode byte, payload []byte) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+	hdr := []byte{0x80 | opcode, 0}
+	switch n := len(payload); {
+	case n < 126:
+		hdr[1] = byte(n)
+	case n <= 0xFFFF:
+		hdr[1] = 126
+		hdr = binary.BigEndian.AppendUint16(hdr, uint16(n))
+	default:
+		hdr[1] = 127
+		hdr = binary.BigEndian.AppendUint64(hdr, uint64(n))
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if _, err := c.conn.Write(append(hdr, payload...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c *wsConn) writeJSON(v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.write(wsText, b)
+}
+
+// readFrame returns an opcode and an unmasked payload of a client frame.
+func (c *wsConn) readFrame() (byte, []byte, error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(c.r, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+	opcode := hdr[0] & 0x0F
+	masked := hdr[1]&0x80 != 0
+	n := uint64(hdr[1] & 0x7F)
+	switch n {
+	case 126:
+		var b [2]byte
+		if _, err := io.ReadFull(c.r, b[:]); err != nil {
+			return 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(b[:]))
+	case 127:
+		var b [8]byte
+		if _, err := io.ReadFull(c.r, b[:]); err != nil {
+			return 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(b[:])
+	}
+	if !masked {
+		return 0, nil, errors.New("unmasked client frame")
+	}
+	if n > wsMaxFrame {
+		return 0, nil, errors.New("client frame too large")
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(c.r, mask[:]); err != nil {
+		return 0, nil, err
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return opcode, payload, nil
+}
+
+// readLoop answers pings and returns when a client closes a connection.
+func (c *wsConn) readLoop() {
+	for {
+		opcode, payload, err := c.readFrame()
+		if err != nil {
+			return
+		}
+		switch opcode {
+		case wsClose:
+			c.write(wsClose, payload)
+			return
+		case wsPing:
+			c.write(wsPong, payload)
+		}
+	}
+}
+
+func RegisterWSHandler(sc *StatusChecker) {
+	// Sends a snapshot of all resources and then every state change.
+	http.HandleFunc("/ws", func(rw http.ResponseWriter, req *http.Request) {
+		ws, err := wsUpgrade(rw, req)
+		if err != nil {
+			slog.Warn("WebSocket", "from", req.RemoteAddr, "error", err)
+			return
+		}
+		defer ws.conn.Close()
+		c, cancel := sc.subscribe()
+		defer cancel()
+		closed := make(chan bool)
+		go func() {
+			ws.readLoop()
+			close(closed)
+		}()
+
+		states := make(map[string]State)
+		for _, el := range sc.Snapshot() {
+			states[el.Address] = el.Status.State()
+			ch := &wsChange{Name: el.Name, Address: redactAddress(el.Address), State: states[el.Address], Old: states[el.Address], Snapshot: true, Status: el.Status}
+			if err := ws.writeJSON(ch); err != nil {
+				return
+			}
+		}
+		ping := time.NewTicker(wsPingEvery)
+		defer ping.Stop()
+		for {
+			select {
+			case <-closed:
+				return
+			case <-ping.C:
+				if err := ws.write(wsPing, nil); err != nil {
+					return
+				}
+			case st := <-c:
+				old := states[st.conf.Address]
+				if cur := st.Status.State(); cur != old {
+					states[st.conf.Address] = cur
+					ch := &wsChange{Name: st.conf.Name, Address: redactAddress(st.conf.Address), State: cur, Old: old, Status: st.Status}
+					if err := ws.writeJSON(ch); err != nil {
+						return
+					}
+				}
+			}
+		}
+	})
+}
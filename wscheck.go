@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// A WebSocket check: a handshake with a ws:// or wss:// Address, then with
+// Ping a ping which should get a pong and with Message a text message which
+// should be echoed. A failed handshake is DOWN with a status code of the
+// response, failed exchanges with an error of the step.
+///////////////////////////////////////////////////////////////////////////////
+
+const (
+	wsTimeout = 10 * time.Second
+	// wsMaxRead limits frames read from servers.
+	wsMaxRead = 1 << 16
+)
+
+func init() {
+	RegisterChecker("websocket", checkWebSocket)
+}
+
+func validateWebSocket(addr string) error {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "ws" && u.Scheme != "wss" {
+		return fmt.Errorf("%s: scheme must be ws or wss", addr)
+	}
+	if len(u.Host) == 0 {
+		return fmt.Errorf("%s: no host", addr)
+	}
+	return nil
+}
+
+// wsClientWrite writes a masked client frame, see wsConn.write.
+func wsClientWrite(w io.Writer, opcode byte, payload []byte) error {
+	b := []byte{0x80 | opcode}
+	switch n := len(payload); {
+	case n < 126:
+		b = append(b, 0x80|byte(n))
+	case n <= 0xffff:
+		b = append(b, 0x80|126)
+		b = binary.BigEndian.AppendUint16(b, uint16(n))
+	default:
+		b = append(b, 0x80|127)
+		b = binary.BigEndian.AppendUint64(b, uint64(n))
+	}
+	var mask [4]byte
+	rand.Read(mask[:])
+	b = append(b, mask[:]...)
+	for i, c := range payload {
+		b = append(b, c^mask[i%4])
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+// wsClientRead reads a server frame, fragments of a message aren't joined.
+func wsClientRead(r io.Reader) (opcode byte, payload []byte, err error) {
+	var h [2]byte
+	if _, err := io.ReadFull(r, h[:]); err != nil {
+		return 0, nil, err
+	}
+	n := uint64(h[1] & 0x7f)
+	switch n {
+	case 126:
+		var l [2]byte
+		if _, err := io.ReadFull(r, l[:]); err != nil {
+			return 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(l[:]))
+	case 127:
+		var l [8]byte
+		if _, err := io.ReadFull(r, l[:]); err != nil {
+			return 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(l[:])
+	}
+	if n > wsMaxRead {
+		return 0, nil, fmt.Errorf("a frame of %d bytes is too big", n)
+	}
+	var mask [4]byte
+	if h[1]&0x80 != 0 {
+		if _, err := io.ReadFull(r, mask[:]); err != nil {
+			return 0, nil, err
+		}
+	}
+	payload = make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return h[0] & 0x0f, payload, nil
+}
+
+// wsAwait reads frames until one of an opcode, answering pings.
+func wsAwait(rw io.ReadWriter, want byte) ([]byte, error) {
+	for {
+		opcode, payload, err := wsClientRead(rw)
+		switch {
+		case err != nil:
+			return nil, err
+		case opcode == want:
+			return payload, nil
+		case opcode == wsClose:
+			return nil, fmt.Errorf("closed by the server")
+		case opcode == wsPing:
+			if err := wsClientWrite(rw, wsPong, payload); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+func checkWebSocket(c *ResConf) *Status {
+	st := &Status{When: time.Now()}
+	fail := func(step string, err error) *Status {
+		st.Latency = time.Since(st.When)
+		st.StatusCode = UnknownError
+		st.Error = step + ": " + c.errorText(err)
+		return st
+	}
+	u, err := url.Parse(c.Address)
+	if err != nil {
+		return fail("handshake", err)
+	}
+	u.Scheme = "http"
+	if c.Address[:3] == "wss" {
+		u.Scheme = "https"
+	}
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return fail("handshake", err)
+	}
+	var nonce [16]byte
+	rand.Read(nonce[:])
+	key := base64.StdEncoding.EncodeToString(nonce[:])
+	for k, v := range c.Headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	// An upgrade needs HTTP/1.1.
+	h1 := *c
+	h1.Protocol = ProtocolHTTP1
+	resp, err := clientFor(&h1).Do(withProxy(req, c))
+	if err != nil {
+		return fail("handshake", err)
+	}
+	defer resp.Body.Close()
+	st.Proto = resp.Proto
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		st.Latency = time.Since(st.When)
+		st.StatusCode = resp.StatusCode
+		st.Error = fmt.Sprintf("handshake: %s instead of 101 Switching Protocols", resp.Status)
+		return st
+	}
+	if resp.Header.Get("Sec-WebSocket-Accept") != wsAccept(key) {
+		return fail("handshake", fmt.Errorf("a wrong Sec-WebSocket-Accept"))
+	}
+	conn, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		return fail("handshake", fmt.Errorf("no upgraded connection"))
+	}
+	// Reads of an upgraded connection have no deadline, it's closed instead.
+	timer := time.AfterFunc(wsTimeout, func() { conn.Close() })
+	defer timer.Stop()
+	st.TTFB = time.Since(st.When)
+	st.Details = append(st.Details, fmt.Sprintf("handshake %s", st.TTFB.Round(time.Millisecond)))
+
+	if c.Ping {
+		start := time.Now()
+		if err := wsClientWrite(conn, wsPing, nonce[:]); err != nil {
+			return fail("ping", err)
+		}
+		if _, err := wsAwait(conn, wsPong); err != nil {
+			return fail("ping", err)
+		}
+		st.Details = append(st.Details, fmt.Sprintf("pong %s", time.Since(start).Round(time.Millisecond)))
+	}
+	if len(c.Message) > 0 {
+		start := time.Now()
+		if err := wsClientWrite(conn, wsText, []byte(c.Message)); err != nil {
+			return fail("echo", err)
+		}
+		reply, err := wsAwait(conn, wsText)
+		if err != nil {
+			return fail("echo", err)
+		}
+		if !bytes.Equal(reply, []byte(c.Message)) {
+			return fail("echo", fmt.Errorf("a reply differs from the message"))
+		}
+		st.Details = append(st.Details, fmt.Sprintf("echo %s", time.Since(start).Round(time.Millisecond)))
+	}
+	// A normal closure.
+	wsClientWrite(conn, wsClose, []byte{0x03, 0xe8})
+	st.Latency = time.Since(st.When)
+	st.StatusCode = http.StatusOK
+	return st
+}
@@ -0,0 +1,561 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// YAML configs: a config file with a .yaml or .yml extension is YAML. The
+// subset used by configs is supported: block mappings and sequences, flow
+// ones on a line, plain and quoted scalars and comments. Scalars are typed
+// by a field they are decoded into, so e.g. a Name may be 123.
+///////////////////////////////////////////////////////////////////////////////
+
+func isYAML(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+type yamlLine struct {
+	num    int
+	indent int
+	text   string
+}
+
+// yamlScalar is a scalar of a document, typed when it's decoded.
+type yamlScalar struct {
+	text   string
+	quoted bool
+}
+
+type yamlMapping struct {
+	keys []string
+	vals map[string]interface{}
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+// stripComment removes a comment from a line, # starts one at a line start
+// or after a space, outside quotes.
+func stripComment(s string) string {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || s[i-1] == ' '):
+			return s[:i]
+		}
+	}
+	return s
+}
+
+func parseYAML(b []byte) (interface{}, error) {
+	p := &yamlParser{}
+	for i, l := range strings.Split(string(b), "\n") {
+		l = strings.TrimRight(stripComment(strings.TrimRight(l, "\r")), " \t")
+		text := strings.TrimLeft(l, " ")
+		if len(text) == 0 || l == "---" || l == "..." {
+			continue
+		}
+		if text[0] == '\t' {
+			return nil, fmt.Errorf("line %d: a tab in indentation", i+1)
+		}
+		p.lines = append(p.lines, yamlLine{i + 1, len(l) - len(text), text})
+	}
+	if len(p.lines) == 0 {
+		return nil, nil
+	}
+	v, err := p.block(0)
+	if err == nil && p.pos < len(p.lines) {
+		err = fmt.Errorf("line %d: unexpected indentation", p.lines[p.pos].num)
+	}
+	return v, err
+}
+
+func isSeqItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// splitKey splits a mapping entry "key: value", ok is false if it's not one.
+func splitKey(text string) (key, rest string, ok bool, err error) {
+	if text[0] == '"' || text[0] == '\'' {
+		s, n, err := quotedScalar(text)
+		if err != nil {
+			return "", "", false, err
+		}
+		after := strings.TrimLeft(text[n:], " ")
+		if !strings.HasPrefix(after, ":") {
+			return "", "", false, nil
+		}
+		return s, strings.TrimSpace(after[1:]), true, nil
+	}
+	if strings.HasPrefix(text, "[") || strings.HasPrefix(text, "{") {
+		return "", "", false, nil
+	}
+	if i := strings.Index(text, ": "); i >= 0 {
+		return strings.TrimSpace(text[:i]), strings.TrimSpace(text[i+2:]), true, nil
+	}
+	if strings.HasSuffix(text, ":") {
+		return strings.TrimSpace(text[:len(text)-1]), "", true, nil
+	}
+	return "", "", false, nil
+}
+
+// block parses a node of lines indented at least by min.
+func (p *yamlParser) block(min int) (interface{}, error) {
+	if p.pos >= len(p.lines) || p.lines[p.pos].indent < min {
+		return nil, nil
+	}
+	l := p.lines[p.pos]
+	if isSeqItem(l.text) {
+		return p.sequence(l.indent)
+	}
+	_, _, ok, err := splitKey(l.text)
+	if err != nil {
+		return nil, fmt.Errorf("line %d: %s", l.num, err)
+	}
+	if ok {
+		return p.mapping(l.indent)
+	}
+	p.pos++
+	v, err := inline(l.text)
+	if err != nil {
+		return nil, fmt.Errorf("line %d: %s", l.num, err)
+	}
+	return v, nil
+}
+
+func (p *yamlParser) sequence(indent int) (interface{}, error) {
+	ret := []interface{}{}
+	for p.pos < len(p.lines) && p.lines[p.pos].indent == indent && isSeqItem(p.lines[p.pos].text) {
+		l := p.lines[p.pos]
+		rest := strings.TrimLeft(strings.TrimPrefix(l.text, "-"), " ")
+		if len(rest) == 0 {
+			p.pos++
+		} else {
+			// An item's content continues at its column, e.g. a mapping.
+			p.lines[p.pos] = yamlLine{l.num, indent + len(l.text) - len(rest), rest}
+		}
+		v, err := p.block(indent + 1)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, v)
+	}
+	return ret, nil
+}
+
+func (p *yamlParser) mapping(indent int) (interface{}, error) {
+	m := &yamlMapping{vals: make(map[string]interface{})}
+	for p.pos < len(p.lines) && p.lines[p.pos].indent == indent {
+		l := p.lines[p.pos]
+		key, rest, ok, err := splitKey(l.text)
+		if err != nil || !ok {
+			return nil, fmt.Errorf("line %d: want a key: value", l.num)
+		}
+		if _, dup := m.vals[key]; dup {
+			return nil, fmt.Errorf("line %d: duplicate key %q", l.num, key)
+		}
+		p.pos++
+		var v interface{}
+		switch {
+		case len(rest) > 0:
+			if v, err = inline(rest); err != nil {
+				return nil, fmt.Errorf("line %d: %s", l.num, err)
+			}
+		case p.pos < len(p.lines) && p.lines[p.pos].indent == indent && isSeqItem(p.lines[p.pos].text):
+			// A sequence may be indented as its key.
+			v, err = p.sequence(indent)
+		default:
+			v, err = p.block(indent + 1)
+		}
+		if err != nil {
+			return nil, err
+		}
+		m.keys = append(m.keys, key)
+		m.vals[key] = v
+	}
+	return m, nil
+}
+
+// quotedScalar parses a quoted scalar at the start of s and returns its
+// length.
+func quotedScalar(s string) (string, int, error) {
+	q := s[0]
+	for i := 1; i < len(s); i++ {
+		switch {
+		case q == '"' && s[i] == '\\':
+			i++
+		case s[i] == q && q == '\'' && i+1 < len(s) && s[i+1] == '\'':
+			i++
+		case s[i] == q:
+			if q == '\'' {
+				return strings.Replace(s[1:i], "''", "'", -1), i + 1, nil
+			}
+			v, err := strconv.Unquote(s[:i+1])
+			return v, i + 1, err
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated %c", q)
+}
+
+// inline parses a scalar or a flow collection taking a whole value.
+func inline(s string) (interface{}, error) {
+	if s == "|" || s == ">" || strings.HasPrefix(s, "|") || strings.HasPrefix(s, ">") {
+		return nil, fmt.Errorf("block scalars are not supported")
+	}
+	v, n, err := flow(s, false)
+	if err != nil {
+		return nil, err
+	}
+	if rest := strings.TrimSpace(s[n:]); len(rest) > 0 {
+		return nil, fmt.Errorf("unexpected %q", rest)
+	}
+	return v, nil
+}
+
+// flow parses a node at the start of s, inside a flow collection if nested,
+// and returns its length.
+func flow(s string, nested bool) (interface{}, int, error) {
+	i := len(s) - len(strings.TrimLeft(s, " "))
+	if i == len(s) {
+		return &yamlScalar{}, i, nil
+	}
+	switch s[i] {
+	case '"', '\'':
+		v, n, err := quotedScalar(s[i:])
+		return &yamlScalar{v, true}, i + n, err
+	case '[', '{':
+		return flowCollection(s, i)
+	}
+	end := len(s)
+	if nested {
+		if j := strings.IndexAny(s[i:], ",]}"); j >= 0 {
+			end = i + j
+		}
+		if j := strings.Index(s[i:end], ": "); j >= 0 {
+			end = i + j
+		}
+	}
+	return &yamlScalar{strings.TrimSpace(s[i:end]), false}, end, nil
+}
+
+func flowCollection(s string, i int) (interface{}, int, error) {
+	open := s[i]
+	close := byte(']')
+	if open == '{' {
+		close = '}'
+	}
+	var seq []interface{}
+	m := &yamlMapping{vals: make(map[string]interface{})}
+	i++
+	for {
+		i += len(s[i:]) - len(strings.TrimLeft(s[i:], " "))
+		if i >= len(s) {
+			return nil, 0, fmt.Errorf("unterminated %c", open)
+		}
+		if s[i] == close {
+			i++
+			break
+		}
+		v, n, err := flow(s[i:], true)
+		if err != nil {
+			return nil, 0, err
+		}
+		i += n
+		if open == '{' {
+			k, ok := v.(*yamlScalar)
+			if !ok {
+				return nil, 0, fmt.Errorf("a collection as a key")
+			}
+			i += len(s[i:]) - len(strings.TrimLeft(s[i:], " "))
+			if !strings.HasPrefix(s[i:], ":") {
+				return nil, 0, fmt.Errorf("want a key: value")
+			}
+			if v, n, err = flow(s[i+1:], true); err != nil {
+				return nil, 0, err
+			}
+			i += 1 + n
+			m.keys = append(m.keys, k.text)
+			m.vals[k.text] = v
+		} else {
+			seq = append(seq, v)
+		}
+		i += len(s[i:]) - len(strings.TrimLeft(s[i:], " "))
+		if i < len(s) && s[i] == ',' {
+			i++
+		}
+	}
+	if open == '{' {
+		return m, i, nil
+	}
+	if seq == nil {
+		seq = []interface{}{}
+	}
+	return seq, i, nil
+}
+
+// yamlValue converts a node to a JSON value of type t.
+func yamlValue(n interface{}, t reflect.Type) (interface{}, error) {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	kind := reflect.Interface
+	if t != nil {
+		kind = t.Kind()
+	}
+	switch n := n.(type) {
+	case nil:
+		return nil, nil
+	case *yamlScalar:
+		if n.quoted {
+			return n.text, nil
+		}
+		if n.text == "" || n.text == "~" || n.text == "null" {
+			if kind == reflect.String {
+				return n.text, nil
+			}
+			return nil, nil
+		}
+		switch kind {
+		case reflect.String:
+			return n.text, nil
+		case reflect.Bool:
+			return strconv.ParseBool(n.text)
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+			reflect.Float32, reflect.Float64:
+			if _, err := strconv.ParseFloat(n.text, 64); err != nil {
+				return nil, fmt.Errorf("%q is not a number", n.text)
+			}
+			return json.Number(n.text), nil
+		case reflect.Interface:
+			// Not strconv.ParseBool, which takes e.g. 1 and f too.
+			if n.text == "true" || n.text == "false" {
+				return n.text == "true", nil
+			}
+			if _, err := strconv.ParseFloat(n.text, 64); err == nil {
+				return json.Number(n.text), nil
+			}
+		}
+		// E.g. a time.Time.
+		return n.text, nil
+	case []interface{}:
+		var elem reflect.Type
+		if kind == reflect.Slice || kind == reflect.Array {
+			elem = t.Elem()
+		}
+		ret := []interface{}{}
+		for _, el := range n {
+			v, err := yamlValue(el, elem)
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, v)
+		}
+		return ret, nil
+	case *yamlMapping:
+		ret := make(map[string]interface{})
+		for _, k := range n.keys {
+			var ft reflect.Type
+			switch kind {
+			case reflect.Map:
+				ft = t.Elem()
+			case reflect.Struct:
+				ft = fieldType(t, k)
+			}
+			v, err := yamlValue(n.vals[k], ft)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", k, err)
+			}
+			ret[k] = v
+		}
+		return ret, nil
+	}
+	return nil, fmt.Errorf("unexpected %T", n)
+}
+
+// fieldType returns a type of a struct field encoding/json would decode a
+// key into, nil if there's none.
+func fieldType(t reflect.Type, key string) reflect.Type {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := f.Name
+		if n := strings.Split(f.Tag.Get("json"), ",")[0]; len(n) > 0 {
+			name = n
+		}
+		if f.Anonymous && f.Tag.Get("json") == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				if ret := fieldType(ft, key); ret != nil {
+					return ret
+				}
+			}
+			continue
+		}
+		if f.IsExported() && strings.EqualFold(name, key) {
+			return f.Type
+		}
+	}
+	return nil
+}
+
+// unmarshalYAML decodes a YAML document into v like json.Unmarshal.
+func unmarshalYAML(b []byte, v interface{}) error {
+	n, err := parseYAML(b)
+	if err != nil {
+		return err
+	}
+	j, err := yamlValue(n, reflect.TypeOf(v))
+	if err != nil {
+		return err
+	}
+	if b, err = json.Marshal(j); err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// orderedJSON decodes a JSON value keeping an order of object keys.
+func orderedJSON(dec *json.Decoder) (interface{}, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch tok {
+	case json.Delim('{'):
+		m := &yamlMapping{vals: make(map[string]interface{})}
+		for dec.More() {
+			k, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			v, err := orderedJSON(dec)
+			if err != nil {
+				return nil, err
+			}
+			m.keys = append(m.keys, k.(string))
+			m.vals[k.(string)] = v
+		}
+		_, err = dec.Token()
+		return m, err
+	case json.Delim('['):
+		seq := []interface{}{}
+		for dec.More() {
+			v, err := orderedJSON(dec)
+			if err != nil {
+				return nil, err
+			}
+			seq = append(seq, v)
+		}
+		_, err = dec.Token()
+		return seq, err
+	}
+	return tok, nil
+}
+
+// plainUnsafe are plain scalars read as something else than a string.
+var plainUnsafe = map[string]bool{"": true, "~": true, "null": true, "true": true, "false": true,
+	"yes": true, "no": true, "on": true, "off": true}
+
+func yamlString(s string) string {
+	_, err := strconv.ParseFloat(s, 64)
+	if err == nil || plainUnsafe[strings.ToLower(s)] || strings.ContainsAny(s[:1], "-?:,[]{}#&*!|>'\"%@` ") ||
+		strings.HasSuffix(s, " ") || strings.HasSuffix(s, ":") || strings.Contains(s, ": ") || strings.Contains(s, " #") ||
+		strings.ContainsAny(s, "\n\r\t\\") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+func emitYAML(b *bytes.Buffer, v interface{}, indent int) {
+	pad := strings.Repeat(" ", indent)
+	switch v := v.(type) {
+	case *yamlMapping:
+		for _, k := range v.keys {
+			b.WriteString(pad + yamlString(k) + ":")
+			emitValue(b, v.vals[k], indent)
+		}
+	case []interface{}:
+		for _, el := range v {
+			if m, ok := el.(*yamlMapping); ok && len(m.keys) == 0 {
+				b.WriteString(pad + "- {}\n")
+				continue
+			}
+			if s, ok := el.([]interface{}); ok && len(s) == 0 {
+				b.WriteString(pad + "- []\n")
+				continue
+			}
+			// An item's content starts on a line of its dash.
+			item := &bytes.Buffer{}
+			emitYAML(item, el, indent+2)
+			b.WriteString(pad + "- " + strings.TrimPrefix(item.String(), pad+"  "))
+		}
+	default:
+		b.WriteString(pad + yamlScalarText(v) + "\n")
+	}
+}
+
+func emitValue(b *bytes.Buffer, v interface{}, indent int) {
+	switch t := v.(type) {
+	case *yamlMapping:
+		if len(t.keys) == 0 {
+			b.WriteString(" {}\n")
+			return
+		}
+	case []interface{}:
+		if len(t) == 0 {
+			b.WriteString(" []\n")
+			return
+		}
+	default:
+		b.WriteString(" " + yamlScalarText(v) + "\n")
+		return
+	}
+	b.WriteString("\n")
+	emitYAML(b, v, indent+2)
+}
+
+func yamlScalarText(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return yamlString(v)
+	}
+	return fmt.Sprint(v)
+}
+
+// marshalYAML encodes v as YAML, fields in an order of json.Marshal.
+func marshalYAML(v interface{}) ([]byte, error) {
+	j, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(j))
+	dec.UseNumber()
+	n, err := orderedJSON(dec)
+	if err != nil {
+		return nil, err
+	}
+	b := &bytes.Buffer{}
+	emitYAML(b, n, 0)
+	return b.Bytes(), nil
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// yamlStrings are strings YAML would read as something else unless quoted.
+var yamlStrings = []string{"", "yes", "No", "null", "~", "123", "-1.5e3", "a: b", "a #b", "#a", "- a", "[a]",
+	"{a: b}", "'a'", `"a"`, "a:", " a", "a ", "a\nb", `a\b`, "tab\t", "@a", "`a`", "!a", "&a", "*a", "|", ">", "%a",
+	"ąę", "http://user:pw@host:80/a?b=c#d"}
+
+// fillResConf sets every exported field of c to a non-zero value picked by i.
+func fillResConf(t *testing.T, c *ResConf, i int) {
+	v := reflect.ValueOf(c).Elem()
+	for j := 0; j < v.NumField(); j++ {
+		if !v.Type().Field(j).IsExported() {
+			continue
+		}
+		f := v.Field(j)
+		s := yamlStrings[(i+j)%len(yamlStrings)]
+		if len(s) == 0 {
+			s = "empty"
+		}
+		switch f.Interface().(type) {
+		case string:
+			f.SetString(s)
+		case bool:
+			f.SetBool(true)
+		case int:
+			f.SetInt(int64(-i - j - 1))
+		case float64:
+			f.SetFloat(float64(i+j) + 0.25)
+		case []string:
+			f.Set(reflect.ValueOf([]string{s, yamlStrings[i%len(yamlStrings)], "plain"}))
+		case map[string]string:
+			f.Set(reflect.ValueOf(map[string]string{s: yamlStrings[i%len(yamlStrings)], "k": s}))
+		case time.Time:
+			f.Set(reflect.ValueOf(time.Date(2026, 1, 2, 3, 4, 5, i, time.UTC)))
+		default:
+			t.Fatalf("ResConf.%s: %s isn't covered", v.Type().Field(j).Name, f.Type())
+		}
+	}
+}
+
+func TestYAMLRoundTrip(t *testing.T) {
+	var confs []*ResConf
+	for i := range yamlStrings {
+		c := &ResConf{}
+		fillResConf(t, c, i)
+		confs = append(confs, c)
+	}
+	confs = append(confs, &ResConf{Name: "minimal"}, &ResConf{Name: "empty collections",
+		Headers: map[string]string{}, Notify: []string{}})
+	for _, c := range confs {
+		b, err := marshalYAML(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := &ResConf{}
+		if err := unmarshalYAML(b, got); err != nil {
+			t.Errorf("%q: %v in\n%s", c.Name, err, b)
+			continue
+		}
+		want, _ := json.Marshal(c)
+		if j, _ := json.Marshal(got); string(j) != string(want) {
+			t.Errorf("%q: got\n%s\nwant\n%s\nfrom\n%s", c.Name, j, want, b)
+		}
+	}
+
+	// A whole config, with resources in a sequence of mappings.
+	config := &Config{Configs: confs[:3]}
+	b, err := marshalYAML(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := &Config{}
+	if err := unmarshalYAML(b, got); err != nil {
+		t.Fatalf("%v in\n%s", err, b)
+	}
+	if !reflect.DeepEqual(got.Configs, config.Configs) {
+		t.Errorf("config round trip:\n%s", b)
+	}
+}
+
+func TestYAMLParse(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string // JSON of a generic decoding
+	}{
+		{"empty", "", "null"},
+		{"comments", "# a\n---\na: 1 # b\nb: c#d\n...\n", `{"a":1,"b":"c#d"}`},
+		{"nested", "a:\n  b:\n    c: d\n  e: f\n", `{"a":{"b":{"c":"d"},"e":"f"}}`},
+		{"sequence", "- a\n- 2\n- true\n-\n  - b\n", `["a",2,true,["b"]]`},
+		{"sequence at key indentation", "a:\n- b\n- c\nd: e\n", `{"a":["b","c"],"d":"e"}`},
+		{"sequence of mappings", "- a: 1\n  b: 2\n- c: 3\n", `[{"a":1,"b":2},{"c":3}]`},
+		{"nulls", "a:\nb: ~\nc: null\n", `{"a":null,"b":null,"c":null}`},
+		{"double quoted", `a: "b: \"c\"\n\u0105"`, `{"a":"b: \"c\"\ną"}`},
+		{"single quoted", "a: 'it''s # not'", `{"a":"it's # not"}`},
+		{"quoted key", `"a: b": c`, `{"a: b":"c"}`},
+		{"quoted number", `a: "1"`, `{"a":"1"}`},
+		{"flow sequence", "a: [1, b, 'c, d', [], {}]", `{"a":[1,"b","c, d",[],{}]}`},
+		{"flow mapping", `a: {b: 1, "c": [d], e: {f: g}}`, `{"a":{"b":1,"c":["d"],"e":{"f":"g"}}}`},
+		{"top-level flow", "[a, {b: c}]", `["a",{"b":"c"}]`},
+		{"colon in value", "a: http://b:80/", `{"a":"http://b:80/"}`},
+		{"crlf", "a: b\r\nc: d\r\n", `{"a":"b","c":"d"}`},
+	}
+	for _, tt := range tests {
+		var got interface{}
+		if err := unmarshalYAML([]byte(tt.in), &got); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if j, _ := json.Marshal(got); string(j) != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, j, tt.want)
+		}
+	}
+}
+
+func TestYAMLMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		err  string
+	}{
+		{"tab indentation", "a:\n\tb: c\n", "line 2: a tab in indentation"},
+		{"over-indented", "a: b\n  c: d\n", "line 2: unexpected indentation"},
+		{"under-indented", "a:\n    b: c\n  d: e\n", "line 3: unexpected indentation"},
+		{"mapping after sequence", "- a\nb: c\n", "line 2: unexpected indentation"},
+		{"not a key", "a: b\nc\n", "line 2: want a key: value"},
+		{"duplicate key", "a: 1\na: 2\n", `line 2: duplicate key "a"`},
+		{"unterminated double quote", `a: "b`, "line 1: unterminated \""},
+		{"unterminated single quote", "a: 'b", "line 1: unterminated '"},
+		{"unterminated quoted key", `"a: b`, "line 1: unterminated \""},
+		{"bad escape", `a: "\q"`, "line 1: invalid syntax"},
+		{"text after quote", `a: "b" c`, `line 1: unexpected "c"`},
+		{"unterminated flow sequence", "a: [b, c", "line 1: unterminated ["},
+		{"unterminated flow mapping", "a: {b: c", "line 1: unterminated {"},
+		{"flow mapping without colon", "a: {b}", "line 1: want a key: value"},
+		{"collection as key", "a: {[b]: c}", "line 1: a collection as a key"},
+		{"text after flow", "a: [b] c", `line 1: unexpected "c"`},
+		{"block scalar", "a: |\n  b\n", "line 1: block scalars are not supported"},
+		{"folded scalar", "a: >-\n  b\n", "line 1: block scalars are not supported"},
+	}
+	for _, tt := range tests {
+		var got interface{}
+		err := unmarshalYAML([]byte(tt.in), &got)
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("%s: got error %v, want %s", tt.name, err, tt.err)
+		}
+	}
+
+	// Scalars are typed by fields.
+	typed := []struct {
+		in, err string
+	}{
+		{"Name: 123\nMinISR: 2\nPing: true\nWeight: 0.5\n", ""},
+		{"MinISR: two\n", `MinISR: "two" is not a number`},
+		{"Ping: maybe\n", `Ping: strconv.ParseBool: parsing "maybe": invalid syntax`},
+		{"Notify: a\n", "json: cannot unmarshal string into Go struct field ResConf.Notify of type []string"},
+		{"Headers: [a]\n", "json: cannot unmarshal array into Go struct field ResConf.Headers of type map[string]string"},
+	}
+	for _, tt := range typed {
+		c := &ResConf{}
+		err := unmarshalYAML([]byte(tt.in), c)
+		if got := ""; err != nil {
+			if got = err.Error(); got != tt.err {
+				t.Errorf("%q: got error %q, want %q", tt.in, got, tt.err)
+			}
+		} else if len(tt.err) > 0 {
+			t.Errorf("%q: no error, want %q", tt.in, tt.err)
+		}
+	}
+}